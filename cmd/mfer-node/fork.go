@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/kataras/golog"
+	"github.com/sec-bit/mfer-node/mferauth"
+	"github.com/sec-bit/mfer-node/mferbackend"
+	"github.com/sec-bit/mfer-node/mferconfig"
+	"github.com/sec-bit/mfer-node/mferevm"
+	"github.com/sec-bit/mfer-node/mfertxpool"
+)
+
+// fork is one forked chain served by the process, the unnamed fork is the
+// only one served when no forks are configured.
+type fork struct {
+	name  string
+	cfg   *mferconfig.Config
+	evm   *mferevm.MferEVM
+	ready int32 // set once the first state is loaded
+}
+
+// checkRoutes rejects forks sharing the same listen address and path, and
+// forks whose routes overlap: a path under the session routes of another
+// fork, or the same port bound on a wildcard and on another address.
+func checkRoutes(forks []*fork) error {
+	routes := make(map[string]string)
+	for _, f := range forks {
+		route := f.cfg.Listen + routePath(f.cfg.Path)
+		if other, ok := routes[route]; ok {
+			return fmt.Errorf("forks '%s' and '%s' are both served at %s", other, f.name, route)
+		}
+		routes[route] = f.name
+	}
+	for i, f := range forks {
+		for _, other := range forks[i+1:] {
+			if err := checkOverlap(f, other); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkOverlap(a, b *fork) error {
+	if a.cfg.Listen != b.cfg.Listen {
+		hostA, portA, _ := net.SplitHostPort(a.cfg.Listen)
+		hostB, portB, _ := net.SplitHostPort(b.cfg.Listen)
+		if portA == portB && (isWildcard(hostA) || isWildcard(hostB)) {
+			return fmt.Errorf("forks '%s' and '%s' both listen on port %s (%s and %s)", a.name, b.name, portA, a.cfg.Listen, b.cfg.Listen)
+		}
+		return nil
+	}
+	for _, c := range [][2]*fork{{a, b}, {b, a}} {
+		base, path := routePath(c[0].cfg.Path), routePath(c[1].cfg.Path)
+		sessions := strings.TrimSuffix(base, "/") + "/session"
+		if path == sessions || strings.HasPrefix(path, sessions+"/") {
+			return fmt.Errorf("fork '%s' at %s overlaps the session routes of fork '%s' at %s", c[1].name, path, c[0].name, base)
+		}
+	}
+	return nil
+}
+
+// routePath is path without its trailing slash, the fork is served at both.
+func routePath(path string) string {
+	if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+		return trimmed
+	}
+	return "/"
+}
+
+func isWildcard(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+// connect dials the upstream of the fork until the first state is loaded and
+// opens its route, the other forks are served meanwhile.
+func (f *fork) connect() {
+	f.evm.Connect()
+	atomic.StoreInt32(&f.ready, 1)
+	golog.Infof("Fork '%s' is ready", f.name)
+}
+
+// whenReady answers the requests to the fork with a 503 until it is
+// connected.
+func (f *fork) whenReady(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&f.ready) == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      nil,
+				"error":   map[string]interface{}{"code": -32000, "message": fmt.Sprintf("fork '%s' is connecting to its upstream", f.name)},
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serve mounts the rpc server of the fork on the mux of its listen address,
+// it answers once the fork is connected.
+func (f *fork) serve(muxes map[string]*http.ServeMux) error {
+	txPool := mfertxpool.NewMferTxPool()
+	txPool.Metrics = f.evm.Metrics
+	b := mferbackend.NewMferBackend(f.evm, txPool, common.HexToAddress(f.cfg.Account), f.cfg.Rand)
+	b.Passthrough = f.cfg.Passthrough
+	b.Shadow = f.cfg.Shadow
+	if f.cfg.ChainID != 0 {
+		b.OverrideChainID = new(big.Int).SetUint64(f.cfg.ChainID)
+	}
+	b.ClientVersion = fmt.Sprintf("mfer-node/v%s/%s-%s/%s", VERSION, runtime.GOOS, runtime.GOARCH, runtime.Version())
+	b.GasMargin = f.cfg.GasMargin
+	b.Security = mferbackend.SecurityOptions{
+		Enabled:      f.cfg.Security.Enabled,
+		Delegates:    make(map[common.Address]bool),
+		OutflowRatio: f.cfg.Security.OutflowRatio,
+	}
+	for _, delegate := range f.cfg.Security.Delegates {
+		b.Security.Delegates[common.HexToAddress(delegate)] = true
+	}
+	if len(f.cfg.Signatures) > 0 {
+		added, err := b.Signatures.Load(f.cfg.Signatures...)
+		if err != nil {
+			return fmt.Errorf("fork '%s': signatures: %v", f.name, err)
+		}
+		golog.Infof("Loaded %d signatures for fork '%s'", added, f.name)
+	}
+	if len(f.cfg.Contracts) > 0 {
+		registered, err := b.Contracts.Load(f.cfg.Contracts...)
+		if err != nil {
+			return fmt.Errorf("fork '%s': contracts: %v", f.name, err)
+		}
+		golog.Infof("Registered %d contracts for fork '%s'", registered, f.name)
+	}
+	limits := f.cfg.Limits
+	b.Limits = mferbackend.Limits{
+		GasCap:        limits.GasCap,
+		Timeout:       time.Duration(limits.Timeout) * time.Second,
+		MaxTraceRange: limits.MaxTraceRange,
+		MaxResultSize: int(limits.MaxResultSize),
+		MaxPoolSize:   int(limits.MaxPoolSize),
+	}
+
+	srv, err := mferbackend.NewRPCServer(b, f.cfg.Namespaces)
+	if err != nil {
+		return err
+	}
+	selfRPCClient := rpc.DialInProc(srv)
+	f.evm.SelfClient = selfRPCClient
+	f.evm.SelfConn = ethclient.NewClient(selfRPCClient)
+
+	mux, ok := muxes[f.cfg.Listen]
+	if !ok {
+		mux = http.NewServeMux()
+		muxes[f.cfg.Listen] = mux
+	}
+	path := routePath(f.cfg.Path)
+	b.Sessions = mferbackend.NewSessionManager(b, f.cfg.Namespaces, path, time.Duration(f.cfg.SessionTTL)*time.Second, newHTTPHandler)
+	handler := b.Sessions.Handler(newHTTPHandler(srv))
+	if limits.MaxConcurrency > 0 {
+		handler = mferauth.ConcurrencyLimit(int(limits.MaxConcurrency), handler)
+	}
+	if f.cfg.Auth.Enabled() {
+		auth, err := mferauth.New(&f.cfg.Auth)
+		if err != nil {
+			return err
+		}
+		handler = auth.Handler(handler)
+	}
+	handler = f.whenReady(handler)
+	mux.Handle(path, handler)
+	if path != "/" {
+		mux.Handle(path+"/", handler)
+	}
+	golog.Infof("Serving fork '%s' (%s) at http://%s%s", f.name, f.cfg.Upstream, f.cfg.Listen, path)
+	return nil
+}
+
+// newHTTPHandler serves srv over http, accepting any origin and vhost.
+func newHTTPHandler(srv *rpc.Server) http.Handler {
+	return node.NewHTTPHandlerStack(srv, []string{"*"}, []string{"*"}, nil)
+}
+
+// serveHTTP serves mux at listen, execTimeout is the longest execution timeout
+// of the forks it serves, responses may take that long to be written.
+func serveHTTP(listen string, mux *http.ServeMux, execTimeout time.Duration) {
+	timeouts := rpc.DefaultHTTPTimeouts
+	if execTimeout+time.Second*5 > timeouts.WriteTimeout {
+		timeouts.WriteTimeout = execTimeout + time.Second*5
+	}
+	server := &http.Server{
+		Addr:         listen,
+		Handler:      mux,
+		ReadTimeout:  timeouts.ReadTimeout,
+		WriteTimeout: timeouts.WriteTimeout,
+		IdleTimeout:  timeouts.IdleTimeout,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		golog.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sec-bit/mfer-node/mferconfig"
+	"github.com/sec-bit/mfer-node/mferevm"
+)
+
+func testFork(name, listen, path string) *fork {
+	cfg := mferconfig.Default()
+	cfg.Listen = listen
+	cfg.Path = path
+	return &fork{name: name, cfg: cfg}
+}
+
+func TestCheckRoutes(t *testing.T) {
+	cases := []struct {
+		name  string
+		forks []*fork
+		err   string
+	}{
+		{"single", []*fork{testFork("", "127.0.0.1:10545", "/")}, ""},
+		{"separate paths", []*fork{testFork("a", "127.0.0.1:10545", "/a"), testFork("b", "127.0.0.1:10545", "/b")}, ""},
+		{"nested path", []*fork{testFork("a", "127.0.0.1:10545", "/a"), testFork("b", "127.0.0.1:10545", "/a/b")}, ""},
+		{"separate listen", []*fork{testFork("a", "127.0.0.1:10545", "/"), testFork("b", "127.0.0.1:10546", "/")}, ""},
+		{"separate hosts", []*fork{testFork("a", "127.0.0.1:10545", "/"), testFork("b", "10.0.0.1:10545", "/")}, ""},
+		{"duplicate", []*fork{testFork("a", "127.0.0.1:10545", "/x"), testFork("b", "127.0.0.1:10545", "/x")}, "both served"},
+		{"trailing slash", []*fork{testFork("a", "127.0.0.1:10545", "/x"), testFork("b", "127.0.0.1:10545", "/x/")}, "both served"},
+		{"root twice", []*fork{testFork("a", "127.0.0.1:10545", "/"), testFork("b", "127.0.0.1:10545", "//")}, "both served"},
+		{"session route", []*fork{testFork("a", "127.0.0.1:10545", "/a"), testFork("b", "127.0.0.1:10545", "/a/session")}, "session routes"},
+		{"under root sessions", []*fork{testFork("b", "127.0.0.1:10545", "/session/b"), testFork("a", "127.0.0.1:10545", "/")}, "session routes"},
+		{"wildcard port", []*fork{testFork("a", "0.0.0.0:10545", "/a"), testFork("b", "127.0.0.1:10545", "/b")}, "port 10545"},
+		{"empty host port", []*fork{testFork("a", "127.0.0.1:10545", "/a"), testFork("b", ":10545", "/b")}, "port 10545"},
+	}
+	for _, c := range cases {
+		err := checkRoutes(c.forks)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: got %v, want %q", c.name, err, c.err)
+		}
+	}
+}
+
+// rpcRequest posts a web3_clientVersion request to path and returns the status
+// and the error message of the response.
+func rpcRequest(t *testing.T, mux http.Handler, path string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"web3_clientVersion","params":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var resp struct {
+		Result string
+		Error  *struct{ Message string }
+	}
+	if w.Code != http.StatusOK && w.Code != http.StatusServiceUnavailable {
+		return w.Code, w.Body.String()
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: %v: %s", path, err, w.Body)
+	}
+	if resp.Error != nil {
+		return w.Code, resp.Error.Message
+	}
+	return w.Code, resp.Result
+}
+
+func TestForkRouting(t *testing.T) {
+	muxes := make(map[string]*http.ServeMux)
+	forks := []*fork{testFork("a", "127.0.0.1:10545", "/a"), testFork("b", "127.0.0.1:10545", "/b/")}
+	for _, f := range forks {
+		f.cfg.Namespaces = []string{"web3"}
+		// never connected, the fork is not dialed by serve
+		f.evm = mferevm.NewMferEVM("http://127.0.0.1:1", common.Address{}, filepath.Join(t.TempDir(), "keys.txt"), 10, 10)
+		if err := f.serve(muxes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := muxes["127.0.0.1:10545"]
+
+	// the forks answer 503 until they are connected
+	for _, path := range []string{"/a", "/a/session/x", "/b", "/b/"} {
+		code, msg := rpcRequest(t, mux, path)
+		fork := path[1:2]
+		if code != http.StatusServiceUnavailable || !strings.Contains(msg, "fork '"+fork+"'") {
+			t.Errorf("%s before ready: %d %s", path, code, msg)
+		}
+	}
+
+	atomic.StoreInt32(&forks[0].ready, 1)
+	if code, msg := rpcRequest(t, mux, "/a"); code != http.StatusOK || !strings.HasPrefix(msg, "mfer-node/") {
+		t.Errorf("/a after ready: %d %s", code, msg)
+	}
+	if code, msg := rpcRequest(t, mux, "/a/session/x"); code != http.StatusNotFound || !strings.Contains(msg, "session x not found") {
+		t.Errorf("/a/session/x after ready: %d %s", code, msg)
+	}
+	// the other fork is still connecting
+	if code, msg := rpcRequest(t, mux, "/b"); code != http.StatusServiceUnavailable || !strings.Contains(msg, "fork 'b'") {
+		t.Errorf("/b while connecting: %d %s", code, msg)
+	}
+	if code, _ := rpcRequest(t, mux, "/c"); code != http.StatusNotFound {
+		t.Errorf("/c: %d", code)
+	}
+}
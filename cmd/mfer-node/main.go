@@ -4,56 +4,83 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"math/big"
+	"net/http"
 	"os"
-	"path"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	_ "github.com/ethereum/go-ethereum/eth/tracers/js"
 	_ "github.com/ethereum/go-ethereum/eth/tracers/native"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/node"
-	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/kataras/golog"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 	"github.com/sec-bit/mfer-node/mferbackend"
+	"github.com/sec-bit/mfer-node/mferconfig"
 	"github.com/sec-bit/mfer-node/mferevm"
-	"github.com/sec-bit/mfer-node/mfertxpool"
+	"github.com/sec-bit/mfer-node/mfermetrics"
 )
 
-func defaultKeyCacheFilePath() string {
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		log.Panic(err)
-	}
-	cacheDir = path.Join(cacheDir, "MferSafe")
-	err = os.MkdirAll(cacheDir, os.ModePerm)
-	if err != nil {
-		log.Panic(err)
-	}
-	fileName := "scratchPadKeyCache.txt"
-	return path.Join(cacheDir, fileName)
+const VERSION = "0.1.6"
+
+// flagKeys maps the flags whose names differ from their config keys.
+var flagKeys = map[string]string{
+	"logpath":  "log.path",
+	"debug":    "log.level",
+	"security": "security.enabled",
 }
 
-const VERSION = "0.1.6"
+// applyOverrides applies the MFER_* env and then the command line flags set
+// onto cfg.
+func applyOverrides(cfg *mferconfig.Config) error {
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return err
+	}
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		key, ok := flagKeys[f.Name]
+		if !ok {
+			key = f.Name
+		}
+		for _, k := range cfg.Keys() {
+			if k == key && err == nil {
+				if setErr := cfg.Set(key, f.Value.String()); setErr != nil {
+					err = fmt.Errorf("--%s: %v", f.Name, setErr)
+				}
+			}
+		}
+	})
+	return err
+}
 
 func main() {
-	account := flag.String("account", "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "impersonate account")
-	rand := flag.Bool("rand", false, "randomize account")
-	passthrough := flag.Bool("passthrough", true, "passthough call (forward call request to upstream, faster and less privacy)")
-	upstreamURL := flag.String("upstream", "http://localhost:8545", "upstream node")
-	listenURL := flag.String("listen", "127.0.0.1:10545", "web3provider bind address port")
-
-	keyCacheFilePath := flag.String("keycache", defaultKeyCacheFilePath(), "state key cache file path")
-	maxKeyCache := flag.Uint64("maxkeys", 100, "max keys stored")
-
-	batchSize := flag.Int("batchsize", 100, "batch request size")
-	logPath := flag.String("logpath", "./mfer-node.log", "path to log file")
-	chainID := flag.Uint64("chainid", 0, "chainid override (0 for auto detect)")
-	debugLevel := flag.String("debug", "info", "debug level")
+	defaults := mferconfig.Default()
+	flag.String("account", defaults.Account, "impersonate account")
+	flag.Bool("rand", defaults.Rand, "randomize account")
+	flag.Bool("passthrough", defaults.Passthrough, "passthough call (forward call request to upstream, faster and less privacy)")
+	flag.Bool("shadow", defaults.Shadow, "run calls locally and on upstream, log mismatched results")
+	flag.String("upstream", defaults.Upstream, "upstream node")
+	flag.String("listen", defaults.Listen, "web3provider bind address port")
+
+	flag.String("keycache", defaults.KeyCache, "state key cache file path")
+	flag.Uint64("maxkeys", defaults.MaxKeys, "max keys stored")
+
+	flag.Int("batchsize", defaults.BatchSize, "batch request size")
+	flag.String("logpath", defaults.Log.Path, "path to log file")
+	flag.Uint64("chainid", defaults.ChainID, "chainid override (0 for auto detect)")
+	flag.String("debug", defaults.Log.Level, "debug level")
+	flag.String("namespaces", strings.Join(defaults.Namespaces, ","), "comma separated rpc namespaces to enable")
+	flag.Bool("metrics", defaults.Metrics, "enable metrics collection and the prometheus endpoint")
+	flag.String("opslisten", defaults.OpsListen, "ops server bind address port (serves /healthz, /readyz and /metrics)")
+	flag.Uint64("maxlag", defaults.MaxLag, "state blocks behind upstream head before /readyz fails (0 to disable)")
+	flag.Uint64("sessionttl", defaults.SessionTTL, "seconds an idle session is kept (0 to keep sessions until expired)")
+	flag.Uint64("gasmargin", defaults.GasMargin, "percent added to eth_estimateGas results")
+	flag.Bool("security", defaults.Security.Enabled, "analyze every tx sent with eth_sendTransaction for security issues")
+	flag.String("contracts", strings.Join(defaults.Contracts, ","), "comma separated contract files or directories (json with address, label and abi)")
+	flag.String("signatures", strings.Join(defaults.Signatures, ","), "comma separated signature files or directories (4byte text, ABI or artifact json)")
+
+	configPath := flag.String("config", "", "toml config file")
+	profile := flag.String("profile", "", "named profile of the config file ([profiles.<name>])")
+	printConfig := flag.Bool("print-config", false, "print the resolved config and exit")
 	version := flag.Bool("version", false, "show version")
 	flag.Parse()
 
@@ -61,7 +88,42 @@ func main() {
 		fmt.Println("mfer-node version:", VERSION)
 		os.Exit(0)
 	}
-	pathToLog := *logPath
+
+	// defaults < config file < profile < MFER_* env < command line flags
+	cfg := defaults
+	if *configPath != "" {
+		if err := cfg.Load(*configPath, *profile); err != nil {
+			log.Fatal(err)
+		}
+	} else if *profile != "" {
+		log.Fatal("--profile requires --config")
+	}
+	// the forks apply the env and the flags over their own profile
+	fileCfg := *cfg
+	if err := applyOverrides(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := mferbackend.FilterAPIs(mferbackend.GetEthAPIs(nil), cfg.Namespaces); err != nil {
+		log.Fatal(err)
+	}
+	for _, key := range cfg.Auth.Keys {
+		if _, err := mferbackend.FilterAPIs(mferbackend.GetEthAPIs(nil), key.Namespaces); err != nil {
+			log.Fatalf("auth key '%s': %v", key.Name, err)
+		}
+	}
+	if *printConfig {
+		out, err := cfg.Marshal()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(string(out))
+		os.Exit(0)
+	}
+
+	pathToLog := cfg.Log.Path
 	// pathToLog += ".%Y%m%d%H%M.log"
 	rl, err := rotatelogs.New(
 		pathToLog,
@@ -70,56 +132,69 @@ func main() {
 	if err != nil {
 		golog.Fatal(err)
 	}
-	if *logPath == "" {
+	if cfg.Log.Path == "" {
 		myLogger := log.New(os.Stdout, "", 0)
 		golog.InstallStd(myLogger)
 	}
 	golog.SetOutput(rl)
 	golog.SetTimeFormat("2006/01/02 15:04:05.000000")
-	golog.SetLevel(*debugLevel)
+	golog.SetLevel(cfg.Log.Level)
 
-	splittedListen := strings.Split(*listenURL, ":")
-	listenAddr := splittedListen[0]
-	listenPort, err := strconv.Atoi(splittedListen[1])
-	if err != nil {
+	forks := []*fork{{cfg: cfg}}
+	if len(cfg.Forks) > 0 {
+		forks = forks[:0]
+		for _, name := range cfg.Forks {
+			forkCfg, err := fileCfg.Fork(name, applyOverrides)
+			if err != nil {
+				golog.Fatal(err)
+			}
+			forks = append(forks, &fork{name: name, cfg: forkCfg})
+		}
+	}
+	if err := checkRoutes(forks); err != nil {
 		golog.Fatal(err)
 	}
 
-	stack, err := node.New(&node.Config{
-		Name: "mfer-safe",
-		P2P: p2p.Config{
-			NoDial:     true,
-			ListenAddr: "",
-		},
-		HTTPHost:         listenAddr,
-		HTTPPort:         listenPort,
-		HTTPCors:         []string{"*"},
-		HTTPVirtualHosts: []string{"*"},
-	})
-	if err != nil {
-		log.Panic(err)
+	if cfg.Metrics {
+		mfermetrics.Enable()
 	}
-
-	impersonatedAccount := common.HexToAddress(*account)
-	mferEVM := mferevm.NewMferEVM(*upstreamURL, impersonatedAccount, *keyCacheFilePath, *maxKeyCache, *batchSize)
-	txPool := mfertxpool.NewMferTxPool()
-	b := mferbackend.NewMferBackend(mferEVM, txPool, impersonatedAccount, *rand)
-	b.Passthrough = *passthrough
-	if *chainID != 0 {
-		b.OverrideChainID = big.NewInt(int64(*chainID))
+	healthTargets := make(map[string]mferbackend.HealthTarget)
+	opsServer := mfermetrics.NewServer(cfg.OpsListen)
+	for _, f := range forks {
+		f.evm = mferevm.NewMferEVM(f.cfg.Upstream, common.HexToAddress(f.cfg.Account), f.cfg.KeyCache, f.cfg.MaxKeys, f.cfg.BatchSize)
+		f.evm.Metrics = mfermetrics.NewFork(f.name)
+		target := mferbackend.HealthTarget{EVM: f.evm, MaxLag: f.cfg.MaxLag}
+		healthTargets[f.name] = target
+		if f.name != "" {
+			single := map[string]mferbackend.HealthTarget{"": target}
+			opsServer.Handle("/healthz/"+f.name, mferbackend.HealthzHandler(single))
+			opsServer.Handle("/readyz/"+f.name, mferbackend.ReadyzHandler(single))
+		}
 	}
-	stack.RegisterAPIs(mferbackend.GetEthAPIs(b))
-	if err := stack.Start(); err != nil {
-		log.Panic(err)
+	opsServer.Handle("/healthz", mferbackend.HealthzHandler(healthTargets))
+	opsServer.Handle("/readyz", mferbackend.ReadyzHandler(healthTargets))
+	if cfg.Metrics {
+		opsServer.Handle("/metrics", mfermetrics.Handler())
 	}
+	opsServer.Start()
 
-	selfRPCClient, err := stack.Attach()
-	if err != nil {
-		log.Panic(err)
+	// every fork is served as soon as its own upstream is connected, one
+	// that can not be reached does not hold up the others
+	muxes := make(map[string]*http.ServeMux)
+	execTimeouts := make(map[string]time.Duration)
+	for _, f := range forks {
+		if err := f.serve(muxes); err != nil {
+			golog.Fatal(err)
+		}
+		if timeout := time.Duration(f.cfg.Limits.Timeout) * time.Second; timeout > execTimeouts[f.cfg.Listen] {
+			execTimeouts[f.cfg.Listen] = timeout
+		}
+		go f.connect()
+	}
+	for listen, mux := range muxes {
+		golog.Infof("HTTP server started at http://%s", listen)
+		go serveHTTP(listen, mux, execTimeouts[listen])
 	}
-
-	mferEVM.SelfClient = selfRPCClient
-	mferEVM.SelfConn = ethclient.NewClient(selfRPCClient)
 
 	select {}
 }
@@ -0,0 +1,299 @@
+package mferabi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// builtinSignatures are known without any file loaded.
+const builtinSignatures = `
+transfer(address,uint256)
+transferFrom(address,address,uint256)
+approve(address,uint256)
+increaseAllowance(address,uint256)
+decreaseAllowance(address,uint256)
+permit(address,address,uint256,uint256,uint8,bytes32,bytes32)
+balanceOf(address)
+allowance(address,address)
+totalSupply()
+name()
+symbol()
+decimals()
+deposit()
+withdraw(uint256)
+setApprovalForAll(address,bool)
+safeTransferFrom(address,address,uint256)
+safeTransferFrom(address,address,uint256,bytes)
+safeTransferFrom(address,address,uint256,uint256,bytes)
+safeBatchTransferFrom(address,address,uint256[],uint256[],bytes)
+transferOwnership(address)
+renounceOwnership()
+grantRole(bytes32,address)
+revokeRole(bytes32,address)
+multicall(bytes[])
+multiSend(bytes)
+execTransaction(address,uint256,bytes,uint8,uint256,uint256,uint256,address,address,bytes)
+approveHash(bytes32)
+event Transfer(address indexed from, address indexed to, uint256 value)
+event Approval(address indexed owner, address indexed spender, uint256 value)
+event ApprovalForAll(address indexed owner, address indexed operator, bool approved)
+event TransferSingle(address indexed operator, address indexed from, address indexed to, uint256 id, uint256 value)
+event TransferBatch(address indexed operator, address indexed from, address indexed to, uint256[] ids, uint256[] values)
+event Deposit(address indexed dst, uint256 wad)
+event Withdrawal(address indexed src, uint256 wad)
+event OwnershipTransferred(address indexed previousOwner, address indexed newOwner)
+event RoleGranted(bytes32 indexed role, address indexed account, address indexed sender)
+event RoleRevoked(bytes32 indexed role, address indexed account, address indexed sender)
+error Error(string reason)
+error Panic(uint256 code)
+`
+
+// entry is a function, event or error. declared reports whether its inputs
+// come from a declaration with names and indexed keywords rather than from
+// a bare type list.
+type entry struct {
+	name     string
+	sig      string
+	inputs   abi.Arguments
+	declared bool
+}
+
+// SignatureDB maps selectors and event topics to the signatures they may be
+// the id of. It is filled from local files and at runtime, it never looks
+// signatures up on the network.
+type SignatureDB struct {
+	mutex     sync.RWMutex
+	functions map[[4]byte][]*entry
+	events    map[common.Hash][]*entry
+	errors    map[[4]byte][]*entry
+}
+
+// NewSignatureDB returns a db holding the common token, ownership and Safe
+// signatures.
+func NewSignatureDB() *SignatureDB {
+	db := newSignatureDB()
+	if _, err := db.loadText(strings.NewReader(builtinSignatures), "builtin"); err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func newSignatureDB() *SignatureDB {
+	return &SignatureDB{
+		functions: make(map[[4]byte][]*entry),
+		events:    make(map[common.Hash][]*entry),
+		errors:    make(map[[4]byte][]*entry),
+	}
+}
+
+// add records e, a declared entry replaces a bare one with the same
+// signature. It reports whether the signature is new.
+func (db *SignatureDB) add(kind string, e *entry) bool {
+	id := crypto.Keccak256Hash([]byte(e.sig))
+	var selector [4]byte
+	copy(selector[:], id[:4])
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	var entries []*entry
+	switch kind {
+	case kindEvent:
+		entries = db.events[id]
+	case kindError:
+		entries = db.errors[selector]
+	default:
+		entries = db.functions[selector]
+	}
+	added := true
+	for i, known := range entries {
+		if known.sig == e.sig {
+			if e.declared || !known.declared {
+				entries[i] = e
+			}
+			added = false
+		}
+	}
+	if added {
+		entries = append(entries, e)
+	}
+	switch kind {
+	case kindEvent:
+		db.events[id] = entries
+	case kindError:
+		db.errors[selector] = entries
+	default:
+		db.functions[selector] = entries
+	}
+	return added
+}
+
+// AddSignature parses and records a text signature, see parseSignature for
+// the format. It reports whether the signature is new.
+func (db *SignatureDB) AddSignature(signature string) (bool, error) {
+	kind, e, err := parseSignature(signature)
+	if err != nil {
+		return false, err
+	}
+	return db.add(kind, e), nil
+}
+
+// AddABI records the functions, events and errors of a json ABI, anonymous
+// events have no topic to be found by and are skipped. It returns the number
+// of new signatures.
+func (db *SignatureDB) AddABI(abiJSON []byte) (int, error) {
+	parsed, err := abi.JSON(bytes.NewReader(abiJSON))
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, m := range parsed.Methods {
+		if db.add(kindFunction, &entry{name: m.RawName, sig: m.Sig, inputs: m.Inputs, declared: true}) {
+			added++
+		}
+	}
+	for _, e := range parsed.Events {
+		if e.Anonymous {
+			continue
+		}
+		if db.add(kindEvent, &entry{name: e.RawName, sig: e.Sig, inputs: e.Inputs, declared: true}) {
+			added++
+		}
+	}
+	for _, e := range parsed.Errors {
+		if db.add(kindError, &entry{name: e.Name, sig: e.Sig, inputs: e.Inputs, declared: true}) {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// Load records the signatures of the files at paths, directories are walked.
+// Files ending in .json hold an ABI, a build artifact with an "abi" field or
+// a 4byte style map of selectors to signatures; any other file has one text
+// signature per line, lines starting with # are comments. It returns the
+// number of new signatures.
+func (db *SignatureDB) Load(paths ...string) (int, error) {
+	added := 0
+	err := walkFiles(paths, func(path string) error {
+		n, err := db.loadFile(path)
+		added += n
+		return err
+	})
+	return added, err
+}
+
+// walkFiles calls fn with every file at paths, directories are walked and
+// hidden files in them skipped.
+func walkFiles(paths []string, fn func(path string) error) error {
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			hidden := path != root && strings.HasPrefix(info.Name(), ".")
+			if info.IsDir() {
+				if hidden {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if hidden {
+				return nil
+			}
+			return fn(path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *SignatureDB) loadFile(path string) (int, error) {
+	if strings.ToLower(filepath.Ext(path)) != ".json" {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		return db.loadText(f, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := db.loadJSON(data)
+	if err != nil {
+		return n, fmt.Errorf("%s: %v", path, err)
+	}
+	return n, nil
+}
+
+func (db *SignatureDB) loadText(r io.Reader, name string) (int, error) {
+	added := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		ok, err := db.AddSignature(text)
+		if err != nil {
+			return added, fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		if ok {
+			added++
+		}
+	}
+	return added, scanner.Err()
+}
+
+// loadJSON records an ABI, an artifact holding one or a map of ids to one
+// or more signatures.
+func (db *SignatureDB) loadJSON(data []byte) (int, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		return db.AddABI(data)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return 0, err
+	}
+	if abiJSON, ok := fields["abi"]; ok {
+		return db.AddABI(abiJSON)
+	}
+	added := 0
+	for id, raw := range fields {
+		var signatures []string
+		if err := json.Unmarshal(raw, &signatures); err != nil {
+			var signature string
+			if err := json.Unmarshal(raw, &signature); err != nil {
+				return added, fmt.Errorf("%s: expected a signature or a list of signatures", id)
+			}
+			signatures = []string{signature}
+		}
+		for _, signature := range signatures {
+			ok, err := db.AddSignature(id + " " + signature)
+			if err != nil {
+				return added, err
+			}
+			if ok {
+				added++
+			}
+		}
+	}
+	return added, nil
+}
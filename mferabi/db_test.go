@@ -0,0 +1,150 @@
+package mferabi
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestParseSignature(t *testing.T) {
+	tests := []struct {
+		line string
+		kind string
+		sig  string
+	}{
+		{"transfer(address,uint)", kindFunction, "transfer(address,uint256)"},
+		{"0xa9059cbb transfer(address to, uint256 amount)", kindFunction, "transfer(address,uint256)"},
+		{"function fill((address,uint256)[] orders, bytes calldata sig) external", kindFunction, "fill((address,uint256)[],bytes)"},
+		{"event Transfer(address indexed from, address indexed to, uint256 value)", kindEvent, "Transfer(address,address,uint256)"},
+		{"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef Transfer(address,address,uint256)", kindEvent, "Transfer(address,address,uint256)"},
+		{"error Unauthorized(address caller)", kindError, "Unauthorized(address)"},
+	}
+	for _, tt := range tests {
+		kind, e, err := parseSignature(tt.line)
+		if err != nil {
+			t.Errorf("parseSignature(%q): %v", tt.line, err)
+			continue
+		}
+		if kind != tt.kind || e.sig != tt.sig {
+			t.Errorf("parseSignature(%q) = %s %s, want %s %s", tt.line, kind, e.sig, tt.kind, tt.sig)
+		}
+	}
+	for _, line := range []string{"transfer", "0xdeadbeef transfer(address,uint256)", "transfer(address", "transfer(foo)"} {
+		if _, _, err := parseSignature(line); err == nil {
+			t.Errorf("parseSignature(%q) succeeded", line)
+		}
+	}
+}
+
+func TestDecodeCall(t *testing.T) {
+	db := NewSignatureDB()
+	to := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	data := append(common.FromHex("0xa9059cbb"), common.LeftPadBytes(to.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes([]byte{0x2a}, 32)...)
+
+	decoded := db.DecodeCall(data)
+	if decoded == nil || decoded.Name != "transfer" {
+		t.Fatalf("DecodeCall = %+v", decoded)
+	}
+	if decoded.Args[0].Value != to.Hex() || decoded.Args[1].Value != "42" {
+		t.Errorf("args = %v %v", decoded.Args[0].Value, decoded.Args[1].Value)
+	}
+	if db.DecodeCall(common.FromHex("0x12345678")) != nil {
+		t.Error("unknown selector decoded")
+	}
+	if db.DecodeCall(data[:20]) != nil {
+		t.Error("truncated calldata decoded")
+	}
+
+	// tuples are objects keyed by the field names
+	if _, err := db.AddSignature("swap((address token, uint256 amount) order)"); err != nil {
+		t.Fatal(err)
+	}
+	selector := crypto.Keccak256([]byte("swap((address,uint256))"))[:4]
+	decoded = db.DecodeCall(append(append(selector, common.LeftPadBytes(to.Bytes(), 32)...), common.LeftPadBytes([]byte{7}, 32)...))
+	if decoded == nil {
+		t.Fatal("tuple calldata not decoded")
+	}
+	out, _ := json.Marshal(decoded.Args[0])
+	if want := `{"name":"order","type":"(address,uint256)","value":{"amount":"7","token":"` + to.Hex() + `"}}`; string(out) != want {
+		t.Errorf("tuple arg = %s, want %s", out, want)
+	}
+}
+
+func TestDecodeLog(t *testing.T) {
+	db := NewSignatureDB()
+	from, to := common.HexToAddress("0x1111111111111111111111111111111111111111"), common.HexToAddress("0x2222222222222222222222222222222222222222")
+	topics := []common.Hash{crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)")), common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())}
+
+	// ERC20 with the value in the data
+	decoded := db.DecodeLog(topics, common.LeftPadBytes([]byte{5}, 32))
+	if decoded == nil || decoded.Args[0].Value != from.Hex() || decoded.Args[2].Value != "5" {
+		t.Fatalf("DecodeLog = %+v", decoded)
+	}
+	// ERC721 with the token id indexed does not match the declared event
+	if decoded := db.DecodeLog(append(topics, common.BigToHash(common.Big1)), nil); decoded != nil {
+		t.Errorf("ERC721 Transfer decoded as %+v", decoded)
+	}
+	// a bare signature takes the first parameters as indexed
+	if _, err := db.AddSignature("event Sent(address,address,uint256)"); err != nil {
+		t.Fatal(err)
+	}
+	topics[0] = crypto.Keccak256Hash([]byte("Sent(address,address,uint256)"))
+	decoded = db.DecodeLog(append(topics, common.BigToHash(common.Big1)), nil)
+	if decoded == nil || decoded.Args[2].Value != "1" {
+		t.Errorf("DecodeLog = %+v", decoded)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	db := NewSignatureDB()
+	if _, err := db.AddABI([]byte(`[{"type":"error","name":"Unauthorized","inputs":[{"name":"caller","type":"address"}]}]`)); err != nil {
+		t.Fatal(err)
+	}
+	caller := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	data := append(crypto.Keccak256([]byte("Unauthorized(address)"))[:4], common.LeftPadBytes(caller.Bytes(), 32)...)
+	decoded := db.DecodeError(data)
+	if decoded == nil || decoded.Name != "Unauthorized" || decoded.Args[0].Name != "caller" || decoded.Args[0].Value != caller.Hex() {
+		t.Errorf("DecodeError = %+v", decoded)
+	}
+	if db.DecodeError(data[:4]) != nil {
+		t.Error("truncated revert data decoded")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"sigs.txt":      "# comment\n0x095ea7b3 approve(address,uint256)\nevent Sync(uint112 reserve0, uint112 reserve1)\n",
+		"4byte.json":    `{"0x70a08231": ["balanceOf(address)", "passphrase_calculate_transfer(uint64,address)"]}`,
+		"artifact.json": `{"abi": [{"type":"function","name":"getReserves","inputs":[],"outputs":[]}]}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := NewSignatureDB()
+	added, err := db.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// approve and balanceOf are builtin
+	if added != 3 {
+		t.Errorf("added %d signatures, want 3", added)
+	}
+	if db.DecodeCall(crypto.Keccak256([]byte("getReserves()"))[:4]) == nil {
+		t.Error("artifact abi not loaded")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.txt"), []byte("transfer(address\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Load(dir); err == nil {
+		t.Error("invalid signature file loaded")
+	}
+}
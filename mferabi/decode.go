@@ -0,0 +1,226 @@
+package mferabi
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Arg is a decoded argument. Integers are decimal strings, addresses and
+// bytes hex strings, tuples objects keyed by field name. The value of an
+// indexed event argument of dynamic type is the topic, the hash of the value.
+type Arg struct {
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// Decoded is decoded calldata, event log or revert data. Implementation is
+// the contract whose ABI decoded it when that is not the callee or emitter,
+// like the implementation behind a proxy. Reason explains the code of a
+// Panic(uint256).
+type Decoded struct {
+	Signature      string          `json:"signature"`
+	Name           string          `json:"name"`
+	Args           []*Arg          `json:"args"`
+	Implementation *common.Address `json:"implementation,omitempty"`
+	Reason         string          `json:"reason,omitempty"`
+}
+
+// lookup copies the entries under the lock, add replaces entries in place.
+func (db *SignatureDB) lookup(entries func() []*entry) []*entry {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return append([]*entry{}, entries()...)
+}
+
+// DecodeCall decodes calldata, nil if the selector is unknown or the data
+// does not match any of its signatures. A selector can be the id of several
+// signatures, the one whose encoding of the decoded values gives back the
+// exact data wins over one that only decodes.
+func (db *SignatureDB) DecodeCall(data []byte) *Decoded {
+	if len(data) < 4 {
+		return nil
+	}
+	var selector [4]byte
+	copy(selector[:], data)
+	entries := db.lookup(func() []*entry { return db.functions[selector] })
+	return decodeSelector(entries, data[4:])
+}
+
+// DecodeError decodes revert data, nil if it is empty or unknown.
+func (db *SignatureDB) DecodeError(data []byte) *Decoded {
+	if len(data) < 4 {
+		return nil
+	}
+	var selector [4]byte
+	copy(selector[:], data)
+	entries := db.lookup(func() []*entry { return db.errors[selector] })
+	decoded := decodeSelector(entries, data[4:])
+	if decoded != nil && decoded.Signature == panicSignature {
+		if value, ok := decoded.Args[0].Value.(string); ok {
+			if code, ok := new(big.Int).SetString(value, 10); ok {
+				decoded.Reason = PanicReason(code)
+			}
+		}
+	}
+	return decoded
+}
+
+func decodeSelector(entries []*entry, data []byte) *Decoded {
+	var loose *Decoded
+	for _, e := range entries {
+		values, err := e.inputs.Unpack(data)
+		if err != nil {
+			continue
+		}
+		decoded := &Decoded{Signature: e.sig, Name: e.name, Args: make([]*Arg, len(e.inputs))}
+		for i, input := range e.inputs {
+			decoded.Args[i] = newArg(input, values[i])
+		}
+		if packed, err := e.inputs.Pack(values...); err == nil && bytes.Equal(packed, data) {
+			return decoded
+		}
+		if loose == nil {
+			loose = decoded
+		}
+	}
+	return loose
+}
+
+// DecodeLog decodes an event log, nil if its first topic is unknown or the
+// log does not match any of its signatures. Events known by their types only
+// are decoded taking the first parameters as the indexed ones.
+func (db *SignatureDB) DecodeLog(topics []common.Hash, data []byte) *Decoded {
+	if len(topics) == 0 {
+		return nil
+	}
+	entries := db.lookup(func() []*entry { return db.events[topics[0]] })
+	var loose *Decoded
+	for _, e := range entries {
+		inputs := e.inputs
+		if !e.declared {
+			if len(topics)-1 > len(inputs) {
+				continue
+			}
+			inputs = make(abi.Arguments, len(e.inputs))
+			for i, input := range e.inputs {
+				input.Indexed = i < len(topics)-1
+				inputs[i] = input
+			}
+		}
+		decoded, exact := decodeEvent(e, inputs, topics[1:], data)
+		if exact {
+			return decoded
+		}
+		if loose == nil {
+			loose = decoded
+		}
+	}
+	return loose
+}
+
+// decodeEvent decodes the log with inputs, exact reports whether the data
+// encodes the decoded values with nothing left over.
+func decodeEvent(e *entry, inputs abi.Arguments, topics []common.Hash, data []byte) (*Decoded, bool) {
+	indexed := 0
+	for _, input := range inputs {
+		if input.Indexed {
+			indexed++
+		}
+	}
+	if indexed != len(topics) {
+		return nil, false
+	}
+	nonIndexed := inputs.NonIndexed()
+	values, err := nonIndexed.Unpack(data)
+	if err != nil {
+		return nil, false
+	}
+	decoded := &Decoded{Signature: e.sig, Name: e.name, Args: make([]*Arg, len(inputs))}
+	topic, value := 0, 0
+	for i, input := range inputs {
+		if !input.Indexed {
+			decoded.Args[i] = newArg(input, values[value])
+			value++
+			continue
+		}
+		decoded.Args[i] = topicArg(input, topics[topic])
+		topic++
+	}
+	packed, err := nonIndexed.Pack(values...)
+	return decoded, err == nil && bytes.Equal(packed, data)
+}
+
+// topicArg decodes an indexed argument, values of dynamic type are hashed
+// into the topic and only the hash is known.
+func topicArg(input abi.Argument, topic common.Hash) *Arg {
+	switch input.Type.T {
+	case abi.IntTy, abi.UintTy, abi.BoolTy, abi.AddressTy, abi.FixedBytesTy:
+		values, err := abi.Arguments{{Type: input.Type}}.Unpack(topic.Bytes())
+		if err == nil {
+			return newArg(input, values[0])
+		}
+	}
+	return &Arg{Name: input.Name, Type: input.Type.String(), Value: topic.Hex()}
+}
+
+func newArg(input abi.Argument, value interface{}) *Arg {
+	return &Arg{Name: input.Name, Type: input.Type.String(), Value: jsonValue(input.Type, reflect.ValueOf(value))}
+}
+
+// jsonValue converts a value unpacked by the abi package to its json form.
+func jsonValue(t abi.Type, v reflect.Value) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if n, ok := v.Interface().(*big.Int); ok {
+			return n.String()
+		}
+		v = v.Elem()
+	}
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return big.NewInt(v.Int()).String()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return new(big.Int).SetUint64(v.Uint()).String()
+		}
+	case abi.AddressTy:
+		if address, ok := v.Interface().(common.Address); ok {
+			return address.Hex()
+		}
+	case abi.FixedBytesTy, abi.FunctionTy, abi.BytesTy:
+		if v.Kind() == reflect.Slice {
+			return hexutil.Encode(v.Bytes())
+		}
+		if v.Kind() == reflect.Array {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return hexutil.Encode(b)
+		}
+	case abi.SliceTy, abi.ArrayTy:
+		if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+			items := make([]interface{}, v.Len())
+			for i := range items {
+				items[i] = jsonValue(*t.Elem, v.Index(i))
+			}
+			return items
+		}
+	case abi.TupleTy:
+		if v.Kind() == reflect.Struct && v.NumField() == len(t.TupleElems) {
+			fields := make(map[string]interface{}, len(t.TupleElems))
+			for i, elem := range t.TupleElems {
+				fields[t.TupleRawNames[i]] = jsonValue(*elem, v.Field(i))
+			}
+			return fields
+		}
+	}
+	return v.Interface()
+}
@@ -0,0 +1,368 @@
+package mferabi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	// maxSlotDistance bounds how far past a keccak hash the slots derived from
+	// it are looked for, that is the offset of an array element or of a field
+	// of a mapping value.
+	maxSlotDistance = 1 << 40
+	// maxDerivation bounds the nesting of mappings and dynamic arrays.
+	maxDerivation = 8
+)
+
+// StorageLayout is the storageLayout solc outputs for a contract.
+type StorageLayout struct {
+	Storage []*layoutVar           `json:"storage"`
+	Types   map[string]*layoutType `json:"types"`
+}
+
+type layoutVar struct {
+	Label  string `json:"label"`
+	Offset int    `json:"offset"`
+	Slot   string `json:"slot"`
+	Type   string `json:"type"`
+
+	slot *big.Int
+}
+
+type layoutType struct {
+	Encoding      string       `json:"encoding"`
+	Label         string       `json:"label"`
+	NumberOfBytes string       `json:"numberOfBytes"`
+	Key           string       `json:"key,omitempty"`
+	Value         string       `json:"value,omitempty"`
+	Base          string       `json:"base,omitempty"`
+	Members       []*layoutVar `json:"members,omitempty"`
+
+	size   int
+	slots  *big.Int
+	length int64 // of a static array
+}
+
+// bytes32Chunk is the type of the slots holding the data of a long string
+// or bytes.
+var bytes32Chunk = &layoutType{Encoding: "inplace", Label: "bytes32", size: 32}
+
+// StorageVar is a variable, or a part of one, held in a slot. Offset is its
+// byte offset in the slot counted from the right, like solc does.
+type StorageVar struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Offset int    `json:"offset,omitempty"`
+	Value  string `json:"value"`
+}
+
+// ParseStorageLayout parses a storageLayout or a build artifact holding one.
+func ParseStorageLayout(data []byte) (*StorageLayout, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if raw, ok := fields["storageLayout"]; ok {
+		data = raw
+	}
+	layout := new(StorageLayout)
+	if err := json.Unmarshal(data, layout); err != nil {
+		return nil, err
+	}
+	if layout.Storage == nil || layout.Types == nil {
+		return nil, errors.New("no storage layout")
+	}
+	for id, t := range layout.Types {
+		size, err := strconv.Atoi(t.NumberOfBytes)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("type %s: bad numberOfBytes %q", id, t.NumberOfBytes)
+		}
+		switch {
+		case t.Encoding != "inplace" && t.Encoding != "mapping" && t.Encoding != "dynamic_array" && t.Encoding != "bytes":
+			return nil, fmt.Errorf("type %s: unknown encoding %q", id, t.Encoding)
+		case t.Encoding != "inplace" && size != 32, t.isValue() && size > 32:
+			return nil, fmt.Errorf("type %s: bad numberOfBytes %q", id, t.NumberOfBytes)
+		}
+		t.size = size
+		t.slots = big.NewInt(int64((size + 31) / 32))
+	}
+	for id, t := range layout.Types {
+		for _, ref := range []string{t.Key, t.Value, t.Base} {
+			if _, ok := layout.Types[ref]; ref != "" && !ok {
+				return nil, fmt.Errorf("type %s: unknown type %s", id, ref)
+			}
+		}
+		if key := layout.Types[t.Key]; key != nil && !key.isValue() && key.Encoding != "bytes" {
+			return nil, fmt.Errorf("type %s: bad key type %s", id, t.Key)
+		}
+		if t.Encoding == "inplace" && t.Base != "" {
+			open := strings.LastIndex(t.Label, "[")
+			length, err := strconv.ParseInt(strings.TrimSuffix(t.Label[open+1:], "]"), 10, 64)
+			if open < 0 || err != nil {
+				return nil, fmt.Errorf("type %s: bad array %q", id, t.Label)
+			}
+			t.length = length
+		}
+		if err := layout.parseVars(t.Members); err != nil {
+			return nil, fmt.Errorf("type %s: %v", id, err)
+		}
+	}
+	if err := layout.parseVars(layout.Storage); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+func (l *StorageLayout) parseVars(vars []*layoutVar) error {
+	for _, v := range vars {
+		slot, ok := new(big.Int).SetString(v.Slot, 10)
+		if !ok {
+			return fmt.Errorf("%s: bad slot %q", v.Label, v.Slot)
+		}
+		v.slot = slot
+		t, ok := l.Types[v.Type]
+		if !ok {
+			return fmt.Errorf("%s: unknown type %s", v.Label, v.Type)
+		}
+		// only value types are packed, anything else starts a slot
+		if v.Offset < 0 || t.isValue() && v.Offset+t.size > 32 || !t.isValue() && v.Offset != 0 {
+			return fmt.Errorf("%s: bad offset %d", v.Label, v.Offset)
+		}
+	}
+	return nil
+}
+
+// isValue tells a value type, one stored in place in a single slot.
+func (t *layoutType) isValue() bool {
+	return t.Encoding == "inplace" && t.Members == nil && t.Base == ""
+}
+
+// Preimages are the keccak preimages the slots of mapping entries and of
+// dynamic data are derived from.
+type Preimages struct {
+	hashes    []common.Hash
+	preimages map[common.Hash][]byte
+}
+
+func NewPreimages(preimages map[common.Hash][]byte) *Preimages {
+	p := &Preimages{preimages: preimages}
+	for hash := range preimages {
+		p.hashes = append(p.hashes, hash)
+	}
+	sort.Slice(p.hashes, func(i, j int) bool {
+		return bytes.Compare(p.hashes[i][:], p.hashes[j][:]) < 0
+	})
+	return p
+}
+
+// below lists the hashes slot may be derived from, the closest first.
+func (p *Preimages) below(slot *big.Int) []*big.Int {
+	if p == nil {
+		return nil
+	}
+	key := common.BigToHash(slot)
+	i := sort.Search(len(p.hashes), func(i int) bool {
+		return bytes.Compare(p.hashes[i][:], key[:]) > 0
+	})
+	var hashes []*big.Int
+	for i--; i >= 0; i-- {
+		hash := p.hashes[i].Big()
+		if new(big.Int).Sub(slot, hash).Cmp(big.NewInt(maxSlotDistance)) >= 0 {
+			break
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// slotVar is a variable found in a slot: a value, or the head slot of a
+// mapping, a dynamic array or a string.
+type slotVar struct {
+	name   string
+	typ    *layoutType
+	offset int
+}
+
+// Decode names the variables slot holds and reads them from value. The slots
+// of mapping entries and dynamic data are traced back to their variable with
+// preimages. It returns nil for a slot the layout does not explain.
+func (l *StorageLayout) Decode(slot, value common.Hash, preimages *Preimages) []*StorageVar {
+	var decoded []*StorageVar
+	for _, v := range l.find(slot.Big(), preimages, 0) {
+		if v.typ.Encoding == "mapping" {
+			continue
+		}
+		decoded = append(decoded, v.decode(value))
+	}
+	return decoded
+}
+
+func (l *StorageLayout) find(slot *big.Int, preimages *Preimages, depth int) []slotVar {
+	var vars []slotVar
+	for _, v := range l.Storage {
+		vars = l.within(vars, v.Label, v.Type, v.slot, v.Offset, slot)
+	}
+	if depth >= maxDerivation {
+		return vars
+	}
+	for _, hash := range preimages.below(slot) {
+		preimage := preimages.preimages[common.BigToHash(hash)]
+		if len(preimage) < 32 {
+			continue
+		}
+		head := new(big.Int).SetBytes(preimage[len(preimage)-32:])
+		for _, container := range l.find(head, preimages, depth+1) {
+			if container.offset != 0 {
+				continue
+			}
+			switch container.typ.Encoding {
+			case "mapping":
+				key := l.formatKey(container.typ.Key, preimage[:len(preimage)-32])
+				vars = l.within(vars, container.name+"["+key+"]", container.typ.Value, hash, 0, slot)
+			case "dynamic_array":
+				if len(preimage) == 32 {
+					vars = l.elements(vars, container.name, container.typ.Base, hash, -1, slot)
+				}
+			case "bytes":
+				if len(preimage) == 32 {
+					chunk := new(big.Int).Sub(slot, hash)
+					vars = append(vars, slotVar{name: fmt.Sprintf("%s.data[%s]", container.name, chunk), typ: bytes32Chunk})
+				}
+			}
+		}
+	}
+	return vars
+}
+
+// within appends the variables of slot that are part of the variable name of
+// type id at base.
+func (l *StorageLayout) within(vars []slotVar, name, id string, base *big.Int, offset int, slot *big.Int) []slotVar {
+	t := l.Types[id]
+	rel := new(big.Int).Sub(slot, base)
+	if rel.Sign() < 0 {
+		return vars
+	}
+	if t.Encoding != "inplace" {
+		if rel.Sign() == 0 {
+			vars = append(vars, slotVar{name: name, typ: t, offset: offset})
+		}
+		return vars
+	}
+	if rel.Cmp(t.slots) >= 0 {
+		return vars
+	}
+	switch {
+	case t.Members != nil:
+		for _, m := range t.Members {
+			vars = l.within(vars, name+"."+m.Label, m.Type, new(big.Int).Add(base, m.slot), m.Offset, slot)
+		}
+	case t.Base != "":
+		vars = l.elements(vars, name, t.Base, base, t.length, slot)
+	default:
+		vars = append(vars, slotVar{name: name, typ: t, offset: offset})
+	}
+	return vars
+}
+
+// elements appends the elements of the array name in slot, length is -1 for
+// a dynamic array.
+func (l *StorageLayout) elements(vars []slotVar, name, id string, base *big.Int, length int64, slot *big.Int) []slotVar {
+	t := l.Types[id]
+	rel := new(big.Int).Sub(slot, base)
+	if t.Encoding == "inplace" && t.Members == nil && t.Base == "" && t.size < 32 {
+		// small values are packed, several to a slot
+		perSlot := int64(32 / t.size)
+		first := new(big.Int).Mul(rel, big.NewInt(perSlot))
+		for j := int64(0); j < perSlot; j++ {
+			index := new(big.Int).Add(first, big.NewInt(j))
+			if length >= 0 && index.Cmp(big.NewInt(length)) >= 0 {
+				break
+			}
+			vars = append(vars, slotVar{name: fmt.Sprintf("%s[%s]", name, index), typ: t, offset: int(j) * t.size})
+		}
+		return vars
+	}
+	index := new(big.Int).Div(rel, t.slots)
+	if length >= 0 && index.Cmp(big.NewInt(length)) >= 0 {
+		return vars
+	}
+	return l.within(vars, fmt.Sprintf("%s[%s]", name, index), id, new(big.Int).Add(base, new(big.Int).Mul(index, t.slots)), 0, slot)
+}
+
+// formatKey formats the key of a mapping entry, key is the padded value or
+// the bytes of a string.
+func (l *StorageLayout) formatKey(id string, key []byte) string {
+	t := l.Types[id]
+	switch {
+	case t.Encoding == "bytes" && t.Label == "string":
+		return strconv.Quote(string(key))
+	case t.Encoding == "bytes":
+		return hexutil.Encode(key)
+	case len(key) != 32:
+		return hexutil.Encode(key)
+	case strings.HasPrefix(t.Label, "bytes"):
+		return formatValue(t.Label, key[:t.size])
+	}
+	return formatValue(t.Label, key[32-t.size:])
+}
+
+func (v slotVar) decode(value common.Hash) *StorageVar {
+	decoded := &StorageVar{Name: v.name, Type: v.typ.Label, Offset: v.offset}
+	switch v.typ.Encoding {
+	case "dynamic_array":
+		decoded.Name += ".length"
+		decoded.Type = "uint256"
+		decoded.Value = value.Big().String()
+	case "bytes":
+		if value[31]&1 == 0 && value[31]/2 <= 31 {
+			data := value[:value[31]/2]
+			if v.typ.Label == "string" {
+				decoded.Value = strconv.Quote(string(data))
+			} else {
+				decoded.Value = hexutil.Encode(data)
+			}
+		} else if value[31]&1 == 1 {
+			length := new(big.Int).Rsh(value.Big(), 1)
+			decoded.Value = fmt.Sprintf("(%s bytes)", length)
+		} else {
+			// no short string, the slot is not the one of the layout
+			decoded.Value = hexutil.Encode(value[:])
+		}
+	default:
+		end := 32 - v.offset
+		start := end - v.typ.size
+		if start < 0 {
+			start = 0
+		}
+		decoded.Value = formatValue(v.typ.Label, value[start:end])
+	}
+	return decoded
+}
+
+// formatValue formats the bytes of a value type by its solidity label.
+func formatValue(label string, data []byte) string {
+	switch {
+	case label == "address" || label == "address payable" || strings.HasPrefix(label, "contract "):
+		return common.BytesToAddress(data).Hex()
+	case label == "bool":
+		return strconv.FormatBool(new(big.Int).SetBytes(data).Sign() != 0)
+	case strings.HasPrefix(label, "uint") || strings.HasPrefix(label, "enum "):
+		return new(big.Int).SetBytes(data).String()
+	case strings.HasPrefix(label, "int"):
+		n := new(big.Int).SetBytes(data)
+		if len(data) > 0 && data[0]&0x80 != 0 {
+			n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(data)*8)))
+		}
+		return n.String()
+	}
+	return hexutil.Encode(data)
+}
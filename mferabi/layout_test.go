@@ -0,0 +1,182 @@
+package mferabi
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const testLayout = `{"storageLayout": {
+	"storage": [
+		{"label": "small", "offset": 0, "slot": "0", "type": "t_uint64"},
+		{"label": "owner", "offset": 8, "slot": "0", "type": "t_address"},
+		{"label": "balances", "offset": 0, "slot": "1", "type": "t_mapping(t_address,t_uint256)"},
+		{"label": "config", "offset": 0, "slot": "2", "type": "t_struct(Config)1_storage"},
+		{"label": "list", "offset": 0, "slot": "4", "type": "t_array(t_uint256)dyn_storage"},
+		{"label": "name", "offset": 0, "slot": "5", "type": "t_string_storage"},
+		{"label": "allowance", "offset": 0, "slot": "6", "type": "t_mapping(t_address,t_mapping(t_address,t_uint256))"},
+		{"label": "flags", "offset": 0, "slot": "7", "type": "t_array(t_int8)3_storage"}
+	],
+	"types": {
+		"t_address": {"encoding": "inplace", "label": "address", "numberOfBytes": "20"},
+		"t_bool": {"encoding": "inplace", "label": "bool", "numberOfBytes": "1"},
+		"t_int8": {"encoding": "inplace", "label": "int8", "numberOfBytes": "1"},
+		"t_uint64": {"encoding": "inplace", "label": "uint64", "numberOfBytes": "8"},
+		"t_uint256": {"encoding": "inplace", "label": "uint256", "numberOfBytes": "32"},
+		"t_string_storage": {"encoding": "bytes", "label": "string", "numberOfBytes": "32"},
+		"t_array(t_uint256)dyn_storage": {"encoding": "dynamic_array", "label": "uint256[]", "numberOfBytes": "32", "base": "t_uint256"},
+		"t_array(t_int8)3_storage": {"encoding": "inplace", "label": "int8[3]", "numberOfBytes": "32", "base": "t_int8"},
+		"t_mapping(t_address,t_uint256)": {"encoding": "mapping", "label": "mapping(address => uint256)", "numberOfBytes": "32", "key": "t_address", "value": "t_uint256"},
+		"t_mapping(t_address,t_mapping(t_address,t_uint256))": {"encoding": "mapping", "label": "mapping(address => mapping(address => uint256))", "numberOfBytes": "32", "key": "t_address", "value": "t_mapping(t_address,t_uint256)"},
+		"t_struct(Config)1_storage": {"encoding": "inplace", "label": "struct Token.Config", "numberOfBytes": "64", "members": [
+			{"label": "cap", "offset": 0, "slot": "0", "type": "t_uint256"},
+			{"label": "paused", "offset": 0, "slot": "1", "type": "t_bool"},
+			{"label": "admin", "offset": 1, "slot": "1", "type": "t_address"}
+		]}
+	}
+}}`
+
+func TestStorageLayoutDecode(t *testing.T) {
+	layout, err := ParseStorageLayout([]byte(testLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		alice  = common.HexToAddress("0x1111111111111111111111111111111111111111")
+		bob    = common.HexToAddress("0x2222222222222222222222222222222222222222")
+		hashes = make(map[common.Hash][]byte)
+	)
+	hash := func(preimage ...[]byte) common.Hash {
+		data := bytes.Join(preimage, nil)
+		h := crypto.Keccak256Hash(data)
+		hashes[h] = data
+		return h
+	}
+	slotOf := func(n int64) []byte { return common.BigToHash(big.NewInt(n)).Bytes() }
+	plus := func(h common.Hash, n int64) common.Hash {
+		return common.BigToHash(new(big.Int).Add(h.Big(), big.NewInt(n)))
+	}
+	balance := hash(common.LeftPadBytes(alice.Bytes(), 32), slotOf(1))
+	inner := hash(common.LeftPadBytes(alice.Bytes(), 32), slotOf(6))
+	allowance := hash(common.LeftPadBytes(bob.Bytes(), 32), inner.Bytes())
+	list := hash(slotOf(4))
+	preimages := NewPreimages(hashes)
+
+	name := common.Hash{}
+	copy(name[:], "money")
+	name[31] = 2 * 5
+
+	tests := []struct {
+		slot, value common.Hash
+		want        []*StorageVar
+	}{
+		{common.Hash{}, common.HexToHash("0x" + "1111111111111111111111111111111111111111" + "0000000000000007"), []*StorageVar{
+			{Name: "small", Type: "uint64", Value: "7"},
+			{Name: "owner", Type: "address", Offset: 8, Value: alice.Hex()},
+		}},
+		{balance, common.BigToHash(big.NewInt(100)), []*StorageVar{
+			{Name: "balances[" + alice.Hex() + "]", Type: "uint256", Value: "100"},
+		}},
+		{common.BigToHash(big.NewInt(3)), common.HexToHash("0x" + "1111111111111111111111111111111111111111" + "01"), []*StorageVar{
+			{Name: "config.paused", Type: "bool", Value: "true"},
+			{Name: "config.admin", Type: "address", Offset: 1, Value: alice.Hex()},
+		}},
+		{common.BigToHash(big.NewInt(4)), common.BigToHash(big.NewInt(2)), []*StorageVar{
+			{Name: "list.length", Type: "uint256", Value: "2"},
+		}},
+		{plus(list, 1), common.BigToHash(big.NewInt(5)), []*StorageVar{
+			{Name: "list[1]", Type: "uint256", Value: "5"},
+		}},
+		{common.BigToHash(big.NewInt(5)), name, []*StorageVar{
+			{Name: "name", Type: "string", Value: `"money"`},
+		}},
+		{allowance, common.BigToHash(big.NewInt(1)), []*StorageVar{
+			{Name: "allowance[" + alice.Hex() + "][" + bob.Hex() + "]", Type: "uint256", Value: "1"},
+		}},
+		{common.BigToHash(big.NewInt(7)), common.HexToHash("0xff0201"), []*StorageVar{
+			{Name: "flags[0]", Type: "int8", Value: "1"},
+			{Name: "flags[1]", Type: "int8", Offset: 1, Value: "2"},
+			{Name: "flags[2]", Type: "int8", Offset: 2, Value: "-1"},
+		}},
+		// an even last byte past the short string length is no string
+		{common.BigToHash(big.NewInt(5)), common.BigToHash(big.NewInt(0x50)), []*StorageVar{
+			{Name: "name", Type: "string", Value: common.BigToHash(big.NewInt(0x50)).Hex()},
+		}},
+		// not derived from a recorded preimage
+		{crypto.Keccak256Hash([]byte("unknown")), common.BigToHash(big.NewInt(1)), nil},
+	}
+	for _, test := range tests {
+		got := layout.Decode(test.slot, test.value, preimages)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Decode(%s) = %s, want %s", test.slot.Hex(), dumpVars(got), dumpVars(test.want))
+		}
+	}
+}
+
+func TestParseStorageLayoutErrors(t *testing.T) {
+	layout := func(storage, types string) string {
+		return `{"storage": [` + storage + `], "types": {"t_uint256": {"encoding": "inplace", "label": "uint256", "numberOfBytes": "32"}` + types + `}}`
+	}
+	tests := []struct {
+		name, layout string
+	}{
+		{"negative offset", layout(`{"label": "a", "offset": -1, "slot": "0", "type": "t_uint256"}`, "")},
+		{"offset past the slot", layout(`{"label": "a", "offset": 1, "slot": "0", "type": "t_uint256"}`, "")},
+		{"offset of a struct", layout(`{"label": "a", "offset": 4, "slot": "0", "type": "t_struct"}`,
+			`, "t_struct": {"encoding": "inplace", "label": "struct S", "numberOfBytes": "32", "members": [{"label": "b", "offset": 0, "slot": "0", "type": "t_uint256"}]}`)},
+		{"offset of a member", layout("",
+			`, "t_struct": {"encoding": "inplace", "label": "struct S", "numberOfBytes": "32", "members": [{"label": "b", "offset": 40, "slot": "0", "type": "t_uint256"}]}`)},
+		{"value wider than a slot", layout(`{"label": "a", "offset": 0, "slot": "0", "type": "t_wide"}`,
+			`, "t_wide": {"encoding": "inplace", "label": "uint512", "numberOfBytes": "64"}`)},
+		{"string not a slot", layout(`{"label": "a", "offset": 0, "slot": "0", "type": "t_string"}`,
+			`, "t_string": {"encoding": "bytes", "label": "string", "numberOfBytes": "16"}`)},
+		{"struct key", layout("",
+			`, "t_struct": {"encoding": "inplace", "label": "struct S", "numberOfBytes": "64", "members": []}, "t_mapping": {"encoding": "mapping", "label": "mapping", "numberOfBytes": "32", "key": "t_struct", "value": "t_uint256"}`)},
+		{"unknown encoding", layout("", `, "t_x": {"encoding": "packed", "label": "x", "numberOfBytes": "32"}`)},
+	}
+	for _, test := range tests {
+		if _, err := ParseStorageLayout([]byte(test.layout)); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func dumpVars(vars []*StorageVar) string {
+	s := "["
+	for _, v := range vars {
+		s += v.Name + " " + v.Type + " " + v.Value + "; "
+	}
+	return s + "]"
+}
+
+func TestRegistryLayout(t *testing.T) {
+	var (
+		token    = common.HexToAddress("0x1111111111111111111111111111111111111111")
+		codeHash = common.HexToHash("0xc0de")
+	)
+	r := NewRegistry()
+	if err := r.Register(Contract{CodeHash: &codeHash, StorageLayout: []byte(testLayout)}); err != nil {
+		t.Fatal(err)
+	}
+	if r.Layout(token, common.Hash{}) != nil || r.Layout(token, codeHash) == nil || !r.HasLayouts() {
+		t.Error("layout not registered by code hash")
+	}
+	if err := r.Register(Contract{CodeHash: &codeHash, Label: "Token"}); err == nil {
+		t.Error("label registered by code hash")
+	}
+	// an artifact holding the ABI and the layout registers both
+	artifact := `{"abi": [], ` + testLayout[1:]
+	if err := r.Register(Contract{Address: token, ABI: []byte(artifact)}); err != nil {
+		t.Fatal(err)
+	}
+	if r.Layout(token, common.Hash{}) == nil {
+		t.Error("layout of the artifact not registered")
+	}
+	if err := r.Register(Contract{Address: token, StorageLayout: []byte(`{"storage": [{"label": "x", "slot": "0", "type": "t_missing"}], "types": {}}`)}); err == nil {
+		t.Error("layout with an unknown type registered")
+	}
+}
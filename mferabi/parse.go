@@ -0,0 +1,218 @@
+package mferabi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// signature kinds
+const (
+	kindFunction = "function"
+	kindEvent    = "event"
+	kindError    = "error"
+)
+
+// parseSignature parses a text signature, optionally prefixed by its kind
+// (function, event or error) and by its 4 byte selector or 32 byte topic:
+//
+//	0xa9059cbb transfer(address,uint256)
+//	event Transfer(address indexed from, address indexed to, uint256 value)
+//	error InsufficientBalance(uint256 available, uint256 required)
+//
+// A 32 byte prefix makes it an event. Parameter names and the indexed keyword
+// are optional, events declared without indexed keywords are decoded assuming
+// their first parameters are the indexed ones.
+func parseSignature(line string) (string, *entry, error) {
+	line = strings.TrimSpace(line)
+	kind, prefix := "", ""
+	for {
+		word := line
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			word = line[:i]
+		}
+		if strings.Contains(word, "(") {
+			break
+		}
+		switch {
+		case word == kindFunction || word == kindEvent || word == kindError:
+			kind = word
+		case strings.HasPrefix(word, "0x") && prefix == "":
+			prefix = word
+		default:
+			return "", nil, fmt.Errorf("invalid signature %q", line)
+		}
+		line = strings.TrimSpace(line[len(word):])
+	}
+	if kind == "" {
+		kind = kindFunction
+		if len(prefix) == 2+2*common.HashLength {
+			kind = kindEvent
+		}
+	}
+
+	open := strings.Index(line, "(")
+	name := strings.TrimSpace(line[:open])
+	if !isIdentifier(name) {
+		return "", nil, fmt.Errorf("invalid name %q in %q", name, line)
+	}
+	end := matchingParen(line, open)
+	if end < 0 {
+		return "", nil, fmt.Errorf("unbalanced parentheses in %q", line)
+	}
+	e := &entry{name: name}
+	types := make([]string, 0)
+	for _, param := range splitParams(line[open+1 : end]) {
+		arg, indexed, named, err := parseParam(param, len(e.inputs))
+		if err != nil {
+			return "", nil, fmt.Errorf("%v in %q", err, line)
+		}
+		typ, err := abi.NewType(arg.Type, "", arg.Components)
+		if err != nil {
+			return "", nil, fmt.Errorf("%v in %q", err, line)
+		}
+		if !named {
+			arg.Name = ""
+		}
+		e.declared = e.declared || named || indexed
+		e.inputs = append(e.inputs, abi.Argument{Name: arg.Name, Type: typ, Indexed: indexed})
+		types = append(types, typ.String())
+	}
+	e.sig = name + "(" + strings.Join(types, ",") + ")"
+
+	if prefix != "" {
+		id := common.FromHex(prefix)
+		want := crypto.Keccak256([]byte(e.sig))
+		if kind != kindEvent {
+			want = want[:4]
+		}
+		if string(id) != string(want) {
+			return "", nil, fmt.Errorf("%s is not the id of %s", prefix, e.sig)
+		}
+	}
+	return kind, e, nil
+}
+
+// parseParam parses a parameter like "uint256", "address indexed from" or
+// "(address,uint256)[] orders". Unnamed parameters are named f0, f1... as
+// tuple components need a name, named reports whether one was given.
+func parseParam(param string, i int) (arg abi.ArgumentMarshaling, indexed, named bool, err error) {
+	param = strings.TrimSpace(param)
+	if param == "" {
+		return arg, false, false, fmt.Errorf("empty parameter")
+	}
+	typ, rest := param, ""
+	if strings.HasPrefix(param, "(") || strings.HasPrefix(param, "tuple(") {
+		open := strings.Index(param, "(")
+		end := matchingParen(param, open)
+		if end < 0 {
+			return arg, false, false, fmt.Errorf("unbalanced parentheses in %q", param)
+		}
+		suffix := param[end+1:]
+		if j := strings.IndexAny(suffix, " \t"); j >= 0 {
+			suffix, rest = suffix[:j], suffix[j:]
+		}
+		for j, component := range splitParams(param[open+1 : end]) {
+			c, _, _, err := parseParam(component, j)
+			if err != nil {
+				return arg, false, false, err
+			}
+			arg.Components = append(arg.Components, c)
+		}
+		typ = "tuple" + suffix
+	} else if j := strings.IndexAny(param, " \t"); j >= 0 {
+		typ, rest = param[:j], param[j:]
+	}
+	arg.Type = canonicalType(typ)
+	arg.Name = fmt.Sprintf("f%d", i)
+	for _, word := range strings.Fields(rest) {
+		switch word {
+		case "indexed":
+			indexed = true
+		case "memory", "calldata", "storage", "payable":
+		default:
+			if !isIdentifier(word) {
+				return arg, false, false, fmt.Errorf("invalid parameter %q", param)
+			}
+			arg.Name, named = word, true
+		}
+	}
+	return arg, indexed, named, nil
+}
+
+// canonicalType expands the int, uint and byte aliases, the selector is
+// computed from the canonical names.
+func canonicalType(typ string) string {
+	base, suffix := typ, ""
+	if i := strings.Index(typ, "["); i >= 0 {
+		base, suffix = typ[:i], typ[i:]
+	}
+	switch base {
+	case "uint":
+		base = "uint256"
+	case "int":
+		base = "int256"
+	case "byte":
+		base = "bytes1"
+	}
+	return base + suffix
+}
+
+// splitParams splits a parameter list at the commas outside of parentheses.
+func splitParams(params string) []string {
+	if strings.TrimSpace(params) == "" {
+		return nil
+	}
+	parts := make([]string, 0)
+	depth, start := 0, 0
+	for i, c := range params {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, params[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, params[start:])
+}
+
+// matchingParen returns the index of the parenthesis closing the one at open,
+// -1 if there is none.
+func matchingParen(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
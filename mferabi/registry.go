@@ -0,0 +1,220 @@
+package mferabi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Contract is the label, the ABI and the storage layout registered for an
+// address. ABI is a json ABI or a build artifact with an "abi" field,
+// StorageLayout the solc storageLayout or an artifact holding one. A contract
+// with a CodeHash only has its storage layout, registered for every account
+// running that code. Diamond marks an EIP-2535 diamond, the facets of its
+// selectors are looked up through its loupe.
+type Contract struct {
+	Address       common.Address  `json:"address,omitempty"`
+	CodeHash      *common.Hash    `json:"codeHash,omitempty"`
+	Label         string          `json:"label,omitempty"`
+	ABI           json.RawMessage `json:"abi,omitempty"`
+	StorageLayout json.RawMessage `json:"storageLayout,omitempty"`
+	Diamond       bool            `json:"diamond,omitempty"`
+
+	signatures *SignatureDB
+	layout     *StorageLayout
+}
+
+// Registry holds the contracts registered by address and the storage layouts
+// registered by code hash.
+type Registry struct {
+	mutex     sync.RWMutex
+	contracts map[common.Address]*Contract
+	layouts   map[common.Hash]*StorageLayout
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		contracts: make(map[common.Address]*Contract),
+		layouts:   make(map[common.Hash]*StorageLayout),
+	}
+}
+
+// Register records the label, the diamond mark, the ABI and the storage
+// layout of c.Address, an empty field keeps what was registered before. The
+// layout is also registered for c.CodeHash if it is set.
+func (r *Registry) Register(c Contract) error {
+	name := c.Address.Hex()
+	if c.Address == (common.Address{}) {
+		if c.CodeHash == nil {
+			return fmt.Errorf("contract without address or code hash")
+		}
+		if c.Label != "" || len(c.ABI) > 0 || c.Diamond {
+			return fmt.Errorf("code hash %s: only a storage layout is registered by code hash", c.CodeHash.Hex())
+		}
+		name = c.CodeHash.Hex()
+	}
+	var signatures *SignatureDB
+	if len(c.ABI) > 0 {
+		signatures = newSignatureDB()
+		if _, err := signatures.loadJSON(c.ABI); err != nil {
+			return fmt.Errorf("abi of %s: %v", name, err)
+		}
+	}
+	var layout *StorageLayout
+	if len(c.StorageLayout) > 0 {
+		var err error
+		if layout, err = ParseStorageLayout(c.StorageLayout); err != nil {
+			return fmt.Errorf("storage layout of %s: %v", name, err)
+		}
+	} else if len(c.ABI) > 0 {
+		// an artifact may hold the layout next to the ABI
+		layout, _ = ParseStorageLayout(c.ABI)
+	}
+	if c.CodeHash != nil && layout == nil {
+		return fmt.Errorf("code hash %s without storage layout", c.CodeHash.Hex())
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if c.CodeHash != nil {
+		r.layouts[*c.CodeHash] = layout
+	}
+	if c.Address == (common.Address{}) {
+		return nil
+	}
+	registered := &Contract{Address: c.Address}
+	if old, ok := r.contracts[c.Address]; ok {
+		*registered = *old
+	}
+	if c.Label != "" {
+		registered.Label = c.Label
+	}
+	if c.Diamond {
+		registered.Diamond = true
+	}
+	if signatures != nil {
+		registered.ABI, registered.signatures = c.ABI, signatures
+	}
+	if layout != nil {
+		registered.StorageLayout, registered.layout = c.StorageLayout, layout
+	}
+	r.contracts[c.Address] = registered
+	return nil
+}
+
+// Label is the label of address, empty if it has none.
+func (r *Registry) Label(address common.Address) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if c, ok := r.contracts[address]; ok {
+		return c.Label
+	}
+	return ""
+}
+
+// Diamond reports whether address is registered as an EIP-2535 diamond.
+func (r *Registry) Diamond(address common.Address) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if c, ok := r.contracts[address]; ok {
+		return c.Diamond
+	}
+	return false
+}
+
+// Signatures is the db of the ABI registered for address, nil if it has none.
+func (r *Registry) Signatures(address common.Address) *SignatureDB {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if c, ok := r.contracts[address]; ok {
+		return c.signatures
+	}
+	return nil
+}
+
+// Layout is the storage layout registered for address, or else for codeHash,
+// nil if there is none.
+func (r *Registry) Layout(address common.Address, codeHash common.Hash) *StorageLayout {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if c, ok := r.contracts[address]; ok && c.layout != nil {
+		return c.layout
+	}
+	return r.layouts[codeHash]
+}
+
+// HasLayouts reports whether a storage layout is registered.
+func (r *Registry) HasLayouts() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if len(r.layouts) > 0 {
+		return true
+	}
+	for _, c := range r.contracts {
+		if c.layout != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// HasABIs reports whether an ABI is registered for any address.
+func (r *Registry) HasABIs() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, c := range r.contracts {
+		if c.signatures != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the registered contracts ordered by address.
+func (r *Registry) List() []*Contract {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	contracts := make([]*Contract, 0, len(r.contracts))
+	for _, c := range r.contracts {
+		contracts = append(contracts, c)
+	}
+	sort.Slice(contracts, func(i, j int) bool {
+		return bytes.Compare(contracts[i].Address.Bytes(), contracts[j].Address.Bytes()) < 0
+	})
+	return contracts
+}
+
+// Load registers the contracts of the json files at paths, directories are
+// walked. A file holds one contract or a list of them. It returns the number
+// of contracts registered.
+func (r *Registry) Load(paths ...string) (int, error) {
+	registered := 0
+	err := walkFiles(paths, func(path string) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var contracts []Contract
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			err = json.Unmarshal(data, &contracts)
+		} else {
+			contracts = make([]Contract, 1)
+			err = json.Unmarshal(data, &contracts[0])
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		for _, c := range contracts {
+			if err := r.Register(c); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			registered++
+		}
+		return nil
+	})
+	return registered, err
+}
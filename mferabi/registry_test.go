@@ -0,0 +1,49 @@
+package mferabi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+	vault := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	contracts := `[{"address": "0x1111111111111111111111111111111111111111", "label": "Vault",
+		"abi": [{"type":"function","name":"sweep","inputs":[{"name":"to","type":"address"}],"outputs":[]}]}]`
+	if err := os.WriteFile(filepath.Join(dir, "vault.json"), []byte(contracts), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	if r.HasABIs() {
+		t.Error("empty registry has ABIs")
+	}
+	if n, err := r.Load(dir); err != nil || n != 1 {
+		t.Fatalf("Load = %d, %v", n, err)
+	}
+	if r.Label(vault) != "Vault" || !r.HasABIs() {
+		t.Errorf("label = %q", r.Label(vault))
+	}
+	data := append(crypto.Keccak256([]byte("sweep(address)"))[:4], common.LeftPadBytes(vault.Bytes(), 32)...)
+	if decoded := r.Signatures(vault).DecodeCall(data); decoded == nil || decoded.Args[0].Name != "to" {
+		t.Errorf("DecodeCall = %+v", decoded)
+	}
+	// the ABI of a registered contract is not known to other addresses
+	if r.Signatures(common.HexToAddress("0x2222222222222222222222222222222222222222")) != nil {
+		t.Error("unregistered address has an ABI")
+	}
+
+	// a label only registration keeps the ABI
+	if err := r.Register(Contract{Address: vault, Label: "Old vault"}); err != nil {
+		t.Fatal(err)
+	}
+	if r.Label(vault) != "Old vault" || r.Signatures(vault) == nil {
+		t.Error("relabeling dropped the ABI")
+	}
+	if err := r.Register(Contract{Address: vault, ABI: []byte(`[{"type":"bogus"}]`)}); err == nil {
+		t.Error("invalid ABI registered")
+	}
+}
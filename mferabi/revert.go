@@ -0,0 +1,63 @@
+package mferabi
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	errorSignature = "Error(string)"
+	panicSignature = "Panic(uint256)"
+)
+
+// panicReasons are the codes of the Panic(uint256) raised by solidity >= 0.8.
+var panicReasons = map[uint64]string{
+	0x00: "generic compiler panic",
+	0x01: "assertion failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "conversion to an invalid enum value",
+	0x22: "incorrectly encoded storage byte array",
+	0x31: "pop on an empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to a zero initialized function",
+}
+
+// PanicReason explains a panic code.
+func PanicReason(code *big.Int) string {
+	if code.IsUint64() {
+		if reason, ok := panicReasons[code.Uint64()]; ok {
+			return reason
+		}
+	}
+	return "unknown panic code"
+}
+
+// RevertMessage is the reason of an Error(string), the explained code of a
+// Panic(uint256) or the custom error with its arguments.
+func (d *Decoded) RevertMessage() string {
+	switch d.Signature {
+	case errorSignature:
+		if reason, ok := d.Args[0].Value.(string); ok {
+			return reason
+		}
+	case panicSignature:
+		if code, ok := d.Args[0].Value.(string); ok {
+			if n, ok := new(big.Int).SetString(code, 10); ok {
+				code = "0x" + n.Text(16)
+			}
+			return fmt.Sprintf("panic %s (%s)", code, d.Reason)
+		}
+	}
+	args := make([]string, len(d.Args))
+	for i, arg := range d.Args {
+		value := fmt.Sprint(arg.Value)
+		if arg.Name != "" {
+			value = arg.Name + "=" + value
+		}
+		args[i] = value
+	}
+	return d.Name + "(" + strings.Join(args, ", ") + ")"
+}
@@ -0,0 +1,53 @@
+package mferabi
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestRevertMessage(t *testing.T) {
+	db := NewSignatureDB()
+	if _, err := db.AddSignature("error InsufficientBalance(uint256 available, uint256 required)"); err != nil {
+		t.Fatal(err)
+	}
+	uint256, _ := abi.NewType("uint256", "", nil)
+	stringType, _ := abi.NewType("string", "", nil)
+	encode := func(sig string, typ abi.Type, values ...interface{}) []byte {
+		args := make(abi.Arguments, len(values))
+		for i := range args {
+			args[i] = abi.Argument{Type: typ}
+		}
+		packed, err := args.Pack(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(crypto.Keccak256([]byte(sig))[:4], packed...)
+	}
+
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{encode("Error(string)", stringType, "not owner"), "not owner"},
+		{encode("Panic(uint256)", uint256, big.NewInt(0x11)), "panic 0x11 (arithmetic overflow or underflow)"},
+		{encode("Panic(uint256)", uint256, big.NewInt(0x99)), "panic 0x99 (unknown panic code)"},
+		{encode("InsufficientBalance(uint256,uint256)", uint256, big.NewInt(1), big.NewInt(2)), "InsufficientBalance(available=1, required=2)"},
+	}
+	for _, tt := range tests {
+		decoded := db.DecodeError(tt.data)
+		if decoded == nil {
+			t.Errorf("DecodeError(%x) = nil", tt.data)
+			continue
+		}
+		if got := decoded.RevertMessage(); got != tt.want {
+			t.Errorf("RevertMessage() = %q, want %q", got, tt.want)
+		}
+	}
+	if reason := PanicReason(new(big.Int).Lsh(common.Big1, 70)); reason != "unknown panic code" {
+		t.Errorf("PanicReason(2^70) = %q", reason)
+	}
+}
@@ -0,0 +1,353 @@
+package mferauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kataras/golog"
+	"github.com/sec-bit/mfer-node/mferconfig"
+)
+
+// maxRequestContentLength matches the request size limit of the rpc server.
+const maxRequestContentLength = 1024 * 1024 * 5
+
+const (
+	errCodeParse        = -32700
+	errCodeUnauthorized = -32001
+	errCodeForbidden    = -32002
+)
+
+// readOnlyMethods are the methods a read-only key may call. They read the
+// simulated state or the node settings and run calls on copies of the state,
+// without replaying the pool.
+var readOnlyMethods = map[string]bool{
+	"eth_accounts":                            true,
+	"eth_blockNumber":                         true,
+	"eth_call":                                true,
+	"eth_callLocal":                           true,
+	"eth_callPassthrough":                     true,
+	"eth_chainId":                             true,
+	"eth_createAccessList":                    true,
+	"eth_estimateGas":                         true,
+	"eth_feeHistory":                          true,
+	"eth_gasPrice":                            true,
+	"eth_getBalance":                          true,
+	"eth_getBlockByHash":                      true,
+	"eth_getBlockByNumber":                    true,
+	"eth_getBlockTransactionCountByHash":      true,
+	"eth_getBlockTransactionCountByNumber":    true,
+	"eth_getCode":                             true,
+	"eth_getLogs":                             true,
+	"eth_getStorageAt":                        true,
+	"eth_getTransactionByBlockHashAndIndex":   true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getTransactionByHash":                true,
+	"eth_getTransactionCount":                 true,
+	"eth_getTransactionReceipt":               true,
+	"eth_requestAccounts":                     true,
+	"eth_simulateV1":                          true,
+	"eth_syncing":                             true,
+	"net_listening":                           true,
+	"net_peerCount":                           true,
+	"net_version":                             true,
+	"web3_clientVersion":                      true,
+	"web3_sha3":                               true,
+	"debug_preimage":                          true,
+	"mfer_getApprovalRisks":                   true,
+	"mfer_getAssetFlows":                      true,
+	"mfer_getBlockNumberDelta":                true,
+	"mfer_getSafeOwnersAndThreshold":          true,
+	"mfer_getStateDiff":                       true,
+	"mfer_getTimeDelta":                       true,
+	"mfer_getTxs":                             true,
+	"mfer_impersonatedAccount":                true,
+	"mfer_listContracts":                      true,
+	"mfer_passthroughEnabled":                 true,
+	"mfer_passthroughSupported":               true,
+	"mfer_randAddrEnabled":                    true,
+	"mfer_resolveProxy":                       true,
+	"mfer_shadowEnabled":                      true,
+}
+
+// IsReadOnly reports whether method is allowed for read-only keys. A method
+// not listed is taken to change the simulated state or the settings of the
+// node, so new methods stay blocked until they are added.
+func IsReadOnly(method string) bool {
+	return readOnlyMethods[method]
+}
+
+// Policy is the set of methods a key may call.
+type Policy struct {
+	Name       string
+	Namespaces map[string]bool
+	Methods    map[string]bool
+	ReadOnly   bool
+}
+
+func newPolicy(key mferconfig.AuthKey) *Policy {
+	p := &Policy{
+		Name:       key.Name,
+		Namespaces: make(map[string]bool),
+		Methods:    make(map[string]bool),
+		ReadOnly:   key.ReadOnly,
+	}
+	for _, namespace := range key.Namespaces {
+		p.Namespaces[namespace] = true
+	}
+	for _, method := range key.Methods {
+		p.Methods[method] = true
+	}
+	return p
+}
+
+// Allow returns why method is denied, nil if it is allowed.
+func (p *Policy) Allow(method string) error {
+	if p.ReadOnly && !IsReadOnly(method) {
+		return fmt.Errorf("method %s is not allowed for read-only key '%s'", method, p.Name)
+	}
+	if len(p.Namespaces) == 0 && len(p.Methods) == 0 {
+		return nil
+	}
+	namespace := strings.SplitN(method, "_", 2)[0]
+	if p.Namespaces[namespace] || p.Methods[method] {
+		return nil
+	}
+	return fmt.Errorf("method %s is not allowed for key '%s'", method, p.Name)
+}
+
+// fullAccess is the policy of JWTs without a sub claim.
+var fullAccess = &Policy{Name: "jwt"}
+
+// Auth authenticates rpc requests by API key or JWT and checks the requested
+// methods against the policy of the key.
+type Auth struct {
+	keys      map[string]*Policy
+	policies  map[string]*Policy
+	jwtSecret []byte
+	audit     *log.Logger
+}
+
+// New loads the keys, the JWT secret and opens the audit log of cfg.
+func New(cfg *mferconfig.AuthConfig) (*Auth, error) {
+	a := &Auth{
+		keys:     make(map[string]*Policy),
+		policies: make(map[string]*Policy),
+	}
+	for _, key := range cfg.Keys {
+		policy := newPolicy(key)
+		a.policies[key.Name] = policy
+		if key.Key != "" {
+			a.keys[key.Key] = policy
+		}
+	}
+	if cfg.JWTSecret != "" {
+		secret, err := readJWTSecret(cfg.JWTSecret)
+		if err != nil {
+			return nil, err
+		}
+		a.jwtSecret = secret
+	}
+	if cfg.AuditLog != "" {
+		f, err := os.OpenFile(cfg.AuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		a.audit = log.New(f, "", 0)
+	}
+	return a, nil
+}
+
+// readJWTSecret reads the hex encoded 32 bytes secret, the same format as the
+// authrpc.jwtsecret file of geth.
+func readJWTSecret(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("jwt secret %s: %v", file, err)
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("jwt secret %s: want 32 bytes, got %d", file, len(secret))
+	}
+	return secret, nil
+}
+
+// credential returns the bearer token or the apikey query of the request.
+func credential(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return r.URL.Query().Get("apikey")
+}
+
+// authenticate resolves the policy of the credential, API keys first, then
+// JWTs signed with the secret. The sub claim of a JWT names the key whose
+// policy applies, JWTs without sub have full access.
+func (a *Auth) authenticate(token string) (*Policy, error) {
+	if token == "" {
+		return nil, errors.New("missing api key or jwt")
+	}
+	if policy, ok := a.keys[token]; ok {
+		return policy, nil
+	}
+	if a.jwtSecret == nil {
+		return nil, errors.New("invalid api key")
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return a.jwtSecret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid api key or jwt: %v", err)
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return fullAccess, nil
+	}
+	policy, ok := a.policies[sub]
+	if !ok {
+		return nil, fmt.Errorf("jwt sub '%s' is not a configured key", sub)
+	}
+	return policy, nil
+}
+
+type jsonrpcMessage struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Error   *jsonError      `json:"error,omitempty"`
+}
+
+type jsonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// parseMessages returns the calls of a single or batch request, ok is false
+// when the body is not a single valid json value.
+func parseMessages(body []byte) (msgs []*jsonrpcMessage, batch bool, ok bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &msgs); err != nil {
+			return nil, true, false
+		}
+		return msgs, true, true
+	}
+	msg := new(jsonrpcMessage)
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, false, false
+	}
+	return []*jsonrpcMessage{msg}, false, true
+}
+
+// Handler rejects unauthenticated requests, requests other than POST but for
+// the bodiless GET and OPTIONS, bodies that are not a single json-rpc request
+// or batch and requests calling a method the key is not allowed to, a batch is
+// rejected as a whole. Denied calls are written to the audit log.
+func (a *Auth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			// the rpc server runs the calls of any method with a body, only
+			// the bodiless GET health probe and CORS preflights pass
+			if (r.Method == http.MethodGet || r.Method == http.MethodOptions) && r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRequestContentLength+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		msgs, batch, ok := parseMessages(body)
+
+		policy, err := a.authenticate(credential(r))
+		if err != nil {
+			a.deny(w, r, "", msgs, batch, http.StatusUnauthorized, errCodeUnauthorized, err)
+			return
+		}
+		if !ok {
+			// the rpc server would run the first value of the body and skip
+			// the rest unchecked
+			a.deny(w, r, policy.Name, nil, false, http.StatusBadRequest, errCodeParse, errors.New("parse error"))
+			return
+		}
+		for _, msg := range msgs {
+			if err := policy.Allow(msg.Method); err != nil {
+				a.deny(w, r, policy.Name, msgs, batch, http.StatusForbidden, errCodeForbidden, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, policy.Name)))
+	})
+}
+
+type clientKey struct{}
+
+// Client is the name of the key a request passed by Handler authenticated
+// with, the rpc server hands the request context on to the method. ok is false
+// for requests Handler did not authenticate.
+func Client(ctx context.Context) (name string, ok bool) {
+	name, ok = ctx.Value(clientKey{}).(string)
+	return name, ok
+}
+
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Remote  string    `json:"remote"`
+	Path    string    `json:"path"`
+	Key     string    `json:"key,omitempty"`
+	Methods []string  `json:"methods"`
+	Reason  string    `json:"reason"`
+}
+
+func (a *Auth) deny(w http.ResponseWriter, r *http.Request, key string, msgs []*jsonrpcMessage, batch bool, status, code int, reason error) {
+	entry := &auditEntry{
+		Time:    time.Now(),
+		Remote:  r.RemoteAddr,
+		Path:    r.URL.Path,
+		Key:     key,
+		Methods: make([]string, len(msgs)),
+		Reason:  reason.Error(),
+	}
+	for i, msg := range msgs {
+		entry.Methods[i] = msg.Method
+	}
+	golog.Warnf("[audit] denied %v from %s (key: %s): %v", entry.Methods, entry.Remote, key, reason)
+	if a.audit != nil {
+		line, _ := json.Marshal(entry)
+		a.audit.Println(string(line))
+	}
+
+	responses := make([]*jsonrpcMessage, len(msgs))
+	for i, msg := range msgs {
+		responses[i] = &jsonrpcMessage{Version: "2.0", ID: msg.ID, Error: &jsonError{Code: code, Message: reason.Error()}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	switch {
+	case batch:
+		json.NewEncoder(w).Encode(responses)
+	case len(responses) == 1:
+		json.NewEncoder(w).Encode(responses[0])
+	default:
+		json.NewEncoder(w).Encode(&jsonrpcMessage{Version: "2.0", ID: json.RawMessage("null"), Error: &jsonError{Code: code, Message: reason.Error()}})
+	}
+}
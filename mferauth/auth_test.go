@@ -0,0 +1,167 @@
+package mferauth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sec-bit/mfer-node/mferconfig"
+)
+
+const testSecret = "0x5d8f3bc0c2fa3c1b7e7e4f2c0e6f6f1f3bdb6b2b9d6a7e4e1d2c3b4a59687766"
+
+func newTestAuth(t *testing.T) (*Auth, string) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "jwt.hex")
+	if err := os.WriteFile(secretFile, []byte(testSecret), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auditLog := filepath.Join(dir, "audit.log")
+	a, err := New(&mferconfig.AuthConfig{
+		JWTSecret: secretFile,
+		AuditLog:  auditLog,
+		Keys: []mferconfig.AuthKey{
+			{Name: "admin", Key: "admin-key"},
+			{Name: "viewer", Key: "viewer-key", ReadOnly: true},
+			{Name: "bundler", Key: "bundler-key", Namespaces: []string{"eth"}, Methods: []string{"mfer_traceTransactionBundle"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, auditLog
+}
+
+func call(handler http.Handler, credential, body string) (int, []byte) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	if credential != "" {
+		r.Header.Set("Authorization", "Bearer "+credential)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code, w.Body.Bytes()
+}
+
+func TestHandler(t *testing.T) {
+	a, auditLog := newTestAuth(t)
+	handler := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _ := Client(r.Context())
+		w.Write([]byte(client))
+	}))
+
+	secret := a.jwtSecret
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	expired := sign(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})
+	viewerJWT := sign(jwt.MapClaims{"sub": "viewer"})
+
+	single := func(method string) string {
+		return `{"jsonrpc":"2.0","id":7,"method":"` + method + `","params":[]}`
+	}
+	cases := []struct {
+		credential, body string
+		status           int
+	}{
+		{"", single("eth_chainId"), http.StatusUnauthorized},
+		{"wrong", single("eth_chainId"), http.StatusUnauthorized},
+		{expired, single("eth_chainId"), http.StatusUnauthorized},
+		{"admin-key", single("mfer_resetState"), http.StatusOK},
+		{sign(jwt.MapClaims{}), single("mfer_resetState"), http.StatusOK},
+		{"viewer-key", single("eth_call"), http.StatusOK},
+		{"viewer-key", single("eth_sendRawTransaction"), http.StatusForbidden},
+		{viewerJWT, single("mfer_impersonate"), http.StatusForbidden},
+		{"bundler-key", single("eth_getBalance"), http.StatusOK},
+		{"bundler-key", single("mfer_traceTransactionBundle"), http.StatusOK},
+		{"bundler-key", single("mfer_traceBlockByNumberRange"), http.StatusForbidden},
+		{"bundler-key", `[` + single("eth_chainId") + `,` + single("debug_traceTransaction") + `]`, http.StatusForbidden},
+		// the stateful methods are not listed as read-only
+		{"viewer-key", single("mfer_debugTransaction"), http.StatusForbidden},
+		{"viewer-key", single("mfer_poolStateDiff"), http.StatusForbidden},
+		{"viewer-key", single("mfer_verifyBlockRange"), http.StatusForbidden},
+		{"viewer-key", single("debug_traceTransaction"), http.StatusForbidden},
+		{"viewer-key", single("debug_storageRangeAt"), http.StatusForbidden},
+		{"viewer-key", single("mfer_getTxs"), http.StatusOK},
+		// trailing bytes would be skipped by the rpc server
+		{"viewer-key", single("mfer_resetState") + ` x`, http.StatusBadRequest},
+		{"admin-key", `{"jsonrpc":`, http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		if status, body := call(handler, c.credential, c.body); status != c.status {
+			t.Errorf("case %d: got %d (%s), want %d", i, status, body, c.status)
+		}
+	}
+
+	// the key is handed on, also when named by the sub of a jwt
+	for credential, want := range map[string]string{"viewer-key": "viewer", viewerJWT: "viewer", sign(jwt.MapClaims{}): "jwt"} {
+		if _, body := call(handler, credential, single("eth_chainId")); string(body) != want {
+			t.Errorf("client %s, want %s", body, want)
+		}
+	}
+
+	_, body := call(handler, "viewer-key", single("eth_chainId")+` x`)
+	var parseResp jsonrpcMessage
+	if err := json.Unmarshal(body, &parseResp); err != nil {
+		t.Fatal(err)
+	}
+	if string(parseResp.ID) != "null" || parseResp.Error == nil || parseResp.Error.Code != errCodeParse {
+		t.Fatalf("unexpected parse error response: %s", body)
+	}
+
+	_, body = call(handler, "viewer-key", single("mfer_clearTxPool"))
+	var resp jsonrpcMessage
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.ID) != "7" || resp.Error == nil || resp.Error.Code != errCodeForbidden {
+		t.Fatalf("unexpected error response: %s", body)
+	}
+
+	audit, err := ioutil.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(audit), "\n"); lines != 16 {
+		t.Fatalf("expected 16 audit entries, got %d:\n%s", lines, audit)
+	}
+}
+
+func TestHandlerMethods(t *testing.T) {
+	a, _ := newTestAuth(t)
+	handler := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	body := `{"jsonrpc":"2.0","id":1,"method":"mfer_resetState","params":[]}`
+	cases := []struct {
+		method, body string
+		status       int
+	}{
+		// the health probe and the CORS preflight carry no call
+		{"GET", "", http.StatusOK},
+		{"OPTIONS", "", http.StatusOK},
+		// the rpc server would run these calls
+		{"GET", body, http.StatusMethodNotAllowed},
+		{"PATCH", body, http.StatusMethodNotAllowed},
+		{"OPTIONS", body, http.StatusMethodNotAllowed},
+		{"PUT", body, http.StatusMethodNotAllowed},
+		{"POST", body, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, "/", strings.NewReader(c.body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s with %d bytes: got %d, want %d", c.method, len(c.body), w.Code, c.status)
+		}
+	}
+}
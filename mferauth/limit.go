@@ -0,0 +1,60 @@
+package mferauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const errCodeTooManyRequests = -32005
+
+// clientID tells clients apart by the key Handler authenticated them with, or
+// by their address without one. The raw credential is no id, a client would
+// get a fresh limit with every token it makes up.
+func clientID(r *http.Request) string {
+	if name, ok := Client(r.Context()); ok {
+		return "key " + name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "address " + r.RemoteAddr
+	}
+	return "address " + host
+}
+
+// ConcurrencyLimit rejects the requests of a client while it already has max
+// requests in flight. It goes inside Handler, so authenticated clients are
+// limited per key.
+func ConcurrencyLimit(max int, next http.Handler) http.Handler {
+	var (
+		mutex    sync.Mutex
+		inflight = make(map[string]int)
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientID(r)
+		mutex.Lock()
+		if inflight[client] >= max {
+			mutex.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(&jsonrpcMessage{
+				Version: "2.0",
+				ID:      json.RawMessage("null"),
+				Error:   &jsonError{Code: errCodeTooManyRequests, Message: fmt.Sprintf("too many concurrent requests (limit %d per client)", max)},
+			})
+			return
+		}
+		inflight[client]++
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			if inflight[client]--; inflight[client] == 0 {
+				delete(inflight, client)
+			}
+			mutex.Unlock()
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
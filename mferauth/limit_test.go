@@ -0,0 +1,73 @@
+package mferauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	a, _ := newTestAuth(t)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	limited := ConcurrencyLimit(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Slow") != "" {
+			close(started)
+			<-release
+		}
+	}))
+	serve := func(handler http.Handler, credential, remote string, slow bool) int {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`))
+		r.RemoteAddr = remote + ":1234"
+		if credential != "" {
+			r.Header.Set("Authorization", "Bearer "+credential)
+		}
+		if slow {
+			r.Header.Set("X-Slow", "1")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	check := func(handler http.Handler, busy func() int, others map[string]func() int) {
+		done := make(chan int)
+		started = make(chan struct{})
+		release = make(chan struct{})
+		go func() { done <- serve(handler, "admin-key", "10.0.0.1", true) }()
+		<-started
+		if code := busy(); code != http.StatusTooManyRequests {
+			t.Errorf("second request of the client: got %d, want %d", code, http.StatusTooManyRequests)
+		}
+		for name, other := range others {
+			if code := other(); code != http.StatusOK {
+				t.Errorf("request of %s: got %d, want %d", name, code, http.StatusOK)
+			}
+		}
+		close(release)
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("first request: got %d", code)
+		}
+	}
+
+	// authenticated clients are limited per key, whatever token they send
+	secret := a.jwtSecret
+	adminJWT, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "admin"}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authed := a.Handler(limited)
+	check(authed, func() int { return serve(authed, adminJWT, "10.0.0.2", false) }, map[string]func() int{
+		"another key": func() int { return serve(authed, "viewer-key", "10.0.0.1", false) },
+	})
+	if code := serve(authed, "made-up", "10.0.0.1", false); code != http.StatusUnauthorized {
+		t.Errorf("made up credential: got %d", code)
+	}
+
+	// anonymous clients are limited per address, a header does not reset it
+	check(limited, func() int { return serve(limited, "other", "10.0.0.1", false) }, map[string]func() int{
+		"another address": func() int { return serve(limited, "admin-key", "10.0.0.2", false) },
+	})
+}
@@ -0,0 +1,96 @@
+package mferbackend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/eth/tracers/logger"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// accessListResult is the result of eth_createAccessList, with the gas the
+// call uses without any access list next to the gas it uses with the list.
+type accessListResult struct {
+	Accesslist               *types.AccessList `json:"accessList"`
+	Error                    string            `json:"error,omitempty"`
+	GasUsed                  hexutil.Uint64    `json:"gasUsed"`
+	GasUsedWithoutAccessList hexutil.Uint64    `json:"gasUsedWithoutAccessList"`
+}
+
+// CreateAccessList creates an EIP-2930 access list for the call, executed on
+// the fork including the pool txs. Like geth, the sender, the recipient and
+// the precompiles are left out of the list.
+func (s *EthAPI) CreateAccessList(ctx context.Context, args TransactionArgs, blockNrOrHash *rpc.BlockNumberOrHash) (interface{}, error) {
+	bNrOrHash := rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber)
+	if blockNrOrHash != nil {
+		bNrOrHash = *blockNrOrHash
+	}
+	if !s.b.isLocalBlock(bNrOrHash) {
+		var result json.RawMessage
+		if err := s.b.upstreamCall(ctx, &result, "eth_createAccessList", toCallArg(args), blockArg(bNrOrHash)); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	args = s.preprocessArgs(args)
+	from := args.from()
+	if args.Nonce == nil {
+		nonce := hexutil.Uint64(s.b.EVM.StateDB.GetNonce(from))
+		args.Nonce = &nonce
+	}
+	to := crypto.CreateAddress(from, uint64(*args.Nonce))
+	if args.To != nil {
+		to = *args.To
+	}
+	precompiles := s.b.EVM.ActivePrecompiles()
+
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+
+	// Retrace the call until the access list converges, every added entry can
+	// change the path of the execution.
+	prevTracer := logger.NewAccessListTracer(nil, from, to, precompiles)
+	if args.AccessList != nil {
+		prevTracer = logger.NewAccessListTracer(*args.AccessList, from, to, precompiles)
+	}
+	result := &accessListResult{}
+	for {
+		accessList := prevTracer.AccessList()
+		args.AccessList = &accessList
+		msg, err := args.ToMessage(s.b.Limits.GasCap, nil)
+		if err != nil {
+			return nil, err
+		}
+		tracer := logger.NewAccessListTracer(accessList, from, to, precompiles)
+		res, err := s.b.EVM.DoCallWithTracer(ctx, &msg, tracer, s.b.EVM.StateDB.Clone())
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply transaction: %v", s.b.execError(err))
+		}
+		if tracer.Equal(prevTracer) {
+			result.Accesslist = &accessList
+			result.GasUsed = hexutil.Uint64(res.UsedGas)
+			if res.Err != nil {
+				result.Error = res.Err.Error()
+			}
+			break
+		}
+		prevTracer = tracer
+	}
+
+	args.AccessList = nil
+	msg, err := args.ToMessage(s.b.Limits.GasCap, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.b.EVM.DoCallWithTracer(ctx, &msg, nil, s.b.EVM.StateDB.Clone())
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply transaction: %v", s.b.execError(err))
+	}
+	result.GasUsedWithoutAccessList = hexutil.Uint64(res.UsedGas)
+	return result, nil
+}
@@ -0,0 +1,349 @@
+package mferbackend
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+// approval risk kinds
+const (
+	riskApprove           = "approve"
+	riskIncreaseAllowance = "increaseAllowance"
+	riskApprovalForAll    = "setApprovalForAll"
+	riskPermit2Approve    = "permit2Approve"
+	riskPermit2Permit     = "permit2Permit"
+	riskOwnership         = "transferOwnership"
+	riskRoleGrant         = "grantRole"
+	// an allowance raised in storage without an event
+	riskAllowanceStorage = "allowanceStorage"
+)
+
+// maxAllowanceSlot bounds the slots of the allowance mapping looked up in the
+// state diff.
+const maxAllowanceSlot = 64
+
+// minAddressKey is the smallest mapping key taken for an address, smaller
+// keys are the indexes and ids of other nested mappings.
+var minAddressKey = new(big.Int).Lsh(common.Big1, 64)
+
+var (
+	permit2Address = common.HexToAddress("0x000000000022D473030F116dDEE9F6B43aC78BA3")
+
+	approvalEventTopic            = crypto.Keccak256Hash([]byte("Approval(address,address,uint256)"))
+	approvalForAllEventTopic      = crypto.Keccak256Hash([]byte("ApprovalForAll(address,address,bool)"))
+	permit2ApprovalEventTopic     = crypto.Keccak256Hash([]byte("Approval(address,address,address,uint160,uint48)"))
+	permit2PermitEventTopic       = crypto.Keccak256Hash([]byte("Permit(address,address,address,uint160,uint48,uint48)"))
+	ownershipTransferredTopic     = crypto.Keccak256Hash([]byte("OwnershipTransferred(address,address)"))
+	ownershipTransferStartedTopic = crypto.Keccak256Hash([]byte("OwnershipTransferStarted(address,address)"))
+	roleGrantedEventTopic         = crypto.Keccak256Hash([]byte("RoleGranted(bytes32,address,address)"))
+
+	increaseAllowanceSelector = crypto.Keccak256([]byte("increaseAllowance(address,uint256)"))[:4]
+	allowanceSelector         = crypto.Keccak256([]byte("allowance(address,address)"))[:4]
+	permit2AllowanceSelector  = crypto.Keccak256([]byte("allowance(address,address,address)"))[:4]
+
+	maxUint160 = new(big.Int).Sub(new(big.Int).Lsh(common.Big1, 160), common.Big1)
+)
+
+// riskFlag is a grant made by the impersonated account. Owner is the owner of
+// the tokens, the previous owner or the sender of a role grant, Spender the
+// spender, operator, new owner or role member. Allowance is what the spender
+// may still take once the pool ran, read from the allowance slot in the state
+// diff when it is found there.
+type riskFlag struct {
+	Idx           *int            `json:"idx,omitempty"`
+	Kind          string          `json:"kind"`
+	Contract      common.Address  `json:"contract"`
+	Symbol        string          `json:"symbol,omitempty"`
+	Owner         common.Address  `json:"owner"`
+	Spender       common.Address  `json:"spender"`
+	SpenderLabel  string          `json:"spenderLabel,omitempty"`
+	Token         *common.Address `json:"token,omitempty"`
+	TokenID       *hexutil.Big    `json:"tokenId,omitempty"`
+	Amount        *hexutil.Big    `json:"amount,omitempty"`
+	Unlimited     bool            `json:"unlimited"`
+	Approved      *bool           `json:"approved,omitempty"`
+	Expiration    *hexutil.Uint64 `json:"expiration,omitempty"`
+	Role          *common.Hash    `json:"role,omitempty"`
+	Allowance     *hexutil.Big    `json:"allowance,omitempty"`
+	AllowanceSlot *common.Hash    `json:"allowanceSlot,omitempty"`
+}
+
+// riskCall is a call made by the account, used to tell increaseAllowance
+// from approve as both emit Approval.
+type riskCall struct {
+	to   common.Address
+	data []byte
+}
+
+func (c riskCall) selector(selector []byte) bool {
+	return len(c.data) >= 4 && string(c.data[:4]) == string(selector)
+}
+
+// poolRisks flags the grants of the pool txs sent by the impersonated account.
+func (a *assetAnalyzer) poolRisks(ctx context.Context) []*riskFlag {
+	txs, _ := a.b.TxPool.GetPoolTxs()
+	account := a.b.ImpersonatedAccount
+	flags := make([]*riskFlag, 0)
+	for i, tx := range txs {
+		if a.b.EVM.TxToMessage(tx).From() != account {
+			continue
+		}
+		receipt := a.stateDB.GetReceipt(tx.Hash())
+		if receipt == nil || len(receipt.Logs) == 0 {
+			continue
+		}
+		calls := []riskCall{{data: tx.Data()}}
+		if tx.To() != nil {
+			calls[0].to = *tx.To()
+		}
+		idx := i
+		for _, flag := range a.logRisks(ctx, account, receipt.Logs[:len(receipt.Logs)-1], calls) {
+			flag.Idx = &idx
+			flags = append(flags, flag)
+		}
+	}
+	return append(flags, a.storageRisks(ctx, account, flags)...)
+}
+
+// logRisks flags the approvals of account and the ownership and role grants in
+// logs, all emitted by txs sent by account.
+func (a *assetAnalyzer) logRisks(ctx context.Context, account common.Address, logs []*types.Log, calls []riskCall) []*riskFlag {
+	flags := make([]*riskFlag, 0)
+	for _, l := range logs {
+		if len(l.Topics) == 0 {
+			continue
+		}
+		flag := &riskFlag{Contract: l.Address}
+		switch {
+		case l.Topics[0] == approvalEventTopic && len(l.Topics) == 3 && len(l.Data) == 32:
+			flag.Kind = riskApprove
+			for _, c := range calls {
+				if c.to == l.Address && c.selector(increaseAllowanceSelector) {
+					flag.Kind = riskIncreaseAllowance
+				}
+			}
+			flag.Owner, flag.Spender = topicAddress(l.Topics[1]), topicAddress(l.Topics[2])
+			amount := new(big.Int).SetBytes(l.Data)
+			flag.Amount, flag.Unlimited = (*hexutil.Big)(amount), amount.Cmp(math.MaxBig256) == 0
+		case l.Topics[0] == approvalEventTopic && len(l.Topics) == 4:
+			flag.Kind = riskApprove
+			flag.Owner, flag.Spender = topicAddress(l.Topics[1]), topicAddress(l.Topics[2])
+			flag.TokenID = (*hexutil.Big)(l.Topics[3].Big())
+		case l.Topics[0] == approvalForAllEventTopic && len(l.Topics) == 3 && len(l.Data) == 32:
+			flag.Kind = riskApprovalForAll
+			flag.Owner, flag.Spender = topicAddress(l.Topics[1]), topicAddress(l.Topics[2])
+			approved := new(big.Int).SetBytes(l.Data).Sign() != 0
+			flag.Approved, flag.Unlimited = &approved, approved
+		case l.Address == permit2Address && (l.Topics[0] == permit2ApprovalEventTopic || l.Topics[0] == permit2PermitEventTopic) && len(l.Topics) == 4 && len(l.Data) >= 64:
+			flag.Kind = riskPermit2Approve
+			if l.Topics[0] == permit2PermitEventTopic {
+				flag.Kind = riskPermit2Permit
+			}
+			token := topicAddress(l.Topics[2])
+			flag.Owner, flag.Token, flag.Spender = topicAddress(l.Topics[1]), &token, topicAddress(l.Topics[3])
+			amount := new(big.Int).SetBytes(l.Data[:32])
+			expiration := hexutil.Uint64(new(big.Int).SetBytes(l.Data[32:64]).Uint64())
+			flag.Amount, flag.Unlimited, flag.Expiration = (*hexutil.Big)(amount), amount.Cmp(maxUint160) == 0, &expiration
+		case (l.Topics[0] == ownershipTransferredTopic || l.Topics[0] == ownershipTransferStartedTopic) && len(l.Topics) == 3:
+			flag.Kind = riskOwnership
+			flag.Owner, flag.Spender = topicAddress(l.Topics[1]), topicAddress(l.Topics[2])
+			flag.Unlimited = true
+		case l.Topics[0] == roleGrantedEventTopic && len(l.Topics) == 4:
+			flag.Kind = riskRoleGrant
+			role := l.Topics[1]
+			flag.Role, flag.Spender, flag.Owner = &role, topicAddress(l.Topics[2]), topicAddress(l.Topics[3])
+		default:
+			continue
+		}
+		// the grants of other holders and owners in the same tx are not
+		// grants of account
+		if flag.Owner != account {
+			continue
+		}
+		if flag.Token != nil {
+			flag.Allowance, flag.AllowanceSlot = a.allowance(ctx, l.Address, flag.Owner, *flag.Token, flag.Spender)
+		} else if flag.Amount != nil {
+			flag.Allowance, flag.AllowanceSlot = a.allowance(ctx, l.Address, flag.Owner, flag.Spender)
+		}
+		token := l.Address
+		if flag.Token != nil {
+			token = *flag.Token
+		}
+		flag.Symbol = a.tokenMeta(ctx, token).symbol
+		flag.SpenderLabel = a.b.Contracts.Label(flag.Spender)
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// allowance finds the allowance keyed by keys, the owner and the spender or
+// with Permit2 the owner, the token and the spender, in the state diff of
+// contract. It tries the mapping at the first slots in both the solidity and
+// the vyper layout. Without the slot the allowance is read with allowance().
+func (a *assetAnalyzer) allowance(ctx context.Context, contract common.Address, keys ...common.Address) (*hexutil.Big, *common.Hash) {
+	if account, ok := a.stateDiff()[contract]; ok && account.Slots() != nil {
+		for p := int64(0); p < maxAllowanceSlot; p++ {
+			solidity, vyper := common.BigToHash(big.NewInt(p)), common.BigToHash(big.NewInt(p))
+			for _, key := range keys {
+				padded := common.LeftPadBytes(key.Bytes(), 32)
+				solidity, vyper = crypto.Keccak256Hash(padded, solidity[:]), crypto.Keccak256Hash(vyper[:], padded)
+			}
+			for _, slot := range []common.Hash{solidity, vyper} {
+				if value, ok := account.Slots()[slot]; ok {
+					slot := slot
+					return (*hexutil.Big)(allowanceAmount(contract, value)), &slot
+				}
+			}
+		}
+	}
+	data, size := common.CopyBytes(allowanceSelector), 32
+	if len(keys) == 3 {
+		// Permit2 returns the amount, the expiration and the nonce
+		data, size = common.CopyBytes(permit2AllowanceSelector), 96
+	}
+	for _, key := range keys {
+		data = append(data, common.LeftPadBytes(key.Bytes(), 32)...)
+	}
+	if ret := a.staticCall(ctx, contract, data); len(ret) == size {
+		return (*hexutil.Big)(new(big.Int).SetBytes(ret[:32])), nil
+	}
+	return nil, nil
+}
+
+// allowanceAmount is the amount of an allowance slot, Permit2 packs the
+// expiration and the nonce above the amount.
+func allowanceAmount(contract common.Address, value common.Hash) *big.Int {
+	amount := value.Big()
+	if contract == permit2Address {
+		amount.And(amount, maxUint160)
+	}
+	return amount
+}
+
+func (a *assetAnalyzer) stateDiff() mferstate.StateOverride {
+	if a.diff == nil {
+		a.diff = a.stateDB.GetStateDiff()
+	}
+	return a.diff
+}
+
+// storageRisks flags the allowances of account raised in the state diff that
+// flags do not cover, the grants made without an event. An allowance slot is
+// told by the keccak preimages it was derived from: an entry of a nested
+// mapping at one of the first slots, keyed by account and then an address.
+func (a *assetAnalyzer) storageRisks(ctx context.Context, account common.Address, flags []*riskFlag) []*riskFlag {
+	type grant struct {
+		contract, token, spender common.Address
+	}
+	reported := make(map[grant]bool)
+	for _, flag := range flags {
+		g := grant{contract: flag.Contract, spender: flag.Spender}
+		if flag.Token != nil {
+			g.token = *flag.Token
+		}
+		reported[g] = true
+	}
+	var root *mferstate.OverlayStateDB
+	risks := make([]*riskFlag, 0)
+	for contract, override := range a.stateDiff() {
+		if override.Slots() == nil {
+			continue
+		}
+		depth := 2
+		if contract == permit2Address {
+			depth = 3
+		}
+		for slot, value := range override.Slots() {
+			keys := a.mappingKeys(slot, depth)
+			if keys == nil || keys[0] != account {
+				continue
+			}
+			flag := &riskFlag{Kind: riskAllowanceStorage, Contract: contract, Owner: account, Spender: keys[len(keys)-1]}
+			g := grant{contract: contract, spender: flag.Spender}
+			if depth == 3 {
+				flag.Token, g.token = &keys[1], keys[1]
+			}
+			if reported[g] {
+				continue
+			}
+			if root == nil {
+				root = a.b.EVM.StateDB.CloneFromRoot()
+			}
+			amount := allowanceAmount(contract, value)
+			if amount.Cmp(allowanceAmount(contract, root.GetState(contract, slot))) <= 0 {
+				continue
+			}
+			slot := slot
+			flag.Amount, flag.Allowance, flag.AllowanceSlot = (*hexutil.Big)(amount), (*hexutil.Big)(amount), &slot
+			if depth == 3 {
+				flag.Unlimited = amount.Cmp(maxUint160) == 0
+				expiration := hexutil.Uint64(new(big.Int).Rsh(value.Big(), 160).Uint64() & (1<<48 - 1))
+				flag.Expiration = &expiration
+			} else {
+				flag.Unlimited = amount.Cmp(math.MaxBig256) == 0
+			}
+			token := contract
+			if flag.Token != nil {
+				token = *flag.Token
+			}
+			flag.Symbol = a.tokenMeta(ctx, token).symbol
+			flag.SpenderLabel = a.b.Contracts.Label(flag.Spender)
+			risks = append(risks, flag)
+		}
+	}
+	sort.Slice(risks, func(i, j int) bool {
+		return bytes.Compare(risks[i].AllowanceSlot[:], risks[j].AllowanceSlot[:]) < 0
+	})
+	return risks
+}
+
+// mappingKeys unwinds slot into the address keys of the entry of a nested
+// mapping of depth levels it is, the outermost key first. It is nil if slot
+// is no such entry, or one of a mapping beyond the first slots. Solidity
+// derives an entry from the key and the slot, vyper from the slot and the
+// key.
+func (a *assetAnalyzer) mappingKeys(slot common.Hash, depth int) []common.Address {
+	for _, vyper := range []bool{false, true} {
+		keys := make([]common.Address, depth)
+		entry := slot
+		for i := depth - 1; i >= 0; i-- {
+			preimage := a.stateDB.Preimage(entry)
+			if len(preimage) != 64 {
+				break
+			}
+			key, parent := preimage[:32], preimage[32:]
+			if vyper {
+				key, parent = parent, key
+			}
+			if new(big.Int).SetBytes(key).Cmp(minAddressKey) < 0 || !bytes.Equal(key[:12], make([]byte, 12)) {
+				break
+			}
+			keys[i], entry = common.BytesToAddress(key), common.BytesToHash(parent)
+			if i == 0 && entry.Big().Cmp(big.NewInt(maxAllowanceSlot)) < 0 {
+				return keys
+			}
+		}
+	}
+	return nil
+}
+
+// safeExecRisks flags the grants of the Safe in the logs of its simulated
+// execution of the pool txs.
+func (a *assetAnalyzer) safeExecRisks(ctx context.Context, stateDB *mferstate.OverlayStateDB, logs []*types.Log, txData []*TxData) []*riskFlag {
+	a.stateDB, a.diff = stateDB, nil
+	calls := make([]riskCall, len(txData))
+	for i, tx := range txData {
+		calls[i] = riskCall{to: tx.To, data: tx.Data}
+	}
+	flags := a.logRisks(ctx, a.b.ImpersonatedAccount, logs, calls)
+	return append(flags, a.storageRisks(ctx, a.b.ImpersonatedAccount, flags)...)
+}
@@ -0,0 +1,171 @@
+package mferbackend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+func TestApprovalRisks(t *testing.T) {
+	var (
+		account  = testSender
+		other    = common.HexToAddress("0x0ddddddddddddddddddddddddddddddddddddddd")
+		tokenA   = common.HexToAddress("0xa000000000000000000000000000000000000001")
+		tokenB   = common.HexToAddress("0xb000000000000000000000000000000000000002")
+		nft      = common.HexToAddress("0xc000000000000000000000000000000000000003")
+		owned    = common.HexToAddress("0xd000000000000000000000000000000000000004")
+		spenderA = common.HexToAddress("0x5a00000000000000000000000000000000000001")
+		spenderB = common.HexToAddress("0x5b00000000000000000000000000000000000002")
+		spenderC = common.HexToAddress("0x5c00000000000000000000000000000000000003")
+		router   = common.HexToAddress("0x5d00000000000000000000000000000000000004")
+		role     = common.HexToHash("0x01")
+	)
+
+	// the allowance slots are keccak(spender . keccak(owner . p)) in solidity
+	// and keccak(keccak(p . owner) . spender) in vyper, Permit2 nests the
+	// token between the owner and the spender
+	preimages := make(map[common.Hash][]byte)
+	derive := func(vyper bool, p int64, keys ...common.Address) common.Hash {
+		h := common.BigToHash(big.NewInt(p))
+		for _, key := range keys {
+			data := append(common.LeftPadBytes(key.Bytes(), 32), h[:]...)
+			if vyper {
+				data = append(h.Bytes(), common.LeftPadBytes(key.Bytes(), 32)...)
+			}
+			h = crypto.Keccak256Hash(data)
+			preimages[h] = data
+		}
+		return h
+	}
+	var (
+		approvedA     = derive(false, 1, account, spenderA)
+		silentB       = derive(true, 3, account, spenderB)
+		loweredB      = derive(true, 3, account, spenderC)
+		othersB       = derive(true, 3, other, spenderB)
+		permit2Event  = derive(false, 1, account, tokenA, router)
+		permit2Silent = derive(false, 1, account, tokenB, router)
+	)
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		tokenB: {storage: map[common.Hash]common.Hash{loweredB: common.BigToHash(big.NewInt(1000))}},
+	})
+	for h, data := range preimages {
+		b.EVM.StateDB.AddPreimage(h, data)
+	}
+
+	// the state diff of the pool
+	expiration := new(big.Int).Lsh(big.NewInt(2000), 160)
+	packed := func(amount *big.Int) common.Hash {
+		return common.BigToHash(new(big.Int).Or(expiration, amount))
+	}
+	a := newAssetAnalyzer(b)
+	a.diff = mferstate.StateOverride{
+		tokenA: {StateDiff: &map[common.Hash]common.Hash{
+			approvedA: common.BigToHash(math.MaxBig256),
+		}},
+		tokenB: {StateDiff: &map[common.Hash]common.Hash{
+			silentB:  common.BigToHash(big.NewInt(500)),
+			loweredB: common.BigToHash(big.NewInt(10)),
+			othersB:  common.BigToHash(big.NewInt(7)),
+		}},
+		permit2Address: {StateDiff: &map[common.Hash]common.Hash{
+			permit2Event:  packed(big.NewInt(300)),
+			permit2Silent: packed(maxUint160),
+		}},
+	}
+
+	topic := func(address common.Address) common.Hash { return common.BytesToHash(address.Bytes()) }
+	word := func(n *big.Int) []byte { return common.BigToHash(n).Bytes() }
+	logs := []*types.Log{
+		{Address: tokenA, Topics: []common.Hash{approvalEventTopic, topic(account), topic(spenderA)}, Data: word(math.MaxBig256)},
+		// the approval of another holder in the same tx
+		{Address: tokenA, Topics: []common.Hash{approvalEventTopic, topic(other), topic(spenderA)}, Data: word(big.NewInt(1))},
+		// not in the state diff, read with allowance()
+		{Address: tokenB, Topics: []common.Hash{approvalEventTopic, topic(account), topic(spenderA)}, Data: word(big.NewInt(10))},
+		{Address: nft, Topics: []common.Hash{approvalEventTopic, topic(account), topic(spenderB), common.BigToHash(big.NewInt(42))}},
+		{Address: nft, Topics: []common.Hash{approvalForAllEventTopic, topic(account), topic(spenderC)}, Data: word(big.NewInt(1))},
+		{Address: permit2Address, Topics: []common.Hash{permit2ApprovalEventTopic, topic(account), topic(tokenA), topic(router)}, Data: append(word(big.NewInt(300)), word(big.NewInt(2000))...)},
+		{Address: owned, Topics: []common.Hash{ownershipTransferredTopic, topic(account), topic(other)}},
+		// the ownership of a contract deployed by account, and a role granted
+		// by someone else, are no grants of account
+		{Address: owned, Topics: []common.Hash{ownershipTransferredTopic, {}, topic(account)}},
+		{Address: owned, Topics: []common.Hash{roleGrantedEventTopic, role, topic(spenderA), topic(account)}},
+		{Address: owned, Topics: []common.Hash{roleGrantedEventTopic, role, topic(spenderB), topic(other)}},
+	}
+	calls := []riskCall{{to: tokenB, data: append(common.CopyBytes(increaseAllowanceSelector), make([]byte, 64)...)}}
+
+	ctx := context.Background()
+	flags := a.logRisks(ctx, account, logs, calls)
+	flags = append(flags, a.storageRisks(ctx, account, flags)...)
+
+	names := map[common.Address]string{
+		account: "account", other: "other", tokenA: "tokenA", tokenB: "tokenB", nft: "nft", owned: "owned",
+		spenderA: "spenderA", spenderB: "spenderB", spenderC: "spenderC", router: "router", permit2Address: "permit2",
+	}
+	slots := map[common.Hash]string{approvedA: "approvedA", silentB: "silentB", permit2Event: "permit2Event", permit2Silent: "permit2Silent"}
+	format := func(f *riskFlag) string {
+		s := fmt.Sprintf("%s %s %s->%s", f.Kind, names[f.Contract], names[f.Owner], names[f.Spender])
+		if f.Token != nil {
+			s += " token " + names[*f.Token]
+		}
+		if f.Amount != nil {
+			s += " amount " + f.Amount.ToInt().String()
+		}
+		if f.TokenID != nil {
+			s += " id " + f.TokenID.ToInt().String()
+		}
+		if f.Allowance != nil {
+			s += " allowance " + f.Allowance.ToInt().String()
+		}
+		if f.AllowanceSlot != nil {
+			s += " slot " + slots[*f.AllowanceSlot]
+		}
+		if f.Expiration != nil {
+			s += fmt.Sprintf(" expires %d", *f.Expiration)
+		}
+		if f.Unlimited {
+			s += " unlimited"
+		}
+		return s
+	}
+	unlimited := math.MaxBig256.String()
+	want := []string{
+		"approve tokenA account->spenderA amount " + unlimited + " allowance " + unlimited + " slot approvedA unlimited",
+		"increaseAllowance tokenB account->spenderA amount 10",
+		"approve nft account->spenderB id 42",
+		"setApprovalForAll nft account->spenderC unlimited",
+		"permit2Approve permit2 account->router token tokenA amount 300 allowance 300 slot permit2Event expires 2000",
+		"transferOwnership owned account->other unlimited",
+		"grantRole owned account->spenderA",
+	}
+	// the grants without an event, ordered by slot: the vyper allowance
+	// raised from 0 and the Permit2 allowance, not the lowered one or the one
+	// of another owner
+	storage := []string{
+		"allowanceStorage tokenB account->spenderB amount 500 allowance 500 slot silentB",
+		"allowanceStorage permit2 account->router token tokenB amount " + maxUint160.String() + " allowance " + maxUint160.String() + " slot permit2Silent expires 2000 unlimited",
+	}
+	if silentB.Big().Cmp(permit2Silent.Big()) > 0 {
+		storage[0], storage[1] = storage[1], storage[0]
+	}
+	want = append(want, storage...)
+
+	var got []string
+	for _, f := range flags {
+		got = append(got, format(f))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("flags:\n%v\nwant:\n%v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("flag %d:\n%s\nwant:\n%s", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package mferbackend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+// asset standards
+const (
+	assetNative  = "native"
+	assetERC20   = "erc20"
+	assetERC721  = "erc721"
+	assetERC1155 = "erc1155"
+
+	nativeDecimals = 18
+)
+
+var (
+	transferEventTopic       = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	transferSingleEventTopic = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	transferBatchEventTopic  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+
+	symbolSelector   = crypto.Keccak256([]byte("symbol()"))[:4]
+	decimalsSelector = crypto.Keccak256([]byte("decimals()"))[:4]
+
+	uint256ArrayType, _ = abi.NewType("uint256[]", "", nil)
+	stringType, _       = abi.NewType("string", "", nil)
+)
+
+// assetTransfer is a movement of ether or of a token, TokenID is set for NFTs.
+type assetTransfer struct {
+	Standard  string          `json:"standard"`
+	Token     *common.Address `json:"token,omitempty"`
+	Symbol    string          `json:"symbol,omitempty"`
+	Decimals  *uint8          `json:"decimals,omitempty"`
+	From      common.Address  `json:"from"`
+	FromLabel string          `json:"fromLabel,omitempty"`
+	To        common.Address  `json:"to"`
+	ToLabel   string          `json:"toLabel,omitempty"`
+	TokenID   *hexutil.Big    `json:"tokenId,omitempty"`
+	Amount    *hexutil.Big    `json:"amount"`
+	Formatted string          `json:"formatted,omitempty"`
+}
+
+// txAssetFlow is the transfers of a pool tx, Fee is the gas fee From paid.
+type txAssetFlow struct {
+	Idx       int              `json:"idx"`
+	TxHash    common.Hash      `json:"pseudoTxHash"`
+	From      common.Address   `json:"from"`
+	Fee       *hexutil.Big     `json:"fee,omitempty"`
+	Transfers []*assetTransfer `json:"transfers"`
+}
+
+// balanceChange is the net change of an asset held by an address over the
+// whole pool.
+type balanceChange struct {
+	Address      common.Address  `json:"address"`
+	Label        string          `json:"label,omitempty"`
+	Impersonated bool            `json:"impersonated"`
+	Standard     string          `json:"standard"`
+	Token        *common.Address `json:"token,omitempty"`
+	Symbol       string          `json:"symbol,omitempty"`
+	Decimals     *uint8          `json:"decimals,omitempty"`
+	TokenID      *hexutil.Big    `json:"tokenId,omitempty"`
+	Delta        *hexutil.Big    `json:"delta"`
+	Formatted    string          `json:"formatted,omitempty"`
+}
+
+type assetFlows struct {
+	ImpersonatedAccount common.Address   `json:"impersonatedAccount"`
+	Txs                 []*txAssetFlow   `json:"txs"`
+	BalanceChanges      []*balanceChange `json:"balanceChanges"`
+}
+
+type tokenMeta struct {
+	symbol   string
+	decimals *uint8
+}
+
+// tokenMetaKey is a token and the hash of its code, a token redeployed or
+// overridden with other code is read again.
+type tokenMetaKey struct {
+	token    common.Address
+	codeHash common.Hash
+}
+
+// tokenMetaCache holds the token metadata read by the requests of a backend.
+type tokenMetaCache struct {
+	mutex sync.Mutex
+	metas map[tokenMetaKey]*tokenMeta
+}
+
+func newTokenMetaCache() *tokenMetaCache {
+	return &tokenMetaCache{metas: make(map[tokenMetaKey]*tokenMeta)}
+}
+
+func (c *tokenMetaCache) get(key tokenMetaKey) (*tokenMeta, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	meta, ok := c.metas[key]
+	return meta, ok
+}
+
+func (c *tokenMetaCache) put(key tokenMetaKey, meta *tokenMeta) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.metas[key] = meta
+}
+
+// callFrame is the part of the callTracer output the ether transfers are
+// read from.
+type callFrame struct {
+	Type  string         `json:"type"`
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Value *hexutil.Big   `json:"value"`
+	Error string         `json:"error"`
+	Calls []*callFrame   `json:"calls"`
+}
+
+// assetAnalyzer extracts the asset movements of the pool txs from their
+// receipts and call traces, the token metadata is read from the fork.
+type assetAnalyzer struct {
+	b       *MferBackend
+	stateDB *mferstate.OverlayStateDB
+	diff    mferstate.StateOverride
+}
+
+func newAssetAnalyzer(b *MferBackend) *assetAnalyzer {
+	return &assetAnalyzer{
+		b:       b,
+		stateDB: b.EVM.StateDB,
+	}
+}
+
+// poolFlows returns the transfers of every pool tx and the net balance
+// changes, the changes of the impersonated account come first.
+func (a *assetAnalyzer) poolFlows(ctx context.Context) *assetFlows {
+	txs, _ := a.b.TxPool.GetPoolTxs()
+	flows := &assetFlows{
+		ImpersonatedAccount: a.b.ImpersonatedAccount,
+		Txs:                 make([]*txAssetFlow, len(txs)),
+	}
+	for i, tx := range txs {
+		msg := a.b.EVM.TxToMessage(tx)
+		flows.Txs[i] = &txAssetFlow{
+			Idx:       i,
+			TxHash:    tx.Hash(),
+			From:      msg.From(),
+			Transfers: a.txTransfers(ctx, tx),
+		}
+		if receipt := a.stateDB.GetReceipt(tx.Hash()); receipt != nil {
+			fee := new(big.Int).Mul(new(big.Int).SetUint64(receipt.GasUsed), msg.GasPrice())
+			if fee.Sign() > 0 {
+				flows.Txs[i].Fee = (*hexutil.Big)(fee)
+			}
+		}
+	}
+	flows.BalanceChanges = a.balanceChanges(flows.Txs)
+	return flows
+}
+
+// txTransfers returns the ether transfers of the call trace followed by the
+// token transfers of the logs, nothing for a rejected tx.
+func (a *assetAnalyzer) txTransfers(ctx context.Context, tx *types.Transaction) []*assetTransfer {
+	transfers := make([]*assetTransfer, 0)
+	receipt := a.stateDB.GetReceipt(tx.Hash())
+	if receipt == nil || len(receipt.Logs) == 0 {
+		return transfers
+	}
+	// the last log holds the trace
+	logs, traceLog := receipt.Logs[:len(receipt.Logs)-1], receipt.Logs[len(receipt.Logs)-1]
+	var frame callFrame
+	if err := json.Unmarshal(traceLog.Data, &frame); err == nil {
+		transfers = appendNativeTransfers(transfers, &frame)
+	}
+	for _, l := range logs {
+		transfers = append(transfers, a.logTransfers(ctx, l)...)
+	}
+	for _, t := range transfers {
+		t.FromLabel, t.ToLabel = a.b.Contracts.Label(t.From), a.b.Contracts.Label(t.To)
+	}
+	return transfers
+}
+
+// appendNativeTransfers walks the call frames, a reverted frame moves nothing
+// and a delegate call carries the value of its caller.
+func appendNativeTransfers(transfers []*assetTransfer, frame *callFrame) []*assetTransfer {
+	if frame.Error != "" {
+		return transfers
+	}
+	if frame.Value != nil && frame.Value.ToInt().Sign() > 0 && frame.Type != "DELEGATECALL" {
+		decimals := uint8(nativeDecimals)
+		transfers = append(transfers, &assetTransfer{
+			Standard:  assetNative,
+			Decimals:  &decimals,
+			From:      frame.From,
+			To:        frame.To,
+			Amount:    frame.Value,
+			Formatted: formatUnits(frame.Value.ToInt(), decimals),
+		})
+	}
+	for _, call := range frame.Calls {
+		transfers = appendNativeTransfers(transfers, call)
+	}
+	return transfers
+}
+
+func topicAddress(topic common.Hash) common.Address {
+	return common.BytesToAddress(topic.Bytes())
+}
+
+// logTransfers decodes the Transfer, TransferSingle and TransferBatch events
+// of l. ERC-20 and ERC-721 share the Transfer event, the token id of ERC-721
+// is indexed.
+func (a *assetAnalyzer) logTransfers(ctx context.Context, l *types.Log) []*assetTransfer {
+	if len(l.Topics) == 0 {
+		return nil
+	}
+	token := l.Address
+	switch {
+	case l.Topics[0] == transferEventTopic && len(l.Topics) == 3 && len(l.Data) == 32:
+		return []*assetTransfer{a.tokenTransfer(ctx, assetERC20, token, topicAddress(l.Topics[1]), topicAddress(l.Topics[2]), nil, new(big.Int).SetBytes(l.Data))}
+	case l.Topics[0] == transferEventTopic && len(l.Topics) == 4:
+		return []*assetTransfer{a.tokenTransfer(ctx, assetERC721, token, topicAddress(l.Topics[1]), topicAddress(l.Topics[2]), l.Topics[3].Big(), big.NewInt(1))}
+	case l.Topics[0] == transferSingleEventTopic && len(l.Topics) == 4 && len(l.Data) == 64:
+		id, value := new(big.Int).SetBytes(l.Data[:32]), new(big.Int).SetBytes(l.Data[32:])
+		return []*assetTransfer{a.tokenTransfer(ctx, assetERC1155, token, topicAddress(l.Topics[2]), topicAddress(l.Topics[3]), id, value)}
+	case l.Topics[0] == transferBatchEventTopic && len(l.Topics) == 4:
+		values, err := abi.Arguments{{Type: uint256ArrayType}, {Type: uint256ArrayType}}.Unpack(l.Data)
+		if err != nil {
+			return nil
+		}
+		ids, amounts := values[0].([]*big.Int), values[1].([]*big.Int)
+		if len(ids) != len(amounts) {
+			return nil
+		}
+		transfers := make([]*assetTransfer, len(ids))
+		for i := range ids {
+			transfers[i] = a.tokenTransfer(ctx, assetERC1155, token, topicAddress(l.Topics[2]), topicAddress(l.Topics[3]), ids[i], amounts[i])
+		}
+		return transfers
+	}
+	return nil
+}
+
+func (a *assetAnalyzer) tokenTransfer(ctx context.Context, standard string, token, from, to common.Address, id, amount *big.Int) *assetTransfer {
+	meta := a.tokenMeta(ctx, token)
+	transfer := &assetTransfer{
+		Standard: standard,
+		Token:    &token,
+		Symbol:   meta.symbol,
+		From:     from,
+		To:       to,
+		Amount:   (*hexutil.Big)(amount),
+	}
+	if id != nil {
+		transfer.TokenID = (*hexutil.Big)(id)
+	}
+	if standard == assetERC20 && meta.decimals != nil {
+		transfer.Decimals = meta.decimals
+		transfer.Formatted = formatUnits(amount, *meta.decimals)
+	}
+	return transfer
+}
+
+// tokenMeta reads symbol() and decimals() of token on the fork, a token
+// without them has an empty symbol and no decimals. The metadata is read once
+// per token, unless the request times out while reading it.
+func (a *assetAnalyzer) tokenMeta(ctx context.Context, token common.Address) *tokenMeta {
+	key := tokenMetaKey{token: token, codeHash: a.stateDB.GetCodeHash(token)}
+	if meta, ok := a.b.tokens.get(key); ok {
+		return meta
+	}
+	meta := &tokenMeta{}
+	if ret := a.staticCall(ctx, token, symbolSelector); ret != nil {
+		meta.symbol = decodeSymbol(ret)
+	}
+	if ret := a.staticCall(ctx, token, decimalsSelector); len(ret) == 32 {
+		if decimals := new(big.Int).SetBytes(ret); decimals.IsUint64() && decimals.Uint64() <= 255 {
+			d := uint8(decimals.Uint64())
+			meta.decimals = &d
+		}
+	}
+	if ctx.Err() == nil {
+		a.b.tokens.put(key, meta)
+	}
+	return meta
+}
+
+func (a *assetAnalyzer) staticCall(ctx context.Context, to common.Address, data []byte) []byte {
+	return a.b.staticCall(ctx, a.stateDB, to, data)
+}
+
+// decodeSymbol decodes an abi string, or a bytes32 as returned by some old
+// tokens.
+func decodeSymbol(ret []byte) string {
+	if values, err := (abi.Arguments{{Type: stringType}}).Unpack(ret); err == nil {
+		return values[0].(string)
+	}
+	if len(ret) == 32 {
+		return string(bytes.TrimRight(ret, "\x00"))
+	}
+	return ""
+}
+
+// formatUnits formats amount with decimals, e.g. 1500000 with 6 decimals is
+// "1.5".
+func formatUnits(amount *big.Int, decimals uint8) string {
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+	digits := new(big.Int).Abs(amount).String()
+	if decimals == 0 {
+		return sign + digits
+	}
+	if len(digits) <= int(decimals) {
+		digits = strings.Repeat("0", int(decimals)-len(digits)+1) + digits
+	}
+	whole, frac := digits[:len(digits)-int(decimals)], strings.TrimRight(digits[len(digits)-int(decimals):], "0")
+	if frac == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + frac
+}
+
+// balanceChanges nets the transfers and the gas fees per holder and asset,
+// assets without a net change are left out. The tips the coinbase earns are
+// not counted.
+func (a *assetAnalyzer) balanceChanges(txs []*txAssetFlow) []*balanceChange {
+	type assetKey struct {
+		holder  common.Address
+		token   common.Address
+		tokenID string
+	}
+	changes := make(map[assetKey]*balanceChange)
+	keys := make([]assetKey, 0)
+	apply := func(holder common.Address, t *assetTransfer, amount *big.Int) {
+		key := assetKey{holder: holder}
+		if t.Token != nil {
+			key.token = *t.Token
+		}
+		if t.TokenID != nil {
+			key.tokenID = t.TokenID.String()
+		}
+		change, ok := changes[key]
+		if !ok {
+			change = &balanceChange{
+				Address:      holder,
+				Label:        a.b.Contracts.Label(holder),
+				Impersonated: holder == a.b.ImpersonatedAccount,
+				Standard:     t.Standard,
+				Token:        t.Token,
+				Symbol:       t.Symbol,
+				Decimals:     t.Decimals,
+				TokenID:      t.TokenID,
+				Delta:        new(hexutil.Big),
+			}
+			changes[key] = change
+			keys = append(keys, key)
+		}
+		change.Delta.ToInt().Add(change.Delta.ToInt(), amount)
+	}
+	nativeDecimals := uint8(nativeDecimals)
+	native := &assetTransfer{Standard: assetNative, Decimals: &nativeDecimals}
+	for _, tx := range txs {
+		if tx.Fee != nil {
+			apply(tx.From, native, new(big.Int).Neg(tx.Fee.ToInt()))
+		}
+		for _, t := range tx.Transfers {
+			amount := t.Amount.ToInt()
+			apply(t.From, t, new(big.Int).Neg(amount))
+			apply(t.To, t, amount)
+		}
+	}
+
+	result := make([]*balanceChange, 0, len(keys))
+	for _, key := range keys {
+		change := changes[key]
+		if change.Delta.ToInt().Sign() == 0 {
+			continue
+		}
+		if change.Decimals != nil {
+			change.Formatted = formatUnits(change.Delta.ToInt(), *change.Decimals)
+		}
+		result = append(result, change)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Impersonated && !result[j].Impersonated
+	})
+	return result
+}
@@ -0,0 +1,229 @@
+package mferbackend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestFormatUnits(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		decimals uint8
+		want     string
+	}{
+		{1500000, 6, "1.5"},
+		{1000000, 6, "1"},
+		{5, 6, "0.000005"},
+		{-2500, 3, "-2.5"},
+		{42, 0, "42"},
+		{0, 18, "0"},
+	}
+	for _, tt := range tests {
+		if got := formatUnits(big.NewInt(tt.amount), tt.decimals); got != tt.want {
+			t.Errorf("formatUnits(%d, %d) = %s, want %s", tt.amount, tt.decimals, got, tt.want)
+		}
+	}
+}
+
+func TestLogTransfers(t *testing.T) {
+	var (
+		account  = testSender
+		other    = common.HexToAddress("0x0ddddddddddddddddddddddddddddddddddddddd")
+		operator = common.HexToAddress("0x0ccccccccccccccccccccccccccccccccccccccc")
+		token    = common.HexToAddress("0xa000000000000000000000000000000000000001")
+		nft      = common.HexToAddress("0xc000000000000000000000000000000000000002")
+		multi    = common.HexToAddress("0xe000000000000000000000000000000000000003")
+	)
+	// the token returns its symbol as a bytes32, the NFTs have no metadata
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		token: {code: dispatcher(
+			selectorCase{symbolSelector, common.BytesToHash(common.RightPadBytes([]byte("TKN"), 32))},
+			selectorCase{decimalsSelector, common.BigToHash(big.NewInt(6))},
+		)},
+	})
+	a := newAssetAnalyzer(b)
+
+	topic := func(address common.Address) common.Hash { return common.BytesToHash(address.Bytes()) }
+	word := func(n int64) []byte { return common.BigToHash(big.NewInt(n)).Bytes() }
+	batch, err := abi.Arguments{{Type: uint256ArrayType}, {Type: uint256ArrayType}}.Pack([]*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(10), big.NewInt(20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []*types.Log{
+		{Address: token, Topics: []common.Hash{transferEventTopic, topic(account), topic(other)}, Data: word(1500000)},
+		{Address: nft, Topics: []common.Hash{transferEventTopic, topic(other), topic(account), common.BigToHash(big.NewInt(42))}},
+		{Address: multi, Topics: []common.Hash{transferSingleEventTopic, topic(operator), topic(account), topic(other)}, Data: append(word(7), word(3)...)},
+		{Address: multi, Topics: []common.Hash{transferBatchEventTopic, topic(operator), topic(account), topic(other)}, Data: batch},
+		// a Transfer with a malformed amount and an unknown event
+		{Address: token, Topics: []common.Hash{transferEventTopic, topic(account), topic(other)}, Data: word(1)[1:]},
+		{Address: token, Topics: []common.Hash{approvalEventTopic, topic(account), topic(other)}, Data: word(1)},
+	}
+	names := map[common.Address]string{account: "account", other: "other", token: "token", nft: "nft", multi: "multi"}
+	var got []string
+	for _, l := range logs {
+		for _, tr := range a.logTransfers(context.Background(), l) {
+			s := fmt.Sprintf("%s %s %s->%s %s", tr.Standard, names[*tr.Token], names[tr.From], names[tr.To], tr.Amount.ToInt())
+			if tr.TokenID != nil {
+				s += " id " + tr.TokenID.ToInt().String()
+			}
+			if tr.Symbol != "" {
+				s += " " + tr.Formatted + " " + tr.Symbol
+			}
+			got = append(got, s)
+		}
+	}
+	want := []string{
+		"erc20 token account->other 1500000 1.5 TKN",
+		"erc721 nft other->account 1 id 42",
+		"erc1155 multi account->other 3 id 7",
+		"erc1155 multi account->other 10 id 1",
+		"erc1155 multi account->other 20 id 2",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("transfers:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestBalanceChanges(t *testing.T) {
+	var (
+		account = testSender
+		other   = common.HexToAddress("0x0ddddddddddddddddddddddddddddddddddddddd")
+		token   = common.HexToAddress("0xa000000000000000000000000000000000000001")
+		nft     = common.HexToAddress("0xc000000000000000000000000000000000000002")
+	)
+	b := newTestBackend(t, nil)
+	decimals := uint8(6)
+	transfer := func(standard string, token *common.Address, from, to common.Address, id, amount int64) *assetTransfer {
+		t := &assetTransfer{Standard: standard, Token: token, From: from, To: to, Amount: (*hexutil.Big)(big.NewInt(amount))}
+		if standard == assetERC20 {
+			t.Decimals = &decimals
+		}
+		if id != 0 {
+			t.TokenID = (*hexutil.Big)(big.NewInt(id))
+		}
+		return t
+	}
+	txs := []*txAssetFlow{
+		{From: other, Fee: (*hexutil.Big)(big.NewInt(300)), Transfers: []*assetTransfer{
+			transfer(assetNative, nil, other, account, 0, 5000),
+			transfer(assetERC20, &token, account, other, 0, 1500000),
+			transfer(assetERC721, &nft, other, account, 42, 1),
+		}},
+		// gives the tokens back, they net out
+		{From: other, Fee: (*hexutil.Big)(big.NewInt(200)), Transfers: []*assetTransfer{
+			transfer(assetERC20, &token, other, account, 0, 1500000),
+		}},
+	}
+	names := map[common.Address]string{account: "account", other: "other", token: "token", nft: "nft"}
+	var got []string
+	for _, c := range newAssetAnalyzer(b).balanceChanges(txs) {
+		s := fmt.Sprintf("%s %s", names[c.Address], c.Standard)
+		if c.Token != nil {
+			s += " " + names[*c.Token]
+		}
+		if c.TokenID != nil {
+			s += " id " + c.TokenID.ToInt().String()
+		}
+		got = append(got, s+" "+c.Delta.ToInt().String())
+	}
+	// the fees are taken from the native balance of the sender
+	want := []string{
+		"account native 5000",
+		"account erc721 nft id 42 1",
+		"other native -5500",
+		"other erc721 nft id 42 -1",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("balance changes:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestPoolFlows(t *testing.T) {
+	var (
+		token     = common.HexToAddress("0xa000000000000000000000000000000000000001")
+		recipient = common.HexToAddress("0xbeef")
+		fresh     = common.HexToAddress("0xa000000000000000000000000000000000000002")
+	)
+	key, _ := crypto.GenerateKey()
+	sender := crypto.PubkeyToAddress(key.PublicKey)
+	// the token emits Transfer(caller, 0xbeef, 1500000) when it is called
+	// with any other selector than its metadata
+	emit := []byte{byte(vm.PUSH3), 0x16, 0xe3, 0x60, byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.PUSH2), 0xbe, 0xef, byte(vm.CALLER), byte(vm.PUSH32)}
+	emit = append(emit, transferEventTopic.Bytes()...)
+	emit = append(emit, byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.LOG3), byte(vm.STOP))
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		sender: {balance: big.NewInt(1e18)},
+		token: {code: dispatcherFallback(emit,
+			selectorCase{symbolSelector, common.BytesToHash(common.RightPadBytes([]byte("TKN"), 32))},
+			selectorCase{decimalsSelector, common.BigToHash(big.NewInt(6))},
+		)},
+	})
+	api := &EthAPI{b}
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		tx, err := types.SignNewTx(key, types.NewLondonSigner(big.NewInt(1)), &types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(100),
+			Gas:       100000,
+			To:        &token,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := tx.MarshalBinary()
+		if _, err := api.SendRawTransaction(context.Background(), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flows := newAssetAnalyzer(b).poolFlows(context.Background())
+	fees := new(big.Int)
+	for _, tx := range flows.Txs {
+		receipt := b.EVM.StateDB.GetReceipt(tx.TxHash)
+		// the pool txs pay their fee cap
+		if tx.From != sender || tx.Fee == nil || tx.Fee.ToInt().Uint64() != receipt.GasUsed*100 {
+			t.Errorf("tx %d from %s fee %v, gas used %d", tx.Idx, tx.From.Hex(), tx.Fee, receipt.GasUsed)
+		}
+		fees.Add(fees, tx.Fee.ToInt())
+		if len(tx.Transfers) != 1 || tx.Transfers[0].Symbol != "TKN" || tx.Transfers[0].Formatted != "1.5" {
+			t.Errorf("tx %d transfers %+v", tx.Idx, tx.Transfers)
+		}
+	}
+	if spent := new(big.Int).Sub(big.NewInt(1e18), b.EVM.StateDB.GetBalance(sender)); spent.Cmp(fees) != 0 {
+		t.Errorf("sender spent %v, fees %v", spent, fees)
+	}
+	var got []string
+	for _, c := range flows.BalanceChanges {
+		got = append(got, fmt.Sprintf("%s %s %s", c.Address.Hex(), c.Standard, c.Delta.ToInt()))
+	}
+	want := []string{
+		fmt.Sprintf("%s native -%s", sender.Hex(), fees),
+		fmt.Sprintf("%s erc20 -3000000", sender.Hex()),
+		fmt.Sprintf("%s erc20 3000000", recipient.Hex()),
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("balance changes:\n%v\nwant:\n%v", got, want)
+	}
+
+	// the metadata is read once per token, not when the request is over
+	if len(b.tokens.metas) != 1 {
+		t.Errorf("%d tokens cached, want 1", len(b.tokens.metas))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	newAssetAnalyzer(b).tokenMeta(ctx, fresh)
+	if len(b.tokens.metas) != 1 {
+		t.Errorf("metadata read by a cancelled request cached")
+	}
+}
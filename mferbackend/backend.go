@@ -5,6 +5,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/sec-bit/mfer-node/constant"
+	"github.com/sec-bit/mfer-node/mferabi"
 	"github.com/sec-bit/mfer-node/mferevm"
 	"github.com/sec-bit/mfer-node/mfertxpool"
 )
@@ -15,7 +16,25 @@ type MferBackend struct {
 	ImpersonatedAccount common.Address
 	Randomized          bool
 	Passthrough         bool
+	Shadow              bool // compare local calls with the upstream
 	OverrideChainID     *big.Int
+	ClientVersion       string
+	Limits              Limits
+	GasMargin           uint64 // percent added to gas estimates
+	Signatures          *mferabi.SignatureDB
+	Contracts           *mferabi.Registry // labels and ABIs by address
+	Security            SecurityOptions
+
+	// Sessions is shared by the root backend of a fork and its sessions,
+	// SessionID is empty on the root backend.
+	Sessions  *SessionManager
+	SessionID string
+
+	probe     *passthroughProbe
+	debugger  *debugger
+	security  *securityReports
+	preimages *preimageCache
+	tokens    *tokenMetaCache
 }
 
 func NewMferBackend(e *mferevm.MferEVM, txPool *mfertxpool.MferTxPool, impersonatedAccount common.Address, randomize bool) *MferBackend {
@@ -24,6 +43,13 @@ func NewMferBackend(e *mferevm.MferEVM, txPool *mfertxpool.MferTxPool, impersona
 		TxPool:              txPool,
 		ImpersonatedAccount: impersonatedAccount,
 		Randomized:          randomize,
+		Signatures:          mferabi.NewSignatureDB(),
+		Contracts:           mferabi.NewRegistry(),
+		probe:               &passthroughProbe{},
+		debugger:            newDebugger(),
+		security:            newSecurityReports(),
+		preimages:           new(preimageCache),
+		tokens:              newTokenMetaCache(),
 	}
 }
 
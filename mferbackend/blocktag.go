@@ -0,0 +1,71 @@
+package mferbackend
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var pseudoBlockHash = common.HexToHash("0xcafecafecafecafecafecafecafecafecafecafecafecafecafecafecafecafe")
+
+// isLocalBlock reports whether blockNrOrHash refers to the overlay state: the
+// latest and pending tags, the pseudo block of the pool, or no block at all.
+// Any other block is historical and answered by the upstream.
+func (b *MferBackend) isLocalBlock(blockNrOrHash rpc.BlockNumberOrHash) bool {
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		return hash == blockHash || hash == pseudoBlockHash
+	}
+	number, ok := blockNrOrHash.Number()
+	if !ok {
+		return true
+	}
+	return b.isLocalBlockNumber(number)
+}
+
+func (b *MferBackend) isLocalBlockNumber(number rpc.BlockNumber) bool {
+	switch number {
+	case rpc.LatestBlockNumber, rpc.PendingBlockNumber:
+		return true
+	case rpc.EarliestBlockNumber, rpc.SafeBlockNumber, rpc.FinalizedBlockNumber:
+		return false
+	}
+	return uint64(number) > b.EVM.StateDB.StateBlockNumber()
+}
+
+// blockArg encodes blockNrOrHash as an upstream request parameter, hashes use
+// the EIP-1898 object form.
+func blockArg(blockNrOrHash rpc.BlockNumberOrHash) interface{} {
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		arg := map[string]interface{}{"blockHash": hash}
+		if blockNrOrHash.RequireCanonical {
+			arg["requireCanonical"] = true
+		}
+		return arg
+	}
+	if number, ok := blockNrOrHash.Number(); ok {
+		return number
+	}
+	return rpc.LatestBlockNumber
+}
+
+// upstreamCall forwards a read to the upstream.
+func (b *MferBackend) upstreamCall(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	start := time.Now()
+	err := b.EVM.RpcClient.CallContext(ctx, result, method, args...)
+	b.EVM.Metrics.UpstreamCall(method, start, err)
+	return err
+}
+
+// upstreamBatch sends reqs to the upstream in one batch, the errors of the
+// single requests are left in their Error field.
+func (b *MferBackend) upstreamBatch(ctx context.Context, reqs []rpc.BatchElem) error {
+	if len(reqs) == 0 {
+		return nil
+	}
+	start := time.Now()
+	err := b.EVM.RpcClient.BatchCallContext(ctx, reqs)
+	b.EVM.Metrics.UpstreamBatch(reqs, start, err)
+	return err
+}
@@ -0,0 +1,412 @@
+package mferbackend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sec-bit/mfer-node/constant"
+	"github.com/sec-bit/mfer-node/mferstate"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+// probeCoinbase is the coinbase of the default context probe.
+var probeCoinbase = common.HexToAddress("0x00000000000000000000000000000000c01bba5e")
+
+// contextChange is a block or tx context to probe a tx in, the fields left
+// out keep the value of the pending block. PrevRandao is the difficulty
+// before the merge. GasPrice and Origin are what GASPRICE and ORIGIN read,
+// the sender and the fee of the tx are unchanged.
+type contextChange struct {
+	Name       string          `json:"name,omitempty"`
+	Timestamp  *hexutil.Uint64 `json:"timestamp,omitempty"`
+	Number     *hexutil.Big    `json:"number,omitempty"`
+	Coinbase   *common.Address `json:"coinbase,omitempty"`
+	BaseFee    *hexutil.Big    `json:"baseFee,omitempty"`
+	PrevRandao *common.Hash    `json:"prevRandao,omitempty"`
+	GasLimit   *hexutil.Uint64 `json:"gasLimit,omitempty"`
+	GasPrice   *hexutil.Big    `json:"gasPrice,omitempty"`
+	Origin     *common.Address `json:"origin,omitempty"`
+}
+
+// apply returns the contexts with c applied, the values of blockCtx and
+// txCtx are not modified.
+func (c *contextChange) apply(blockCtx vm.BlockContext, txCtx vm.TxContext) (vm.BlockContext, vm.TxContext) {
+	var changed []string
+	if c.Timestamp != nil {
+		blockCtx.Time = new(big.Int).SetUint64(uint64(*c.Timestamp))
+		changed = append(changed, "timestamp")
+	}
+	if c.Number != nil {
+		blockCtx.BlockNumber = new(big.Int).Set(c.Number.ToInt())
+		changed = append(changed, "number")
+	}
+	if c.Coinbase != nil {
+		blockCtx.Coinbase = *c.Coinbase
+		changed = append(changed, "coinbase")
+	}
+	if c.BaseFee != nil {
+		blockCtx.BaseFee = new(big.Int).Set(c.BaseFee.ToInt())
+		changed = append(changed, "baseFee")
+	}
+	if c.PrevRandao != nil {
+		if blockCtx.Random != nil {
+			random := *c.PrevRandao
+			blockCtx.Random = &random
+		} else {
+			blockCtx.Difficulty = c.PrevRandao.Big()
+		}
+		changed = append(changed, "prevRandao")
+	}
+	if c.GasLimit != nil {
+		blockCtx.GasLimit = uint64(*c.GasLimit)
+		changed = append(changed, "gasLimit")
+	}
+	if c.GasPrice != nil {
+		txCtx.GasPrice = new(big.Int).Set(c.GasPrice.ToInt())
+		changed = append(changed, "gasPrice")
+	}
+	if c.Origin != nil {
+		txCtx.Origin = *c.Origin
+		changed = append(changed, "origin")
+	}
+	if c.Name == "" {
+		c.Name = strings.Join(changed, "+")
+	}
+	return blockCtx, txCtx
+}
+
+// defaultContextChanges changes each field of the context in turn.
+func defaultContextChanges(blockCtx vm.BlockContext, txCtx vm.TxContext) []*contextChange {
+	gwei := big.NewInt(1e9)
+	timestamp := hexutil.Uint64(blockCtx.Time.Uint64() + 24*3600)
+	number := (*hexutil.Big)(new(big.Int).Add(blockCtx.BlockNumber, big.NewInt(7200)))
+	baseFee := new(big.Int).Set(gwei)
+	if blockCtx.BaseFee != nil && blockCtx.BaseFee.Sign() > 0 {
+		baseFee.Mul(blockCtx.BaseFee, big.NewInt(2))
+	}
+	var random common.Hash
+	if blockCtx.Random != nil {
+		random = crypto.Keccak256Hash(blockCtx.Random.Bytes())
+	} else if blockCtx.Difficulty != nil {
+		random = crypto.Keccak256Hash(common.BigToHash(blockCtx.Difficulty).Bytes())
+	}
+	gasLimit := hexutil.Uint64(blockCtx.GasLimit / 2)
+	gasPrice := new(big.Int).Set(gwei)
+	if txCtx.GasPrice != nil {
+		gasPrice.Add(gasPrice, new(big.Int).Mul(txCtx.GasPrice, big.NewInt(2)))
+	}
+	origin := constant.FAKE_ACCOUNT_1
+	if txCtx.Origin == origin {
+		origin = constant.FAKE_ACCOUNT_2
+	}
+	return []*contextChange{
+		{Name: "timestamp", Timestamp: &timestamp},
+		{Name: "number", Number: number},
+		{Name: "coinbase", Coinbase: &probeCoinbase},
+		{Name: "baseFee", BaseFee: (*hexutil.Big)(baseFee)},
+		{Name: "prevRandao", PrevRandao: &random},
+		{Name: "gasLimit", GasLimit: &gasLimit},
+		{Name: "gasPrice", GasPrice: (*hexutil.Big)(gasPrice)},
+		{Name: "origin", Origin: &origin},
+	}
+}
+
+// probeRun is the outcome of a probed run. A run the context makes invalid,
+// e.g. with a fee cap below the base fee, is not executed and fails with
+// Invalid set.
+type probeRun struct {
+	Failed  bool          `json:"failed"`
+	Invalid bool          `json:"invalid,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Output  hexutil.Bytes `json:"output"`
+	GasUsed uint64        `json:"gasUsed"`
+
+	tracer *mfertracer.ProbeTracer
+}
+
+// probeCall is a call frame of a probed run.
+type probeCall struct {
+	Type     string         `json:"type"`
+	From     common.Address `json:"from"`
+	To       common.Address `json:"to"`
+	Label    string         `json:"label,omitempty"`
+	Selector hexutil.Bytes  `json:"selector,omitempty"`
+	Value    *hexutil.Big   `json:"value,omitempty"`
+	Depth    int            `json:"depth"`
+	Failed   bool           `json:"failed,omitempty"`
+}
+
+// callDivergence is the first call frame two runs differ at, nil at the end
+// of the run that made fewer calls.
+type callDivergence struct {
+	Index int        `json:"index"`
+	Base  *probeCall `json:"base"`
+	Probe *probeCall `json:"probe"`
+}
+
+// writeDivergence is a slot two runs leave with different values, nil in the
+// run that did not write it.
+type writeDivergence struct {
+	Address common.Address `json:"address"`
+	Label   string         `json:"label,omitempty"`
+	Slot    common.Hash    `json:"slot"`
+	Base    *common.Hash   `json:"base"`
+	Probe   *common.Hash   `json:"probe"`
+}
+
+// logDivergence is a log two runs emit differently, nil in the run that
+// emitted fewer logs.
+type logDivergence struct {
+	Index int        `json:"index"`
+	Base  *types.Log `json:"base"`
+	Probe *types.Log `json:"probe"`
+}
+
+// contextProbe is a run in a changed context and how it diverges from the
+// run in the pending block. An invalid run is not compared.
+type contextProbe struct {
+	Change   *contextChange     `json:"change"`
+	Run      *probeRun          `json:"run"`
+	Diverged bool               `json:"diverged"`
+	Output   bool               `json:"output"`
+	Writes   []*writeDivergence `json:"writes,omitempty"`
+	Logs     []*logDivergence   `json:"logs,omitempty"`
+	Calls    *callDivergence    `json:"calls,omitempty"`
+}
+
+// contextSensitivity tells how a tx depends on its context. Reads counts the
+// context opcodes the base run executes, Sensitive names the changes it
+// diverges under and Invalid the ones it cannot run under.
+type contextSensitivity struct {
+	Base      *probeRun       `json:"base"`
+	Reads     map[string]int  `json:"reads"`
+	Probes    []*contextProbe `json:"probes"`
+	Sensitive []string        `json:"sensitive"`
+	Invalid   []string        `json:"invalid"`
+}
+
+func (b *MferBackend) probeRun(ctx context.Context, msg types.Message, blockCtx vm.BlockContext, txCtx vm.TxContext, stateDB *mferstate.OverlayStateDB) (*probeRun, error) {
+	tracer := mfertracer.NewProbeTracer()
+	result, err := b.EVM.DoCallWithTxContext(ctx, &msg, blockCtx, txCtx, tracer, stateDB.Clone())
+	if ctx.Err() != nil {
+		return nil, b.execError(ctx.Err())
+	}
+	run := &probeRun{tracer: tracer}
+	switch {
+	case err != nil:
+		run.Failed, run.Invalid, run.Error = true, true, err.Error()
+	case result.Failed():
+		run.Failed, run.Error, run.Output = true, result.Err.Error(), result.Revert()
+		run.GasUsed = result.UsedGas
+	default:
+		run.Output, run.GasUsed = result.Return(), result.UsedGas
+	}
+	return run, nil
+}
+
+// probeContext runs msg on stateDB in the context of the pending block and
+// in each of changes, nil for the default changes.
+func (b *MferBackend) probeContext(ctx context.Context, msg types.Message, stateDB *mferstate.OverlayStateDB, changes []*contextChange) (*contextSensitivity, error) {
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+	blockCtx, txCtx := b.EVM.GetVMContext(), core.NewEVMTxContext(msg)
+	if changes == nil {
+		changes = defaultContextChanges(blockCtx, txCtx)
+	}
+	for _, change := range changes {
+		if change == nil {
+			return nil, errors.New("empty context change")
+		}
+	}
+	base, err := b.probeRun(ctx, msg, blockCtx, txCtx, stateDB)
+	if err != nil {
+		return nil, err
+	}
+	if base.Invalid {
+		return nil, fmt.Errorf("tx is invalid in the pending block: %s", base.Error)
+	}
+	sensitivity := &contextSensitivity{
+		Base:      base,
+		Reads:     make(map[string]int),
+		Sensitive: []string{},
+		Invalid:   []string{},
+	}
+	for op, n := range base.tracer.Reads() {
+		sensitivity.Reads[op.String()] = n
+	}
+	for _, change := range changes {
+		probeBlockCtx, probeTxCtx := change.apply(blockCtx, txCtx)
+		run, err := b.probeRun(ctx, msg, probeBlockCtx, probeTxCtx, stateDB)
+		if err != nil {
+			return nil, err
+		}
+		if run.Invalid {
+			sensitivity.Invalid = append(sensitivity.Invalid, change.Name)
+			sensitivity.Probes = append(sensitivity.Probes, &contextProbe{Change: change, Run: run})
+			continue
+		}
+		probe := &contextProbe{
+			Change: change,
+			Run:    run,
+			Output: run.Failed != base.Failed || run.Error != base.Error || !bytes.Equal(run.Output, base.Output),
+			Writes: b.writeDivergences(base.tracer.Writes(), run.tracer.Writes()),
+			Logs:   logDivergences(base.tracer.Logs(), run.tracer.Logs()),
+			Calls:  b.callDivergence(base.tracer.Calls(), run.tracer.Calls()),
+		}
+		probe.Diverged = probe.Output || len(probe.Writes) > 0 || len(probe.Logs) > 0 || probe.Calls != nil
+		if probe.Diverged {
+			sensitivity.Sensitive = append(sensitivity.Sensitive, change.Name)
+		}
+		sensitivity.Probes = append(sensitivity.Probes, probe)
+	}
+	return sensitivity, nil
+}
+
+// writeDivergences compares the values the writes of two runs leave.
+func (b *MferBackend) writeDivergences(base, probe []mfertracer.ProbeWrite) []*writeDivergence {
+	type slotKey struct {
+		address common.Address
+		slot    common.Hash
+	}
+	final := func(writes []mfertracer.ProbeWrite) map[slotKey]common.Hash {
+		values := make(map[slotKey]common.Hash)
+		for _, w := range writes {
+			values[slotKey{w.Address, w.Slot}] = w.Value
+		}
+		return values
+	}
+	baseValues, probeValues := final(base), final(probe)
+	var divergences []*writeDivergence
+	for _, values := range []map[slotKey]common.Hash{baseValues, probeValues} {
+		for key := range values {
+			baseValue, inBase := baseValues[key]
+			probeValue, inProbe := probeValues[key]
+			if inBase && inProbe && baseValue == probeValue {
+				continue
+			}
+			if !inBase && !inProbe {
+				continue
+			}
+			d := &writeDivergence{Address: key.address, Label: b.Contracts.Label(key.address), Slot: key.slot}
+			if inBase {
+				d.Base = &baseValue
+			}
+			if inProbe {
+				d.Probe = &probeValue
+			}
+			// a slot written by both runs is found twice
+			delete(baseValues, key)
+			delete(probeValues, key)
+			divergences = append(divergences, d)
+		}
+	}
+	sort.Slice(divergences, func(i, j int) bool {
+		if divergences[i].Address != divergences[j].Address {
+			return bytes.Compare(divergences[i].Address.Bytes(), divergences[j].Address.Bytes()) < 0
+		}
+		return bytes.Compare(divergences[i].Slot.Bytes(), divergences[j].Slot.Bytes()) < 0
+	})
+	return divergences
+}
+
+func logDivergences(base, probe []*types.Log) []*logDivergence {
+	var divergences []*logDivergence
+	for i := 0; i < len(base) || i < len(probe); i++ {
+		d := &logDivergence{Index: i}
+		if i < len(base) {
+			d.Base = base[i]
+		}
+		if i < len(probe) {
+			d.Probe = probe[i]
+		}
+		if d.Base != nil && d.Probe != nil && sameLog(d.Base, d.Probe) {
+			continue
+		}
+		divergences = append(divergences, d)
+	}
+	return divergences
+}
+
+func sameLog(a, b *types.Log) bool {
+	if a.Address != b.Address || len(a.Topics) != len(b.Topics) || !bytes.Equal(a.Data, b.Data) {
+		return false
+	}
+	for i := range a.Topics {
+		if a.Topics[i] != b.Topics[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// callDivergence finds the first frame the call paths of two runs differ
+// at, a frame being its type, its callee, its selector, its value, its depth
+// and whether it failed.
+func (b *MferBackend) callDivergence(base, probe []*mfertracer.ProbeCall) *callDivergence {
+	for i := 0; i < len(base) || i < len(probe); i++ {
+		d := &callDivergence{Index: i}
+		if i < len(base) {
+			d.Base = b.newProbeCall(base[i])
+		}
+		if i < len(probe) {
+			d.Probe = b.newProbeCall(probe[i])
+		}
+		if d.Base == nil || d.Probe == nil || !sameCall(d.Base, d.Probe) {
+			return d
+		}
+	}
+	return nil
+}
+
+func (b *MferBackend) newProbeCall(call *mfertracer.ProbeCall) *probeCall {
+	c := &probeCall{
+		Type:   call.Type,
+		From:   call.From,
+		To:     call.To,
+		Label:  b.Contracts.Label(call.To),
+		Depth:  call.Depth,
+		Failed: call.Failed,
+	}
+	if len(call.Input) >= 4 {
+		c.Selector = call.Input[:4]
+	}
+	if call.Value != nil && call.Value.Sign() > 0 {
+		c.Value = (*hexutil.Big)(call.Value)
+	}
+	return c
+}
+
+func sameCall(a, b *probeCall) bool {
+	return a.Type == b.Type && a.From == b.From && a.To == b.To && bytes.Equal(a.Selector, b.Selector) &&
+		(a.Value == nil) == (b.Value == nil) && (a.Value == nil || a.Value.ToInt().Cmp(b.Value.ToInt()) == 0) &&
+		a.Depth == b.Depth && a.Failed == b.Failed
+}
+
+// ContextSensitivity runs a pool tx in the context of the pending block and
+// again under each of changes, or the default ones that change one field
+// each, and reports what diverges: the output, the storage written, the logs
+// and the call path.
+func (p *ProbeAPI) ContextSensitivity(ctx context.Context, txHash common.Hash, changes *[]*contextChange) (*contextSensitivity, error) {
+	ctx, cancel := p.b.execContext(ctx)
+	defer cancel()
+	tx, stateDB, err := p.b.poolTxState(ctx, txHash)
+	if err != nil {
+		return nil, err
+	}
+	var matrix []*contextChange
+	if changes != nil {
+		matrix = *changes
+	}
+	return p.b.probeContext(ctx, p.b.EVM.TxToMessage(tx), stateDB, matrix)
+}
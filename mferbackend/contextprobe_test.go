@@ -0,0 +1,189 @@
+package mferbackend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/sec-bit/mfer-node/mferabi"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+func TestContextChange(t *testing.T) {
+	random := common.HexToHash("0x01")
+	blockCtx := vm.BlockContext{
+		Time:        big.NewInt(100),
+		BlockNumber: big.NewInt(10),
+		Difficulty:  big.NewInt(0),
+		BaseFee:     big.NewInt(7),
+		Random:      &random,
+		GasLimit:    30000000,
+	}
+	txCtx := vm.TxContext{Origin: common.HexToAddress("0xaaaa"), GasPrice: big.NewInt(7)}
+
+	origin := common.HexToAddress("0xbbbb")
+	timestamp := hexutil.Uint64(200)
+	change := &contextChange{Timestamp: &timestamp, Number: (*hexutil.Big)(big.NewInt(20)), Origin: &origin}
+	probeBlockCtx, probeTxCtx := change.apply(blockCtx, txCtx)
+	if change.Name != "timestamp+number+origin" {
+		t.Errorf("name %q", change.Name)
+	}
+	if probeBlockCtx.Time.Uint64() != 200 || probeBlockCtx.BlockNumber.Uint64() != 20 || probeTxCtx.Origin != origin {
+		t.Errorf("change not applied: %+v %+v", probeBlockCtx, probeTxCtx)
+	}
+	if blockCtx.Time.Uint64() != 100 || blockCtx.BlockNumber.Uint64() != 10 || txCtx.Origin != common.HexToAddress("0xaaaa") {
+		t.Errorf("base context modified: %+v %+v", blockCtx, txCtx)
+	}
+
+	// each default change names the one field it changes
+	for _, change := range defaultContextChanges(blockCtx, txCtx) {
+		name := change.Name
+		change.Name = ""
+		probeBlockCtx, probeTxCtx := change.apply(blockCtx, txCtx)
+		if change.Name != name {
+			t.Errorf("default change %s changes %s", name, change.Name)
+		}
+		if fmt.Sprint(probeBlockCtx, probeTxCtx) == fmt.Sprint(blockCtx, txCtx) {
+			t.Errorf("default change %s changes nothing", name)
+		}
+	}
+	if *probeBlockCtx.Random != random || blockCtx.Difficulty.Sign() != 0 {
+		t.Error("prevRandao modified")
+	}
+}
+
+func TestLogDivergences(t *testing.T) {
+	token := common.HexToAddress("0x1111")
+	transfer := func(amount byte) *types.Log {
+		return &types.Log{Address: token, Topics: []common.Hash{common.HexToHash("0xddf2")}, Data: []byte{amount}}
+	}
+	tests := []struct {
+		base, probe []*types.Log
+		want        []int
+	}{
+		{[]*types.Log{transfer(1)}, []*types.Log{transfer(1)}, nil},
+		{[]*types.Log{transfer(1), transfer(2)}, []*types.Log{transfer(1), transfer(3)}, []int{1}},
+		{[]*types.Log{transfer(1)}, []*types.Log{transfer(1), transfer(2)}, []int{1}},
+		{[]*types.Log{transfer(1)}, nil, []int{0}},
+	}
+	for i, test := range tests {
+		var got []int
+		for _, d := range logDivergences(test.base, test.probe) {
+			got = append(got, d.Index)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("test %d: diverged at %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestWriteDivergences(t *testing.T) {
+	var (
+		a, b = common.HexToAddress("0x1111"), common.HexToAddress("0x2222")
+		one  = common.HexToHash("0x01")
+		two  = common.HexToHash("0x02")
+	)
+	write := func(address common.Address, slot common.Hash, value int64) mfertracer.ProbeWrite {
+		return mfertracer.ProbeWrite{Address: address, Slot: slot, Value: common.BigToHash(big.NewInt(value))}
+	}
+	base := []mfertracer.ProbeWrite{
+		write(b, one, 1),
+		write(a, two, 5), write(a, two, 6), // the final value counts
+		write(a, one, 3),
+		write(b, two, 9),
+	}
+	probe := []mfertracer.ProbeWrite{
+		write(a, one, 3),
+		write(a, two, 7), write(a, two, 6),
+		write(b, one, 2),
+		write(a, common.HexToHash("0x03"), 1),
+	}
+	var got []string
+	for _, d := range (&MferBackend{Contracts: mferabi.NewRegistry()}).writeDivergences(base, probe) {
+		value := func(v *common.Hash) string {
+			if v == nil {
+				return "nil"
+			}
+			return v.Big().String()
+		}
+		got = append(got, fmt.Sprintf("%s:%d %s %s", d.Address.Hex()[38:], d.Slot.Big(), value(d.Base), value(d.Probe)))
+	}
+	// sorted by address and slot, the slots left the same are no divergence
+	want := []string{"1111:3 nil 1", "2222:1 1 2", "2222:2 9 nil"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("divergences %v, want %v", got, want)
+	}
+}
+
+func TestCallDivergence(t *testing.T) {
+	var (
+		router = common.HexToAddress("0x1111")
+		token  = common.HexToAddress("0x2222")
+		pool   = common.HexToAddress("0x3333")
+	)
+	call := func(to common.Address, selector byte, value int64, depth int, failed bool) *mfertracer.ProbeCall {
+		return &mfertracer.ProbeCall{Type: "CALL", From: router, To: to, Input: []byte{selector, 0, 0, 0, 1}, Value: big.NewInt(value), Depth: depth, Failed: failed}
+	}
+	path := func(calls ...*mfertracer.ProbeCall) []*mfertracer.ProbeCall { return calls }
+	base := path(call(router, 1, 0, 0, false), call(token, 2, 0, 1, false), call(pool, 3, 0, 1, false))
+	tests := []struct {
+		name        string
+		probe       []*mfertracer.ProbeCall
+		index       int // -1 for none
+		base, other bool
+	}{
+		{"same path", path(call(router, 1, 0, 0, false), call(token, 2, 0, 1, false), call(pool, 3, 0, 1, false)), -1, false, false},
+		{"other callee", path(call(router, 1, 0, 0, false), call(pool, 2, 0, 1, false), call(pool, 3, 0, 1, false)), 1, true, true},
+		{"other selector", path(call(router, 1, 0, 0, false), call(token, 4, 0, 1, false)), 1, true, true},
+		{"value sent", path(call(router, 1, 0, 0, false), call(token, 2, 5, 1, false)), 1, true, true},
+		{"failed", path(call(router, 1, 0, 0, false), call(token, 2, 0, 1, true)), 1, true, true},
+		{"fewer calls", path(call(router, 1, 0, 0, false), call(token, 2, 0, 1, false)), 2, true, false},
+	}
+	b := &MferBackend{Contracts: mferabi.NewRegistry()}
+	for _, test := range tests {
+		d := b.callDivergence(base, test.probe)
+		if test.index < 0 {
+			if d != nil {
+				t.Errorf("%s: diverged at %d", test.name, d.Index)
+			}
+			continue
+		}
+		if d == nil || d.Index != test.index || (d.Base != nil) != test.base || (d.Probe != nil) != test.other {
+			t.Errorf("%s: divergence %+v", test.name, d)
+		}
+	}
+}
+
+func TestProbeContext(t *testing.T) {
+	clock := common.HexToAddress("0xc10c")
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		testSender: {balance: big.NewInt(1e18)},
+		// stores TIMESTAMP in slot 0
+		clock: {code: []byte{byte(vm.TIMESTAMP), byte(vm.PUSH1), 0, byte(vm.SSTORE), byte(vm.STOP)}},
+	})
+	// a fee cap of 1 wei, below the base fee of the default baseFee change
+	msg := types.NewMessage(testSender, &clock, 0, new(big.Int), 100000, big.NewInt(1), big.NewInt(1), big.NewInt(1), nil, nil, false)
+	sensitivity, err := b.probeContext(context.Background(), msg, b.EVM.StateDB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sensitivity.Base.Failed || sensitivity.Reads["TIMESTAMP"] != 1 {
+		t.Fatalf("base run %+v, reads %v", sensitivity.Base, sensitivity.Reads)
+	}
+	if fmt.Sprint(sensitivity.Sensitive) != "[timestamp]" || fmt.Sprint(sensitivity.Invalid) != "[baseFee]" {
+		t.Errorf("sensitive %v, invalid %v", sensitivity.Sensitive, sensitivity.Invalid)
+	}
+	for _, probe := range sensitivity.Probes {
+		if probe.Change.Name == "baseFee" && (!probe.Run.Invalid || probe.Diverged || probe.Output) {
+			t.Errorf("baseFee probe %+v, run %+v", probe, probe.Run)
+		}
+		if probe.Change.Name == "timestamp" && (len(probe.Writes) != 1 || probe.Writes[0].Address != clock || probe.Output) {
+			t.Errorf("timestamp probe %+v", probe)
+		}
+	}
+}
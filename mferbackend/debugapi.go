@@ -13,6 +13,7 @@ import (
 	"github.com/ethereum/go-ethereum/eth/tracers"
 	"github.com/ethereum/go-ethereum/eth/tracers/logger"
 	"github.com/kataras/golog"
+	"github.com/sec-bit/mfer-node/mferabi"
 	"github.com/sec-bit/mfer-node/mfertracer"
 )
 
@@ -140,18 +141,21 @@ func (s *DebugAPI) TraceTransaction(ctx context.Context, txHash common.Hash, con
 	}
 	// Run the transaction with tracing enabled.
 
+	execCtx, cancel := s.b.execContext(ctx)
+	defer cancel()
 	stateDB := s.b.EVM.StateDB.CloneFromRoot()
 	stateDB.InitFakeAccounts()
-	s.b.EVM.ExecuteTxs(txs, stateDB, nil)
+	s.b.replayTxs(execCtx, txs, stateDB)
 
 	s.b.EVM.SetTracer(tracer)
 	msg := s.b.EVM.TxToMessage(txToBeTraced)
 
-	result, err := s.b.EVM.DoCall(&msg, true, stateDB)
+	result, err := s.b.EVM.DoCall(execCtx, &msg, true, stateDB)
 	if err != nil {
-		return nil, err
+		return nil, s.b.execError(err)
 	}
 	// Depending on the tracer type, format and return the output.
+	var traceResult interface{}
 	switch tracer := tracer.(type) {
 	case *logger.StructLogger:
 		// If the result contains a revert reason, return it.
@@ -159,25 +163,34 @@ func (s *DebugAPI) TraceTransaction(ctx context.Context, txHash common.Hash, con
 		if len(result.Revert()) > 0 {
 			returnVal = fmt.Sprintf("%x", result.Revert())
 		}
-		return &ExecutionResult{
+		traceResult = &ExecutionResult{
 			Gas:         result.UsedGas,
 			Failed:      result.Failed(),
 			ReturnValue: returnVal,
 			StructLogs:  FormatLogs(tracer.StructLogs()),
-		}, nil
+		}
 
 	case tracers.Tracer:
-		return tracer.GetResult()
+		result, err := tracer.GetResult()
+		if err != nil {
+			return nil, err
+		}
+		traceResult = s.b.newDecoder(execCtx, stateDB).trace(result)
 
 	default:
 		panic(fmt.Sprintf("bad tracer type %T", tracer))
 	}
+	if err := s.b.checkResultSize(traceResult); err != nil {
+		return nil, err
+	}
+	return traceResult, nil
 }
 
+// Preimage returns a keccak preimage recorded while executing the pool.
 func (s *DebugAPI) Preimage(ctx context.Context, hash common.Hash) (hexutil.Bytes, error) {
-	// if preimage := s.b.EVM.StateDB.ReadPreimage(api.eth.ChainDb(), hash); preimage != nil {
-	// 	return preimage, nil
-	// }
+	if preimage := s.b.EVM.StateDB.Preimage(hash); preimage != nil {
+		return preimage, nil
+	}
 	return nil, errors.New("unknown preimage")
 }
 
@@ -188,9 +201,12 @@ type StorageRangeResult struct {
 
 type storageMap map[common.Hash]storageEntry
 
+// storageEntry is a slot, Decoded holds its variables when a storage layout
+// is registered for the contract.
 type storageEntry struct {
-	Key   *common.Hash `json:"key"`
-	Value common.Hash  `json:"value"`
+	Key     *common.Hash          `json:"key"`
+	Value   common.Hash           `json:"value"`
+	Decoded []*mferabi.StorageVar `json:"decoded,omitempty"`
 }
 
 // StorageRangeAt returns the storage at the given block height and transaction index.
@@ -224,6 +240,8 @@ func (s *DebugAPI) StorageRangeAt(ctx context.Context, blockHash common.Hash, tx
 	// txs = txs[:txIndex]
 
 	// Run the transaction with tracing enabled.
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
 	stateDB := s.b.EVM.StateDB.CloneFromRoot()
 	stateDB.InitFakeAccounts()
 	tracer := mfertracer.NewStateTracer()
@@ -231,17 +249,22 @@ func (s *DebugAPI) StorageRangeAt(ctx context.Context, blockHash common.Hash, tx
 
 	for _, tx := range txs {
 		msg := s.b.EVM.TxToMessage(tx)
-		s.b.EVM.DoCall(&msg, true, stateDB) //collect trace
-		s.b.EVM.ExecuteTxs(types.Transactions{tx}, stateDB, nil)
+		s.b.EVM.DoCall(ctx, &msg, true, stateDB) //collect trace
+		s.b.replayTxs(ctx, types.Transactions{tx}, stateDB)
+	}
+	if err := ctx.Err(); err != nil {
+		return StorageRangeResult{}, s.b.execError(err)
 	}
 
 	touchedState := tracer.GetResult()
 	spew.Dump(touchedState)
 	contractState := touchedState[contractAddress]
 
+	dec := s.b.newDecoder(ctx, stateDB)
 	for key := range contractState {
+		key := key
 		val := stateDB.GetState(contractAddress, key)
-		result.Storage[key] = storageEntry{Key: &key, Value: val}
+		result.Storage[key] = storageEntry{Key: &key, Value: val, Decoded: dec.storage(contractAddress, key, val)}
 	}
 
 	return result, nil
@@ -0,0 +1,399 @@
+package mferbackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/sec-bit/mfer-node/mferstate"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+const (
+	// maxDebugSteps bounds the opcodes of a debugged call.
+	maxDebugSteps = 1 << 20
+	// debugStepSize is about the memory a recorded step takes.
+	debugStepSize = 128
+	// maxDebugSessions is the number of debug sessions a client keeps open,
+	// its least recently used one is closed to open another.
+	maxDebugSessions = 16
+	// maxDebugClients is the number of clients whose step budgets all debug
+	// sessions share.
+	maxDebugClients = 8
+)
+
+// debugBreakpoint stops a debug session at the steps matching all of its
+// fields. Address matches the account the code comes from, or the account
+// written to when Slot is set. Slot matches the SSTOREs of the slot and Depth
+// the steps where the call depth becomes depth, 1 being the top call.
+type debugBreakpoint struct {
+	Address *common.Address `json:"address,omitempty"`
+	Pc      *hexutil.Uint64 `json:"pc,omitempty"`
+	Op      string          `json:"op,omitempty"`
+	Slot    *common.Hash    `json:"slot,omitempty"`
+	Depth   *int            `json:"depth,omitempty"`
+
+	op vm.OpCode
+}
+
+func (bp *debugBreakpoint) validate() error {
+	if bp.Address == nil && bp.Pc == nil && bp.Op == "" && bp.Slot == nil && bp.Depth == nil {
+		return errors.New("empty breakpoint")
+	}
+	if bp.Op != "" {
+		bp.op = vm.StringToOp(bp.Op)
+		if bp.op.String() != bp.Op {
+			return fmt.Errorf("unknown opcode %s", bp.Op)
+		}
+	}
+	return nil
+}
+
+func (bp *debugBreakpoint) match(steps []mfertracer.Step, i int) bool {
+	step := &steps[i]
+	if bp.Slot != nil {
+		if step.Slot == nil || *step.Slot != *bp.Slot {
+			return false
+		}
+		if bp.Address != nil && *bp.Address != step.Address {
+			return false
+		}
+	} else if bp.Address != nil && *bp.Address != step.CodeAddress {
+		return false
+	}
+	if bp.Pc != nil && uint64(*bp.Pc) != step.Pc {
+		return false
+	}
+	if bp.Op != "" && bp.op != step.Op {
+		return false
+	}
+	if bp.Depth != nil && (step.Depth != *bp.Depth || i > 0 && steps[i-1].Depth == step.Depth) {
+		return false
+	}
+	return true
+}
+
+// debugStep is a step of a debugged call with the machine state before it,
+// Storage holds the slots of Address read or written so far.
+type debugStep struct {
+	Pc          uint64                      `json:"pc"`
+	Op          string                      `json:"op"`
+	Gas         uint64                      `json:"gas"`
+	GasCost     uint64                      `json:"gasCost"`
+	Depth       int                         `json:"depth"`
+	Address     common.Address              `json:"address"`
+	CodeAddress common.Address              `json:"codeAddress"`
+	Label       string                      `json:"label,omitempty"`
+	Error       string                      `json:"error,omitempty"`
+	Stack       []string                    `json:"stack"`
+	Memory      []string                    `json:"memory"`
+	Storage     map[common.Hash]common.Hash `json:"storage"`
+	ReturnData  hexutil.Bytes               `json:"returnData"`
+}
+
+// debugState is the position of a debug session, Breakpoint is the index of
+// the breakpoint it stopped at.
+type debugState struct {
+	ID          string        `json:"id"`
+	Steps       int           `json:"steps"`
+	Index       int           `json:"index"`
+	Breakpoint  *int          `json:"breakpoint,omitempty"`
+	Step        *debugStep    `json:"step,omitempty"`
+	GasUsed     uint64        `json:"gasUsed"`
+	Failed      bool          `json:"failed"`
+	ReturnValue hexutil.Bytes `json:"returnValue"`
+}
+
+// debugSession is a recorded call. The machine state of a step is taken by
+// running the call again on the state before it, in the block context it was
+// recorded in, so moving back costs the same as moving forward. The state is
+// replayed from the root state, later pool txs do not show through it.
+type debugSession struct {
+	mutex       sync.Mutex
+	id          string
+	client      string
+	msg         types.Message
+	blockCtx    vm.BlockContext
+	stateDB     *mferstate.OverlayStateDB
+	steps       []mfertracer.Step
+	result      *core.ExecutionResult
+	index       int
+	breakpoints []*debugBreakpoint
+	lastUsed    time.Time
+}
+
+// debugger holds the debug sessions of a backend by client. The steps
+// recorded for a client are bounded by a budget, the steps of all sessions by
+// maxDebugClients budgets.
+type debugger struct {
+	mutex    sync.Mutex
+	sessions map[string]*debugSession
+}
+
+func newDebugger() *debugger {
+	return &debugger{sessions: make(map[string]*debugSession)}
+}
+
+// debugStepBudget is the number of steps the sessions of a client may hold,
+// their memory is bounded like a trace result.
+func (b *MferBackend) debugStepBudget() int {
+	if b.Limits.MaxResultSize <= 0 || b.Limits.MaxResultSize/debugStepSize > maxDebugSteps {
+		return maxDebugSteps
+	}
+	return b.Limits.MaxResultSize / debugStepSize
+}
+
+// add keeps session, closing the least recently used sessions of its client,
+// or of any client, the budgets would be exceeded by.
+func (d *debugger) add(session *debugSession, budget int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for {
+		var (
+			oldest, oldestOwn *debugSession
+			steps, ownSteps   int
+			own               int
+		)
+		for _, s := range d.sessions {
+			steps += len(s.steps)
+			if oldest == nil || s.lastUsed.Before(oldest.lastUsed) {
+				oldest = s
+			}
+			if s.client != session.client {
+				continue
+			}
+			own++
+			ownSteps += len(s.steps)
+			if oldestOwn == nil || s.lastUsed.Before(oldestOwn.lastUsed) {
+				oldestOwn = s
+			}
+		}
+		switch {
+		case oldestOwn != nil && (own >= maxDebugSessions || ownSteps+len(session.steps) > budget):
+			delete(d.sessions, oldestOwn.id)
+		case oldest != nil && steps+len(session.steps) > maxDebugClients*budget:
+			delete(d.sessions, oldest.id)
+		default:
+			d.sessions[session.id] = session
+			return
+		}
+	}
+}
+
+// get finds session id of client, the sessions of other clients are not
+// found.
+func (d *debugger) get(id, client string) (*debugSession, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	session, ok := d.sessions[id]
+	if !ok || session.client != client {
+		return nil, fmt.Errorf("debug session %s not found", id)
+	}
+	session.lastUsed = time.Now()
+	return session, nil
+}
+
+func (d *debugger) close(id, client string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if session, ok := d.sessions[id]; !ok || session.client != client {
+		return fmt.Errorf("debug session %s not found", id)
+	}
+	delete(d.sessions, id)
+	return nil
+}
+
+// openDebugSession records msg on stateDB, the state before the call
+// replayed from the root state, and opens a session at its first step.
+func (b *MferBackend) openDebugSession(ctx context.Context, msg types.Message, stateDB *mferstate.OverlayStateDB) (*debugState, error) {
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+	budget := b.debugStepBudget()
+	blockCtx := b.EVM.GetVMContext()
+	tracer := mfertracer.NewStepTracer(budget)
+	result, err := b.EVM.DoCallWithBlockContext(ctx, &msg, blockCtx, tracer, stateDB.Clone())
+	// the tracer aborts the call past the budget
+	steps, stepsErr := tracer.Steps()
+	if stepsErr != nil {
+		return nil, fmt.Errorf("%v: more than %d steps", stepsErr, budget)
+	}
+	if err != nil {
+		return nil, b.execError(err)
+	}
+	session := &debugSession{
+		id:       newSessionID(),
+		client:   requestClient(ctx),
+		msg:      msg,
+		blockCtx: blockCtx,
+		stateDB:  stateDB,
+		steps:    steps,
+		result:   result,
+		lastUsed: time.Now(),
+	}
+	b.debugger.add(session, budget)
+	return b.debugMove(ctx, session.id, func(s *debugSession) (int, *int) {
+		return 0, nil
+	})
+}
+
+// debugMove moves the session to the step move returns and describes it,
+// move is called with the session locked.
+func (b *MferBackend) debugMove(ctx context.Context, id string, move func(*debugSession) (int, *int)) (*debugState, error) {
+	session, err := b.debugger.get(id, requestClient(ctx))
+	if err != nil {
+		return nil, err
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	index, breakpoint := move(session)
+	if index >= len(session.steps) {
+		index = len(session.steps) - 1
+	}
+	if index < 0 {
+		index = 0
+	}
+	session.index = index
+
+	state := &debugState{
+		ID:          session.id,
+		Steps:       len(session.steps),
+		Index:       index,
+		Breakpoint:  breakpoint,
+		GasUsed:     session.result.UsedGas,
+		Failed:      session.result.Failed(),
+		ReturnValue: session.result.Return(),
+	}
+	if session.result.Failed() {
+		state.ReturnValue = session.result.Revert()
+	}
+	if len(session.steps) == 0 {
+		return state, nil
+	}
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+	tracer := mfertracer.NewSnapshotTracer(index)
+	msg := session.msg
+	if _, err := b.EVM.DoCallWithBlockContext(ctx, &msg, session.blockCtx, tracer, session.stateDB.Clone()); err != nil && ctx.Err() != nil {
+		return nil, b.execError(ctx.Err())
+	}
+	snapshot := tracer.Snapshot()
+	if snapshot == nil {
+		return nil, fmt.Errorf("step %d not reached", index)
+	}
+	step := &session.steps[index]
+	state.Step = &debugStep{
+		Pc:          step.Pc,
+		Op:          step.Op.String(),
+		Gas:         step.Gas,
+		GasCost:     step.Cost,
+		Depth:       step.Depth,
+		Address:     step.Address,
+		CodeAddress: step.CodeAddress,
+		Label:       b.Contracts.Label(step.CodeAddress),
+		Stack:       make([]string, len(snapshot.Stack)),
+		Memory:      make([]string, 0, len(snapshot.Memory)/32),
+		Storage:     snapshot.Storage,
+		ReturnData:  snapshot.ReturnData,
+	}
+	if step.Err != nil {
+		state.Step.Error = step.Err.Error()
+	}
+	for i, value := range snapshot.Stack {
+		state.Step.Stack[i] = value.Hex()
+	}
+	for i := 0; i+32 <= len(snapshot.Memory); i += 32 {
+		state.Step.Memory = append(state.Step.Memory, fmt.Sprintf("%x", snapshot.Memory[i:i+32]))
+	}
+	return state, nil
+}
+
+// DebugTransaction opens a debug session on a pool tx, on the state the
+// txs before it leave.
+func (s *MferActionAPI) DebugTransaction(ctx context.Context, txHash common.Hash) (*debugState, error) {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	tx, stateDB, err := s.b.poolTxState(ctx, txHash)
+	if err != nil {
+		return nil, err
+	}
+	return s.b.openDebugSession(ctx, s.b.EVM.TxToMessage(tx), stateDB)
+}
+
+// DebugCall opens a debug session on a call on the pending state, like
+// eth_call.
+func (s *MferActionAPI) DebugCall(ctx context.Context, args TransactionArgs, overrides *mferstate.StateOverride) (*debugState, error) {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	msg, stateDB, err := s.b.callStateOf(args, overrides, func() *mferstate.OverlayStateDB { return s.b.poolState(ctx) })
+	if err != nil {
+		return nil, err
+	}
+	return s.b.openDebugSession(ctx, msg, stateDB)
+}
+
+// DebugStep moves a debug session n steps, backwards if n is negative.
+func (s *MferActionAPI) DebugStep(ctx context.Context, id string, n int) (*debugState, error) {
+	return s.b.debugMove(ctx, id, func(session *debugSession) (int, *int) {
+		return session.index + n, nil
+	})
+}
+
+// DebugGoto moves a debug session to a step.
+func (s *MferActionAPI) DebugGoto(ctx context.Context, id string, index int) (*debugState, error) {
+	return s.b.debugMove(ctx, id, func(*debugSession) (int, *int) {
+		return index, nil
+	})
+}
+
+// DebugContinue moves a debug session to the next step matching a
+// breakpoint, the previous one if reverse is set. Without any it stops at the
+// last (first) step.
+func (s *MferActionAPI) DebugContinue(ctx context.Context, id string, reverse bool) (*debugState, error) {
+	return s.b.debugMove(ctx, id, func(session *debugSession) (int, *int) {
+		next, end := 1, len(session.steps)
+		if reverse {
+			next, end = -1, -1
+		}
+		for i := session.index + next; i != end; i += next {
+			for j, bp := range session.breakpoints {
+				if bp.match(session.steps, i) {
+					j := j
+					return i, &j
+				}
+			}
+		}
+		return end - next, nil
+	})
+}
+
+// DebugSetBreakpoints replaces the breakpoints of a debug session.
+func (s *MferActionAPI) DebugSetBreakpoints(ctx context.Context, id string, breakpoints []*debugBreakpoint) error {
+	for i, bp := range breakpoints {
+		if bp == nil {
+			return fmt.Errorf("breakpoint %d: empty breakpoint", i)
+		}
+		if err := bp.validate(); err != nil {
+			return fmt.Errorf("breakpoint %d: %v", i, err)
+		}
+	}
+	session, err := s.b.debugger.get(id, requestClient(ctx))
+	if err != nil {
+		return err
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.breakpoints = breakpoints
+	return nil
+}
+
+// DebugClose closes a debug session.
+func (s *MferActionAPI) DebugClose(ctx context.Context, id string) error {
+	return s.b.debugger.close(id, requestClient(ctx))
+}
@@ -0,0 +1,113 @@
+package mferbackend
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+func TestDebugBreakpoint(t *testing.T) {
+	proxy, impl := common.HexToAddress("0x1967"), common.HexToAddress("0x3333")
+	slot := common.HexToHash("0x01")
+	steps := []mfertracer.Step{
+		{Pc: 0, Op: vm.PUSH1, Depth: 1, Address: proxy, CodeAddress: proxy},
+		{Pc: 2, Op: vm.DELEGATECALL, Depth: 1, Address: proxy, CodeAddress: proxy},
+		{Pc: 0, Op: vm.PUSH1, Depth: 2, Address: proxy, CodeAddress: impl},
+		{Pc: 2, Op: vm.SSTORE, Depth: 2, Address: proxy, CodeAddress: impl, Slot: &slot},
+		{Pc: 3, Op: vm.STOP, Depth: 2, Address: proxy, CodeAddress: impl},
+		{Pc: 3, Op: vm.STOP, Depth: 1, Address: proxy, CodeAddress: proxy},
+	}
+	pc := hexutil.Uint64(2)
+	depth := 2
+	tests := []struct {
+		name string
+		bp   debugBreakpoint
+		want []int
+	}{
+		{"address and pc", debugBreakpoint{Address: &impl, Pc: &pc}, []int{3}},
+		{"opcode", debugBreakpoint{Op: "STOP"}, []int{4, 5}},
+		{"slot write of the proxy", debugBreakpoint{Slot: &slot, Address: &proxy}, []int{3}},
+		{"slot write of the implementation", debugBreakpoint{Slot: &slot, Address: &impl}, nil},
+		{"depth", debugBreakpoint{Depth: &depth}, []int{2}},
+	}
+	for _, tt := range tests {
+		if err := tt.bp.validate(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []int
+		for i := range steps {
+			if tt.bp.match(steps, i) {
+				got = append(got, i)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: matches %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	for _, bp := range []debugBreakpoint{{}, {Op: "JUMPY"}} {
+		if err := bp.validate(); err == nil {
+			t.Errorf("breakpoint %+v is valid", bp)
+		}
+	}
+}
+
+func TestDebuggerSessions(t *testing.T) {
+	d := newDebugger()
+	// the sessions are used in the order they are opened, before any get
+	lastUsed := time.Now().Add(-time.Hour)
+	session := func(id, client string, steps int) *debugSession {
+		lastUsed = lastUsed.Add(time.Second)
+		return &debugSession{id: id, client: client, steps: make([]mfertracer.Step, steps), lastUsed: lastUsed}
+	}
+	d.add(session("a1", "a", 40), 100)
+	d.add(session("b1", "b", 40), 100)
+	d.add(session("a2", "a", 40), 100)
+	if _, err := d.get("a1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// the other client does not see the session
+	if _, err := d.get("a1", "b"); err == nil {
+		t.Error("session a1 found by client b")
+	}
+	if err := d.close("a1", "b"); err == nil {
+		t.Error("session a1 closed by client b")
+	}
+	// over the budget of a, its least recently used session a2 is closed
+	d.add(session("a3", "a", 40), 100)
+	if _, err := d.get("a2", "a"); err == nil {
+		t.Error("session a2 kept over the budget")
+	}
+	for _, id := range []string{"a1", "a3"} {
+		if _, err := d.get(id, "a"); err != nil {
+			t.Errorf("session %s: %v", id, err)
+		}
+	}
+	if _, err := d.get("b1", "b"); err != nil {
+		t.Errorf("session b1 closed by client a: %v", err)
+	}
+	for i := 0; i < maxDebugSessions+1; i++ {
+		d.add(session(fmt.Sprint("c", i), "c", 0), 100)
+	}
+	if _, err := d.get("c0", "c"); err == nil {
+		t.Errorf("more than %d sessions kept", maxDebugSessions)
+	}
+	// all sessions share maxDebugClients budgets
+	for i := 0; i < maxDebugClients; i++ {
+		d.add(session(fmt.Sprint("d", i), fmt.Sprint("d", i), 100), 100)
+	}
+	steps := 0
+	for _, s := range d.sessions {
+		steps += len(s.steps)
+	}
+	if steps > maxDebugClients*100 {
+		t.Errorf("%d steps kept, more than %d budgets", steps, maxDebugClients)
+	}
+	if _, err := d.get(fmt.Sprint("d", maxDebugClients-1), fmt.Sprint("d", maxDebugClients-1)); err != nil {
+		t.Errorf("newest session: %v", err)
+	}
+}
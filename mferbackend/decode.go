@@ -0,0 +1,216 @@
+package mferbackend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sec-bit/mfer-node/mferabi"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+// decodedLog is a log decoded with the signature db or the ABI registered for
+// its emitter, Label is the label of the emitter.
+type decodedLog struct {
+	Address common.Address `json:"address"`
+	Label   string         `json:"label,omitempty"`
+	*mferabi.Decoded
+}
+
+// decoder decodes calldata, logs and revert data with the ABIs registered for
+// the contracts involved, then with the signature db. Proxies are resolved on
+// stateDB and the results cached for the lifetime of the decoder.
+type decoder struct {
+	b        *MferBackend
+	ctx      context.Context
+	stateDB  *mferstate.OverlayStateDB
+	proxies  map[common.Address]*proxyInfo
+	diamonds map[common.Address]bool
+	facets   map[string]*proxyInfo
+	layouts  map[common.Address]*mferabi.StorageLayout
+
+	preimages *mferabi.Preimages
+}
+
+func (b *MferBackend) newDecoder(ctx context.Context, stateDB *mferstate.OverlayStateDB) *decoder {
+	return &decoder{
+		b:        b,
+		ctx:      ctx,
+		stateDB:  stateDB,
+		proxies:  make(map[common.Address]*proxyInfo),
+		diamonds: make(map[common.Address]bool),
+		facets:   make(map[string]*proxyInfo),
+		layouts:  make(map[common.Address]*mferabi.StorageLayout),
+	}
+}
+
+// contracts lists address and the implementations behind it whose ABIs are
+// registered, the diamond facet of selector included. Proxies are only
+// resolved when ABIs are registered, facets only for diamonds.
+func (d *decoder) contracts(address common.Address, selector []byte) []common.Address {
+	contracts := []common.Address{address}
+	if !d.b.Contracts.HasABIs() {
+		return contracts
+	}
+	proxy := d.proxy(address)
+	if proxy != nil {
+		contracts = append(contracts, proxy.Implementation)
+	}
+	if len(selector) == 4 && d.b.Contracts.Signatures(address) == nil && proxy == nil && d.diamond(address) {
+		key := string(address.Bytes()) + string(selector)
+		facet, ok := d.facets[key]
+		if !ok {
+			facet = d.b.resolveFacet(d.ctx, d.stateDB, address, selector)
+			d.facets[key] = facet
+		}
+		if facet != nil {
+			contracts = append(contracts, facet.Implementation)
+		}
+	}
+	return contracts
+}
+
+// proxy resolves the proxy at address, nil if it is none.
+func (d *decoder) proxy(address common.Address) *proxyInfo {
+	proxy, ok := d.proxies[address]
+	if !ok {
+		proxy = d.b.resolveProxy(d.ctx, d.stateDB, address)
+		d.proxies[address] = proxy
+	}
+	return proxy
+}
+
+// diamond reports whether address is an EIP-2535 diamond: it is registered
+// as one, emitted DiamondCut in the logs decoded or implements the loupe.
+func (d *decoder) diamond(address common.Address) bool {
+	diamond, ok := d.diamonds[address]
+	if !ok {
+		diamond = d.b.Contracts.Diamond(address) || d.b.supportsLoupe(d.ctx, d.stateDB, address)
+		d.diamonds[address] = diamond
+	}
+	return diamond
+}
+
+// decode tries the ABIs registered for address and its implementations, then
+// the signature db.
+func (d *decoder) decode(address common.Address, selector []byte, decode func(*mferabi.SignatureDB) *mferabi.Decoded) *mferabi.Decoded {
+	for _, contract := range d.contracts(address, selector) {
+		signatures := d.b.Contracts.Signatures(contract)
+		if signatures == nil {
+			continue
+		}
+		if decoded := decode(signatures); decoded != nil {
+			if contract != address {
+				contract := contract
+				decoded.Implementation = &contract
+			}
+			return decoded
+		}
+	}
+	return decode(d.b.Signatures)
+}
+
+// call decodes calldata sent to to.
+func (d *decoder) call(to common.Address, data []byte) *mferabi.Decoded {
+	if len(data) < 4 {
+		return nil
+	}
+	return d.decode(to, data[:4], func(db *mferabi.SignatureDB) *mferabi.Decoded {
+		return db.DecodeCall(data)
+	})
+}
+
+// revert decodes the revert data of a call to to.
+func (d *decoder) revert(to common.Address, data []byte) *mferabi.Decoded {
+	if len(data) < 4 {
+		return nil
+	}
+	return d.decode(to, nil, func(db *mferabi.SignatureDB) *mferabi.Decoded {
+		return db.DecodeError(data)
+	})
+}
+
+// logs decodes logs, the logs neither decoded nor labeled are nil.
+func (d *decoder) logs(logs []*types.Log) []*decodedLog {
+	for _, l := range logs {
+		if len(l.Topics) > 0 && l.Topics[0] == diamondCutEventTopic {
+			d.diamonds[l.Address] = true
+		}
+	}
+	decoded := make([]*decodedLog, len(logs))
+	for i, l := range logs {
+		log := &decodedLog{
+			Address: l.Address,
+			Label:   d.b.Contracts.Label(l.Address),
+			Decoded: d.decode(l.Address, nil, func(db *mferabi.SignatureDB) *mferabi.Decoded {
+				return db.DecodeLog(l.Topics, l.Data)
+			}),
+		}
+		if log.Decoded != nil || log.Label != "" {
+			decoded[i] = log
+		}
+	}
+	return decoded
+}
+
+// trace adds the decoded input and revert data to every frame of a
+// callTracer trace as decodedInput and decodedError, and the labels of the
+// caller and callee as fromLabel and toLabel. The output of any other tracer
+// is returned as is.
+func (d *decoder) trace(trace json.RawMessage) json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(trace))
+	dec.UseNumber()
+	var frame map[string]interface{}
+	if err := dec.Decode(&frame); err != nil {
+		return trace
+	}
+	if _, ok := frame["type"].(string); !ok {
+		return trace
+	}
+	d.frame(frame)
+	decoded, err := json.Marshal(frame)
+	if err != nil {
+		return trace
+	}
+	return decoded
+}
+
+func (d *decoder) frame(frame map[string]interface{}) {
+	for _, field := range []string{"from", "to"} {
+		if address, ok := frame[field].(string); ok && common.IsHexAddress(address) {
+			if label := d.b.Contracts.Label(common.HexToAddress(address)); label != "" {
+				frame[field+"Label"] = label
+			}
+		}
+	}
+	to, _ := frame["to"].(string)
+	switch frame["type"] {
+	case "CREATE", "CREATE2", "SELFDESTRUCT":
+	default:
+		if input, ok := frame["input"].(string); ok && common.IsHexAddress(to) {
+			if data, err := hexutil.Decode(input); err == nil {
+				if decoded := d.call(common.HexToAddress(to), data); decoded != nil {
+					frame["decodedInput"] = decoded
+				}
+			}
+		}
+	}
+	if _, failed := frame["error"]; failed {
+		if output, ok := frame["output"].(string); ok && common.IsHexAddress(to) {
+			if data, err := hexutil.Decode(output); err == nil {
+				if decoded := d.revert(common.HexToAddress(to), data); decoded != nil {
+					frame["decodedError"] = decoded
+				}
+			}
+		}
+	}
+	calls, _ := frame["calls"].([]interface{})
+	for _, call := range calls {
+		if call, ok := call.(map[string]interface{}); ok {
+			d.frame(call)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package mferbackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/kataras/golog"
+)
+
+// estimateGas binary searches the lowest gas limit args executes with on the
+// overlay state, between the intrinsic gas and the block gas limit (or the gas
+// of args), like geth does. The limit is capped by what the sender can pay at
+// the gas price of args, the calls themselves run at a zero gas price.
+// GasMargin percent is added to the result.
+func (b *MferBackend) estimateGas(ctx context.Context, args TransactionArgs) (hexutil.Uint64, error) {
+	var (
+		lo  = params.TxGas - 1
+		hi  = b.EVM.GetVMContext().GasLimit
+		cap uint64
+	)
+	if args.Gas != nil && uint64(*args.Gas) >= params.TxGas {
+		hi = uint64(*args.Gas)
+	}
+	// Recap the highest gas limit with the balance of the sender.
+	feeCap := args.GasPrice
+	if feeCap == nil {
+		feeCap = args.MaxFeePerGas
+	}
+	if feeCap != nil && feeCap.ToInt().BitLen() != 0 && args.From != nil {
+		available := new(big.Int).Set(b.EVM.StateDB.GetBalance(*args.From))
+		if args.Value != nil {
+			if args.Value.ToInt().Cmp(available) >= 0 {
+				return 0, core.ErrInsufficientFundsForTransfer
+			}
+			available.Sub(available, args.Value.ToInt())
+		}
+		allowance := new(big.Int).Div(available, feeCap.ToInt())
+		if allowance.IsUint64() && hi > allowance.Uint64() {
+			golog.Warnf("Gas estimation capped by limited funds, original: %d, balance: %s, feecap: %s, fundable: %d", hi, available, feeCap.ToInt(), allowance.Uint64())
+			hi = allowance.Uint64()
+		}
+	}
+	args.GasPrice, args.MaxFeePerGas, args.MaxPriorityFeePerGas = nil, nil, nil
+	if b.Limits.GasCap != 0 && hi > b.Limits.GasCap {
+		hi = b.Limits.GasCap
+	}
+	cap = hi
+
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+
+	// executable reports whether the call fails with the given gas, running
+	// out of intrinsic gas counts as a failure.
+	executable := func(gas uint64) (bool, *core.ExecutionResult, error) {
+		args.Gas = (*hexutil.Uint64)(&gas)
+		msg, err := args.ToMessage(0, nil)
+		if err != nil {
+			return true, nil, err
+		}
+		result, err := b.EVM.DoCall(ctx, &msg, false, b.EVM.StateDB.Clone())
+		if err != nil {
+			if errors.Is(err, core.ErrIntrinsicGas) {
+				return true, nil, nil
+			}
+			return true, nil, err
+		}
+		return result.Failed(), result, nil
+	}
+
+	// Fail fast if the call does not succeed with the highest allowance, a
+	// revert at every gas level is reported with its reason.
+	failed, result, err := executable(hi)
+	if err != nil {
+		return 0, b.execError(err)
+	}
+	if failed {
+		if result != nil && result.Err != vm.ErrOutOfGas {
+			if len(result.Revert()) > 0 {
+				return 0, b.newDecoder(ctx, b.EVM.StateDB).revertError(args.To, result, nil)
+			}
+			return 0, result.Err
+		}
+		return 0, fmt.Errorf("gas required exceeds allowance (%d)", cap)
+	}
+	// The call needs at least the gas it used.
+	if result.UsedGas-1 > lo {
+		lo = result.UsedGas - 1
+	}
+	for lo+1 < hi {
+		mid := (hi + lo) / 2
+		failed, _, err := executable(mid)
+		if err != nil {
+			return 0, b.execError(err)
+		}
+		if failed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	if b.GasMargin > 0 {
+		hi += hi * b.GasMargin / 100
+		if hi > cap {
+			hi = cap
+		}
+	}
+	return hexutil.Uint64(hi), nil
+}
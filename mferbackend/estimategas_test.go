@@ -0,0 +1,62 @@
+package mferbackend
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+)
+
+func TestEstimateGas(t *testing.T) {
+	var (
+		writer   = common.HexToAddress("0x5705e")
+		reverter = common.HexToAddress("0x5e7e")
+		poor     = common.HexToAddress("0x9009")
+	)
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		testSender: {balance: big.NewInt(1e9)},
+		poor:       {balance: big.NewInt(30000)},
+		// stores 1 in slot 0, 21000 + 6 + 22100 gas
+		writer:   {code: storeCode},
+		reverter: {code: revertCode},
+	})
+	api := &EthAPI{b}
+	estimate := func(from, to common.Address, gasPrice, value int64) (hexutil.Uint64, error) {
+		args := TransactionArgs{From: &from, To: &to, GasPrice: (*hexutil.Big)(big.NewInt(gasPrice))}
+		if value != 0 {
+			args.Value = (*hexutil.Big)(big.NewInt(value))
+		}
+		return api.EstimateGas(context.Background(), args, nil)
+	}
+
+	if gas, err := estimate(testSender, writer, 1, 0); err != nil || gas != 43106 {
+		t.Errorf("estimate: %d, %v", gas, err)
+	}
+	// the calls run at a zero gas price, the sender pays nothing
+	if gas, err := estimate(poor, writer, 0, 0); err != nil || gas != 43106 {
+		t.Errorf("estimate at zero gas price: %d, %v", gas, err)
+	}
+
+	// the sender can pay for 30000 gas, or for 10000 once the value is sent
+	if _, err := estimate(poor, writer, 1, 0); err == nil || !strings.Contains(err.Error(), "allowance (30000)") {
+		t.Errorf("estimate above the balance: %v", err)
+	}
+	if _, err := estimate(poor, writer, 1, 20000); err == nil || !strings.Contains(err.Error(), "allowance (10000)") {
+		t.Errorf("estimate above the balance with value: %v", err)
+	}
+	if _, err := estimate(poor, writer, 1, 30000); !errors.Is(err, core.ErrInsufficientFundsForTransfer) {
+		t.Errorf("estimate of a value above the balance: %v", err)
+	}
+
+	// a call reverting at every gas level reports the revert
+	_, err := estimate(testSender, reverter, 1, 0)
+	var revert *revertError
+	if !errors.As(err, &revert) || revert.ErrorData() != "0xaa" {
+		t.Errorf("estimate of a reverting call: %v", err)
+	}
+}
@@ -2,15 +2,13 @@ package mferbackend
 
 import (
 	"errors"
-	"fmt"
 	"math/big"
 
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/common/math"
-	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/kataras/golog"
 )
 
@@ -183,21 +181,74 @@ func (args *TransactionArgs) ToTransaction() *types.Transaction {
 	return args.toTransaction()
 }
 
+// BlockOverrides is a set of header fields to override. FeeRecipient,
+// PrevRandao and BaseFeePerGas are the eth_simulateV1 names of Coinbase,
+// Random and BaseFee.
+type BlockOverrides struct {
+	Number        *hexutil.Big
+	Difficulty    *hexutil.Big
+	Time          *hexutil.Uint64
+	GasLimit      *hexutil.Uint64
+	Coinbase      *common.Address
+	Random        *common.Hash
+	BaseFee       *hexutil.Big
+	FeeRecipient  *common.Address
+	PrevRandao    *common.Hash
+	BaseFeePerGas *hexutil.Big
+}
+
+// Apply overrides the given header fields into the given block context.
+func (diff *BlockOverrides) Apply(blockCtx *vm.BlockContext) {
+	if diff == nil {
+		return
+	}
+	if diff.Number != nil {
+		blockCtx.BlockNumber = new(big.Int).Set(diff.Number.ToInt())
+	}
+	if diff.Difficulty != nil {
+		blockCtx.Difficulty = new(big.Int).Set(diff.Difficulty.ToInt())
+	}
+	if diff.Time != nil {
+		blockCtx.Time = new(big.Int).SetUint64(uint64(*diff.Time))
+	}
+	if diff.GasLimit != nil {
+		blockCtx.GasLimit = uint64(*diff.GasLimit)
+	}
+	if diff.Coinbase != nil {
+		blockCtx.Coinbase = *diff.Coinbase
+	}
+	if diff.FeeRecipient != nil {
+		blockCtx.Coinbase = *diff.FeeRecipient
+	}
+	if diff.Random != nil {
+		random := *diff.Random
+		blockCtx.Random = &random
+	}
+	if diff.PrevRandao != nil {
+		random := *diff.PrevRandao
+		blockCtx.Random = &random
+	}
+	if diff.BaseFee != nil {
+		blockCtx.BaseFee = new(big.Int).Set(diff.BaseFee.ToInt())
+	}
+	if diff.BaseFeePerGas != nil {
+		blockCtx.BaseFee = new(big.Int).Set(diff.BaseFeePerGas.ToInt())
+	}
+}
+
 type revertError struct {
 	error
 	reason string // revert reason hex encoded
 }
 
-func newRevertError(result *core.ExecutionResult) *revertError {
-	reason, errUnpack := abi.UnpackRevert(result.Revert())
-	err := errors.New("execution reverted")
-	if errUnpack == nil {
-		err = fmt.Errorf("execution reverted: %v", reason)
-	}
-	return &revertError{
-		error:  err,
-		reason: hexutil.Encode(result.Revert()),
-	}
+// ErrorCode is the json-rpc error code of reverted calls.
+func (e *revertError) ErrorCode() int {
+	return 3
+}
+
+// ErrorData returns the hex encoded revert data.
+func (e *revertError) ErrorData() interface{} {
+	return e.reason
 }
 
 type RPCTransaction struct {
@@ -0,0 +1,209 @@
+package mferbackend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sec-bit/mfer-node/mferstate"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+// gasProfileFrame is the gas profile of a call frame. GasUsed includes the
+// calls it makes, SelfGas is the gas of its own opcodes split by opcode
+// class in Classes.
+type gasProfileFrame struct {
+	Type          string             `json:"type"`
+	From          common.Address     `json:"from"`
+	To            common.Address     `json:"to"`
+	Label         string             `json:"label,omitempty"`
+	Selector      hexutil.Bytes      `json:"selector,omitempty"`
+	Function      string             `json:"function,omitempty"`
+	GasUsed       uint64             `json:"gasUsed"`
+	SelfGas       uint64             `json:"selfGas"`
+	Classes       map[string]uint64  `json:"classes"`
+	WarmAccesses  int                `json:"warmAccesses"`
+	ColdAccesses  int                `json:"coldAccesses"`
+	ColdAccessGas uint64             `json:"coldAccessGas"`
+	Error         string             `json:"error,omitempty"`
+	Calls         []*gasProfileFrame `json:"calls,omitempty"`
+}
+
+// gasProfileEntry is the gas of the frames running the code of a contract,
+// or of one of its functions when Selector is set.
+type gasProfileEntry struct {
+	Address  common.Address `json:"address"`
+	Label    string         `json:"label,omitempty"`
+	Selector hexutil.Bytes  `json:"selector,omitempty"`
+	Function string         `json:"function,omitempty"`
+	Calls    int            `json:"calls"`
+	SelfGas  uint64         `json:"selfGas"`
+}
+
+// gasProfile is the gas of a tx or call by contract, function and opcode
+// class, the most expensive first. Folded holds the frames in the folded
+// stack format of flamegraph tools, it adds up to GasUsed + Refund.
+type gasProfile struct {
+	GasUsed       uint64             `json:"gasUsed"`
+	IntrinsicGas  uint64             `json:"intrinsicGas"`
+	Refund        uint64             `json:"refund"`
+	Classes       map[string]uint64  `json:"classes"`
+	WarmAccesses  int                `json:"warmAccesses"`
+	ColdAccesses  int                `json:"coldAccesses"`
+	ColdAccessGas uint64             `json:"coldAccessGas"`
+	Contracts     []*gasProfileEntry `json:"contracts"`
+	Functions     []*gasProfileEntry `json:"functions"`
+	Folded        string             `json:"folded"`
+	Tree          *gasProfileFrame   `json:"tree"`
+}
+
+// profileGas runs msg on stateDB with the gas tracer.
+func (b *MferBackend) profileGas(ctx context.Context, msg types.Message, stateDB *mferstate.OverlayStateDB) (*gasProfile, error) {
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+	tracer := mfertracer.NewGasTracer(msg.AccessList())
+	if _, err := b.EVM.DoCallWithTracer(ctx, &msg, tracer, stateDB.Clone()); err != nil {
+		return nil, b.execError(err)
+	}
+	if tracer.Root() == nil {
+		return nil, fmt.Errorf("nothing executed")
+	}
+	return b.newGasProfile(b.newDecoder(ctx, stateDB), tracer.Root(), tracer.GasUsed(), tracer.IntrinsicGas(), tracer.Refund()), nil
+}
+
+// newGasProfile rolls the frames under root up by contract, function and
+// opcode class.
+func (b *MferBackend) newGasProfile(dec *decoder, root *mfertracer.GasFrame, gasUsed, intrinsic, refund uint64) *gasProfile {
+	profile := &gasProfile{
+		GasUsed:      gasUsed,
+		IntrinsicGas: intrinsic,
+		Refund:       refund,
+		Classes:      make(map[string]uint64),
+	}
+	contracts := make(map[string]*gasProfileEntry)
+	functions := make(map[string]*gasProfileEntry)
+	var folded strings.Builder
+	if profile.IntrinsicGas > 0 {
+		fmt.Fprintf(&folded, "intrinsic %d\n", profile.IntrinsicGas)
+	}
+
+	var walk func(frame *mfertracer.GasFrame, stack string) *gasProfileFrame
+	walk = func(frame *mfertracer.GasFrame, stack string) *gasProfileFrame {
+		f := &gasProfileFrame{
+			Type:          frame.Type,
+			From:          frame.From,
+			To:            frame.To,
+			Label:         b.Contracts.Label(frame.To),
+			GasUsed:       frame.GasUsed,
+			SelfGas:       frame.SelfGas,
+			Classes:       frame.Classes,
+			WarmAccesses:  frame.WarmAccesses,
+			ColdAccesses:  frame.ColdAccesses,
+			ColdAccessGas: frame.ColdAccessGas,
+		}
+		if frame.Err != nil {
+			f.Error = frame.Err.Error()
+		}
+		name := "fallback"
+		switch {
+		case frame.Type == "CREATE" || frame.Type == "CREATE2":
+			name = "constructor"
+		case len(frame.Input) >= 4:
+			f.Selector = frame.Input[:4]
+			name = f.Selector.String()
+			if decoded := dec.call(frame.To, frame.Input); decoded != nil {
+				f.Function = decoded.Signature
+				name = decoded.Name
+			}
+		}
+		contract := f.Label
+		if contract == "" {
+			contract = f.To.Hex()
+		}
+		if stack != "" {
+			stack += ";"
+		}
+		stack += strings.ReplaceAll(contract+":"+name, ";", ",")
+
+		for class, gas := range f.Classes {
+			profile.Classes[class] += gas
+		}
+		profile.WarmAccesses += f.WarmAccesses
+		profile.ColdAccesses += f.ColdAccesses
+		profile.ColdAccessGas += f.ColdAccessGas
+		entry, ok := contracts[string(f.To.Bytes())]
+		if !ok {
+			entry = &gasProfileEntry{Address: f.To, Label: f.Label}
+			contracts[string(f.To.Bytes())] = entry
+		}
+		entry.Calls++
+		entry.SelfGas += f.SelfGas
+		key := string(f.To.Bytes()) + name
+		fn, ok := functions[key]
+		if !ok {
+			fn = &gasProfileEntry{Address: f.To, Label: f.Label, Selector: f.Selector, Function: f.Function}
+			functions[key] = fn
+		}
+		fn.Calls++
+		fn.SelfGas += f.SelfGas
+
+		classes := make([]string, 0, len(f.Classes))
+		for class := range f.Classes {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			if gas := f.Classes[class]; gas > 0 {
+				fmt.Fprintf(&folded, "%s;%s %d\n", stack, class, gas)
+			}
+		}
+		for _, call := range frame.Calls {
+			f.Calls = append(f.Calls, walk(call, stack))
+		}
+		return f
+	}
+	profile.Tree = walk(root, "")
+	profile.Folded = folded.String()
+	profile.Contracts = sortGasEntries(contracts)
+	profile.Functions = sortGasEntries(functions)
+	return profile
+}
+
+func sortGasEntries(entries map[string]*gasProfileEntry) []*gasProfileEntry {
+	sorted := make([]*gasProfileEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].SelfGas != sorted[j].SelfGas {
+			return sorted[i].SelfGas > sorted[j].SelfGas
+		}
+		return sorted[i].Address.Hex()+sorted[i].Selector.String() < sorted[j].Address.Hex()+sorted[j].Selector.String()
+	})
+	return sorted
+}
+
+// ProfileTransaction profiles the gas of a pool tx.
+func (s *MferActionAPI) ProfileTransaction(ctx context.Context, txHash common.Hash) (*gasProfile, error) {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	tx, stateDB, err := s.b.poolTxState(ctx, txHash)
+	if err != nil {
+		return nil, err
+	}
+	return s.b.profileGas(ctx, s.b.EVM.TxToMessage(tx), stateDB)
+}
+
+// ProfileCall profiles the gas of a call on the pending state, like
+// eth_call.
+func (s *MferActionAPI) ProfileCall(ctx context.Context, args TransactionArgs, overrides *mferstate.StateOverride) (*gasProfile, error) {
+	msg, stateDB, err := s.b.callState(args, overrides)
+	if err != nil {
+		return nil, err
+	}
+	return s.b.profileGas(ctx, msg, stateDB)
+}
@@ -0,0 +1,78 @@
+package mferbackend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sec-bit/mfer-node/mferabi"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+func TestNewGasProfile(t *testing.T) {
+	router, token := common.HexToAddress("0x1000"), common.HexToAddress("0x2000")
+	contracts := mferabi.NewRegistry()
+	if err := contracts.Register(mferabi.Contract{Address: router, Label: "Router"}); err != nil {
+		t.Fatal(err)
+	}
+	b := &MferBackend{Contracts: contracts, Signatures: mferabi.NewSignatureDB()}
+
+	transfer := hexutil.MustDecode("0xa9059cbb" + "000000000000000000000000000000000000000000000000000000000000bbbb" + "0000000000000000000000000000000000000000000000000000000000000001")
+	call := func(gasUsed, storage, compute uint64) *mfertracer.GasFrame {
+		return &mfertracer.GasFrame{
+			Type: "CALL", From: router, To: token, Input: transfer,
+			GasUsed: gasUsed, SelfGas: storage + compute,
+			Classes:      map[string]uint64{mfertracer.GasStorage: storage, mfertracer.GasCompute: compute},
+			ColdAccesses: 1, ColdAccessGas: 2000,
+		}
+	}
+	root := &mfertracer.GasFrame{
+		Type: "CALL", From: common.HexToAddress("0xaaaa"), To: router, Input: []byte{1, 2, 3, 4},
+		GasUsed: 3000 + 2200 + 300, SelfGas: 3000,
+		Classes:      map[string]uint64{mfertracer.GasCalls: 2600, mfertracer.GasCompute: 400},
+		ColdAccesses: 1, ColdAccessGas: 2500,
+		Calls: []*mfertracer.GasFrame{call(2200, 2100, 100), call(300, 100, 200)},
+	}
+	profile := b.newGasProfile(b.newDecoder(context.Background(), nil), root, 21000+5500-100, 21000, 100)
+
+	if profile.Classes[mfertracer.GasStorage] != 2200 || profile.Classes[mfertracer.GasCompute] != 700 || profile.Classes[mfertracer.GasCalls] != 2600 {
+		t.Errorf("classes %v", profile.Classes)
+	}
+	if profile.ColdAccesses != 3 || profile.ColdAccessGas != 6500 {
+		t.Errorf("cold accesses %d (%d gas)", profile.ColdAccesses, profile.ColdAccessGas)
+	}
+	// the calls of the token are rolled up, the router only pays for itself
+	if len(profile.Contracts) != 2 {
+		t.Fatalf("contracts %+v", profile.Contracts)
+	}
+	if c := profile.Contracts[0]; c.Address != router || c.Label != "Router" || c.Calls != 1 || c.SelfGas != 3000 {
+		t.Errorf("router %+v", c)
+	}
+	if c := profile.Contracts[1]; c.Address != token || c.Calls != 2 || c.SelfGas != 2500 {
+		t.Errorf("token %+v", c)
+	}
+	if len(profile.Functions) != 2 {
+		t.Fatalf("functions %+v", profile.Functions)
+	}
+	if f := profile.Functions[1]; f.Address != token || f.Function != "transfer(address,uint256)" || f.Calls != 2 || f.SelfGas != 2500 {
+		t.Errorf("transfer %+v", f)
+	}
+	if f := profile.Functions[0]; f.Selector.String() != "0x01020304" || f.Function != "" {
+		t.Errorf("router function %+v", f)
+	}
+
+	folded := "intrinsic 21000\n" +
+		"Router:0x01020304;calls 2600\n" +
+		"Router:0x01020304;compute 400\n" +
+		"Router:0x01020304;" + token.Hex() + ":transfer;compute 100\n" +
+		"Router:0x01020304;" + token.Hex() + ":transfer;storage 2100\n" +
+		"Router:0x01020304;" + token.Hex() + ":transfer;compute 200\n" +
+		"Router:0x01020304;" + token.Hex() + ":transfer;storage 100\n"
+	if profile.Folded != folded {
+		t.Errorf("folded:\n%s\nwant:\n%s", profile.Folded, folded)
+	}
+	if len(profile.Tree.Calls) != 2 || profile.Tree.Calls[0].Function != "transfer(address,uint256)" || profile.Tree.Label != "Router" {
+		t.Errorf("tree %+v", profile.Tree)
+	}
+}
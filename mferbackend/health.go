@@ -0,0 +1,66 @@
+package mferbackend
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/sec-bit/mfer-node/mferevm"
+)
+
+// HealthTarget is a fork checked by the health handlers, MaxLag is the number
+// of blocks its state may lag behind the upstream head (0 disables the check).
+type HealthTarget struct {
+	EVM    *mferevm.MferEVM
+	MaxLag uint64
+}
+
+// HealthzHandler reports the upstream reachability and state status of the
+// forks (keyed by name), it fails while any upstream can not be reached.
+func HealthzHandler(targets map[string]HealthTarget) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reports := make(map[string]*mferevm.Health)
+		ok := true
+		for name, target := range targets {
+			h := target.EVM.Health(r.Context())
+			ok = ok && h.UpstreamReachable
+			reports[name] = h
+		}
+		writeHealth(w, reports, ok)
+	})
+}
+
+// ReadyzHandler fails until the first state of every fork is loaded, while
+// any upstream can not be reached, and when a state lags behind its upstream
+// head by more than MaxLag blocks.
+func ReadyzHandler(targets map[string]HealthTarget) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reports := make(map[string]*mferevm.Health)
+		ok := true
+		for name, target := range targets {
+			h := target.EVM.Health(r.Context())
+			ready := h.Prepared && h.UpstreamReachable
+			if target.MaxLag > 0 && h.Lag > int64(target.MaxLag) {
+				ready = false
+			}
+			ok = ok && ready
+			reports[name] = h
+		}
+		writeHealth(w, reports, ok)
+	})
+}
+
+// writeHealth writes the bare report of an unnamed single fork, a report per
+// fork otherwise.
+func writeHealth(w http.ResponseWriter, reports map[string]*mferevm.Health, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if h, single := reports[""]; single && len(reports) == 1 {
+		json.NewEncoder(w).Encode(h)
+		return
+	}
+	json.NewEncoder(w).Encode(reports)
+}
@@ -0,0 +1,86 @@
+package mferbackend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sec-bit/mfer-node/mferauth"
+)
+
+// Limits bounds the resources a single request may use, a zero value disables
+// the limit.
+type Limits struct {
+	GasCap        uint64
+	Timeout       time.Duration
+	MaxTraceRange uint64
+	MaxResultSize int
+	MaxPoolSize   int
+}
+
+// execContext bounds the execution of a request by the configured timeout, it
+// is also cancelled when the client goes away.
+func (b *MferBackend) execContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.Limits.Timeout > 0 {
+		return context.WithTimeout(ctx, b.Limits.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// requestClient tells the clients of requests apart, by the key they
+// authenticated with or else by their address.
+func requestClient(ctx context.Context) string {
+	if name, ok := mferauth.Client(ctx); ok {
+		return "key " + name
+	}
+	addr := rpc.PeerInfoFromContext(ctx).RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "address " + addr
+}
+
+// execError describes the execution error of a request bound by execContext.
+func (b *MferBackend) execError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("execution aborted (timeout = %v)", b.Limits.Timeout)
+	}
+	return err
+}
+
+func (b *MferBackend) checkTraceRange(from, to uint64) error {
+	if to < from {
+		return fmt.Errorf("invalid trace range: %d > %d", from, to)
+	}
+	if n := to - from + 1; b.Limits.MaxTraceRange > 0 && n > b.Limits.MaxTraceRange {
+		return fmt.Errorf("trace range of %d blocks exceeds the limit of %d blocks", n, b.Limits.MaxTraceRange)
+	}
+	return nil
+}
+
+// checkResultSize rejects results whose json encoding exceeds the configured
+// size.
+func (b *MferBackend) checkResultSize(result interface{}) error {
+	if b.Limits.MaxResultSize <= 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if len(encoded) > b.Limits.MaxResultSize {
+		return fmt.Errorf("result of %d bytes exceeds the limit of %d bytes", len(encoded), b.Limits.MaxResultSize)
+	}
+	return nil
+}
+
+func (b *MferBackend) checkPoolSize() error {
+	if n := b.TxPool.Len(); b.Limits.MaxPoolSize > 0 && n >= b.Limits.MaxPoolSize {
+		return fmt.Errorf("tx pool is full (%d txs), clear it with mfer_clearTxPool", n)
+	}
+	return nil
+}
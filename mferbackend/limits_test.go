@@ -0,0 +1,48 @@
+package mferbackend
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestExecTimeoutStalledUpstream(t *testing.T) {
+	slow := common.HexToAddress("0x5105")
+	value := common.HexToHash("0x2a")
+	// reads slot 0
+	b, upstream := newUpstreamBackend(t, map[common.Address]upstreamAccount{
+		slow: {code: []byte{byte(vm.PUSH1), 0, byte(vm.SLOAD), byte(vm.STOP)}, storage: map[common.Hash]common.Hash{{}: value}},
+	})
+	b.Limits.Timeout = 100 * time.Millisecond
+	stall := make(chan struct{})
+	upstream.stall = stall
+
+	// a pool tx waiting on the upstream is aborted and not added
+	gas := hexutil.Uint64(100000)
+	start := time.Now()
+	_, err := (&EthAPI{b}).SendTransaction(context.Background(), TransactionArgs{To: &slow, Gas: &gas})
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("stalled tx: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stalled tx aborted after %v", elapsed)
+	}
+	if txs, _ := b.TxPool.GetPoolTxs(); len(txs) != 0 {
+		t.Errorf("%d txs in the pool", len(txs))
+	}
+	close(stall)
+
+	// the abandoned load is not cached as an empty slot
+	if got := b.EVM.StateDB.GetState(slow, common.Hash{}); got != value {
+		t.Errorf("slot read after the stall: %s", got.Hex())
+	}
+	sendTx(t, b, slow, nil)
+	if txs, _ := b.TxPool.GetPoolTxs(); len(txs) != 1 {
+		t.Errorf("%d txs in the pool", len(txs))
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -18,8 +19,11 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/kataras/golog"
 	"github.com/sec-bit/mfer-node/constant"
+	"github.com/sec-bit/mfer-node/mferabi"
+	"github.com/sec-bit/mfer-node/mferevm"
 	"github.com/sec-bit/mfer-node/mfersigner"
 	"github.com/sec-bit/mfer-node/mferstate"
+	"github.com/sec-bit/mfer-node/mfertracer"
 	"github.com/sec-bit/mfer-node/multisend"
 )
 
@@ -27,8 +31,16 @@ type MferActionAPI struct {
 	b *MferBackend
 }
 
-func (s *MferActionAPI) ClearKeyCache() {
+// errSharedState rejects the methods changing the state cache all sessions
+// share when they are called in a session.
+var errSharedState = errors.New("not available in a session, the state cache is shared with the other sessions")
+
+func (s *MferActionAPI) ClearKeyCache() error {
+	if s.b.SessionID != "" {
+		return errSharedState
+	}
 	s.b.EVM.StateDB.InitState(true, true)
+	return nil
 }
 
 func (s *MferActionAPI) ResetState() {
@@ -39,6 +51,7 @@ func (s *MferActionAPI) ResetState() {
 
 func (s *MferActionAPI) ClearTxPool() {
 	s.b.TxPool.Reset()
+	s.b.security.reset()
 	s.b.EVM.StateLock()
 	defer s.b.EVM.StateUnlock()
 	// s.b.EVM.Prepare()
@@ -51,8 +64,30 @@ func (s *MferActionAPI) ReExecTxPool() {
 	s.b.EVM.Prepare()
 
 	txs, _ := s.b.TxPool.GetPoolTxs()
-	execResults := s.b.EVM.ExecuteTxs(txs, s.b.EVM.StateDB, nil)
+	execResults := s.b.EVM.ExecuteTxs(context.Background(), txs, s.b.EVM.StateDB, nil)
 	s.b.TxPool.SetResults(execResults)
+	if s.b.SessionID == "" && s.b.Sessions != nil {
+		s.b.Sessions.ReExecTxPools()
+	}
+}
+
+func (s *MferActionAPI) CreateSession(args *SessionArgs) (*SessionInfo, error) {
+	if args == nil {
+		args = &SessionArgs{}
+	}
+	session, err := s.b.Sessions.Create(*args)
+	if err != nil {
+		return nil, err
+	}
+	return s.b.Sessions.Info(session.ID)
+}
+
+func (s *MferActionAPI) ListSessions() []*SessionInfo {
+	return s.b.Sessions.List()
+}
+
+func (s *MferActionAPI) ExpireSession(id string) bool {
+	return s.b.Sessions.Expire(id)
 }
 
 func (s *MferActionAPI) SetTimeDelta(delta uint64) {
@@ -82,9 +117,13 @@ func (s *MferActionAPI) ImpersonatedAccount() common.Address {
 	return s.b.ImpersonatedAccount
 }
 
-func (s *MferActionAPI) SetBatchSize(batchSize int) {
+func (s *MferActionAPI) SetBatchSize(batchSize int) error {
+	if s.b.SessionID != "" {
+		return errSharedState
+	}
 	golog.Infof("Setting batch size to %d", batchSize)
 	s.b.EVM.StateDB.SetBatchSize(batchSize)
+	return nil
 }
 
 func (s *MferActionAPI) SetBlockNumberDelta(delta uint64) {
@@ -105,8 +144,13 @@ func (s *MferActionAPI) RandAddrEnabled() bool {
 	return s.b.Randomized
 }
 
+// TogglePassthrough switches passthrough calls, enabling it probes the
+// upstream again.
 func (s *MferActionAPI) TogglePassthrough(enable bool) {
 	golog.Infof("toggle passthrough %v", enable)
+	if enable {
+		s.b.probe.reset()
+	}
 	s.b.Passthrough = enable
 }
 
@@ -114,8 +158,86 @@ func (s *MferActionAPI) PassthroughEnabled() bool {
 	return s.b.Passthrough
 }
 
-func (s *MferActionAPI) GetStateDiff() mferstate.StateOverride {
-	return s.b.EVM.StateDB.GetStateDiff()
+// PassthroughSupported reports whether the upstream takes eth_call state
+// overrides, without them passthrough calls run locally.
+func (s *MferActionAPI) PassthroughSupported(ctx context.Context) bool {
+	return s.b.passthroughSupported(ctx)
+}
+
+// ToggleShadow switches shadow mode, calls run locally and again on the
+// upstream and mismatches are logged.
+func (s *MferActionAPI) ToggleShadow(enable bool) {
+	golog.Infof("toggle shadow %v", enable)
+	s.b.Shadow = enable
+}
+
+func (s *MferActionAPI) ShadowEnabled() bool {
+	return s.b.Shadow
+}
+
+// RegisterSignatures adds text signatures to the signature db used to decode
+// calldata, logs and revert data, it returns the number of new ones.
+func (s *MferActionAPI) RegisterSignatures(signatures []string) (int, error) {
+	added := 0
+	for _, signature := range signatures {
+		ok, err := s.b.Signatures.AddSignature(signature)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+		}
+	}
+	golog.Infof("registered %d signatures", added)
+	return added, nil
+}
+
+// RegisterABI adds the functions, events and errors of a json ABI to the
+// signature db, it returns the number of new signatures.
+func (s *MferActionAPI) RegisterABI(abiJSON json.RawMessage) (int, error) {
+	added, err := s.b.Signatures.AddABI(abiJSON)
+	if err != nil {
+		return 0, err
+	}
+	golog.Infof("registered %d signatures from abi", added)
+	return added, nil
+}
+
+// RegisterContract records a label, an ABI and a storage layout for an
+// address, or a storage layout for a code hash. They also decode the proxies
+// delegating to the address.
+func (s *MferActionAPI) RegisterContract(contract mferabi.Contract) error {
+	if err := s.b.Contracts.Register(contract); err != nil {
+		return err
+	}
+	if contract.Address == (common.Address{}) {
+		golog.Infof("registered storage layout of code hash %s", contract.CodeHash.Hex())
+		return nil
+	}
+	golog.Infof("registered contract %s (%s)", contract.Address.Hex(), contract.Label)
+	return nil
+}
+
+func (s *MferActionAPI) ListContracts() []*mferabi.Contract {
+	return s.b.Contracts.List()
+}
+
+// ResolveProxy returns the implementation of an EIP-1967, beacon or
+// ZeppelinOS proxy on the pool state, nil if address is none of them.
+func (s *MferActionAPI) ResolveProxy(ctx context.Context, address common.Address) *proxyInfo {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	proxy := s.b.resolveProxy(ctx, s.b.EVM.StateDB, address)
+	if proxy != nil {
+		proxy.Label = s.b.Contracts.Label(proxy.Implementation)
+	}
+	return proxy
+}
+
+func (s *MferActionAPI) GetStateDiff(ctx context.Context) labeledStateDiff {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	return s.b.newDecoder(ctx, s.b.EVM.StateDB).labelStateDiff(s.b.EVM.StateDB.GetStateDiff())
 }
 
 func (s *MferActionAPI) PrintMoney(account common.Address) {
@@ -131,12 +253,15 @@ func (s *MferActionAPI) PrintMoney(account common.Address) {
 }
 
 type TxData struct {
-	Idx          int            `json:"idx"`
-	From         common.Address `json:"from"`
-	To           common.Address `json:"to"`
-	Data         hexutil.Bytes  `json:"calldata"`
-	ExecResult   string         `json:"execResult"`
-	PseudoTxHash common.Hash    `json:"pseudoTxHash"`
+	Idx          int              `json:"idx"`
+	From         common.Address   `json:"from"`
+	To           common.Address   `json:"to"`
+	Data         hexutil.Bytes    `json:"calldata"`
+	ExecResult   string           `json:"execResult"`
+	PseudoTxHash common.Hash      `json:"pseudoTxHash"`
+	Decoded      *mferabi.Decoded `json:"decoded,omitempty"`
+	Revert       *revertInfo      `json:"revert,omitempty"`
+	Transfers    []*assetTransfer `json:"transfers,omitempty"`
 }
 
 type MultiSendData struct {
@@ -148,12 +273,21 @@ type MultiSendData struct {
 	SafeNonce           int64                 `json:"safeNonce"`
 	ExecResult          *core.ExecutionResult `json:"execResult"`
 	RevertError         string                `json:"revertError"`
-	CallError           error                 `json:"callError"`
+	DecodedRevert       *mferabi.Decoded      `json:"decodedRevert,omitempty"`
+	RevertOrigin        *revertOrigin         `json:"revertOrigin,omitempty"`
+	CallError           string                `json:"callError,omitempty"`
 	EventLogs           []*types.Log          `json:"eventLogs"`
+	DecodedLogs         []*decodedLog         `json:"decodedLogs"`
 	DebugTrace          json.RawMessage       `json:"debugTrace"`
+	Risks               []*riskFlag           `json:"risks"`
 }
 
-func (s *MferActionAPI) GetTxs() ([]*TxData, error) {
+// GetTxs lists the pool txs with the assets each of them moves.
+func (s *MferActionAPI) GetTxs(ctx context.Context) ([]*TxData, error) {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	analyzer := newAssetAnalyzer(s.b)
+	dec := s.b.newDecoder(ctx, s.b.EVM.StateDB)
 	txs, execResult := s.b.TxPool.GetPoolTxs()
 	txData := make([]*TxData, len(txs))
 	for i, tx := range txs {
@@ -164,10 +298,17 @@ func (s *MferActionAPI) GetTxs() ([]*TxData, error) {
 			to = *tx.To()
 		}
 
-		var result string
+		var (
+			result string
+			revert *revertInfo
+		)
 		if execResult[i] != nil {
 			result = execResult[i].Error()
 		}
+		if execErr, ok := execResult[i].(*mferevm.ExecError); ok {
+			revert = dec.revertInfo(to, execErr.Revert, execErr.Origin)
+			result = revert.Message
+		}
 
 		msg := s.b.EVM.TxToMessage(tx)
 		txData[i] = &TxData{
@@ -177,12 +318,35 @@ func (s *MferActionAPI) GetTxs() ([]*TxData, error) {
 			Data:         tx.Data(),
 			ExecResult:   result,
 			PseudoTxHash: tx.Hash(),
+			Decoded:      dec.call(to, tx.Data()),
+			Revert:       revert,
+			Transfers:    analyzer.txTransfers(ctx, tx),
 		}
 	}
 
 	return txData, nil
 }
 
+// GetApprovalRisks flags the approvals, ownership transfers and role grants
+// made by the impersonated account in the pool.
+func (s *MferActionAPI) GetApprovalRisks(ctx context.Context) ([]*riskFlag, error) {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	return newAssetAnalyzer(s.b).poolRisks(ctx), nil
+}
+
+// GetAssetFlows returns the ether and token transfers of every pool tx and the
+// net balance changes they add up to, the impersonated account first.
+func (s *MferActionAPI) GetAssetFlows(ctx context.Context) (*assetFlows, error) {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	flows := newAssetAnalyzer(s.b).poolFlows(ctx)
+	if err := s.b.checkResultSize(flows); err != nil {
+		return nil, err
+	}
+	return flows, nil
+}
+
 func (s *MferActionAPI) getSafeOwnersAndThreshold(safeAddr common.Address) ([]common.Address, int, error) {
 	safe, err := multisend.NewGnosisSafe(safeAddr, s.b.EVM.SelfConn)
 	if err != nil {
@@ -214,6 +378,7 @@ func (s *MferActionAPI) GetSafeOwnersAndThreshold() (*SafeOwnerInfo, error) {
 
 func (s *MferActionAPI) SimulateSafeExec(ctx context.Context, safeOwners []common.Address) (*MultiSendData, error) {
 	safeAddr := s.b.ImpersonatedAccount
+	dec := s.b.newDecoder(ctx, s.b.EVM.StateDB)
 	txs, _ := s.b.TxPool.GetPoolTxs()
 	txData := make([]*TxData, len(txs))
 	for i, tx := range txs {
@@ -224,9 +389,10 @@ func (s *MferActionAPI) SimulateSafeExec(ctx context.Context, safeOwners []commo
 			to = *tx.To()
 		}
 		txData[i] = &TxData{
-			Idx:  i,
-			To:   to,
-			Data: tx.Data(),
+			Idx:     i,
+			To:      to,
+			Data:    tx.Data(),
+			Decoded: dec.call(to, tx.Data()),
 		}
 	}
 
@@ -261,6 +427,8 @@ func (s *MferActionAPI) SimulateSafeExec(ctx context.Context, safeOwners []commo
 	}
 
 	// s.b.EVM.StateDB.InitState()
+	execCtx, cancel := s.b.execContext(ctx)
+	defer cancel()
 	simulationStateDB := s.b.EVM.StateDB.CloneFromRoot()
 
 	msData := &MultiSendData{
@@ -289,7 +457,7 @@ func (s *MferActionAPI) SimulateSafeExec(ctx context.Context, safeOwners []commo
 		if err != nil {
 			log.Panic(err)
 		}
-		s.b.EVM.ExecuteTxs(types.Transactions{tx}, simulationStateDB, nil)
+		s.b.replayTxs(execCtx, types.Transactions{tx}, simulationStateDB)
 	}
 	msg := types.NewMessage(
 		safeOwners[0],
@@ -310,26 +478,39 @@ func (s *MferActionAPI) SimulateSafeExec(ctx context.Context, safeOwners []commo
 		log.Panic(err)
 	}
 
-	s.b.EVM.SetTracer(tracer)
+	revertTracer := mfertracer.NewRevertTracer()
 	txHash := crypto.Keccak256Hash([]byte("psuedoTransaction"))
 	simulationStateDB.StartLogCollection(txHash, crypto.Keccak256Hash([]byte("blockhash")))
-	result, err := s.b.EVM.DoCall(&msg, true, simulationStateDB)
+	result, err := s.b.EVM.DoCallWithTracer(execCtx, &msg, mfertracer.NewMuxTracer(tracer, revertTracer), simulationStateDB)
 	spew.Dump(result, err)
-	msData.ExecResult = result
+	if err != nil && execCtx.Err() != nil {
+		return nil, s.b.execError(err)
+	}
 	if err != nil {
-		msData.CallError = err
+		// the Safe tx was not executed, e.g. the owner can not pay for it,
+		// the calldata and the hashes are still returned
+		msData.CallError = err.Error()
+		return msData, nil
 	}
+	msData.ExecResult = result
 
+	// the proxies are resolved on the state the Safe executed on
+	dec = s.b.newDecoder(execCtx, simulationStateDB)
 	if len(result.Revert()) > 0 {
-		msData.RevertError = newRevertError(result).error.Error()
+		revert := dec.revertInfo(safeAddr, result.Revert(), revertTracer.Origin())
+		msData.RevertError = revert.Message
+		msData.DecodedRevert = revert.Decoded
+		msData.RevertOrigin = revert.Origin
 	}
 
 	traceResult, err := tracer.GetResult()
 	if err != nil {
 		return nil, err
 	}
-	msData.DebugTrace = traceResult
+	msData.DebugTrace = dec.trace(traceResult)
 	msData.EventLogs = simulationStateDB.GetLogs(txHash)
+	msData.DecodedLogs = dec.logs(msData.EventLogs)
+	msData.Risks = newAssetAnalyzer(s.b).safeExecRisks(execCtx, simulationStateDB, msData.EventLogs, txData)
 
 	return msData, nil
 
@@ -340,42 +521,139 @@ type txTraceResult struct {
 	Error  string      `json:"error,omitempty"`  // Trace failure produced by the tracer
 }
 
-func (s *MferActionAPI) traceBlocks(ctx context.Context, blocks []*types.Block, config *tracers.TraceConfig) ([][]*txTraceResult, error) {
+// replayBlocks re-executes blocks on a private state at the parent of the
+// first block, the state, block number and block context of the fork and its
+// sessions are left alone. onBlock is called after each block with the results
+// of its txs. The trace of each tx is the last log of its receipt.
+func (s *MferActionAPI) replayBlocks(ctx context.Context, blocks []*types.Block, config *tracers.TraceConfig, onBlock func(block *types.Block, stateDB *mferstate.OverlayStateDB, execResults []error) error) error {
 	if len(blocks) == 0 {
-		return nil, errors.New("no blocks supplied")
+		return errors.New("no blocks supplied")
 	}
 
 	stateBN := blocks[0].NumberU64() - 1
-	s.b.EVM.Prepare() // TODO keep underlying state for re-use
-	s.b.EVM.SetVMContextByBlockHeader(s.b.EVM.GetBlockHeader(fmt.Sprintf("0x%x", stateBN)))
-	s.b.EVM.SetBlockNumber(stateBN)
-	s.b.EVM.StateDB.InitState(true, false)
+	evm := s.b.EVM.Replay(stateBN)
+	stateDB := evm.StateDB
+	defer stateDB.Close()
+	golog.Infof("Replaying: block from %d to %d using state %d", blocks[0].Header().Number, blocks[0].Header().Number.Int64()+int64(len(blocks))-1, stateBN)
+	for _, block := range blocks {
+		if err := ctx.Err(); err != nil {
+			return s.b.execError(err)
+		}
+		evm.SetVMContextByBlockHeader(block.Header())
+		evm.AddGasPool()
+		execResults := evm.ExecuteTxs(ctx, block.Transactions(), stateDB, config)
+		if err := ctx.Err(); err != nil {
+			return s.b.execError(err)
+		}
+		if err := onBlock(block, stateDB, execResults); err != nil {
+			return err
+		}
+	}
+	cacheSize := stateDB.CacheSize()
+	golog.Infof("Final cache size %d", cacheSize)
+	return nil
+}
 
-	txTraceResults := make([][]*txTraceResult, len(blocks))
-	stateDB := s.b.EVM.StateDB.CloneFromRoot()
-	golog.Infof("Tracing: block from %d to %d using state %d", blocks[0].Header().Number, blocks[0].Header().Number.Int64()+int64(len(blocks))-1, stateBN)
-	for i, block := range blocks {
+// newReplayGasPool is the gas pool of a replay, the pool txs were accepted
+// already so it never runs out.
+func newReplayGasPool() *core.GasPool {
+	return new(core.GasPool).AddGas(math.MaxUint64)
+}
+
+// replayTxs re-executes txs on stateDB without drawing from the block gas
+// pool, the txs left when ctx is done are not replayed.
+func (b *MferBackend) replayTxs(ctx context.Context, txs types.Transactions, stateDB *mferstate.OverlayStateDB) []error {
+	return b.EVM.ExecuteTxsWithGasPool(ctx, txs, stateDB, nil, newReplayGasPool())
+}
+
+// poolTxState finds pool tx txHash and the state it executes on, the state
+// the txs before it leave. The replay is bound by ctx.
+func (b *MferBackend) poolTxState(ctx context.Context, txHash common.Hash) (*types.Transaction, *mferstate.OverlayStateDB, error) {
+	txs, _ := b.TxPool.GetPoolTxs()
+	for i, tx := range txs {
+		if tx.Hash() == txHash {
+			stateDB := b.EVM.StateDB.CloneFromRoot()
+			stateDB.InitFakeAccounts()
+			b.replayTxs(ctx, txs[:i], stateDB)
+			if err := ctx.Err(); err != nil {
+				return nil, nil, b.execError(err)
+			}
+			return tx, stateDB, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("tx %s not found", txHash.Hex())
+}
+
+// poolState is the pending state replayed from the root state, unlike a
+// clone of the pending state the pool txs sent later do not show through it.
+// The replay is bound by ctx, the executions on a state it cut short are
+// aborted by the same ctx.
+func (b *MferBackend) poolState(ctx context.Context) *mferstate.OverlayStateDB {
+	txs, _ := b.TxPool.GetPoolTxs()
+	stateDB := b.EVM.StateDB.CloneFromRoot()
+	stateDB.InitFakeAccounts()
+	b.replayTxs(ctx, txs, stateDB)
+	return stateDB
+}
+
+// callState is the message of a call and the pending state with overrides
+// applied it executes on, like eth_call.
+func (b *MferBackend) callState(args TransactionArgs, overrides *mferstate.StateOverride) (types.Message, *mferstate.OverlayStateDB, error) {
+	return b.callStateOf(args, overrides, b.EVM.StateDB.Clone)
+}
+
+// callStateOf is callState on the pending state newState returns.
+func (b *MferBackend) callStateOf(args TransactionArgs, overrides *mferstate.StateOverride, newState func() *mferstate.OverlayStateDB) (types.Message, *mferstate.OverlayStateDB, error) {
+	if err := overrides.Validate(); err != nil {
+		return types.Message{}, nil, err
+	}
+	msg, err := args.ToMessage(b.Limits.GasCap, nil)
+	if err != nil {
+		return types.Message{}, nil, err
+	}
+	stateDB := newState()
+	if err := overrides.Apply(stateDB); err != nil {
+		return types.Message{}, nil, err
+	}
+	return msg, stateDB, nil
+}
+
+// txTrace is the trace ExecuteTxs left in the receipt of tx, nil if it was
+// rejected.
+func txTrace(stateDB *mferstate.OverlayStateDB, tx *types.Transaction) json.RawMessage {
+	receipt := stateDB.GetReceipt(tx.Hash())
+	if receipt == nil || len(receipt.Logs) == 0 {
+		return nil
+	}
+	return json.RawMessage(receipt.Logs[len(receipt.Logs)-1].Data)
+}
+
+func (s *MferActionAPI) traceBlocks(ctx context.Context, blocks []*types.Block, config *tracers.TraceConfig) ([][]*txTraceResult, error) {
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+
+	txTraceResults := make([][]*txTraceResult, 0, len(blocks))
+	err := s.replayBlocks(ctx, blocks, config, func(block *types.Block, stateDB *mferstate.OverlayStateDB, _ []error) error {
 		txs := block.Transactions()
-		s.b.EVM.SetVMContextByBlockHeader(block.Header())
-		s.b.EVM.AddGasPool()
-		s.b.EVM.ExecuteTxs(txs, stateDB, config)
 		results := make([]*txTraceResult, len(txs))
+		dec := s.b.newDecoder(ctx, stateDB)
 		for i, tx := range txs {
-			receipt := stateDB.GetReceipt(tx.Hash())
-			if receipt != nil && len(receipt.Logs) > 0 {
-				trace := receipt.Logs[len(receipt.Logs)-1].Data
+			if trace := txTrace(stateDB, tx); trace != nil {
 				results[i] = &txTraceResult{
-					Result: json.RawMessage(trace),
+					Result: dec.trace(trace),
 				}
 			}
 		}
-		txTraceResults[i] = results
+		txTraceResults = append(txTraceResults, results)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	cacheSize := stateDB.CacheSize()
-	golog.Infof("Final cache size %d", cacheSize)
-
-	// Run the transaction with tracing enabled.
 
+	if err := s.b.checkResultSize(txTraceResults); err != nil {
+		return nil, err
+	}
 	return txTraceResults, nil
 }
 
@@ -390,17 +668,43 @@ func (s *MferActionAPI) TraceBlockByNumber(ctx context.Context, number rpc.Block
 		return nil, err
 	}
 	results, err := s.traceBlocks(ctx, []*types.Block{blk}, config)
-	return results[0], err
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
 }
 
 func (s *MferActionAPI) TraceBlockByNumberRange(ctx context.Context, numberFrom, numberTo rpc.BlockNumber, config *tracers.TraceConfig) ([][]*txTraceResult, error) {
 	golog.Infof("tracing block number range: %d-%d", numberFrom, numberTo)
+	blks, err := s.fetchBlockRange(ctx, numberFrom, numberTo)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.traceBlocks(ctx, blks, config)
+	// spew.Dump(results)
+	return results, err
+}
+
+// fetchBlockRange fetches the blocks from numberFrom to numberTo included from
+// the upstream, negative numbers are the upstream head.
+func (s *MferActionAPI) fetchBlockRange(ctx context.Context, numberFrom, numberTo rpc.BlockNumber) ([]*types.Block, error) {
 	var bnFrom, bnTo *big.Int
-	if numberFrom != -1 {
-		bnFrom = big.NewInt(numberFrom.Int64())
+	if numberFrom < 0 || numberTo < 0 {
+		latest, err := s.b.EVM.Conn.BlockNumber(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if numberFrom < 0 {
+			numberFrom = rpc.BlockNumber(latest)
+		}
+		if numberTo < 0 {
+			numberTo = rpc.BlockNumber(latest)
+		}
 	}
-	if numberTo != -1 {
-		bnTo = big.NewInt(numberTo.Int64())
+	bnFrom = big.NewInt(numberFrom.Int64())
+	bnTo = big.NewInt(numberTo.Int64())
+	if err := s.b.checkTraceRange(bnFrom.Uint64(), bnTo.Uint64()); err != nil {
+		return nil, err
 	}
 	blockCnt := bnTo.Int64() - bnFrom.Int64() + 1
 	blks := make([]*types.Block, blockCnt)
@@ -412,19 +716,17 @@ func (s *MferActionAPI) TraceBlockByNumberRange(ctx context.Context, numberFrom,
 		}
 		blks[i] = blk
 	}
-	results, err := s.traceBlocks(ctx, blks, config)
-	// spew.Dump(results)
-	return results, err
+	return blks, nil
 }
 
 type TransactionBundleResult struct {
 	Transactions        []*RPCTransaction        `json:"transactions"`
 	TransactionReceipts []map[string]interface{} `json:"transactionReceipts"`
-	StateDiff           mferstate.StateOverride  `json:"stateDiff"`
+	StateDiff           labeledStateDiff         `json:"stateDiff"`
 	LastTxHash          common.Hash              `json:"lastTxHash"`
 }
 
-func (s *MferActionAPI) buildRPCReceipt(tx *types.Transaction, receipt *types.Receipt) map[string]interface{} {
+func (s *MferActionAPI) buildRPCReceipt(dec *decoder, tx *types.Transaction, receipt *types.Receipt) map[string]interface{} {
 	fields := map[string]interface{}{
 		"blockHash":         blockHash,
 		"blockNumber":       hexutil.Uint64(s.b.EVM.GetVMContext().BlockNumber.Uint64()),
@@ -435,6 +737,7 @@ func (s *MferActionAPI) buildRPCReceipt(tx *types.Transaction, receipt *types.Re
 		"cumulativeGasUsed": hexutil.Uint64(receipt.CumulativeGasUsed),
 		"contractAddress":   nil,
 		"logs":              receipt.Logs,
+		"decodedLogs":       dec.logs(receipt.Logs),
 		"logsBloom":         receipt.Bloom,
 		"type":              hexutil.Uint(0),
 	}
@@ -455,13 +758,18 @@ func (s *MferActionAPI) buildRPCReceipt(tx *types.Transaction, receipt *types.Re
 
 func (s *MferActionAPI) TraceTransactionBundle(ctx context.Context, msgArgs []*TransactionArgs) (TransactionBundleResult, error) {
 	spew.Dump("TraceTransactionBundle", msgArgs)
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
 	stateDB := s.b.EVM.StateDB.CloneFromRoot()
-	s.b.EVM.AddGasPool()
-	// s.b.EVM.ExecuteTxs(txs, stateDB, nil)
+	dec := s.b.newDecoder(ctx, stateDB)
+	gasPool := newReplayGasPool()
 	var lastTxHash common.Hash
 	rpcTransactions := make([]*RPCTransaction, len(msgArgs))
 	rpcReceipts := make([]map[string]interface{}, len(msgArgs))
 	for i, msgArg := range msgArgs {
+		if err := ctx.Err(); err != nil {
+			return TransactionBundleResult{}, s.b.execError(err)
+		}
 		if msgArg.From == nil {
 			return TransactionBundleResult{}, fmt.Errorf("missing required field 'from' for transaction")
 		}
@@ -475,6 +783,10 @@ func (s *MferActionAPI) TraceTransactionBundle(ctx context.Context, msgArgs []*T
 			gasLimit := hexutil.Uint64(10000000)
 			msgArg.Gas = &gasLimit
 		}
+		if gasCap := s.b.Limits.GasCap; gasCap != 0 && uint64(*msgArg.Gas) > gasCap {
+			gasLimit := hexutil.Uint64(gasCap)
+			msgArg.Gas = &gasLimit
+		}
 
 		signer := mfersigner.NewSigner(s.b.EVM.ChainID().Int64())
 		tx, err := msgArg.ToTransaction().WithSignature(signer, msgArg.From.Bytes())
@@ -483,18 +795,25 @@ func (s *MferActionAPI) TraceTransactionBundle(ctx context.Context, msgArgs []*T
 		}
 		lastTxHash = tx.Hash()
 		// golog.Infof("Executing tx %s", lastTxHash.Hex())
-		s.b.EVM.ExecuteMsg(stateDB, s.b.EVM.TxToMessage(tx), tx.Hash(), i, nil)
+		s.b.EVM.ExecuteMsgWithGasPool(ctx, stateDB, s.b.EVM.TxToMessage(tx), tx.Hash(), i, nil, gasPool)
+		if err := ctx.Err(); err != nil {
+			return TransactionBundleResult{}, s.b.execError(err)
+		}
 		receiptItem := stateDB.GetReceipt(tx.Hash())
 		if receiptItem == nil {
 			return TransactionBundleResult{}, fmt.Errorf("missing receipt for tx %s", tx.Hash().Hex())
 		}
 		rpcTransactions[i] = newRPCTransaction(tx, receiptItem.BlockHash, receiptItem.BlockNumber.Uint64(), uint64(receiptItem.TransactionIndex), nil)
 		rpcTransactions[i].From = *msgArg.From
-		rpcReceipt := s.buildRPCReceipt(tx, receiptItem)
+		rpcReceipt := s.buildRPCReceipt(dec, tx, receiptItem)
 		rpcReceipt["from"] = msgArg.From
 		rpcReceipts[i] = rpcReceipt
 	}
 	// spew.Dump("rpcTransactions", rpcTransactions, "rpcReceipts", rpcReceipts)
-	stateDiff := stateDB.GetStateDiff()
-	return TransactionBundleResult{rpcTransactions, rpcReceipts, stateDiff, lastTxHash}, nil
+	stateDiff := dec.labelStateDiff(stateDB.GetStateDiff())
+	result := TransactionBundleResult{rpcTransactions, rpcReceipts, stateDiff, lastTxHash}
+	if err := s.b.checkResultSize(result); err != nil {
+		return TransactionBundleResult{}, err
+	}
+	return result, nil
 }
@@ -0,0 +1,147 @@
+package mferbackend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/kataras/golog"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+var (
+	// probeAddress holds the probe code in the override sent to the upstream.
+	probeAddress = common.HexToAddress("0x00000000000000000000000000000000006d6665")
+	// probeCode returns the word 0x2a: PUSH1 0x2a PUSH1 0 MSTORE PUSH1 0x20 PUSH1 0 RETURN
+	probeCode   = hexutil.Bytes(common.FromHex("0x602a60005260206000f3"))
+	probeResult = common.LeftPadBytes([]byte{0x2a}, 32)
+)
+
+// shadowTimeout bounds the upstream half of a shadow call, it runs after the
+// local result was returned.
+const shadowTimeout = 30 * time.Second
+
+// passthroughProbe records whether the upstream takes state overrides on
+// eth_call. It is shared by the root backend of a fork and its sessions as
+// they have the same upstream.
+type passthroughProbe struct {
+	mutex     sync.Mutex
+	probed    bool
+	supported bool
+}
+
+// reset forgets the result, the next passthrough call probes again.
+func (p *passthroughProbe) reset() {
+	p.mutex.Lock()
+	p.probed = false
+	p.mutex.Unlock()
+}
+
+// passthroughSupported probes the upstream on first use. A transport error is
+// not recorded, the probe is repeated by the next call.
+func (b *MferBackend) passthroughSupported(ctx context.Context) bool {
+	p := b.probe
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.probed {
+		return p.supported
+	}
+
+	override := mferstate.StateOverride{probeAddress: {Code: &probeCode}}
+	var result hexutil.Bytes
+	err := b.upstreamCall(ctx, &result, "eth_call", map[string]interface{}{"to": probeAddress}, b.stateBlockArg(), override)
+	var rpcErr rpc.Error
+	switch {
+	case err == nil:
+		p.supported = bytes.Equal(result, probeResult)
+	case errors.As(err, &rpcErr):
+		p.supported = false
+	default:
+		golog.Warnf("passthrough probe failed: %v", err)
+		return false
+	}
+	p.probed = true
+	switch {
+	case p.supported:
+		golog.Infof("upstream supports eth_call state overrides, passthrough enabled")
+	case err != nil:
+		golog.Warnf("upstream rejects eth_call state overrides (%v), calls run locally", err)
+	default:
+		golog.Warnf("upstream ignores eth_call state overrides (probe returned %s), calls run locally", result)
+	}
+	return p.supported
+}
+
+// stateBlockArg is the block the overlay is forked from.
+func (b *MferBackend) stateBlockArg() hexutil.Uint64 {
+	return hexutil.Uint64(b.EVM.StateDB.StateBlockNumber())
+}
+
+// passthroughFallback reports whether a failed passthrough call should run
+// again locally. Reverts are the result of the call, anything else may be the
+// upstream refusing the request (payload too large, override limits...) and
+// the local EVM gives the answer either way.
+func passthroughFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == errCodeReverted {
+		return false
+	}
+	return true
+}
+
+// shadowCall runs the call on the upstream with the state diff of the overlay
+// in the background and logs when its result differs from the local one.
+func (s *EthAPI) shadowCall(args TransactionArgs, overrides *mferstate.StateOverride, local hexutil.Bytes, localErr error) {
+	if !s.b.passthroughSupported(context.Background()) {
+		return
+	}
+	diff := s.b.EVM.StateDB.GetStateDiff()
+	stateOverride := diff.Merge(overrides)
+	blockArg := s.b.stateBlockArg()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+		defer cancel()
+		s.shadowCompare(ctx, args, blockArg, stateOverride, local, localErr)
+	}()
+}
+
+// shadowCompare runs the call on the upstream at blockArg with stateOverride
+// and reports whether its result matches the local one, a mismatch is logged.
+// ok is false when the upstream could not be reached.
+func (s *EthAPI) shadowCompare(ctx context.Context, args TransactionArgs, blockArg hexutil.Uint64, stateOverride mferstate.StateOverride, local hexutil.Bytes, localErr error) (match, ok bool) {
+	var upstream hexutil.Bytes
+	err := s.b.upstreamCall(ctx, &upstream, "eth_call", toCallArg(args), blockArg, stateOverride)
+	if err != nil && !errors.As(err, new(rpc.Error)) {
+		golog.Warnf("shadow call failed: %v", err)
+		return false, false
+	}
+	match = bytes.Equal(local, upstream) && (localErr == nil) == (err == nil)
+	if match && err != nil {
+		match = fmt.Sprint(errorData(localErr)) == fmt.Sprint(errorData(err))
+	}
+	s.b.EVM.Metrics.ShadowCall(match)
+	if !match {
+		callArg, _ := json.Marshal(toCallArg(args))
+		golog.Warnf("shadow mismatch at block %d: call %s, local: %s (err: %v), upstream: %s (err: %v)", blockArg, callArg, local, localErr, upstream, err)
+	}
+	return match, true
+}
+
+// errorData is the data of an rpc error, the revert data of reverted calls.
+func errorData(err error) interface{} {
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		return dataErr.ErrorData()
+	}
+	return nil
+}
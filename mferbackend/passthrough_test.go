@@ -0,0 +1,152 @@
+package mferbackend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+// overrideUpstream answers the probe like an upstream taking state overrides
+// and every other call with result.
+func overrideUpstream(result hexutil.Bytes, err error) func(map[string]interface{}, *mferstate.StateOverride) (hexutil.Bytes, error) {
+	return func(args map[string]interface{}, overrides *mferstate.StateOverride) (hexutil.Bytes, error) {
+		if overrides != nil {
+			if probe := (*overrides)[probeAddress]; probe != nil && probe.Code != nil && bytes.Equal(*probe.Code, probeCode) {
+				return probeResult, nil
+			}
+		}
+		return result, err
+	}
+}
+
+func TestPassthroughProbe(t *testing.T) {
+	target := common.HexToAddress("0x7a7a")
+	upstreamResult := hexutil.Bytes{0xbe, 0xef}
+	cases := map[string]struct {
+		call      func(map[string]interface{}, *mferstate.StateOverride) (hexutil.Bytes, error)
+		supported bool
+		want      hexutil.Bytes
+	}{
+		"takes overrides": {overrideUpstream(upstreamResult, nil), true, upstreamResult},
+		"ignores overrides": {func(map[string]interface{}, *mferstate.StateOverride) (hexutil.Bytes, error) {
+			return upstreamResult, nil
+		}, false, probeResult},
+		"rejects eth_call": {nil, false, probeResult},
+		// the call is refused, it runs locally
+		"refuses the call": {overrideUpstream(nil, errors.New("request too large")), true, probeResult},
+	}
+	for name, c := range cases {
+		b, upstream := newUpstreamBackend(t, map[common.Address]upstreamAccount{
+			// returns the word 0x2a locally
+			target: {code: probeCode},
+		})
+		upstream.call = c.call
+		b.Passthrough = true
+		if supported := b.passthroughSupported(context.Background()); supported != c.supported {
+			t.Errorf("%s: supported %v, want %v", name, supported, c.supported)
+		}
+		result, err := (&EthAPI{b}).Call(context.Background(), TransactionArgs{To: &target}, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), nil, nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(result, c.want) {
+			t.Errorf("%s: got %x, want %x", name, result, c.want)
+		}
+	}
+}
+
+func TestPassthroughProbeRetry(t *testing.T) {
+	b, upstream := newUpstreamBackend(t, nil)
+	upstream.call = overrideUpstream(nil, nil)
+	b.probe.probed, b.probe.supported = true, false
+	b.probe.reset()
+	if !b.passthroughSupported(context.Background()) {
+		t.Fatal("reset probe not repeated")
+	}
+}
+
+func TestShadowCompare(t *testing.T) {
+	target := common.HexToAddress("0x7a7a")
+	b, upstream := newUpstreamBackend(t, map[common.Address]upstreamAccount{
+		target: {code: probeCode},
+	})
+	b.Shadow = true
+	api := &EthAPI{b}
+	args := api.preprocessArgs(TransactionArgs{To: &target})
+	local, err := api.Call(context.Background(), TransactionArgs{To: &target}, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), nil, nil)
+	if err != nil || !bytes.Equal(local, probeResult) {
+		t.Fatalf("local call %x: %v", local, err)
+	}
+
+	cases := map[string]struct {
+		result hexutil.Bytes
+		err    error
+		match  bool
+	}{
+		"same result":      {probeResult, nil, true},
+		"different result": {hexutil.Bytes{0xbe, 0xef}, nil, false},
+		"upstream error":   {nil, errors.New("execution reverted"), false},
+	}
+	for name, c := range cases {
+		upstream.call = overrideUpstream(c.result, c.err)
+		match, ok := api.shadowCompare(context.Background(), args, b.stateBlockArg(), b.EVM.StateDB.GetStateDiff(), local, nil)
+		if !ok || match != c.match {
+			t.Errorf("%s: match %v (ok %v), want %v", name, match, ok, c.match)
+		}
+	}
+}
+
+func TestPassthroughSuicide(t *testing.T) {
+	target := common.HexToAddress("0x5d5d")
+	stored := common.HexToHash("0x05")
+	b, upstream := newUpstreamBackend(t, map[common.Address]upstreamAccount{
+		// self-destructs when called with data, returns slot 0 otherwise
+		target: {code: []byte{
+			byte(vm.CALLDATASIZE), byte(vm.PUSH1), 15, byte(vm.JUMPI),
+			byte(vm.PUSH1), 0, byte(vm.SLOAD), byte(vm.PUSH1), 0, byte(vm.MSTORE),
+			byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN),
+			byte(vm.JUMPDEST), byte(vm.CALLER), byte(vm.SELFDESTRUCT),
+		}, storage: map[common.Hash]common.Hash{{}: stored}},
+	})
+	// reads slot 0 of target like the upstream node applying the overrides
+	upstream.call = func(args map[string]interface{}, overrides *mferstate.StateOverride) (hexutil.Bytes, error) {
+		value := stored
+		if overrides != nil {
+			if override := (*overrides)[target]; override != nil && override.State != nil {
+				value = (*override.State)[common.Hash{}]
+			} else if override != nil && override.StateDiff != nil {
+				if diff, ok := (*override.StateDiff)[common.Hash{}]; ok {
+					value = diff
+				}
+			}
+		}
+		return value.Bytes(), nil
+	}
+	api := &EthAPI{b}
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	local, err := api.CallLocal(context.Background(), TransactionArgs{To: &target}, latest, nil, nil)
+	if err != nil || common.BytesToHash(local) != stored {
+		t.Fatalf("local call before the self-destruct %x: %v", local, err)
+	}
+
+	sendTx(t, b, target, []byte{0x01})
+	local, err = api.CallLocal(context.Background(), TransactionArgs{To: &target}, latest, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passthrough, err := api.CallPassthrough(context.Background(), TransactionArgs{To: &target}, latest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(local, passthrough) || common.BytesToHash(local) != (common.Hash{}) {
+		t.Errorf("local call %x, passthrough call %x, want the storage cleared", local, passthrough)
+	}
+}
@@ -39,7 +39,12 @@ func (p *ProbeAPI) RunTxWithDifferentContext(ctx context.Context, txHash common.
 	stateDB := p.b.EVM.StateDB.CloneFromRoot()
 	stateDB.InitFakeAccounts()
 
-	p.b.EVM.ExecuteTxs(txs, stateDB, nil)
+	execCtx, cancel := p.b.execContext(ctx)
+	defer cancel()
+	p.b.replayTxs(execCtx, txs, stateDB)
+	if err := execCtx.Err(); err != nil {
+		return nil, p.b.execError(err)
+	}
 	msg := p.b.EVM.TxToMessage(txToBeTraced)
 	stateDB.SetCodeHash(msg.From(), common.Hash{})
 
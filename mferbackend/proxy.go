@@ -0,0 +1,128 @@
+package mferbackend
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+// proxy kinds
+const (
+	proxyEIP1967 = "eip1967"
+	proxyBeacon  = "beacon"
+	proxyZOS     = "zeppelinos"
+	proxyDiamond = "diamond"
+)
+
+var (
+	// eip1967ImplementationSlot is keccak256("eip1967.proxy.implementation") - 1
+	eip1967ImplementationSlot = common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
+	// eip1967BeaconSlot is keccak256("eip1967.proxy.beacon") - 1
+	eip1967BeaconSlot = common.HexToHash("0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeaee59ff6cb3582b35133d50")
+	// zosImplementationSlot is keccak256("org.zeppelinos.proxy.implementation")
+	zosImplementationSlot = crypto.Keccak256Hash([]byte("org.zeppelinos.proxy.implementation"))
+
+	implementationSelector    = crypto.Keccak256([]byte("implementation()"))[:4]
+	facetAddressSelector      = crypto.Keccak256([]byte("facetAddress(bytes4)"))[:4]
+	supportsInterfaceSelector = crypto.Keccak256([]byte("supportsInterface(bytes4)"))[:4]
+	// diamondLoupeInterfaceID is the ERC-165 id of the IDiamondLoupe
+	// interface of EIP-2535, the xor of its selectors
+	diamondLoupeInterfaceID = interfaceID("facets()", "facetFunctionSelectors(address)", "facetAddresses()", "facetAddress(bytes4)")
+	diamondCutEventTopic    = crypto.Keccak256Hash([]byte("DiamondCut((address,uint8,bytes4[])[],address,bytes)"))
+)
+
+// interfaceID is the ERC-165 id of the interface made of the functions.
+func interfaceID(functions ...string) []byte {
+	id := make([]byte, 4)
+	for _, function := range functions {
+		for i, b := range crypto.Keccak256([]byte(function))[:4] {
+			id[i] ^= b
+		}
+	}
+	return id
+}
+
+// proxyInfo is the implementation a proxy delegates to, Beacon is set for
+// beacon proxies. Diamonds delegate per selector, Implementation is then the
+// facet of the selector asked for.
+type proxyInfo struct {
+	Kind           string          `json:"kind"`
+	Implementation common.Address  `json:"implementation"`
+	Beacon         *common.Address `json:"beacon,omitempty"`
+	Label          string          `json:"label,omitempty"`
+}
+
+// resolveProxy reads the implementation of an EIP-1967, beacon or
+// ZeppelinOS proxy from its storage, nil if address is none of them.
+func (b *MferBackend) resolveProxy(ctx context.Context, stateDB *mferstate.OverlayStateDB, address common.Address) *proxyInfo {
+	if stateDB.GetCodeSize(address) == 0 {
+		return nil
+	}
+	if impl := slotAddress(stateDB.GetState(address, eip1967ImplementationSlot)); impl != (common.Address{}) {
+		return &proxyInfo{Kind: proxyEIP1967, Implementation: impl}
+	}
+	if beacon := slotAddress(stateDB.GetState(address, eip1967BeaconSlot)); beacon != (common.Address{}) {
+		ret := b.staticCall(ctx, stateDB, beacon, implementationSelector)
+		if len(ret) == 32 {
+			if impl := slotAddress(common.BytesToHash(ret)); impl != (common.Address{}) {
+				return &proxyInfo{Kind: proxyBeacon, Implementation: impl, Beacon: &beacon}
+			}
+		}
+	}
+	if impl := slotAddress(stateDB.GetState(address, zosImplementationSlot)); impl != (common.Address{}) {
+		return &proxyInfo{Kind: proxyZOS, Implementation: impl}
+	}
+	return nil
+}
+
+// supportsLoupe asks address through ERC-165 whether it implements the
+// loupe of an EIP-2535 diamond.
+func (b *MferBackend) supportsLoupe(ctx context.Context, stateDB *mferstate.OverlayStateDB, address common.Address) bool {
+	if stateDB.GetCodeSize(address) == 0 {
+		return false
+	}
+	ret := b.staticCall(ctx, stateDB, address, append(common.CopyBytes(supportsInterfaceSelector), common.RightPadBytes(diamondLoupeInterfaceID, 32)...))
+	return len(ret) == 32 && common.BytesToHash(ret) == common.BigToHash(common.Big1)
+}
+
+// resolveFacet asks an EIP-2535 diamond for the facet of selector through
+// its loupe, nil if address is no diamond or the selector has no facet.
+func (b *MferBackend) resolveFacet(ctx context.Context, stateDB *mferstate.OverlayStateDB, address common.Address, selector []byte) *proxyInfo {
+	if stateDB.GetCodeSize(address) == 0 {
+		return nil
+	}
+	ret := b.staticCall(ctx, stateDB, address, append(common.CopyBytes(facetAddressSelector), common.RightPadBytes(selector, 32)...))
+	if len(ret) != 32 {
+		return nil
+	}
+	facet := slotAddress(common.BytesToHash(ret))
+	if facet == (common.Address{}) || stateDB.GetCodeSize(facet) == 0 {
+		return nil
+	}
+	return &proxyInfo{Kind: proxyDiamond, Implementation: facet}
+}
+
+// slotAddress is the address held by a storage word, zero if the word holds
+// anything but an address.
+func slotAddress(word common.Hash) common.Address {
+	for _, b := range word[:common.HashLength-common.AddressLength] {
+		if b != 0 {
+			return common.Address{}
+		}
+	}
+	return common.BytesToAddress(word[common.HashLength-common.AddressLength:])
+}
+
+// staticCall runs a read only call on a copy of stateDB, nil if it fails.
+func (b *MferBackend) staticCall(ctx context.Context, stateDB *mferstate.OverlayStateDB, to common.Address, data []byte) []byte {
+	msg := types.NewMessage(common.Address{}, &to, 0, new(big.Int), 1_000_000, new(big.Int), new(big.Int), new(big.Int), data, nil, true)
+	result, err := b.EVM.DoCall(ctx, &msg, false, stateDB.Clone())
+	if err != nil || result.Failed() {
+		return nil
+	}
+	return result.Return()
+}
@@ -0,0 +1,103 @@
+package mferbackend
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sec-bit/mfer-node/mferabi"
+)
+
+func TestResolveProxy(t *testing.T) {
+	var (
+		impl   = common.HexToAddress("0x1111")
+		eip    = common.HexToAddress("0x2222")
+		beacon = common.HexToAddress("0x3333")
+		viaBcn = common.HexToAddress("0x4444")
+		zos    = common.HexToAddress("0x5555")
+		plain  = common.HexToAddress("0x6666")
+		code   = []byte{byte(vm.STOP)}
+	)
+	implWord := common.BytesToHash(impl.Bytes())
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		impl:   {code: code},
+		eip:    {code: code, storage: map[common.Hash]common.Hash{eip1967ImplementationSlot: implWord}},
+		beacon: {code: dispatcher(selectorCase{implementationSelector, implWord})},
+		viaBcn: {code: code, storage: map[common.Hash]common.Hash{eip1967BeaconSlot: common.BytesToHash(beacon.Bytes())}},
+		zos:    {code: code, storage: map[common.Hash]common.Hash{zosImplementationSlot: implWord}},
+		// a word that is no address is no implementation
+		plain: {code: code, storage: map[common.Hash]common.Hash{eip1967ImplementationSlot: common.BigToHash(math.MaxBig256)}},
+	})
+
+	ctx := context.Background()
+	for address, kind := range map[common.Address]string{eip: proxyEIP1967, viaBcn: proxyBeacon, zos: proxyZOS} {
+		proxy := b.resolveProxy(ctx, b.EVM.StateDB, address)
+		if proxy == nil || proxy.Kind != kind || proxy.Implementation != impl {
+			t.Errorf("proxy %s: %+v, want %s of %s", address.Hex(), proxy, kind, impl.Hex())
+			continue
+		}
+		if (kind == proxyBeacon) != (proxy.Beacon != nil) || proxy.Beacon != nil && *proxy.Beacon != beacon {
+			t.Errorf("proxy %s: beacon %v", address.Hex(), proxy.Beacon)
+		}
+	}
+	for _, address := range []common.Address{plain, impl, common.HexToAddress("0x7777")} {
+		if proxy := b.resolveProxy(ctx, b.EVM.StateDB, address); proxy != nil {
+			t.Errorf("%s resolved to %+v", address.Hex(), proxy)
+		}
+	}
+}
+
+func TestDiamondFacets(t *testing.T) {
+	var (
+		facet      = common.HexToAddress("0xfacE")
+		loupe      = common.HexToAddress("0x1001")
+		registered = common.HexToAddress("0x1002")
+		cut        = common.HexToAddress("0x1003")
+		plain      = common.HexToAddress("0x1004")
+	)
+	fooSelector := crypto.Keccak256([]byte("foo()"))[:4]
+	facetCase := selectorCase{facetAddressSelector, common.BytesToHash(facet.Bytes())}
+	// every contract answers facetAddress, only the one of loupe reports the
+	// loupe interface
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		facet:      {code: []byte{byte(vm.STOP)}},
+		loupe:      {code: dispatcher(selectorCase{supportsInterfaceSelector, common.BigToHash(common.Big1)}, facetCase)},
+		registered: {code: dispatcher(facetCase)},
+		cut:        {code: dispatcher(facetCase)},
+		plain:      {code: dispatcher(facetCase)},
+	})
+	abi := json.RawMessage(`[{"type":"function","name":"foo","inputs":[],"outputs":[]}]`)
+	for _, c := range []mferabi.Contract{{Address: facet, ABI: abi}, {Address: registered, Diamond: true}} {
+		if err := b.Contracts.Register(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dec := b.newDecoder(context.Background(), b.EVM.StateDB)
+	dec.logs([]*types.Log{{Address: cut, Topics: []common.Hash{diamondCutEventTopic}}})
+	for _, diamond := range []common.Address{loupe, registered, cut} {
+		decoded := dec.call(diamond, fooSelector)
+		if decoded == nil || decoded.Implementation == nil || *decoded.Implementation != facet {
+			t.Errorf("call of diamond %s decoded to %+v", diamond.Hex(), decoded)
+		}
+	}
+	// a contract without a diamond signal is not asked for facets, and it is
+	// not asked again in the same request
+	if decoded := dec.call(plain, fooSelector); decoded != nil && decoded.Implementation != nil {
+		t.Errorf("call of %s decoded with facet %s", plain.Hex(), decoded.Implementation.Hex())
+	}
+	dec.call(plain, crypto.Keccak256([]byte("bar()"))[:4])
+	if diamond, ok := dec.diamonds[plain]; !ok || diamond {
+		t.Errorf("diamond check of %s: %v, %v", plain.Hex(), diamond, ok)
+	}
+	for key := range dec.facets {
+		if common.BytesToAddress([]byte(key)[:common.AddressLength]) == plain {
+			t.Errorf("facet of %s probed", plain.Hex())
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mferbackend
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/sec-bit/mfer-node/mferabi"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+// revertInfo is revert data decoded with the ABIs of the contract that raised
+// it, then with the signature db.
+type revertInfo struct {
+	Message string           `json:"message"`
+	Data    hexutil.Bytes    `json:"data"`
+	Decoded *mferabi.Decoded `json:"decoded,omitempty"`
+	Origin  *revertOrigin    `json:"origin,omitempty"`
+}
+
+// revertOrigin is the call frame that raised a revert, Address is the
+// contract whose code reverted and Function the decoded input of the frame.
+type revertOrigin struct {
+	Type     string           `json:"type"`
+	From     common.Address   `json:"from"`
+	Address  common.Address   `json:"address"`
+	Label    string           `json:"label,omitempty"`
+	Depth    int              `json:"depth"`
+	Function *mferabi.Decoded `json:"function,omitempty"`
+}
+
+// revertInfo decodes the revert data of a call to to, origin is nil when the
+// frame that raised it is unknown. The message names the origin when it is
+// not the top call frame.
+func (d *decoder) revertInfo(to common.Address, data []byte, origin *mfertracer.RevertOrigin) *revertInfo {
+	info := &revertInfo{Message: "execution reverted", Data: common.CopyBytes(data)}
+	if origin != nil {
+		to = origin.Address
+		info.Origin = &revertOrigin{
+			Type:     origin.Type,
+			From:     origin.From,
+			Address:  origin.Address,
+			Label:    d.b.Contracts.Label(origin.Address),
+			Depth:    origin.Depth,
+			Function: d.call(origin.Address, origin.Input),
+		}
+	}
+	if info.Decoded = d.revert(to, data); info.Decoded != nil {
+		info.Message += ": " + info.Decoded.RevertMessage()
+	}
+	if o := info.Origin; o != nil && o.Depth > 0 {
+		contract := o.Address.Hex()
+		if o.Label != "" {
+			contract = o.Label + " " + contract
+		}
+		if o.Function != nil {
+			contract += " in " + o.Function.Name
+		}
+		info.Message += fmt.Sprintf(" (raised by %s at depth %d)", contract, o.Depth)
+	}
+	return info
+}
+
+// revertError is the json-rpc error of a call to to reverting with result,
+// to is nil for a contract creation.
+func (d *decoder) revertError(to *common.Address, result *core.ExecutionResult, origin *mfertracer.RevertOrigin) *revertError {
+	var callee common.Address
+	if to != nil {
+		callee = *to
+	}
+	info := d.revertInfo(callee, result.Revert(), origin)
+	return &revertError{
+		error:  errors.New(info.Message),
+		reason: hexutil.Encode(info.Data),
+	}
+}
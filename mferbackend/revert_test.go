@@ -0,0 +1,50 @@
+package mferbackend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestRevertOrigin(t *testing.T) {
+	var (
+		leaf    = common.HexToAddress("0x1eaf")
+		bubbler = common.HexToAddress("0xb0b0")
+	)
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		leaf: {code: revertCode},
+		// calls the leaf and reverts with its revert data
+		bubbler: {code: []byte{
+			byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+			byte(vm.PUSH2), 0x1e, 0xaf, byte(vm.GAS), byte(vm.CALL), byte(vm.POP),
+			byte(vm.RETURNDATASIZE), byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.RETURNDATACOPY),
+			byte(vm.RETURNDATASIZE), byte(vm.PUSH1), 0, byte(vm.REVERT),
+		}},
+	})
+	raisedBy := "(raised by " + leaf.Hex() + " at depth 1)"
+
+	// the call runs without the revert tracer and is replayed with it
+	_, err := (&EthAPI{b}).Call(context.Background(), TransactionArgs{From: &testSender, To: &bubbler}, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), nil, nil)
+	if err == nil || !strings.Contains(err.Error(), raisedBy) {
+		t.Fatalf("call error %v, want the leaf as the origin", err)
+	}
+	if data := err.(*revertError).ErrorData(); data != "0xaa" {
+		t.Errorf("call revert data %v", data)
+	}
+
+	// the pool tx is replayed on the state before it, not after its nonce
+	// and fee were taken
+	sendTx(t, b, bubbler, nil)
+	txs, err := (&MferActionAPI{b}).GetTxs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 || txs[0].Revert == nil || txs[0].Revert.Origin == nil || txs[0].Revert.Origin.Address != leaf ||
+		!strings.Contains(txs[0].ExecResult, raisedBy) {
+		t.Fatalf("pool txs %+v", txs)
+	}
+}
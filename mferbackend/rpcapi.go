@@ -3,10 +3,13 @@ package mferbackend
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
+	"strings"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum"
@@ -20,7 +23,6 @@ import (
 	"github.com/sec-bit/mfer-node/constant"
 	"github.com/sec-bit/mfer-node/mfersigner"
 	"github.com/sec-bit/mfer-node/mferstate"
-	"github.com/sec-bit/mfer-node/mfertracer"
 )
 
 func GetEthAPIs(b *MferBackend) []rpc.API {
@@ -37,6 +39,12 @@ func GetEthAPIs(b *MferBackend) []rpc.API {
 			Service:   &AuxAPI{b},
 			Public:    true,
 		},
+		{
+			Namespace: "web3",
+			Version:   "1.0",
+			Service:   &Web3API{b},
+			Public:    true,
+		},
 		{
 			Namespace: "wallet",
 			Version:   "1.0",
@@ -64,6 +72,43 @@ func GetEthAPIs(b *MferBackend) []rpc.API {
 	}
 }
 
+// FilterAPIs keeps the apis of the given namespaces.
+func FilterAPIs(apis []rpc.API, namespaces []string) ([]rpc.API, error) {
+	known := make(map[string]bool)
+	for _, api := range apis {
+		known[api.Namespace] = true
+	}
+	enabled := make(map[string]bool)
+	for _, namespace := range namespaces {
+		if !known[namespace] {
+			return nil, fmt.Errorf("unknown namespace: %s", namespace)
+		}
+		enabled[namespace] = true
+	}
+	filtered := make([]rpc.API, 0, len(apis))
+	for _, api := range apis {
+		if enabled[api.Namespace] {
+			filtered = append(filtered, api)
+		}
+	}
+	return filtered, nil
+}
+
+// NewRPCServer serves the apis of the enabled namespaces.
+func NewRPCServer(b *MferBackend, namespaces []string) (*rpc.Server, error) {
+	apis, err := FilterAPIs(GetEthAPIs(b), namespaces)
+	if err != nil {
+		return nil, err
+	}
+	srv := rpc.NewServer()
+	for _, api := range apis {
+		if err := srv.RegisterName(api.Namespace, api.Service); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
+}
+
 type EthAPI struct {
 	b *MferBackend
 }
@@ -80,6 +125,22 @@ func (s *AuxAPI) Listening() bool {
 	return true
 }
 
+func (s *AuxAPI) PeerCount() hexutil.Uint {
+	return 0
+}
+
+type Web3API struct {
+	b *MferBackend
+}
+
+func (s *Web3API) ClientVersion() string {
+	return s.b.ClientVersion
+}
+
+func (s *Web3API) Sha3(input hexutil.Bytes) hexutil.Bytes {
+	return crypto.Keccak256(input)
+}
+
 type ChainIDArgs struct {
 	ChainID *hexutil.Big `json:"balance"`
 }
@@ -135,58 +196,86 @@ func toCallArg(msg TransactionArgs) interface{} {
 	return arg
 }
 
-func (s *EthAPI) Call(ctx context.Context, args TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *mferstate.StateOverride) (hexutil.Bytes, error) {
-	if s.b.Passthrough {
-		return s.CallPassthrough(ctx, args, blockNrOrHash, nil)
-	} else {
-		return s.CallLocal(ctx, args, blockNrOrHash, overrides)
+// Call executes the call on the fork. State overrides apply on top of the pool
+// txs in both modes, calls with block overrides always run locally as the
+// upstream may not support them. Passthrough calls run locally when the
+// upstream does not take state overrides or refuses the request.
+func (s *EthAPI) Call(ctx context.Context, args TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *mferstate.StateOverride, blockOverrides *BlockOverrides) (hexutil.Bytes, error) {
+	if err := overrides.Validate(); err != nil {
+		return nil, err
+	}
+	if !s.b.isLocalBlock(blockNrOrHash) {
+		return s.callHistorical(ctx, args, blockNrOrHash, overrides, blockOverrides)
 	}
+	if s.b.Passthrough && blockOverrides == nil && !s.b.Shadow && s.b.passthroughSupported(ctx) {
+		result, err := s.CallPassthrough(ctx, args, blockNrOrHash, overrides)
+		if err == nil || !passthroughFallback(ctx, err) {
+			return result, err
+		}
+		golog.Warnf("passthrough call failed, running locally: %v", err)
+	}
+	result, err := s.CallLocal(ctx, args, blockNrOrHash, overrides, blockOverrides)
+	if s.b.Shadow && blockOverrides == nil {
+		s.shadowCall(s.preprocessArgs(args), overrides, result, err)
+	}
+	return result, err
 }
 
 func (s *EthAPI) CallPassthrough(ctx context.Context, args TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *mferstate.StateOverride) (hexutil.Bytes, error) {
 	args = s.preprocessArgs(args)
 	var hex hexutil.Bytes
 	diff := s.b.EVM.StateDB.GetStateDiff()
-	var stateOverride *mferstate.StateOverride
-	if overrides != nil {
-		stateOverride = overrides
-	} else {
-		stateOverride = &diff
+	stateOverride := diff.Merge(overrides)
+	stateBN := s.b.stateBlockArg()
+	if err := s.b.upstreamCall(ctx, &hex, "eth_call", toCallArg(args), stateBN, stateOverride); err != nil {
+		golog.Debugf("err: %v, args: %v, bn: %s, stateDiff: %d accounts", err, toCallArg(args), stateBN, len(stateOverride))
+		return nil, err
 	}
-	stateBN := s.b.EVM.StateDB.StateBlockNumber()
-	stateBNH := hexutil.EncodeUint64(stateBN)
-	_ = stateBNH
-	diffB, err2 := json.Marshal(diff)
-	err := s.b.EVM.RpcClient.CallContext(ctx, &hex, "eth_call", toCallArg(args), "latest", stateOverride)
-	if err != nil {
-		golog.Debugf("err: %v,hex: %v, args: %v, bn: %s, stateDiff: %s, err2: %v", err, hex, toCallArg(args), stateBNH, string(diffB), err2)
+	return hex, nil
+}
+
+// callHistorical runs the call on the upstream at a block before the fork,
+// the simulated state does not apply there.
+func (s *EthAPI) callHistorical(ctx context.Context, args TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *mferstate.StateOverride, blockOverrides *BlockOverrides) (hexutil.Bytes, error) {
+	args = s.preprocessArgs(args)
+	var hex hexutil.Bytes
+	callArgs := []interface{}{toCallArg(args), blockArg(blockNrOrHash)}
+	if overrides != nil || blockOverrides != nil {
+		callArgs = append(callArgs, overrides)
+	}
+	if blockOverrides != nil {
+		callArgs = append(callArgs, blockOverrides)
+	}
+	if err := s.b.upstreamCall(ctx, &hex, "eth_call", callArgs...); err != nil {
 		return nil, err
 	}
 	return hex, nil
 }
 
-func (s *EthAPI) CallLocal(ctx context.Context, args TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *mferstate.StateOverride) (hexutil.Bytes, error) {
+func (s *EthAPI) CallLocal(ctx context.Context, args TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides *mferstate.StateOverride, blockOverrides *BlockOverrides) (hexutil.Bytes, error) {
 	args = s.preprocessArgs(args)
-	msg, err := args.ToMessage(0, nil)
+	msg, err := args.ToMessage(s.b.Limits.GasCap, nil)
 	if err != nil {
 		return nil, err
 	}
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	// the overrides go into a throwaway layer on top of the overlay
 	stateDB := s.b.EVM.StateDB.Clone()
-	if blockNrOrHash.BlockNumber != nil && *blockNrOrHash.BlockNumber != -1 {
-		bnHex := fmt.Sprintf("0x%x", *blockNrOrHash.BlockNumber)
-		golog.Infof("Call with block number %s", bnHex)
-		header := s.b.EVM.GetBlockHeader(bnHex)
-		if header != nil {
-			s.b.EVM.SetVMContextByBlockHeader(header)
-		}
+	if err := overrides.Apply(stateDB); err != nil {
+		return nil, err
 	}
-	result, err := s.b.EVM.DoCall(&msg, false, stateDB)
+	blockCtx := s.b.EVM.GetVMContext()
+	blockOverrides.Apply(&blockCtx)
+	snapshot := stateDB.Snapshot()
+	result, err := s.b.EVM.DoCallWithBlockContext(ctx, &msg, blockCtx, nil, stateDB)
 	if err != nil {
-		return nil, err
+		return nil, s.b.execError(err)
 	}
-	// If the result contains a revert reason, try to unpack and return it.
+	// If the result contains a revert reason, try to decode and return it.
 	if len(result.Revert()) > 0 {
-		return nil, newRevertError(result)
+		origin := s.b.EVM.CallRevertOrigin(ctx, &msg, blockCtx, stateDB, snapshot)
+		return nil, s.b.newDecoder(ctx, stateDB).revertError(msg.To(), result, origin)
 	}
 	return result.Return(), result.Err
 }
@@ -199,31 +288,18 @@ func (s *EthAPI) EstimateGas(ctx context.Context, args TransactionArgs, blockNrO
 	} else {
 		from = new(common.Address)
 	}
-	args.GasPrice = nil
 	nonce := s.b.EVM.StateDB.GetNonce(*from)
 	huNonce := hexutil.Uint64(nonce)
 	args.Nonce = &huNonce
-	msg, err := args.ToMessage(0, nil)
-	if err != nil {
-		return 0, err
-	}
-	tracer := &mfertracer.KeccakTracer{}
-
-	s.b.EVM.SetTracer(tracer)
-	stateDB := s.b.EVM.StateDB.Clone()
-	defer tracer.Reset()
-	result, err := s.b.EVM.DoCall(&msg, true, stateDB)
-	if err != nil {
-		return 0, err
-	}
-	// If the result contains a revert reason, try to unpack and return it.
-	if len(result.Revert()) > 0 {
-		return hexutil.Uint64(result.UsedGas * 2), newRevertError(result)
-	}
-	return hexutil.Uint64(result.UsedGas * 2), nil
+	return s.b.estimateGas(ctx, args)
 }
 
 func (s *EthAPI) GetBalance(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	if !s.b.isLocalBlock(blockNrOrHash) {
+		var balance hexutil.Big
+		err := s.b.upstreamCall(ctx, &balance, "eth_getBalance", address, blockArg(blockNrOrHash))
+		return &balance, err
+	}
 	state := s.b.EVM.StateDB
 
 	if state == nil {
@@ -233,6 +309,11 @@ func (s *EthAPI) GetBalance(ctx context.Context, address common.Address, blockNr
 }
 
 func (s *EthAPI) GetCode(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	if !s.b.isLocalBlock(blockNrOrHash) {
+		var code hexutil.Bytes
+		err := s.b.upstreamCall(ctx, &code, "eth_getCode", address, blockArg(blockNrOrHash))
+		return code, err
+	}
 	state := s.b.EVM.StateDB
 	if state == nil {
 		return nil, fmt.Errorf("mfer state not found")
@@ -240,6 +321,39 @@ func (s *EthAPI) GetCode(ctx context.Context, address common.Address, blockNrOrH
 	return (hexutil.Bytes)(state.GetCode(address)), nil
 }
 
+func (s *EthAPI) GetStorageAt(ctx context.Context, address common.Address, key string, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	if !s.b.isLocalBlock(blockNrOrHash) {
+		var value hexutil.Bytes
+		err := s.b.upstreamCall(ctx, &value, "eth_getStorageAt", address, key, blockArg(blockNrOrHash))
+		return value, err
+	}
+	slot, err := decodeStorageKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage key %s: %v", key, err)
+	}
+	value := s.b.EVM.StateDB.GetState(address, slot)
+	return value.Bytes(), nil
+}
+
+// decodeStorageKey parses a storage slot like geth does, any hex string of up
+// to 32 bytes with or without leading zeros, left-padded to 32 bytes.
+func decodeStorageKey(key string) (common.Hash, error) {
+	if strings.HasPrefix(key, "0x") || strings.HasPrefix(key, "0X") {
+		key = key[2:]
+	}
+	if len(key)%2 == 1 {
+		key = "0" + key
+	}
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return common.Hash{}, errors.New("hex string invalid")
+	}
+	if len(b) > common.HashLength {
+		return common.Hash{}, errors.New("hex string too long, want at most 32 bytes")
+	}
+	return common.BytesToHash(b), nil
+}
+
 func (s *EthAPI) SendTransaction(ctx context.Context, args TransactionArgs) (common.Hash, error) {
 	args = s.preprocessArgs(args)
 	var from *common.Address
@@ -257,15 +371,27 @@ func (s *EthAPI) SendTransaction(ctx context.Context, args TransactionArgs) (com
 
 	s.b.EVM.StateLock()
 	defer s.b.EVM.StateUnlock()
-	if args.Gas == nil {
-		blockGasLimit := s.b.EVM.GetVMContext().GasLimit
-		gas := hexutil.Uint64(blockGasLimit / 3)
-		args.Gas = &gas
+	if err := s.b.checkPoolSize(); err != nil {
+		return common.Hash{}, err
 	}
-
 	nonce := s.b.EVM.StateDB.GetNonce(*from)
 	args.Nonce = (*hexutil.Uint64)(&nonce)
 
+	if args.Gas == nil {
+		estimateArgs := args
+		estimateArgs.From = from
+		gas, err := s.b.estimateGas(ctx, estimateArgs)
+		if err != nil {
+			// still simulate the failing tx, with all the gas it may use
+			golog.Warnf("estimate gas: %v", err)
+			gas = hexutil.Uint64(s.b.EVM.GetVMContext().GasLimit)
+			if s.b.Limits.GasCap != 0 && uint64(gas) > s.b.Limits.GasCap {
+				gas = hexutil.Uint64(s.b.Limits.GasCap)
+			}
+		}
+		args.Gas = &gas
+	}
+
 	signer := mfersigner.NewSigner(s.b.EVM.ChainID().Int64())
 
 	golog.Debugf("Tx: %s", spew.Sdump(args))
@@ -274,19 +400,42 @@ func (s *EthAPI) SendTransaction(ctx context.Context, args TransactionArgs) (com
 	if err != nil {
 		log.Panic(err)
 	}
-	res := s.b.EVM.ExecuteTxs(types.Transactions{tx}, s.b.EVM.StateDB, nil)
-	s.b.TxPool.AddTx(tx, res[0])
+	if s.b.Security.Enabled {
+		s.b.analyzeSentTx(ctx, tx)
+	}
+	if err := s.b.addPoolTx(ctx, tx); err != nil {
+		return common.Hash{}, err
+	}
 	return tx.Hash(), nil
 }
 
+// addPoolTx executes tx on the pending state and adds it to the pool. A tx
+// whose execution is aborted by the execution timeout is not added.
+func (b *MferBackend) addPoolTx(ctx context.Context, tx *types.Transaction) error {
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+	res := b.EVM.ExecuteTxs(ctx, types.Transactions{tx}, b.EVM.StateDB, nil)
+	if err := ctx.Err(); err != nil {
+		return b.execError(err)
+	}
+	b.TxPool.AddTx(tx, res[0])
+	return nil
+}
+
 func (s *EthAPI) SendRawTransaction(ctx context.Context, input hexutil.Bytes) (common.Hash, error) {
 	tx := new(types.Transaction)
 	if err := tx.UnmarshalBinary(input); err != nil {
 		return common.Hash{}, err
 	}
 
-	res := s.b.EVM.ExecuteTxs(types.Transactions{tx}, s.b.EVM.StateDB, nil)
-	s.b.TxPool.AddTx(tx, res[0])
+	s.b.EVM.StateLock()
+	defer s.b.EVM.StateUnlock()
+	if err := s.b.checkPoolSize(); err != nil {
+		return common.Hash{}, err
+	}
+	if err := s.b.addPoolTx(ctx, tx); err != nil {
+		return common.Hash{}, err
+	}
 	return tx.Hash(), nil
 }
 
@@ -294,24 +443,95 @@ var (
 	blockHash = crypto.Keccak256Hash([]byte("fake block hash"))
 )
 
-func (s *EthAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) (*RPCTransaction, error) {
-	// Try to return an already finalized transaction
+func (s *EthAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) (interface{}, error) {
+	// Try to return a transaction of the pool
 	index, tx := s.b.TxPool.GetTransactionByHash(hash)
-	if tx == nil {
-		return nil, fmt.Errorf("tx: %s not found", hash.Hex())
-	}
 	if tx != nil {
-		rpcTx := newRPCTransaction(tx, blockHash, uint64(s.BlockNumber()), uint64(index), nil)
-		msg := s.b.EVM.TxToMessage(tx)
-		rpcTx.From = msg.From()
-		return rpcTx, nil
+		return s.poolRPCTransaction(tx, index), nil
 	}
 
-	// Transaction unknown, return as such
-	return nil, nil
+	// Transaction unknown to the fork, ask the upstream
+	var rpcTx json.RawMessage
+	if err := s.b.upstreamCall(ctx, &rpcTx, "eth_getTransactionByHash", hash); err != nil {
+		return nil, err
+	}
+	return rpcTx, nil
+}
+
+func (s *EthAPI) poolRPCTransaction(tx *types.Transaction, index int) *RPCTransaction {
+	rpcTx := newRPCTransaction(tx, blockHash, uint64(s.BlockNumber()), uint64(index), nil)
+	msg := s.b.EVM.TxToMessage(tx)
+	rpcTx.From = msg.From()
+	return rpcTx
+}
+
+// poolTransactionAt returns the pool transaction at index, nil if out of range.
+func (s *EthAPI) poolTransactionAt(index hexutil.Uint) *RPCTransaction {
+	txs, _ := s.b.TxPool.GetPoolTxs()
+	if int(index) >= len(txs) {
+		return nil
+	}
+	return s.poolRPCTransaction(txs[index], int(index))
+}
+
+func (s *EthAPI) GetTransactionByBlockNumberAndIndex(ctx context.Context, number rpc.BlockNumber, index hexutil.Uint) (interface{}, error) {
+	if s.isPoolBlock(number) {
+		return s.poolTransactionAt(index), nil
+	}
+	var rpcTx json.RawMessage
+	if err := s.b.upstreamCall(ctx, &rpcTx, "eth_getTransactionByBlockNumberAndIndex", number, index); err != nil {
+		return nil, err
+	}
+	return rpcTx, nil
+}
+
+func (s *EthAPI) GetTransactionByBlockHashAndIndex(ctx context.Context, hash common.Hash, index hexutil.Uint) (interface{}, error) {
+	if s.b.isLocalBlock(rpc.BlockNumberOrHashWithHash(hash, false)) {
+		return s.poolTransactionAt(index), nil
+	}
+	var rpcTx json.RawMessage
+	if err := s.b.upstreamCall(ctx, &rpcTx, "eth_getTransactionByBlockHashAndIndex", hash, index); err != nil {
+		return nil, err
+	}
+	return rpcTx, nil
+}
+
+func (s *EthAPI) GetBlockTransactionCountByNumber(ctx context.Context, number rpc.BlockNumber) (*hexutil.Uint, error) {
+	if s.isPoolBlock(number) {
+		txs, _ := s.b.TxPool.GetPoolTxs()
+		count := hexutil.Uint(len(txs))
+		return &count, nil
+	}
+	var count *hexutil.Uint
+	err := s.b.upstreamCall(ctx, &count, "eth_getBlockTransactionCountByNumber", number)
+	return count, err
+}
+
+func (s *EthAPI) GetBlockTransactionCountByHash(ctx context.Context, hash common.Hash) (*hexutil.Uint, error) {
+	if s.b.isLocalBlock(rpc.BlockNumberOrHashWithHash(hash, false)) {
+		txs, _ := s.b.TxPool.GetPoolTxs()
+		count := hexutil.Uint(len(txs))
+		return &count, nil
+	}
+	var count *hexutil.Uint
+	err := s.b.upstreamCall(ctx, &count, "eth_getBlockTransactionCountByHash", hash)
+	return count, err
+}
+
+// isPoolBlock reports whether number is the pseudo block holding the pool
+// transactions, the block after the upstream head.
+func (s *EthAPI) isPoolBlock(number rpc.BlockNumber) bool {
+	switch number {
+	case rpc.LatestBlockNumber, rpc.PendingBlockNumber:
+		return true
+	}
+	return number >= 0 && uint64(number) == uint64(s.BlockNumber())
 }
 
 func (s *EthAPI) GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) (map[string]interface{}, error) {
+	if hash == pseudoBlockHash {
+		return s.GetBlockByNumber(ctx, rpc.LatestBlockNumber, fullTx)
+	}
 	block, err := s.b.EVM.Conn.BlockByHash(ctx, hash)
 	if block != nil && err == nil {
 		return RPCMarshalBlock(block, true, fullTx)
@@ -327,8 +547,8 @@ func (s *EthAPI) GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bo
 
 func (s *EthAPI) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
 	var response map[string]interface{}
-	switch number {
-	case rpc.LatestBlockNumber:
+	switch {
+	case s.isPoolBlock(number):
 		{
 			prevBlock, err := s.b.EVM.Conn.BlockByNumber(ctx, nil)
 			if err != nil {
@@ -343,22 +563,32 @@ func (s *EthAPI) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, f
 			if err != nil {
 				return nil, err
 			}
-			response["hash"] = common.HexToHash("0xcafecafecafecafecafecafecafecafecafecafecafecafecafecafecafecafe")
+			response["hash"] = pseudoBlockHash
 
 		}
-	case rpc.PendingBlockNumber:
-		return response, nil
 	default:
-		block, err := s.b.EVM.Conn.BlockByNumber(ctx, big.NewInt(int64(number)))
+		blockNumber := big.NewInt(int64(number))
+		if number < 0 {
+			// safe and finalized are resolved by the upstream
+			var header *types.Header
+			if err := s.b.upstreamCall(ctx, &header, "eth_getBlockByNumber", number, false); err != nil {
+				return nil, err
+			}
+			if header == nil {
+				return nil, nil
+			}
+			blockNumber = header.Number
+		}
+		block, err := s.b.EVM.Conn.BlockByNumber(ctx, blockNumber)
+		if err == ethereum.NotFound {
+			return nil, nil
+		}
 		if err != nil {
 			return nil, err
 		}
 		response, err = RPCMarshalBlock(block, true, false)
-		if err == nil && number == rpc.PendingBlockNumber {
-			// Pending blocks need to nil out a few fields
-			for _, field := range []string{"hash", "nonce"} {
-				response[field] = nil
-			}
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -380,15 +610,24 @@ func (s *EthAPI) GasPrice(ctx context.Context) (*hexutil.Big, error) {
 }
 
 func (s *EthAPI) GetTransactionCount(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Uint64, error) {
+	if !s.b.isLocalBlock(blockNrOrHash) {
+		var nonce hexutil.Uint64
+		err := s.b.upstreamCall(ctx, &nonce, "eth_getTransactionCount", address, blockArg(blockNrOrHash))
+		return &nonce, err
+	}
 	nonce := s.b.EVM.StateDB.GetNonce(address)
 	return (*hexutil.Uint64)(&nonce), nil
 }
 
-func (s *EthAPI) GetTransactionReceipt(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
+func (s *EthAPI) GetTransactionReceipt(ctx context.Context, hash common.Hash) (interface{}, error) {
 	// spew.Dump(ctx)
 	index, tx := s.b.TxPool.GetTransactionByHash(hash)
 	if tx == nil {
-		return nil, fmt.Errorf("tx: %s not found", hash.Hex())
+		var receipt json.RawMessage
+		if err := s.b.upstreamCall(ctx, &receipt, "eth_getTransactionReceipt", hash); err != nil {
+			return nil, err
+		}
+		return receipt, nil
 	}
 
 	receipt := s.b.EVM.StateDB.GetReceipt(hash)
@@ -412,6 +651,7 @@ func (s *EthAPI) GetTransactionReceipt(ctx context.Context, hash common.Hash) (m
 		"cumulativeGasUsed": hexutil.Uint64(receipt.CumulativeGasUsed),
 		"contractAddress":   nil,
 		"logs":              receipt.Logs,
+		"decodedLogs":       s.b.newDecoder(ctx, s.b.EVM.StateDB).logs(receipt.Logs),
 		"logsBloom":         receipt.Bloom,
 		"type":              hexutil.Uint(tx.Type()),
 	}
@@ -438,6 +678,10 @@ func (s *EthAPI) ChainId() (*hexutil.Big, error) {
 	return (*hexutil.Big)(s.b.EVM.ChainID()), nil
 }
 
+func (s *EthAPI) Syncing() (interface{}, error) {
+	return false, nil
+}
+
 func (s *EthAPI) BlockNumber() hexutil.Uint64 {
 	bn, err := s.b.EVM.Conn.BlockNumber(context.TODO())
 	if err != nil {
@@ -0,0 +1,135 @@
+package mferbackend
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestGetStorageAtKeys(t *testing.T) {
+	var (
+		account = common.HexToAddress("0xacc0")
+		// a keccak derived slot with a leading zero nibble
+		derived = common.HexToHash("0x0b10e2d527612073b26eecdfd717e6a320cf44b4afac2b0732d9fcbe2b7fa0cf")
+	)
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		account: {storage: map[common.Hash]common.Hash{
+			{}:                        common.HexToHash("0x05"),
+			common.HexToHash("0xabc"): common.HexToHash("0x09"),
+			derived:                   common.HexToHash("0x07"),
+		}},
+	})
+	api := &EthAPI{b}
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	historical := rpc.BlockNumberOrHashWithNumber(testBlock)
+	cases := []struct {
+		key   string
+		block rpc.BlockNumberOrHash
+		want  common.Hash
+	}{
+		{"0x0", latest, common.HexToHash("0x05")},
+		{"0x00", latest, common.HexToHash("0x05")},
+		{"0x" + strings.Repeat("0", 64), latest, common.HexToHash("0x05")},
+		{"0xabc", latest, common.HexToHash("0x09")},
+		{"0x0abc", latest, common.HexToHash("0x09")},
+		{"0x" + strings.Repeat("0", 61) + "abc", latest, common.HexToHash("0x09")},
+		{derived.Hex(), latest, common.HexToHash("0x07")},
+		{strings.TrimPrefix(derived.Hex(), "0x"), latest, common.HexToHash("0x07")},
+		// the upstream is asked with the key as it was sent
+		{derived.Hex(), historical, common.HexToHash("0x07")},
+	}
+	for _, c := range cases {
+		value, err := api.GetStorageAt(context.Background(), account, c.key, c.block)
+		if err != nil {
+			t.Errorf("%s: %v", c.key, err)
+			continue
+		}
+		if common.BytesToHash(value) != c.want {
+			t.Errorf("%s: got %x, want %x", c.key, value, c.want)
+		}
+	}
+	for _, key := range []string{"0x" + strings.Repeat("00", 33), "0xzz"} {
+		if _, err := api.GetStorageAt(context.Background(), account, key, latest); err == nil {
+			t.Errorf("%s: no error", key)
+		}
+	}
+}
+
+func TestBlockTagRouting(t *testing.T) {
+	account := common.HexToAddress("0xacc0")
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		account: {balance: big.NewInt(100)},
+	})
+	b.EVM.StateDB.SetBalance(account, big.NewInt(50))
+	api := &EthAPI{b}
+	cases := map[string]struct {
+		block rpc.BlockNumberOrHash
+		want  int64
+	}{
+		"latest":      {rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), 50},
+		"pending":     {rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber), 50},
+		"next block":  {rpc.BlockNumberOrHashWithNumber(testBlock + 1), 50},
+		"pool block":  {rpc.BlockNumberOrHashWithHash(pseudoBlockHash, false), 50},
+		"state block": {rpc.BlockNumberOrHashWithNumber(testBlock), 100},
+		"earliest":    {rpc.BlockNumberOrHashWithNumber(rpc.EarliestBlockNumber), 100},
+		"finalized":   {rpc.BlockNumberOrHashWithNumber(rpc.FinalizedBlockNumber), 100},
+	}
+	for name, c := range cases {
+		balance, err := api.GetBalance(context.Background(), account, c.block)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if balance.ToInt().Int64() != c.want {
+			t.Errorf("%s: got %v, want %d", name, balance, c.want)
+		}
+	}
+}
+
+func TestUnknownTxFallback(t *testing.T) {
+	b, upstream := newUpstreamBackend(t, nil)
+	api := &EthAPI{b}
+	poolTx := sendTx(t, b, common.HexToAddress("0x10"), nil)
+	known := common.HexToHash("0x01")
+	upstream.receipts[known] = &upstreamReceipt{GasUsed: 21000, Logs: []*upstreamLog{}}
+
+	tx, err := api.GetTransactionByHash(context.Background(), poolTx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rpcTx, ok := tx.(*RPCTransaction); !ok || rpcTx.Hash != poolTx.Hash() || rpcTx.BlockHash == nil || *rpcTx.BlockHash != blockHash {
+		t.Errorf("pool tx %v", tx)
+	}
+
+	tx, err = api.GetTransactionByHash(context.Background(), known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upstreamTx struct{ Hash common.Hash }
+	if err := json.Unmarshal(tx.(json.RawMessage), &upstreamTx); err != nil || upstreamTx.Hash != known {
+		t.Errorf("upstream tx %s: %v", tx, err)
+	}
+	receipt, err := api.GetTransactionReceipt(context.Background(), known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upstreamRcpt upstreamReceipt
+	if err := json.Unmarshal(receipt.(json.RawMessage), &upstreamRcpt); err != nil || upstreamRcpt.GasUsed != hexutil.Uint64(21000) {
+		t.Errorf("upstream receipt %s: %v", receipt, err)
+	}
+
+	// unknown to both, null like an upstream
+	tx, err = api.GetTransactionByHash(context.Background(), common.HexToHash("0x02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded, err := json.Marshal(tx); err != nil || string(encoded) != "null" {
+		t.Errorf("unknown tx %s: %v", encoded, err)
+	}
+}
@@ -0,0 +1,321 @@
+package mferbackend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/kataras/golog"
+	"github.com/sec-bit/mfer-node/mferstate"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+// finding severities
+const (
+	severityHigh   = "high"
+	severityMedium = "medium"
+	severityLow    = "low"
+	severityInfo   = "info"
+)
+
+// findingLargeOutflow is an account losing a large part of its ether or of a
+// token, the other kinds are found by mfertracer.SecurityTracer.
+const findingLargeOutflow = "largeOutflow"
+
+var balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+
+// SecurityOptions sets up the security analyzer. With Enabled every tx sent
+// is analyzed before it runs. The delegate calls to the Delegates are
+// trusted. An account losing OutflowRatio percent of its balance of an asset
+// in a tx is flagged.
+type SecurityOptions struct {
+	Enabled      bool
+	Delegates    map[common.Address]bool
+	OutflowRatio uint64
+}
+
+// securityFinding is a risky pattern in a tx. Contract is the contract it
+// was found in, or the account losing Amount of its Balance for a large
+// outflow. CodeAddress is set when the code run is not the one of Contract.
+// Target is the callee, the contract reentered or the beneficiary of a
+// selfdestruct.
+type securityFinding struct {
+	Kind        string          `json:"kind"`
+	Severity    string          `json:"severity"`
+	Description string          `json:"description"`
+	Contract    common.Address  `json:"contract"`
+	Label       string          `json:"label,omitempty"`
+	CodeAddress *common.Address `json:"codeAddress,omitempty"`
+	Pc          *hexutil.Uint64 `json:"pc,omitempty"`
+	Depth       int             `json:"depth"`
+	Op          string          `json:"op,omitempty"`
+	Target      *common.Address `json:"target,omitempty"`
+	TargetLabel string          `json:"targetLabel,omitempty"`
+	Token       *common.Address `json:"token,omitempty"`
+	Symbol      string          `json:"symbol,omitempty"`
+	Amount      *hexutil.Big    `json:"amount,omitempty"`
+	Balance     *hexutil.Big    `json:"balance,omitempty"`
+	Reverted    bool            `json:"reverted,omitempty"`
+}
+
+// securityReport holds the findings of a tx, Error is the failure of the tx.
+type securityReport struct {
+	TxHash   common.Hash        `json:"txHash"`
+	Failed   bool               `json:"failed"`
+	Error    string             `json:"error,omitempty"`
+	Findings []*securityFinding `json:"findings"`
+}
+
+// securityReports keeps the reports of the txs analyzed when they were sent,
+// until the pool is cleared.
+type securityReports struct {
+	mutex   sync.Mutex
+	reports map[common.Hash]*securityReport
+}
+
+func newSecurityReports() *securityReports {
+	return &securityReports{reports: make(map[common.Hash]*securityReport)}
+}
+
+func (r *securityReports) get(txHash common.Hash) *securityReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.reports[txHash]
+}
+
+func (r *securityReports) put(report *securityReport) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reports[report.TxHash] = report
+}
+
+func (r *securityReports) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reports = make(map[common.Hash]*securityReport)
+}
+
+// analyzeSecurity runs msg on stateDB with the security tracer, ctx is the
+// execution context of the whole request.
+func (b *MferBackend) analyzeSecurity(ctx context.Context, msg types.Message, txHash common.Hash, stateDB *mferstate.OverlayStateDB) (*securityReport, error) {
+	tracer := mfertracer.NewSecurityTracer(b.Security.Delegates)
+	result, err := b.EVM.DoCallWithTxContext(ctx, &msg, b.EVM.GetVMContext(), core.NewEVMTxContext(msg), tracer, stateDB.Clone())
+	if ctx.Err() != nil {
+		return nil, b.execError(ctx.Err())
+	}
+	report := &securityReport{TxHash: txHash, Findings: make([]*securityFinding, 0)}
+	switch {
+	case err != nil:
+		report.Failed, report.Error = true, err.Error()
+	case result.Failed():
+		report.Failed, report.Error = true, result.Err.Error()
+	}
+	dec := b.newDecoder(ctx, stateDB)
+	seen := make(map[string]bool)
+	for _, f := range tracer.Findings() {
+		// a check in a loop is found at every iteration
+		key := fmt.Sprint(f.Kind, f.Address, f.CodeAddress, f.Pc, f.Target, f.Reverted)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		report.Findings = append(report.Findings, b.securityFinding(dec, f))
+	}
+	a := &assetAnalyzer{b: b, stateDB: stateDB}
+	report.Findings = append(report.Findings, b.outflowFindings(ctx, a, tracer.Outflows())...)
+	return report, nil
+}
+
+func (b *MferBackend) securityFinding(dec *decoder, f *mfertracer.Finding) *securityFinding {
+	finding := &securityFinding{
+		Kind:     f.Kind,
+		Contract: f.Address,
+		Label:    b.Contracts.Label(f.Address),
+		Depth:    f.Depth,
+		Op:       f.Op.String(),
+		Target:   f.Target,
+		Reverted: f.Reverted,
+	}
+	if f.CodeAddress != f.Address {
+		code := f.CodeAddress
+		finding.CodeAddress = &code
+	}
+	pc := hexutil.Uint64(f.Pc)
+	finding.Pc = &pc
+	target := "?"
+	if f.Target != nil {
+		finding.TargetLabel = b.Contracts.Label(*f.Target)
+		target = f.Target.Hex()
+		if finding.TargetLabel != "" {
+			target = finding.TargetLabel
+		}
+	}
+	switch f.Kind {
+	case mfertracer.FindingTxOrigin:
+		finding.Severity = severityMedium
+		finding.Description = "compares tx.origin with an address other than msg.sender, tx.origin must not be used for authentication"
+	case mfertracer.FindingReentrancy:
+		finding.Severity = severityLow
+		finding.Description = fmt.Sprintf("calls back into %s while a call into it is on the stack", target)
+		if f.WriteAfter {
+			finding.Severity = severityHigh
+			finding.Description += ", which writes storage once the call returns"
+		}
+	case mfertracer.FindingUncheckedCall:
+		finding.Severity = severityMedium
+		finding.Description = fmt.Sprintf("never checks whether its %s to %s succeeded", f.Op, target)
+	case mfertracer.FindingDelegateCall:
+		finding.Severity = severityMedium
+		finding.Description = fmt.Sprintf("runs the code of %s, which is not trusted, with its storage and balance", target)
+		if proxy := dec.proxy(f.Address); proxy != nil && f.Target != nil && proxy.Implementation == *f.Target {
+			finding.Severity = severityInfo
+			finding.Description = fmt.Sprintf("delegates to %s, its %s proxy implementation", target, proxy.Kind)
+		}
+	case mfertracer.FindingSelfDestruct:
+		finding.Severity = severityHigh
+		finding.Amount = (*hexutil.Big)(f.Value)
+		finding.Description = fmt.Sprintf("self-destructs and sends its %s wei to %s", f.Value, target)
+	}
+	return finding
+}
+
+// outflowFindings flags the accounts whose net outflow of an asset is at
+// least OutflowRatio percent of what they held before the tx.
+func (b *MferBackend) outflowFindings(ctx context.Context, a *assetAnalyzer, outflows []*mfertracer.Outflow) []*securityFinding {
+	type assetKey struct {
+		holder common.Address
+		token  common.Address
+	}
+	var (
+		keys []assetKey
+		net  = make(map[assetKey]*big.Int)
+	)
+	add := func(holder common.Address, token *common.Address, amount *big.Int) {
+		key := assetKey{holder: holder}
+		if token != nil {
+			key.token = *token
+		}
+		if _, ok := net[key]; !ok {
+			keys = append(keys, key)
+			net[key] = new(big.Int)
+		}
+		net[key].Add(net[key], amount)
+	}
+	for _, o := range outflows {
+		add(o.From, o.Token, o.Amount)
+		add(o.To, o.Token, new(big.Int).Neg(o.Amount))
+	}
+	findings := make([]*securityFinding, 0)
+	for _, key := range keys {
+		// what passes through an account is no outflow
+		amount := net[key]
+		if amount.Sign() <= 0 {
+			continue
+		}
+		finding := &securityFinding{
+			Kind:     findingLargeOutflow,
+			Contract: key.holder,
+			Label:    b.Contracts.Label(key.holder),
+			Amount:   (*hexutil.Big)(amount),
+		}
+		asset := "ether"
+		var balance *big.Int
+		if key.token == (common.Address{}) {
+			balance = a.stateDB.GetBalance(key.holder)
+		} else {
+			token := key.token
+			finding.Token = &token
+			finding.Symbol = a.tokenMeta(ctx, token).symbol
+			asset = token.Hex()
+			if finding.Symbol != "" {
+				asset = finding.Symbol
+			}
+			ret := a.staticCall(ctx, token, append(common.CopyBytes(balanceOfSelector), common.LeftPadBytes(key.holder.Bytes(), 32)...))
+			if len(ret) != 32 {
+				continue
+			}
+			balance = new(big.Int).SetBytes(ret)
+		}
+		ratio := new(big.Int).Mul(balance, new(big.Int).SetUint64(b.Security.OutflowRatio))
+		if balance.Sign() == 0 || new(big.Int).Mul(amount, big.NewInt(100)).Cmp(ratio) < 0 {
+			continue
+		}
+		finding.Balance = (*hexutil.Big)(balance)
+		finding.Severity = severityMedium
+		if amount.Cmp(balance) >= 0 {
+			finding.Severity = severityHigh
+		}
+		percent := new(big.Int).Div(new(big.Int).Mul(amount, big.NewInt(100)), balance)
+		finding.Description = fmt.Sprintf("loses %s%% of its %s balance", percent, asset)
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// analyzeSentTx analyzes tx on the pending state before it is executed and
+// logs its findings, the report is kept for mfer_securityFindings.
+func (b *MferBackend) analyzeSentTx(ctx context.Context, tx *types.Transaction) {
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+	report, err := b.analyzeSecurity(ctx, b.EVM.TxToMessage(tx), tx.Hash(), b.EVM.StateDB)
+	if err != nil {
+		golog.Warnf("security analysis of %s: %v", tx.Hash().Hex(), err)
+		return
+	}
+	b.security.put(report)
+	for _, f := range report.Findings {
+		golog.Warnf("[Security] tx %s: %s %s %s: %s", tx.Hash().Hex(), f.Severity, f.Kind, f.Contract.Hex(), f.Description)
+	}
+}
+
+// poolSecurityReports replays the pool from the root state and analyzes each
+// tx on the state it runs on, the whole replay is bound by one execContext.
+func (b *MferBackend) poolSecurityReports(ctx context.Context, txs types.Transactions) ([]*securityReport, error) {
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+	stateDB := b.EVM.StateDB.CloneFromRoot()
+	stateDB.InitFakeAccounts()
+	gasPool := newReplayGasPool()
+	reports := make([]*securityReport, 0, len(txs))
+	for _, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return nil, b.execError(err)
+		}
+		report, err := b.analyzeSecurity(ctx, b.EVM.TxToMessage(tx), tx.Hash(), stateDB)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+		b.EVM.ExecuteTxsWithGasPool(ctx, types.Transactions{tx}, stateDB, nil, gasPool)
+	}
+	return reports, nil
+}
+
+// SecurityFindings returns the security findings of a pool tx, the ones
+// found when it was sent if the analyzer is enabled.
+func (s *MferActionAPI) SecurityFindings(ctx context.Context, txHash common.Hash) (*securityReport, error) {
+	if _, tx := s.b.TxPool.GetTransactionByHash(txHash); tx != nil {
+		if report := s.b.security.get(txHash); report != nil {
+			return report, nil
+		}
+	}
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+	tx, stateDB, err := s.b.poolTxState(ctx, txHash)
+	if err != nil {
+		return nil, err
+	}
+	return s.b.analyzeSecurity(ctx, s.b.EVM.TxToMessage(tx), txHash, stateDB)
+}
+
+// PoolSecurityFindings analyzes every pool tx again on the current pool.
+func (s *MferActionAPI) PoolSecurityFindings(ctx context.Context) ([]*securityReport, error) {
+	txs, _ := s.b.TxPool.GetPoolTxs()
+	return s.b.poolSecurityReports(ctx, txs)
+}
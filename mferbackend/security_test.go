@@ -0,0 +1,52 @@
+package mferbackend
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestSecurityOutflow(t *testing.T) {
+	recipient := common.HexToAddress("0x4e4e")
+	b := newTestBackend(t, nil)
+	b.Security = SecurityOptions{Enabled: true, OutflowRatio: 50}
+	api := &EthAPI{b}
+	send := func(value *big.Int) common.Hash {
+		hash, err := api.SendTransaction(context.Background(), TransactionArgs{To: &recipient, Value: (*hexutil.Big)(value)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	balance := b.EVM.StateDB.GetBalance(testSender)
+	amount := new(big.Int).Div(new(big.Int).Mul(balance, big.NewInt(6)), big.NewInt(10))
+	large := send(amount)
+	// a few wei of what is left are under the ratio
+	small := send(big.NewInt(100))
+
+	report := b.security.get(large)
+	if report == nil || len(report.Findings) != 1 {
+		t.Fatalf("report of the large outflow %+v", report)
+	}
+	f := report.Findings[0]
+	if f.Kind != findingLargeOutflow || f.Severity != severityMedium || f.Contract != testSender || f.Token != nil ||
+		f.Amount.ToInt().Cmp(amount) != 0 || f.Balance.ToInt().Cmp(balance) != 0 || f.Description != "loses 60% of its ether balance" {
+		t.Errorf("finding %+v", f)
+	}
+	if report := b.security.get(small); report == nil || len(report.Findings) != 0 {
+		t.Errorf("report of the small outflow %+v", report)
+	}
+	// the report kept when the tx was sent is returned
+	found, err := (&MferActionAPI{b}).SecurityFindings(context.Background(), large)
+	if err != nil || found != report {
+		t.Errorf("stored report not returned: %+v %v", found, err)
+	}
+
+	(&MferActionAPI{b}).ClearTxPool()
+	if report := b.security.get(large); report != nil {
+		t.Errorf("report kept after the pool was cleared: %+v", report)
+	}
+}
@@ -0,0 +1,283 @@
+package mferbackend
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/kataras/golog"
+	"github.com/sec-bit/mfer-node/mfertxpool"
+)
+
+// SessionHeader selects the session of a request, the session can also be
+// selected by the <path>/session/<id> route or the ?token=<id> query.
+const SessionHeader = "X-Mfer-Session"
+
+// Session is an isolated simulation on top of the shared root state cache of a
+// fork, with its own tx pool, overlay branch, impersonation, time deltas and
+// passthrough flag.
+type Session struct {
+	ID       string
+	Backend  *MferBackend
+	handler  http.Handler
+	created  time.Time
+	lastUsed time.Time
+	ttl      time.Duration
+}
+
+type SessionInfo struct {
+	ID                  string         `json:"id"`
+	Path                string         `json:"path"`
+	ImpersonatedAccount common.Address `json:"impersonatedAccount"`
+	Passthrough         bool           `json:"passthrough"`
+	TxCount             int            `json:"txCount"`
+	TimeDelta           uint64         `json:"timeDelta"`
+	BlockNumberDelta    uint64         `json:"blockNumberDelta"`
+	Created             time.Time      `json:"created"`
+	LastUsed            time.Time      `json:"lastUsed"`
+	ExpiresAt           *time.Time     `json:"expiresAt,omitempty"`
+}
+
+// SessionArgs overrides the settings a session inherits from the root backend,
+// TTL is the idle time in seconds after which the session expires.
+type SessionArgs struct {
+	Impersonate *common.Address `json:"impersonate"`
+	Passthrough *bool           `json:"passthrough"`
+	TTL         *uint64         `json:"ttl"`
+}
+
+type SessionManager struct {
+	mutex      *sync.RWMutex
+	root       *MferBackend
+	namespaces []string
+	path       string
+	ttl        time.Duration
+	newHandler func(*rpc.Server) http.Handler
+	sessions   map[string]*Session
+}
+
+// NewSessionManager manages the sessions of the fork served by root at path,
+// idle sessions expire after ttl (never if 0). The rpc server of each session
+// is served over http by the handler newHandler wraps it in.
+func NewSessionManager(root *MferBackend, namespaces []string, path string, ttl time.Duration, newHandler func(*rpc.Server) http.Handler) *SessionManager {
+	m := &SessionManager{
+		mutex:      &sync.RWMutex{},
+		root:       root,
+		namespaces: namespaces,
+		path:       path,
+		ttl:        ttl,
+		newHandler: newHandler,
+		sessions:   make(map[string]*Session),
+	}
+	go m.expireLoop()
+	return m
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Create branches a new session off the root backend.
+func (m *SessionManager) Create(args SessionArgs) (*Session, error) {
+	root := m.root
+	b := NewMferBackend(nil, mfertxpool.NewMferTxPool(), root.ImpersonatedAccount, root.Randomized)
+	b.Passthrough = root.Passthrough
+	b.Shadow = root.Shadow
+	b.probe = root.probe
+	b.OverrideChainID = root.OverrideChainID
+	b.ClientVersion = root.ClientVersion
+	b.Limits = root.Limits
+	b.GasMargin = root.GasMargin
+	b.Security = root.Security
+	b.Signatures = root.Signatures
+	b.Contracts = root.Contracts
+	b.Sessions = m
+	b.SessionID = newSessionID()
+	if args.Impersonate != nil {
+		b.ImpersonatedAccount = *args.Impersonate
+	}
+	if args.Passthrough != nil {
+		b.Passthrough = *args.Passthrough
+	}
+
+	srv, err := NewRPCServer(b, m.namespaces)
+	if err != nil {
+		return nil, err
+	}
+	root.EVM.StateLock()
+	b.EVM = root.EVM.Branch(b.ImpersonatedAccount)
+	root.EVM.StateUnlock()
+	b.EVM.SelfClient = rpc.DialInProc(srv)
+	b.EVM.SelfConn = ethclient.NewClient(b.EVM.SelfClient)
+
+	now := time.Now()
+	session := &Session{
+		ID:       b.SessionID,
+		Backend:  b,
+		handler:  m.newHandler(srv),
+		created:  now,
+		lastUsed: now,
+		ttl:      m.ttl,
+	}
+	if args.TTL != nil {
+		session.ttl = time.Duration(*args.TTL) * time.Second
+	}
+	m.mutex.Lock()
+	m.sessions[session.ID] = session
+	m.mutex.Unlock()
+	golog.Infof("Session %s created (impersonate: %s, passthrough: %v)", session.ID, b.ImpersonatedAccount.Hex(), b.Passthrough)
+	return session, nil
+}
+
+// Get returns the session and marks it as used, nil if it does not exist.
+func (m *SessionManager) Get(id string) *Session {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil
+	}
+	session.lastUsed = time.Now()
+	return session
+}
+
+// Info describes the session.
+func (m *SessionManager) Info(id string) (*SessionInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("session %s not found", id)
+	}
+	return m.info(session), nil
+}
+
+func (m *SessionManager) info(session *Session) *SessionInfo {
+	b := session.Backend
+	txs, _ := b.TxPool.GetPoolTxs()
+	info := &SessionInfo{
+		ID:                  session.ID,
+		Path:                strings.TrimSuffix(m.path, "/") + "/session/" + session.ID,
+		ImpersonatedAccount: b.ImpersonatedAccount,
+		Passthrough:         b.Passthrough,
+		TxCount:             len(txs),
+		TimeDelta:           b.EVM.GetTimeDelta(),
+		BlockNumberDelta:    b.EVM.GetBlockNumberDelta(),
+		Created:             session.created,
+		LastUsed:            session.lastUsed,
+	}
+	if session.ttl > 0 {
+		expiresAt := session.lastUsed.Add(session.ttl)
+		info.ExpiresAt = &expiresAt
+	}
+	return info
+}
+
+// List describes all sessions, oldest first.
+func (m *SessionManager) List() []*SessionInfo {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	infos := make([]*SessionInfo, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		infos = append(infos, m.info(session))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.Before(infos[j].Created)
+	})
+	return infos
+}
+
+// Expire drops the session, it returns false if it does not exist.
+func (m *SessionManager) Expire(id string) bool {
+	m.mutex.Lock()
+	session, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	session.Backend.EVM.Close()
+	golog.Infof("Session %s expired", id)
+	return true
+}
+
+func (m *SessionManager) expireIdle(now time.Time) {
+	m.mutex.RLock()
+	idle := make([]string, 0)
+	for id, session := range m.sessions {
+		if session.ttl > 0 && now.Sub(session.lastUsed) > session.ttl {
+			idle = append(idle, id)
+		}
+	}
+	m.mutex.RUnlock()
+	for _, id := range idle {
+		m.Expire(id)
+	}
+}
+
+func (m *SessionManager) expireLoop() {
+	ticker := time.NewTicker(time.Second * 10)
+	for now := range ticker.C {
+		m.expireIdle(now)
+	}
+}
+
+// ReExecTxPools re-executes the pool of every session on its refreshed
+// branch.
+func (m *SessionManager) ReExecTxPools() {
+	m.mutex.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.mutex.RUnlock()
+	for _, session := range sessions {
+		(&MferActionAPI{session.Backend}).ReExecTxPool()
+	}
+}
+
+// sessionID extracts the session selected by the header, the token query or
+// the session route of the request.
+func (m *SessionManager) sessionID(r *http.Request) string {
+	if id := r.Header.Get(SessionHeader); id != "" {
+		return id
+	}
+	if id := r.URL.Query().Get("token"); id != "" {
+		return id
+	}
+	route := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, m.path), "/")
+	if strings.HasPrefix(route, "session/") {
+		return strings.Trim(strings.TrimPrefix(route, "session/"), "/")
+	}
+	return ""
+}
+
+// Handler routes the requests selecting a session to it, and the others to
+// root.
+func (m *SessionManager) Handler(root http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := m.sessionID(r)
+		if id == "" {
+			root.ServeHTTP(w, r)
+			return
+		}
+		session := m.Get(id)
+		if session == nil {
+			http.Error(w, fmt.Sprintf("session %s not found", id), http.StatusNotFound)
+			return
+		}
+		session.handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,134 @@
+package mferbackend
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sec-bit/mfer-node/mferevm"
+)
+
+func TestSessionID(t *testing.T) {
+	m := &SessionManager{path: "/mainnet"}
+	cases := map[string]string{
+		"/mainnet":                 "",
+		"/mainnet/session/abc":     "abc",
+		"/mainnet/session/abc/":    "abc",
+		"/mainnet?token=def":       "def",
+		"/mainnet/session/abc?x=1": "abc",
+	}
+	for target, want := range cases {
+		if got := m.sessionID(httptest.NewRequest("POST", target, nil)); got != want {
+			t.Errorf("%s: got %q, want %q", target, got, want)
+		}
+	}
+	r := httptest.NewRequest("POST", "/mainnet/session/abc", nil)
+	r.Header.Set(SessionHeader, "ghi")
+	if got := m.sessionID(r); got != "ghi" {
+		t.Errorf("header: got %q, want ghi", got)
+	}
+
+	root := &SessionManager{path: "/"}
+	if got := root.sessionID(httptest.NewRequest("POST", "/session/abc", nil)); got != "abc" {
+		t.Errorf("root path: got %q, want abc", got)
+	}
+}
+
+func TestExpireIdle(t *testing.T) {
+	now := time.Now()
+	m := &SessionManager{mutex: &sync.RWMutex{}, sessions: make(map[string]*Session)}
+	for id, ttl := range map[string]time.Duration{"idle": time.Minute, "kept": 0, "fresh": time.Hour} {
+		m.sessions[id] = &Session{
+			ID:       id,
+			Backend:  &MferBackend{EVM: &mferevm.MferEVM{}},
+			lastUsed: now.Add(-2 * time.Minute),
+			ttl:      ttl,
+		}
+	}
+	m.expireIdle(now)
+	if m.Get("idle") != nil || m.Get("kept") == nil || m.Get("fresh") == nil {
+		t.Fatalf("unexpected sessions after expiry: %v", m.sessions)
+	}
+	if m.Expire("idle") {
+		t.Fatal("expired session expired twice")
+	}
+}
+
+func TestSessionSharedState(t *testing.T) {
+	api := &MferActionAPI{b: &MferBackend{SessionID: "abc", EVM: &mferevm.MferEVM{}}}
+	if err := api.ClearKeyCache(); err != errSharedState {
+		t.Errorf("clearKeyCache in a session: %v", err)
+	}
+	if err := api.SetBatchSize(10); err != errSharedState {
+		t.Errorf("setBatchSize in a session: %v", err)
+	}
+}
+
+func TestSessionReplayIsolation(t *testing.T) {
+	counter := common.HexToAddress("0xc0c0")
+	root := newTestBackend(t, map[common.Address]upstreamAccount{
+		counter: {code: storeCode},
+	})
+	m := NewSessionManager(root, []string{"eth", "mfer"}, "/", 0, func(srv *rpc.Server) http.Handler { return srv })
+	root.Sessions = m
+	traced, err := m.Create(SessionArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := m.Create(SessionArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTx := sendTx(t, root, counter, nil)
+	otherTx := sendTx(t, other.Backend, counter, nil)
+	tracedTx := sendTx(t, traced.Backend, counter, nil)
+	rootCtx := root.EVM.GetVMContext()
+	otherCtx := other.Backend.EVM.GetVMContext()
+
+	// the traced block holds the tx of the session, on top of the upstream
+	block := types.NewBlockWithHeader(&types.Header{
+		Number:     big.NewInt(testBlock),
+		Time:       900,
+		Difficulty: new(big.Int),
+		GasLimit:   30000000,
+		BaseFee:    new(big.Int), // the pool txs pay no fee
+	}).WithBody(types.Transactions{tracedTx}, nil)
+	results, err := (&MferActionAPI{traced.Backend}).traceBlocks(context.Background(), []*types.Block{block}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0]) != 1 || results[0][0] == nil {
+		t.Fatalf("trace results %v", results)
+	}
+
+	for name, b := range map[string]*MferBackend{"root": root, "other": other.Backend, "traced": traced.Backend} {
+		if bn := b.EVM.StateDB.StateBlockNumber(); bn != testBlock {
+			t.Errorf("%s state block %d, want %d", name, bn, testBlock)
+		}
+	}
+	for name, c := range map[string][2]vm.BlockContext{"root": {rootCtx, root.EVM.GetVMContext()}, "other": {otherCtx, other.Backend.EVM.GetVMContext()}} {
+		before, after := c[0], c[1]
+		if after.BlockNumber.Cmp(before.BlockNumber) != 0 || after.BaseFee.Cmp(before.BaseFee) != 0 || after.Time.Cmp(before.Time) != 0 {
+			t.Errorf("%s block context changed: %v %v %v", name, after.BlockNumber, after.BaseFee, after.Time)
+		}
+	}
+	for name, c := range map[string]struct {
+		b  *MferBackend
+		tx *types.Transaction
+	}{"root": {root, rootTx}, "other": {other.Backend, otherTx}, "traced": {traced.Backend, tracedTx}} {
+		if c.b.EVM.StateDB.GetReceipt(c.tx.Hash()) == nil {
+			t.Errorf("%s lost its pool tx", name)
+		}
+		if value := c.b.EVM.StateDB.GetState(counter, common.Hash{}); value != common.BigToHash(big.NewInt(1)) {
+			t.Errorf("%s lost its pool state: %x", name, value)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package mferbackend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/sec-bit/mfer-node/mferstate"
+	"github.com/sec-bit/mfer-node/mfertracer"
+)
+
+const (
+	// maxSimulateBlocks bounds the blocks of a single eth_simulateV1 request.
+	maxSimulateBlocks = 256
+	// simulateTimestampIncrement is the time between simulated blocks without
+	// a time override.
+	simulateTimestampIncrement = 12
+)
+
+// eth_simulateV1 error codes
+const (
+	errCodeReverted       = 3
+	errCodeVMError        = -32015
+	errCodeNonceTooLow    = -38010
+	errCodeNonceTooHigh   = -38011
+	errCodeFeeCapTooLow   = -38012
+	errCodeIntrinsicGas   = -38013
+	errCodeInsufficient   = -38014
+	errCodeBlockNumber    = -38020
+	errCodeBlockTimestamp = -38021
+	errCodeBlockGasLimit  = -38015
+	errCodeClientLimit    = -38026
+)
+
+type simulateError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data,omitempty"`
+}
+
+func (e *simulateError) Error() string  { return e.Message }
+func (e *simulateError) ErrorCode() int { return e.Code }
+
+// txErrorCodes maps the errors of invalid txs to their eth_simulateV1 codes.
+var txErrorCodes = []struct {
+	err  error
+	code int
+}{
+	{core.ErrNonceTooLow, errCodeNonceTooLow},
+	{core.ErrNonceTooHigh, errCodeNonceTooHigh},
+	{core.ErrFeeCapTooLow, errCodeFeeCapTooLow},
+	{core.ErrIntrinsicGas, errCodeIntrinsicGas},
+	{core.ErrInsufficientFunds, errCodeInsufficient},
+	{core.ErrInsufficientFundsForTransfer, errCodeInsufficient},
+}
+
+func newTxError(i int, err error) error {
+	for _, e := range txErrorCodes {
+		if errors.Is(err, e.err) {
+			return &simulateError{Code: e.code, Message: fmt.Sprintf("call %d: %v", i, err)}
+		}
+	}
+	return fmt.Errorf("call %d: %w", i, err)
+}
+
+type simulateBlock struct {
+	BlockOverrides *BlockOverrides          `json:"blockOverrides"`
+	StateOverrides *mferstate.StateOverride `json:"stateOverrides"`
+	Calls          []TransactionArgs        `json:"calls"`
+}
+
+type simulateOpts struct {
+	BlockStateCalls        []simulateBlock `json:"blockStateCalls"`
+	TraceTransfers         bool            `json:"traceTransfers"`
+	Validation             bool            `json:"validation"`
+	ReturnFullTransactions bool            `json:"returnFullTransactions"`
+}
+
+type simulateCallResult struct {
+	ReturnValue hexutil.Bytes  `json:"returnData"`
+	Logs        []*types.Log   `json:"logs"`
+	GasUsed     hexutil.Uint64 `json:"gasUsed"`
+	Status      hexutil.Uint64 `json:"status"`
+	Error       *simulateError `json:"error,omitempty"`
+}
+
+// SimulateV1 runs calls in a sequence of simulated blocks on top of the fork,
+// each block with its own state and block overrides. The simulation runs on a
+// throwaway copy of the overlay and never touches the pool. With validation
+// the calls are checked like txs: nonces, balances and fees.
+func (s *EthAPI) SimulateV1(ctx context.Context, opts simulateOpts, blockNrOrHash *rpc.BlockNumberOrHash) (interface{}, error) {
+	bNrOrHash := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	if blockNrOrHash != nil {
+		bNrOrHash = *blockNrOrHash
+	}
+	if !s.b.isLocalBlock(bNrOrHash) {
+		var result json.RawMessage
+		if err := s.b.upstreamCall(ctx, &result, "eth_simulateV1", opts, blockArg(bNrOrHash)); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	if len(opts.BlockStateCalls) > maxSimulateBlocks {
+		return nil, &simulateError{Code: errCodeClientLimit, Message: fmt.Sprintf("too many blocks: %d > %d", len(opts.BlockStateCalls), maxSimulateBlocks)}
+	}
+
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+
+	stateDB := s.b.EVM.StateDB.Clone()
+	parentCtx := s.b.EVM.GetVMContext()
+	parentHash := pseudoBlockHash
+	blocks := make([]map[string]interface{}, 0, len(opts.BlockStateCalls))
+	first := 0
+	for i, block := range opts.BlockStateCalls {
+		blockCtx := parentCtx
+		blockCtx.BlockNumber = new(big.Int).Add(parentCtx.BlockNumber, common.Big1)
+		blockCtx.Time = new(big.Int).Add(parentCtx.Time, big.NewInt(simulateTimestampIncrement))
+		block.BlockOverrides.Apply(&blockCtx)
+		if blockCtx.BlockNumber.Cmp(parentCtx.BlockNumber) <= 0 {
+			return nil, &simulateError{Code: errCodeBlockNumber, Message: fmt.Sprintf("block numbers must be in order: %d <= %d", blockCtx.BlockNumber, parentCtx.BlockNumber)}
+		}
+		if blockCtx.Time.Cmp(parentCtx.Time) <= 0 {
+			return nil, &simulateError{Code: errCodeBlockTimestamp, Message: fmt.Sprintf("block timestamps must be in order: %d <= %d", blockCtx.Time, parentCtx.Time)}
+		}
+		if err := block.StateOverrides.Apply(stateDB); err != nil {
+			return nil, err
+		}
+
+		result, err := s.simulateBlock(ctx, &opts, &block, first, blockCtx, parentHash, stateDB)
+		if err != nil {
+			var simErr *simulateError
+			if errors.As(err, &simErr) {
+				return nil, simErr
+			}
+			return nil, fmt.Errorf("block %d: %w", i, s.b.execError(err))
+		}
+		blocks = append(blocks, result)
+		first += len(block.Calls)
+		parentCtx = blockCtx
+		parentHash = result["hash"].(common.Hash)
+	}
+	if err := s.b.checkResultSize(blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// simulatedLogKey keys the logs of the call at index i of a simulation, the
+// unsigned txs of identical calls share their hash.
+func simulatedLogKey(i int) common.Hash {
+	return crypto.Keccak256Hash([]byte("simulatedCall"), big.NewInt(int64(i)).Bytes())
+}
+
+// simulateBlock runs the calls of block, first is the index of its first call
+// in the simulation.
+func (s *EthAPI) simulateBlock(ctx context.Context, opts *simulateOpts, block *simulateBlock, first int, blockCtx vm.BlockContext, parentHash common.Hash, stateDB *mferstate.OverlayStateDB) (map[string]interface{}, error) {
+	header := &types.Header{
+		ParentHash: parentHash,
+		UncleHash:  types.EmptyUncleHash,
+		Coinbase:   blockCtx.Coinbase,
+		Difficulty: blockCtx.Difficulty,
+		Number:     blockCtx.BlockNumber,
+		GasLimit:   blockCtx.GasLimit,
+		Time:       blockCtx.Time.Uint64(),
+		BaseFee:    blockCtx.BaseFee,
+	}
+	if blockCtx.Random != nil {
+		header.MixDigest = *blockCtx.Random
+	}
+
+	var (
+		gasPool = new(core.GasPool).AddGas(blockCtx.GasLimit)
+		txs     = make(types.Transactions, len(block.Calls))
+		senders = make([]common.Address, len(block.Calls))
+		calls   = make([]*simulateCallResult, len(block.Calls))
+		// the logs get their block hash once all txs are known
+		logs = make([]*types.Log, 0)
+	)
+	for i, args := range block.Calls {
+		args = s.preprocessArgs(args)
+		from := args.from()
+		if args.Nonce == nil {
+			nonce := hexutil.Uint64(stateDB.GetNonce(from))
+			args.Nonce = &nonce
+		}
+		if args.Gas == nil {
+			gas := hexutil.Uint64(gasPool.Gas())
+			if s.b.Limits.GasCap != 0 && uint64(gas) > s.b.Limits.GasCap {
+				gas = hexutil.Uint64(s.b.Limits.GasCap)
+			}
+			args.Gas = &gas
+		}
+		if uint64(*args.Gas) > gasPool.Gas() {
+			return nil, &simulateError{Code: errCodeBlockGasLimit, Message: fmt.Sprintf("call %d: block gas limit reached: %d > %d", i, *args.Gas, gasPool.Gas())}
+		}
+		var baseFee *big.Int
+		if opts.Validation {
+			baseFee = blockCtx.BaseFee
+		}
+		msg, err := args.ToMessage(s.b.Limits.GasCap, baseFee)
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		// without validation the message skips the nonce and fee checks
+		msg = types.NewMessage(msg.From(), msg.To(), msg.Nonce(), msg.Value(), msg.Gas(), msg.GasPrice(), msg.GasFeeCap(), msg.GasTipCap(), msg.Data(), msg.AccessList(), !opts.Validation)
+
+		if args.MaxFeePerGas == nil && args.GasPrice == nil {
+			zero := hexutil.Big{}
+			args.GasPrice = &zero
+		}
+		gas := hexutil.Uint64(msg.Gas())
+		args.Gas = &gas
+		tx := args.ToTransaction()
+		txs[i], senders[i] = tx, from
+
+		revertTracer := mfertracer.NewRevertTracer()
+		vmCfg := vm.Config{NoBaseFee: !opts.Validation, Debug: true, Tracer: revertTracer}
+		if opts.TraceTransfers {
+			vmCfg.Tracer = mfertracer.NewMuxTracer(mfertracer.NewTransferTracer(), revertTracer)
+		}
+		stateDB.SubRefund(stateDB.GetRefund())
+		stateDB.StartLogCollection(simulatedLogKey(first+i), common.Hash{})
+		result, err := s.b.EVM.ApplyMessage(ctx, &msg, blockCtx, vmCfg, stateDB, gasPool)
+		if err != nil {
+			return nil, newTxError(i, err)
+		}
+
+		call := &simulateCallResult{
+			ReturnValue: result.Return(),
+			GasUsed:     hexutil.Uint64(result.UsedGas),
+			Status:      hexutil.Uint64(types.ReceiptStatusSuccessful),
+			Logs:        make([]*types.Log, 0),
+		}
+		if result.Failed() {
+			call.Status = hexutil.Uint64(types.ReceiptStatusFailed)
+			if errors.Is(result.Err, vm.ErrExecutionReverted) {
+				revertErr := s.b.newDecoder(ctx, stateDB).revertError(msg.To(), result, revertTracer.Origin())
+				call.Error = &simulateError{Code: errCodeReverted, Message: revertErr.Error(), Data: revertErr.reason}
+			} else {
+				call.Error = &simulateError{Code: errCodeVMError, Message: result.Err.Error()}
+			}
+		}
+		for _, l := range stateDB.GetLogs(simulatedLogKey(first + i)) {
+			cpy := *l
+			cpy.BlockNumber = header.Number.Uint64()
+			cpy.TxHash = tx.Hash()
+			cpy.TxIndex = uint(i)
+			cpy.Index = uint(len(logs))
+			call.Logs = append(call.Logs, &cpy)
+			logs = append(logs, &cpy)
+		}
+		calls[i] = call
+	}
+	header.GasUsed = blockCtx.GasLimit - gasPool.Gas()
+	header.TxHash = types.DeriveSha(txs, trie.NewStackTrie(nil))
+	header.Bloom = types.BytesToBloom(types.LogsBloom(logs))
+	hash := header.Hash()
+	for _, l := range logs {
+		l.BlockHash = hash
+	}
+
+	response := RPCMarshalHeader(header)
+	if opts.ReturnFullTransactions {
+		fullTxs := make([]*RPCTransaction, len(txs))
+		for i, tx := range txs {
+			fullTxs[i] = newRPCTransaction(tx, hash, header.Number.Uint64(), uint64(i), header.BaseFee)
+			fullTxs[i].From = senders[i]
+		}
+		response["transactions"] = fullTxs
+	} else {
+		hashes := make([]common.Hash, len(txs))
+		for i, tx := range txs {
+			hashes[i] = tx.Hash()
+		}
+		response["transactions"] = hashes
+	}
+	response["calls"] = calls
+	return response, nil
+}
@@ -0,0 +1,91 @@
+package mferbackend
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+func TestSimulateV1(t *testing.T) {
+	var (
+		emitter  = common.HexToAddress("0xe317")
+		reverter = common.HexToAddress("0x5e7e")
+		other    = common.HexToAddress("0xbbbb")
+	)
+	b := newTestBackend(t, map[common.Address]upstreamAccount{
+		// emits an empty log
+		emitter:  {code: []byte{byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.LOG0), byte(vm.STOP)}},
+		reverter: {code: revertCode},
+	})
+	api := &EthAPI{b}
+	simulate := func(blocks ...simulateBlock) ([]map[string]interface{}, error) {
+		result, err := api.SimulateV1(context.Background(), simulateOpts{BlockStateCalls: blocks}, nil)
+		if err != nil {
+			return nil, err
+		}
+		return result.([]map[string]interface{}), nil
+	}
+	calls := func(block map[string]interface{}) []*simulateCallResult {
+		return block["calls"].([]*simulateCallResult)
+	}
+
+	// the calls of other senders with the same nonce are the same unsigned
+	// tx, each still gets its own logs
+	nonce := hexutil.Uint64(0)
+	balance := (*hexutil.Big)(big.NewInt(1e18))
+	blocks, err := simulate(
+		simulateBlock{Calls: []TransactionArgs{
+			{From: &testSender, To: &emitter, Nonce: &nonce},
+			{From: &other, To: &emitter, Nonce: &nonce},
+		}},
+		simulateBlock{
+			StateOverrides: &mferstate.StateOverride{other: {Balance: &balance}},
+			Calls:          []TransactionArgs{{From: &other, To: &emitter, Nonce: &nonce}, {From: &testSender, To: &reverter}},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("%d blocks", len(blocks))
+	}
+	for i, call := range append(calls(blocks[0]), calls(blocks[1])[0]) {
+		if call.Status != hexutil.Uint64(types.ReceiptStatusSuccessful) || len(call.Logs) != 1 || call.Logs[0].Address != emitter {
+			t.Errorf("call %d: status %d, logs %+v", i, call.Status, call.Logs)
+		}
+	}
+	first := calls(blocks[0])
+	if first[1].Logs[0].TxIndex != 1 || first[1].Logs[0].Index != 1 || first[1].Logs[0].BlockHash != blocks[0]["hash"] {
+		t.Errorf("second log %+v", first[1].Logs[0])
+	}
+	if number := blocks[1]["number"].(*hexutil.Big).ToInt(); number.Uint64() != b.EVM.GetVMContext().BlockNumber.Uint64()+2 {
+		t.Errorf("second block number %d", number)
+	}
+	if revert := calls(blocks[1])[1]; revert.Status != hexutil.Uint64(types.ReceiptStatusFailed) || revert.Error == nil ||
+		revert.Error.Code != errCodeReverted || revert.Error.Data != "0xaa" || len(revert.Logs) != 0 {
+		t.Errorf("reverting call %+v", revert)
+	}
+
+	// the simulation does not touch the overlay
+	if txs, _ := b.TxPool.GetPoolTxs(); len(txs) != 0 {
+		t.Errorf("%d txs in the pool", len(txs))
+	}
+	if balance := b.EVM.StateDB.GetBalance(other); balance.Sign() != 0 {
+		t.Errorf("balance override leaked: %s", balance)
+	}
+
+	// blocks must move forward
+	past := hexutil.Uint64(1)
+	_, err = simulate(simulateBlock{BlockOverrides: &BlockOverrides{Time: &past}})
+	var simErr *simulateError
+	if !errors.As(err, &simErr) || simErr.Code != errCodeBlockTimestamp {
+		t.Errorf("block in the past: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package mferbackend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+// txStateDiff is the state change of a pool tx, shaped like a
+// debug_traceBlock result of the geth prestate tracer in diffMode. Error is
+// the failure of the tx, a failed tx still pays its gas. DecodedStorage
+// holds the changed slots of the accounts with a storage layout.
+type txStateDiff struct {
+	TxHash         common.Hash                                         `json:"txHash"`
+	Result         *mferstate.StateDiff                                `json:"result"`
+	Error          string                                              `json:"error,omitempty"`
+	DecodedStorage map[common.Address]map[common.Hash][]*storageChange `json:"decodedStorage,omitempty"`
+}
+
+// poolStateDiff is the state change of each pool tx and of the whole pool,
+// with the labels of the accounts changed.
+type poolStateDiff struct {
+	Txs            []*txStateDiff                                      `json:"txs"`
+	Pool           *mferstate.StateDiff                                `json:"pool"`
+	DecodedStorage map[common.Address]map[common.Hash][]*storageChange `json:"decodedStorage,omitempty"`
+	Labels         map[common.Address]string                           `json:"labels,omitempty"`
+}
+
+// poolStateDiff replays txs, the first pool txs, from the root state and
+// diffs the state around each of them.
+func (b *MferBackend) poolStateDiff(ctx context.Context, txs types.Transactions) (*poolStateDiff, error) {
+	ctx, cancel := b.execContext(ctx)
+	defer cancel()
+	stateDB := b.EVM.StateDB.CloneFromRoot()
+	stateDB.InitFakeAccounts()
+	base := stateDB.Checkpoint()
+	gasPool := newReplayGasPool()
+	result := &poolStateDiff{
+		Txs:    make([]*txStateDiff, 0, len(txs)),
+		Labels: make(map[common.Address]string),
+	}
+	for _, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return nil, b.execError(err)
+		}
+		pre := stateDB.Checkpoint()
+		execErr := b.EVM.ExecuteTxsWithGasPool(ctx, types.Transactions{tx}, stateDB, nil, gasPool)[0]
+		if err := ctx.Err(); err != nil {
+			return nil, b.execError(err)
+		}
+		diff, err := stateDB.DiffFrom(pre)
+		if err != nil {
+			return nil, err
+		}
+		txDiff := &txStateDiff{TxHash: tx.Hash(), Result: diff}
+		if execErr != nil {
+			txDiff.Error = execErr.Error()
+		}
+		result.Txs = append(result.Txs, txDiff)
+	}
+	diff, err := stateDB.DiffFrom(base)
+	if err != nil {
+		return nil, err
+	}
+	result.Pool = diff
+	dec := b.newDecoder(ctx, stateDB)
+	for _, tx := range result.Txs {
+		tx.DecodedStorage = dec.storageChanges(tx.Result)
+	}
+	result.DecodedStorage = dec.storageChanges(diff)
+	for _, accounts := range []map[common.Address]*mferstate.DiffAccount{diff.Pre, diff.Post} {
+		for address := range accounts {
+			if label := b.Contracts.Label(address); label != "" {
+				result.Labels[address] = label
+			}
+		}
+	}
+	return result, nil
+}
+
+// PoolStateDiff is the state change of each pool tx and of the whole pool.
+func (s *MferActionAPI) PoolStateDiff(ctx context.Context) (*poolStateDiff, error) {
+	txs, _ := s.b.TxPool.GetPoolTxs()
+	return s.b.poolStateDiff(ctx, txs)
+}
+
+// TxStateDiff is the state change of a pool tx.
+func (s *MferActionAPI) TxStateDiff(ctx context.Context, txHash common.Hash) (*txStateDiff, error) {
+	txs, _ := s.b.TxPool.GetPoolTxs()
+	for i, tx := range txs {
+		if tx.Hash() == txHash {
+			diff, err := s.b.poolStateDiff(ctx, txs[:i+1])
+			if err != nil {
+				return nil, err
+			}
+			return diff.Txs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("tx %s not found", txHash.Hex())
+}
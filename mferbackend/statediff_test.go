@@ -0,0 +1,75 @@
+package mferbackend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestPoolStateDiff(t *testing.T) {
+	setter := common.HexToAddress("0x5e75")
+	var (
+		slot     = common.Hash{}
+		stored   = common.HexToHash("0x05")
+		set      = common.HexToHash("0x07")
+		setInput = set.Bytes()
+	)
+	b, _ := newUpstreamBackend(t, map[common.Address]upstreamAccount{
+		// stores the first word of the calldata at slot 0
+		setter: {
+			code:    []byte{byte(vm.PUSH1), 0, byte(vm.CALLDATALOAD), byte(vm.PUSH1), 0, byte(vm.SSTORE), byte(vm.STOP)},
+			storage: map[common.Hash]common.Hash{slot: stored},
+		},
+	})
+	first := sendTx(t, b, setter, setInput)
+	// writes the value the first tx left
+	second := sendTx(t, b, setter, setInput)
+	api := &MferActionAPI{b}
+
+	diff, err := api.PoolStateDiff(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Txs) != 2 || diff.Txs[0].TxHash != first.Hash() || diff.Txs[1].TxHash != second.Hash() {
+		t.Fatalf("txs %+v", diff.Txs)
+	}
+	tx := diff.Txs[0].Result
+	if pre, post := tx.Pre[setter], tx.Post[setter]; pre == nil || post == nil || pre.Storage[slot] != stored || post.Storage[slot] != set {
+		t.Errorf("first tx diff of the setter: %+v %+v", pre, post)
+	}
+	if pre, post := tx.Pre[testSender], tx.Post[testSender]; pre == nil || post == nil || pre.Nonce != 0 || post.Nonce != 1 {
+		t.Errorf("first tx diff of the sender: %+v %+v", pre, post)
+	}
+	// the setter is left as it was, only the nonce of the sender moves
+	tx = diff.Txs[1].Result
+	if _, ok := tx.Pre[setter]; ok {
+		t.Errorf("second tx diff has the no-op write: %+v", tx.Pre[setter])
+	}
+	if _, ok := tx.Post[setter]; ok {
+		t.Errorf("second tx diff has the no-op write: %+v", tx.Post[setter])
+	}
+	if pre, post := tx.Pre[testSender], tx.Post[testSender]; pre == nil || post == nil || pre.Nonce != 1 || post.Nonce != 2 {
+		t.Errorf("second tx diff of the sender: %+v %+v", pre, post)
+	}
+
+	pool := diff.Pool
+	if pre, post := pool.Pre[setter], pool.Post[setter]; pre == nil || post == nil || pre.Storage[slot] != stored || post.Storage[slot] != set {
+		t.Errorf("pool diff of the setter: %+v %+v", pre, post)
+	}
+	if pre, post := pool.Pre[testSender], pool.Post[testSender]; pre == nil || post == nil || pre.Nonce != 0 || post.Nonce != 2 {
+		t.Errorf("pool diff of the sender: %+v %+v", pre, post)
+	}
+
+	txDiff, err := api.TxStateDiff(context.Background(), second.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txDiff.TxHash != second.Hash() || len(txDiff.Result.Post) != len(diff.Txs[1].Result.Post) {
+		t.Errorf("tx diff %+v", txDiff.Result)
+	}
+	if _, err := api.TxStateDiff(context.Background(), common.HexToHash("0x01")); err == nil {
+		t.Error("unknown tx diffed")
+	}
+}
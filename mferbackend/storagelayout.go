@@ -0,0 +1,138 @@
+package mferbackend
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/sec-bit/mfer-node/mferabi"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+// storageChange is a variable changed in a slot, decoded with a storage
+// layout.
+type storageChange struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Offset int    `json:"offset,omitempty"`
+	Pre    string `json:"pre"`
+	Post   string `json:"post"`
+}
+
+// layout is the storage layout registered for address or its code hash, or
+// else for the implementation of the proxy at address.
+func (d *decoder) layout(address common.Address) *mferabi.StorageLayout {
+	if layout, ok := d.layouts[address]; ok {
+		return layout
+	}
+	var layout *mferabi.StorageLayout
+	if d.b.Contracts.HasLayouts() {
+		layout = d.b.Contracts.Layout(address, crypto.Keccak256Hash(d.stateDB.GetCode(address)))
+		if proxy := d.proxy(address); layout == nil && proxy != nil {
+			impl := proxy.Implementation
+			layout = d.b.Contracts.Layout(impl, crypto.Keccak256Hash(d.stateDB.GetCode(impl)))
+		}
+	}
+	d.layouts[address] = layout
+	return layout
+}
+
+// storage decodes a slot of address, nil if it has no layout or the layout
+// does not explain the slot. Mapping entries are resolved with the keccak
+// preimages recorded so far.
+func (d *decoder) storage(address common.Address, slot, value common.Hash) []*mferabi.StorageVar {
+	layout := d.layout(address)
+	if layout == nil {
+		return nil
+	}
+	if d.preimages == nil {
+		d.preimages = d.b.preimages.get(d.stateDB)
+	}
+	return layout.Decode(slot, value, d.preimages)
+}
+
+// preimageCache holds the sorted preimages of the last decoder, they are
+// sorted again only once new preimages were recorded.
+type preimageCache struct {
+	mutex     sync.Mutex
+	version   uint64
+	preimages *mferabi.Preimages
+}
+
+func (c *preimageCache) get(stateDB *mferstate.OverlayStateDB) *mferabi.Preimages {
+	if c == nil {
+		hashes, _ := stateDB.Preimages()
+		return mferabi.NewPreimages(hashes)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.preimages == nil || c.version != stateDB.PreimagesVersion() {
+		hashes, version := stateDB.Preimages()
+		c.preimages, c.version = mferabi.NewPreimages(hashes), version
+	}
+	return c.preimages
+}
+
+// storageChanges decodes the slots changed in diff, by account and slot.
+func (d *decoder) storageChanges(diff *mferstate.StateDiff) map[common.Address]map[common.Hash][]*storageChange {
+	changes := make(map[common.Address]map[common.Hash][]*storageChange)
+	for _, accounts := range []map[common.Address]*mferstate.DiffAccount{diff.Pre, diff.Post} {
+		for address, account := range accounts {
+			for slot := range account.Storage {
+				if _, ok := changes[address][slot]; ok {
+					continue
+				}
+				var pre, post common.Hash
+				if account, ok := diff.Pre[address]; ok {
+					pre = account.Storage[slot]
+				}
+				if account, ok := diff.Post[address]; ok {
+					post = account.Storage[slot]
+				}
+				before, after := d.storage(address, slot, pre), d.storage(address, slot, post)
+				if len(before) == 0 {
+					continue
+				}
+				if changes[address] == nil {
+					changes[address] = make(map[common.Hash][]*storageChange)
+				}
+				slotChanges := make([]*storageChange, 0, len(before))
+				for i, v := range before {
+					if v.Value == after[i].Value {
+						continue
+					}
+					slotChanges = append(slotChanges, &storageChange{Name: v.Name, Type: v.Type, Offset: v.Offset, Pre: v.Value, Post: after[i].Value})
+				}
+				changes[address][slot] = slotChanges
+			}
+		}
+	}
+	return changes
+}
+
+// labeledAccount is an account of a state diff with its registered label
+// and its slots decoded with its storage layout.
+type labeledAccount struct {
+	Label          string                                `json:"label,omitempty"`
+	DecodedStorage map[common.Hash][]*mferabi.StorageVar `json:"decodedStorage,omitempty"`
+	*mferstate.OverrideAccount
+}
+
+type labeledStateDiff map[common.Address]*labeledAccount
+
+func (d *decoder) labelStateDiff(diff mferstate.StateOverride) labeledStateDiff {
+	labeled := make(labeledStateDiff, len(diff))
+	for address, account := range diff {
+		labeledAccount := &labeledAccount{Label: d.b.Contracts.Label(address), OverrideAccount: account}
+		for slot, value := range account.Slots() {
+			if vars := d.storage(address, slot, value); vars != nil {
+				if labeledAccount.DecodedStorage == nil {
+					labeledAccount.DecodedStorage = make(map[common.Hash][]*mferabi.StorageVar)
+				}
+				labeledAccount.DecodedStorage[slot] = vars
+			}
+		}
+		labeled[address] = labeledAccount
+	}
+	return labeled
+}
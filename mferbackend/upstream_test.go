@@ -0,0 +1,192 @@
+package mferbackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sec-bit/mfer-node/mferevm"
+	"github.com/sec-bit/mfer-node/mferstate"
+	"github.com/sec-bit/mfer-node/mfertxpool"
+
+	// the tracers the pool txs are traced with
+	_ "github.com/ethereum/go-ethereum/eth/tracers/js"
+	_ "github.com/ethereum/go-ethereum/eth/tracers/native"
+)
+
+var testSender = common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+// upstreamAccount is an account of the test upstream.
+type upstreamAccount struct {
+	balance *big.Int
+	nonce   uint64
+	code    []byte
+	storage map[common.Hash]common.Hash
+}
+
+// testBlock is the block of the test upstream, after London.
+const testBlock = 16000000
+
+// testUpstream serves the state of accounts at testBlock, the methods are
+// the ones a fork reads its state with.
+type testUpstream struct {
+	accounts map[common.Address]upstreamAccount
+	receipts map[common.Hash]*upstreamReceipt
+	header   *types.Header
+	stall    chan struct{} // holds the storage reads until it is closed
+	// call answers eth_call, the upstream rejects it when nil
+	call func(args map[string]interface{}, overrides *mferstate.StateOverride) (hexutil.Bytes, error)
+}
+
+func (u *testUpstream) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(big.NewInt(1))
+}
+
+func (u *testUpstream) GetBlockByNumber(number string, full bool) *types.Header {
+	return u.header
+}
+
+func (u *testUpstream) GetTransactionCount(address common.Address, number string) hexutil.Uint64 {
+	return hexutil.Uint64(u.accounts[address].nonce)
+}
+
+func (u *testUpstream) GetBalance(address common.Address, number string) *hexutil.Big {
+	if balance := u.accounts[address].balance; balance != nil {
+		return (*hexutil.Big)(balance)
+	}
+	return new(hexutil.Big)
+}
+
+func (u *testUpstream) GetCode(address common.Address, number string) hexutil.Bytes {
+	return u.accounts[address].code
+}
+
+func (u *testUpstream) GetStorageAt(address common.Address, key common.Hash, number string) hexutil.Bytes {
+	if u.stall != nil {
+		<-u.stall
+	}
+	value := u.accounts[address].storage[key]
+	return value[:]
+}
+
+func (u *testUpstream) GetTransactionReceipt(hash common.Hash) *upstreamReceipt {
+	return u.receipts[hash]
+}
+
+func (u *testUpstream) Call(args map[string]interface{}, number string, overrides *mferstate.StateOverride) (hexutil.Bytes, error) {
+	if u.call == nil {
+		return nil, errors.New("the method eth_call does not exist/is not available")
+	}
+	return u.call(args, overrides)
+}
+
+// GetTransactionByHash serves the txs of the receipts, with their hash only.
+func (u *testUpstream) GetTransactionByHash(hash common.Hash) map[string]interface{} {
+	if u.receipts[hash] == nil {
+		return nil
+	}
+	return map[string]interface{}{"hash": hash}
+}
+
+// newTestBackend is the backend of a fork of an upstream holding accounts,
+// testSender is the impersonated account.
+func newTestBackend(t *testing.T, accounts map[common.Address]upstreamAccount) *MferBackend {
+	t.Helper()
+	b, _ := newUpstreamBackend(t, accounts)
+	return b
+}
+
+// newUpstreamBackend is newTestBackend returning the upstream too, the tests
+// change its state and receipts.
+func newUpstreamBackend(t *testing.T, accounts map[common.Address]upstreamAccount) (*MferBackend, *testUpstream) {
+	t.Helper()
+	upstream := &testUpstream{
+		accounts: accounts,
+		receipts: make(map[common.Hash]*upstreamReceipt),
+		header: &types.Header{
+			Number:     big.NewInt(testBlock),
+			Time:       1000,
+			Difficulty: new(big.Int),
+			GasLimit:   30000000,
+			BaseFee:    big.NewInt(7),
+		},
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", upstream); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	t.Cleanup(func() {
+		client.Close()
+		server.Stop()
+	})
+
+	e := mferevm.NewMferEVM(fmt.Sprintf("inproc@%d", testBlock), testSender, filepath.Join(t.TempDir(), "keys.txt"), 1000, 100)
+	e.RpcClient, e.Conn = client, ethclient.NewClient(client)
+	if err := e.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	return NewMferBackend(e, mfertxpool.NewMferTxPool(), testSender, false), upstream
+}
+
+// The codes of the test contracts.
+var (
+	// storeCode stores 1 at slot 0
+	storeCode = []byte{byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.SSTORE), byte(vm.STOP)}
+	// revertCode reverts with 0xaa
+	revertCode = []byte{byte(vm.PUSH1), 0xaa, byte(vm.PUSH1), 0, byte(vm.MSTORE8), byte(vm.PUSH1), 1, byte(vm.PUSH1), 0, byte(vm.REVERT)}
+)
+
+// selectorCase is a selector a dispatcher answers and the word it returns.
+type selectorCase struct {
+	selector []byte
+	word     common.Hash
+}
+
+// dispatcher is the code of a contract returning the word of the selector it
+// is called with, nothing for the other selectors.
+func dispatcher(cases ...selectorCase) []byte {
+	return dispatcherFallback([]byte{byte(vm.STOP)}, cases...)
+}
+
+// dispatcherFallback is dispatcher running fallback for the other selectors,
+// fallback must not jump.
+func dispatcherFallback(fallback []byte, cases ...selectorCase) []byte {
+	code := []byte{byte(vm.PUSH1), 0, byte(vm.CALLDATALOAD), byte(vm.PUSH1), 0xe0, byte(vm.SHR)}
+	// each case is 11 bytes, the returns start after them and the fallback
+	start := len(code) + 11*len(cases) + len(fallback)
+	for i, c := range cases {
+		dest := start + 42*i
+		code = append(code, byte(vm.DUP1), byte(vm.PUSH4))
+		code = append(code, c.selector...)
+		code = append(code, byte(vm.EQ), byte(vm.PUSH2), byte(dest>>8), byte(dest), byte(vm.JUMPI))
+	}
+	code = append(code, fallback...)
+	for _, c := range cases {
+		code = append(code, byte(vm.JUMPDEST), byte(vm.PUSH32))
+		code = append(code, c.word.Bytes()...)
+		code = append(code, byte(vm.PUSH1), 0, byte(vm.MSTORE), byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN))
+	}
+	return code
+}
+
+// sendTx adds a tx of testSender to the pool.
+func sendTx(t *testing.T, b *MferBackend, to common.Address, data []byte) *types.Transaction {
+	t.Helper()
+	input := hexutil.Bytes(data)
+	hash, err := (&EthAPI{b}).SendTransaction(context.Background(), TransactionArgs{To: &to, Input: &input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tx := b.TxPool.GetTransactionByHash(hash)
+	return tx
+}
@@ -0,0 +1,280 @@
+package mferbackend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/kataras/golog"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+type verifyConfig struct {
+	// CheckState compares the accounts touched by the replay with the
+	// upstream after every block.
+	CheckState bool `json:"checkState"`
+}
+
+// divergence is a difference between the replay and the upstream, Trace is the
+// call trace of the local execution of the tx.
+type divergence struct {
+	Block    uint64          `json:"block"`
+	TxHash   *common.Hash    `json:"txHash,omitempty"`
+	TxIndex  *int            `json:"txIndex,omitempty"`
+	Field    string          `json:"field"`
+	Account  *common.Address `json:"account,omitempty"`
+	Slot     *common.Hash    `json:"slot,omitempty"`
+	Local    interface{}     `json:"local"`
+	Upstream interface{}     `json:"upstream"`
+	Trace    json.RawMessage `json:"trace,omitempty"`
+}
+
+type verifyResult struct {
+	FromBlock    uint64        `json:"fromBlock"`
+	ToBlock      uint64        `json:"toBlock"`
+	Transactions int           `json:"transactions"`
+	Divergences  []*divergence `json:"divergences"`
+}
+
+type upstreamLog struct {
+	Address common.Address `json:"address"`
+	Topics  []common.Hash  `json:"topics"`
+	Data    hexutil.Bytes  `json:"data"`
+}
+
+// upstreamReceipt holds the fields of a receipt the replay is checked against,
+// receipts before Byzantium have no status.
+type upstreamReceipt struct {
+	Status  *hexutil.Uint64 `json:"status"`
+	GasUsed hexutil.Uint64  `json:"gasUsed"`
+	Logs    []*upstreamLog  `json:"logs"`
+}
+
+// VerifyBlockRange replays the blocks from numberFrom to numberTo like
+// TraceBlockByNumberRange and compares the status, gas used and logs of every
+// tx with the upstream receipt. With CheckState the balance, nonce, code and
+// storage of every account touched by the replay are compared after each
+// block. Like tracing, it replays on a private state from the parent of
+// numberFrom, the pool and the pending state are left alone.
+func (s *MferActionAPI) VerifyBlockRange(ctx context.Context, numberFrom, numberTo rpc.BlockNumber, config *verifyConfig) (*verifyResult, error) {
+	golog.Infof("verifying block number range: %d-%d", numberFrom, numberTo)
+	if config == nil {
+		config = &verifyConfig{}
+	}
+	blocks, err := s.fetchBlockRange(ctx, numberFrom, numberTo)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.b.execContext(ctx)
+	defer cancel()
+
+	result := &verifyResult{
+		FromBlock:   blocks[0].NumberU64(),
+		ToBlock:     blocks[len(blocks)-1].NumberU64(),
+		Divergences: make([]*divergence, 0),
+	}
+	// a diverged state entry is reported at the first block only
+	reported := make(map[string]bool)
+	// the state a block starts from, the replay starts from the root
+	var base *mferstate.OverlayStateDB
+	err = s.replayBlocks(ctx, blocks, nil, func(block *types.Block, stateDB *mferstate.OverlayStateDB, execResults []error) error {
+		divergences, err := s.verifyReceipts(ctx, block, stateDB, execResults)
+		if err != nil {
+			return err
+		}
+		result.Transactions += len(block.Transactions())
+		result.Divergences = append(result.Divergences, divergences...)
+		if !config.CheckState {
+			return nil
+		}
+		// only the entries the block wrote are fetched from the upstream
+		var diff mferstate.StateOverride
+		if base == nil {
+			diff = stateDB.GetStateDiff()
+		} else if diff, err = stateDB.StateDiffFrom(base); err != nil {
+			return err
+		}
+		base = stateDB.Checkpoint()
+		divergences, err = s.verifyState(ctx, block, diff)
+		if err != nil {
+			return err
+		}
+		for _, d := range divergences {
+			key := d.Field + "/" + d.Account.Hex()
+			if d.Slot != nil {
+				key += "/" + d.Slot.Hex()
+			}
+			if !reported[key] {
+				reported[key] = true
+				result.Divergences = append(result.Divergences, d)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	golog.Infof("verified %d txs in blocks %d-%d: %d divergences", result.Transactions, result.FromBlock, result.ToBlock, len(result.Divergences))
+	if err := s.b.checkResultSize(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *MferActionAPI) verifyReceipts(ctx context.Context, block *types.Block, stateDB *mferstate.OverlayStateDB, execResults []error) ([]*divergence, error) {
+	txs := block.Transactions()
+	receipts := make([]*upstreamReceipt, len(txs))
+	reqs := make([]rpc.BatchElem, len(txs))
+	for i, tx := range txs {
+		reqs[i] = rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []interface{}{tx.Hash()}, Result: &receipts[i]}
+	}
+	if err := s.b.upstreamBatch(ctx, reqs); err != nil {
+		return nil, err
+	}
+
+	dec := s.b.newDecoder(ctx, stateDB)
+	divergences := make([]*divergence, 0)
+	for i, tx := range txs {
+		if reqs[i].Error != nil {
+			return nil, fmt.Errorf("receipt of %s: %w", tx.Hash().Hex(), reqs[i].Error)
+		}
+		upstream := receipts[i]
+		if upstream == nil {
+			return nil, fmt.Errorf("receipt of %s not found", tx.Hash().Hex())
+		}
+		txHash, txIndex := tx.Hash(), i
+		diverged := func(field string, local, upstream interface{}) {
+			divergences = append(divergences, &divergence{
+				Block:    block.NumberU64(),
+				TxHash:   &txHash,
+				TxIndex:  &txIndex,
+				Field:    field,
+				Local:    local,
+				Upstream: upstream,
+				Trace:    dec.trace(txTrace(stateDB, tx)),
+			})
+		}
+
+		local := stateDB.GetReceipt(txHash)
+		if local == nil {
+			diverged("rejected", fmt.Sprint(execResults[i]), nil)
+			continue
+		}
+		if upstream.Status != nil && uint64(*upstream.Status) != local.Status {
+			diverged("status", hexutil.Uint64(local.Status), *upstream.Status)
+		}
+		if uint64(upstream.GasUsed) != local.GasUsed {
+			diverged("gasUsed", hexutil.Uint64(local.GasUsed), upstream.GasUsed)
+		}
+		// the last log holds the trace
+		localLogs := local.Logs[:len(local.Logs)-1]
+		if len(localLogs) != len(upstream.Logs) {
+			diverged("logs", len(localLogs), len(upstream.Logs))
+			continue
+		}
+		for j, l := range localLogs {
+			if !logEqual(l, upstream.Logs[j]) {
+				diverged(fmt.Sprintf("logs[%d]", j), &upstreamLog{l.Address, l.Topics, l.Data}, upstream.Logs[j])
+			}
+		}
+	}
+	return divergences, nil
+}
+
+func logEqual(local *types.Log, upstream *upstreamLog) bool {
+	if local.Address != upstream.Address || !bytes.Equal(local.Data, upstream.Data) || len(local.Topics) != len(upstream.Topics) {
+		return false
+	}
+	for i, topic := range local.Topics {
+		if topic != upstream.Topics[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyState compares the accounts in diff, the state the replay of block
+// wrote, with the upstream at block.
+func (s *MferActionAPI) verifyState(ctx context.Context, block *types.Block, diff mferstate.StateOverride) ([]*divergence, error) {
+	type check struct {
+		field    string
+		account  common.Address
+		slot     *common.Hash
+		local    interface{}
+		upstream interface{}
+	}
+	number := rpc.BlockNumber(block.NumberU64())
+	checks := make([]*check, 0)
+	reqs := make([]rpc.BatchElem, 0)
+	add := func(c *check, method string, args ...interface{}) {
+		checks = append(checks, c)
+		reqs = append(reqs, rpc.BatchElem{Method: method, Args: append(args, number), Result: &c.upstream})
+	}
+	for account, override := range diff {
+		if override.Balance != nil {
+			add(&check{field: "balance", account: account, local: *override.Balance}, "eth_getBalance", account)
+		}
+		if override.Nonce != nil {
+			add(&check{field: "nonce", account: account, local: *override.Nonce}, "eth_getTransactionCount", account)
+		}
+		if override.Code != nil {
+			add(&check{field: "code", account: account, local: *override.Code}, "eth_getCode", account)
+		}
+		for slot, value := range override.Slots() {
+			slot := slot
+			add(&check{field: "storage", account: account, slot: &slot, local: value}, "eth_getStorageAt", account, slot)
+		}
+	}
+	if err := s.b.upstreamBatch(ctx, reqs); err != nil {
+		return nil, err
+	}
+
+	divergences := make([]*divergence, 0)
+	for i, c := range checks {
+		if reqs[i].Error != nil {
+			return nil, fmt.Errorf("%s of %s: %w", c.field, c.account.Hex(), reqs[i].Error)
+		}
+		if !stateEqual(c.local, c.upstream) {
+			account := c.account
+			divergences = append(divergences, &divergence{
+				Block:    block.NumberU64(),
+				Field:    c.field,
+				Account:  &account,
+				Slot:     c.slot,
+				Local:    c.local,
+				Upstream: c.upstream,
+			})
+		}
+	}
+	return divergences, nil
+}
+
+// stateEqual compares a local state value with the hex string returned by the
+// upstream.
+func stateEqual(local, upstream interface{}) bool {
+	hex, ok := upstream.(string)
+	if !ok {
+		return false
+	}
+	switch local := local.(type) {
+	case *hexutil.Big:
+		value, err := hexutil.DecodeBig(hex)
+		return err == nil && value.Cmp(local.ToInt()) == 0
+	case hexutil.Uint64:
+		value, err := hexutil.DecodeUint64(hex)
+		return err == nil && value == uint64(local)
+	case hexutil.Bytes:
+		value, err := hexutil.Decode(hex)
+		return err == nil && bytes.Equal(value, local)
+	case common.Hash:
+		value, err := hexutil.Decode(hex)
+		return err == nil && new(big.Int).SetBytes(value).Cmp(local.Big()) == 0
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package mferbackend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+func TestVerifyReceipts(t *testing.T) {
+	emitter := common.HexToAddress("0xe0e0")
+	b, upstream := newUpstreamBackend(t, map[common.Address]upstreamAccount{
+		// emits a log with topic 0xee
+		emitter: {code: []byte{byte(vm.PUSH1), 0xee, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.LOG1), byte(vm.STOP)}},
+	})
+	txs := types.Transactions{sendTx(t, b, emitter, nil), sendTx(t, b, emitter, nil)}
+	_, execResults := b.TxPool.GetPoolTxs()
+
+	status := hexutil.Uint64(types.ReceiptStatusSuccessful)
+	for i, tx := range txs {
+		local := b.EVM.StateDB.GetReceipt(tx.Hash())
+		receipt := &upstreamReceipt{
+			Status:  &status,
+			GasUsed: hexutil.Uint64(local.GasUsed),
+			Logs:    []*upstreamLog{{Address: emitter, Topics: []common.Hash{common.BigToHash(big.NewInt(0xee))}, Data: hexutil.Bytes{}}},
+		}
+		// the second tx used more gas and logged another topic upstream
+		if i == 1 {
+			receipt.GasUsed++
+			receipt.Logs[0].Topics[0] = common.BigToHash(big.NewInt(0xef))
+		}
+		upstream.receipts[tx.Hash()] = receipt
+	}
+	block := types.NewBlockWithHeader(upstream.header).WithBody(txs, nil)
+
+	divergences, err := (&MferActionAPI{b}).verifyReceipts(context.Background(), block, b.EVM.StateDB, execResults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range divergences {
+		got = append(got, fmt.Sprintf("%d %s", *d.TxIndex, d.Field))
+		if *d.TxHash != txs[1].Hash() || d.Block != testBlock || len(d.Trace) == 0 {
+			t.Errorf("divergence %+v", d)
+		}
+	}
+	if fmt.Sprint(got) != "[1 gasUsed 1 logs[0]]" {
+		t.Errorf("divergences %v", got)
+	}
+}
+
+func TestVerifyState(t *testing.T) {
+	var (
+		account = common.HexToAddress("0xacc0")
+		one     = common.HexToHash("0x01")
+		two     = common.HexToHash("0x02")
+	)
+	b, _ := newUpstreamBackend(t, map[common.Address]upstreamAccount{
+		account: {balance: big.NewInt(100), nonce: 3, code: []byte{0x60}, storage: map[common.Hash]common.Hash{one: common.HexToHash("0x05"), two: common.HexToHash("0x07")}},
+	})
+	balance := (*hexutil.Big)(big.NewInt(100))
+	nonce := hexutil.Uint64(2)
+	code := hexutil.Bytes{0x60}
+	diff := mferstate.StateOverride{account: {
+		Balance:   &balance,
+		Nonce:     &nonce,
+		Code:      &code,
+		StateDiff: &map[common.Hash]common.Hash{one: common.HexToHash("0x05"), two: common.HexToHash("0x06")},
+	}}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(testBlock)})
+
+	divergences, err := (&MferActionAPI{b}).verifyState(context.Background(), block, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range divergences {
+		field := d.Field
+		if d.Slot != nil {
+			field += " " + d.Slot.Hex()[64:]
+		}
+		got = append(got, fmt.Sprintf("%s %v %v", field, d.Local, d.Upstream))
+	}
+	// the balance, the code and slot 1 match the upstream
+	if len(got) != 2 || got[0] != "nonce 0x2 0x3" || got[1] != "storage 02 "+common.HexToHash("0x06").Hex()+" "+common.HexToHash("0x07").Hex() {
+		t.Errorf("divergences %v", got)
+	}
+}
+
+func TestTxToMessageFee(t *testing.T) {
+	b := newTestBackend(t, nil)
+	key, _ := crypto.GenerateKey()
+	to := common.HexToAddress("0x10")
+	tx, err := types.SignNewTx(key, types.NewLondonSigner(big.NewInt(1)), &types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		GasTipCap: big.NewInt(1),
+		GasFeeCap: big.NewInt(100),
+		Gas:       21000,
+		To:        &to,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the simulated blocks have no base fee, the pool txs pay their fee cap
+	if price := b.EVM.TxToMessage(tx).GasPrice(); price.Int64() != 100 {
+		t.Errorf("pool gas price %v, want the fee cap", price)
+	}
+	// a replayed block prices them at its base fee plus the tip
+	b.EVM.SetVMContextByBlockHeader(&types.Header{Number: big.NewInt(testBlock), Difficulty: new(big.Int), BaseFee: big.NewInt(7)})
+	if price := b.EVM.TxToMessage(tx).GasPrice(); price.Int64() != 8 {
+		t.Errorf("replay gas price %v, want 8", price)
+	}
+}
+
+func TestVerifyKeepsPool(t *testing.T) {
+	counter := common.HexToAddress("0xc0c0")
+	b, upstream := newUpstreamBackend(t, map[common.Address]upstreamAccount{
+		counter: {code: storeCode},
+	})
+	// the upstream block has no txs
+	upstream.header.TxHash = types.EmptyRootHash
+	upstream.header.UncleHash = types.EmptyUncleHash
+	tx := sendTx(t, b, counter, nil)
+	blockCtx := b.EVM.GetVMContext()
+
+	result, err := (&MferActionAPI{b}).VerifyBlockRange(context.Background(), rpc.BlockNumber(testBlock), rpc.BlockNumber(testBlock), &verifyConfig{CheckState: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FromBlock != testBlock || result.ToBlock != testBlock || len(result.Divergences) != 0 {
+		t.Errorf("result %+v", result)
+	}
+	if bn := b.EVM.StateDB.StateBlockNumber(); bn != testBlock {
+		t.Errorf("state block %d, want %d", bn, testBlock)
+	}
+	if after := b.EVM.GetVMContext(); after.BlockNumber.Cmp(blockCtx.BlockNumber) != 0 || after.BaseFee.Cmp(blockCtx.BaseFee) != 0 || after.Coinbase != blockCtx.Coinbase {
+		t.Errorf("block context changed: %v %v", after.BlockNumber, after.BaseFee)
+	}
+	if b.EVM.StateDB.GetReceipt(tx.Hash()) == nil {
+		t.Error("pool tx lost")
+	}
+	if value := b.EVM.StateDB.GetState(counter, common.Hash{}); value != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("pending state lost: %x", value)
+	}
+}
+
+func TestVerifyStopsReplay(t *testing.T) {
+	b, upstream := newUpstreamBackend(t, nil)
+	upstream.header.TxHash = types.EmptyRootHash
+	upstream.header.UncleHash = types.EmptyUncleHash
+	api := &MferActionAPI{b}
+	verify := func() {
+		if _, err := api.VerifyBlockRange(context.Background(), rpc.BlockNumber(testBlock), rpc.BlockNumber(testBlock), &verifyConfig{CheckState: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first run starts the goroutines of the upstream connection
+	verify()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 3; i++ {
+		verify()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines after verifying, want %d", n, before)
+	}
+}
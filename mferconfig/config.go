@@ -0,0 +1,492 @@
+package mferconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/naoina/toml"
+	"github.com/naoina/toml/ast"
+)
+
+// EnvPrefix prefixes the environment variables overriding config keys, e.g.
+// MFER_UPSTREAM or MFER_LOG_LEVEL.
+const EnvPrefix = "MFER_"
+
+type LogConfig struct {
+	Path  string `toml:"path"`
+	Level string `toml:"level"`
+}
+
+// AuthKey is an API key and the methods it may call. Without namespaces and
+// methods every method is allowed, ReadOnly blocks the state-mutating ones.
+// Key may be left empty for keys only used as the sub claim of JWTs.
+type AuthKey struct {
+	Name       string   `toml:"name"`
+	Key        string   `toml:"key"`
+	Namespaces []string `toml:"namespaces"`
+	Methods    []string `toml:"methods"`
+	ReadOnly   bool     `toml:"readonly"`
+}
+
+// AuthConfig enables authentication when it has keys or a JWT secret.
+type AuthConfig struct {
+	JWTSecret string    `toml:"jwtsecret"`
+	AuditLog  string    `toml:"auditlog"`
+	Keys      []AuthKey `toml:"keys"`
+}
+
+func (c *AuthConfig) Enabled() bool {
+	return c.JWTSecret != "" || len(c.Keys) > 0
+}
+
+// LimitsConfig bounds the resources of a single request or client, 0 disables
+// a limit. Timeout is in seconds and MaxResultSize in bytes.
+type LimitsConfig struct {
+	GasCap         uint64 `toml:"gascap"`
+	Timeout        uint64 `toml:"timeout"`
+	MaxTraceRange  uint64 `toml:"maxtracerange"`
+	MaxResultSize  uint64 `toml:"maxresultsize"`
+	MaxConcurrency uint64 `toml:"maxconcurrency"`
+	MaxPoolSize    uint64 `toml:"maxpoolsize"`
+}
+
+// SecurityConfig sets up the security analyzer. With Enabled every tx sent
+// with eth_sendTransaction is analyzed. Delegates are the trusted delegate
+// call targets, OutflowRatio is the percent of its balance an account must
+// lose in a tx for the outflow to be flagged.
+type SecurityConfig struct {
+	Enabled      bool     `toml:"enabled"`
+	Delegates    []string `toml:"delegates"`
+	OutflowRatio uint64   `toml:"outflowratio"`
+}
+
+type Config struct {
+	Upstream    string         `toml:"upstream"`
+	Listen      string         `toml:"listen"`
+	OpsListen   string         `toml:"opslisten"`
+	Account     string         `toml:"account"`
+	Rand        bool           `toml:"rand"`
+	Passthrough bool           `toml:"passthrough"`
+	Shadow      bool           `toml:"shadow"`
+	KeyCache    string         `toml:"keycache"`
+	MaxKeys     uint64         `toml:"maxkeys"`
+	BatchSize   int            `toml:"batchsize"`
+	ChainID     uint64         `toml:"chainid"`
+	Namespaces  []string       `toml:"namespaces"`
+	Metrics     bool           `toml:"metrics"`
+	MaxLag      uint64         `toml:"maxlag"`
+	SessionTTL  uint64         `toml:"sessionttl"`
+	GasMargin   uint64         `toml:"gasmargin"`
+	Signatures  []string       `toml:"signatures"`
+	Contracts   []string       `toml:"contracts"`
+	Log         LogConfig      `toml:"log"`
+	Auth        AuthConfig     `toml:"auth"`
+	Limits      LimitsConfig   `toml:"limits"`
+	Security    SecurityConfig `toml:"security"`
+
+	// Forks lists the profiles served side by side by one process, each under
+	// its own Path on its Listen address.
+	Forks []string `toml:"forks"`
+	Path  string   `toml:"path"`
+
+	profiles map[string]*ast.Table
+}
+
+func DefaultKeyCacheFilePath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		log.Panic(err)
+	}
+	cacheDir = path.Join(cacheDir, "MferSafe")
+	err = os.MkdirAll(cacheDir, os.ModePerm)
+	if err != nil {
+		log.Panic(err)
+	}
+	fileName := "scratchPadKeyCache.txt"
+	return path.Join(cacheDir, fileName)
+}
+
+func Default() *Config {
+	return &Config{
+		Upstream:    "http://localhost:8545",
+		Listen:      "127.0.0.1:10545",
+		OpsListen:   "127.0.0.1:6060",
+		Account:     "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		Passthrough: true,
+		KeyCache:    DefaultKeyCacheFilePath(),
+		MaxKeys:     100,
+		BatchSize:   100,
+		Namespaces:  []string{"eth", "net", "web3", "wallet", "debug", "mfer", "probe"},
+		Path:        "/",
+		SessionTTL:  3600,
+		Limits: LimitsConfig{
+			GasCap:        50_000_000,
+			Timeout:       60,
+			MaxTraceRange: 100,
+			MaxResultSize: 100 << 20,
+			MaxPoolSize:   1000,
+		},
+		Security: SecurityConfig{
+			OutflowRatio: 50,
+		},
+		Log: LogConfig{
+			Path:  "./mfer-node.log",
+			Level: "info",
+		},
+	}
+}
+
+// tomlSettings matches keys through the struct tags only and rejects
+// unknown keys.
+var tomlSettings = toml.Config{
+	NormFieldName: func(rt reflect.Type, key string) string {
+		return key
+	},
+	FieldToKey: func(rt reflect.Type, field string) string {
+		return strings.ToLower(field)
+	},
+	MissingField: func(rt reflect.Type, field string) error {
+		return fmt.Errorf("field '%s' is not defined in %s", field, rt.String())
+	},
+}
+
+// Load applies the top-level keys of the config file onto cfg, then the keys
+// of the named profile ([profiles.<name>]) if any.
+func (cfg *Config) Load(file, profile string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := cfg.load(data, profile); err != nil {
+		return fmt.Errorf("%s, %v", file, err)
+	}
+	return nil
+}
+
+func (cfg *Config) load(data []byte, profile string) error {
+	root, err := toml.Parse(data)
+	if err != nil {
+		return err
+	}
+	profiles := make(map[string]*ast.Table)
+	cfg.profiles = profiles
+	if field, ok := root.Fields["profiles"]; ok {
+		profilesTable, ok := field.(*ast.Table)
+		if !ok {
+			return errors.New("'profiles' must be a table")
+		}
+		for name, field := range profilesTable.Fields {
+			table, ok := field.(*ast.Table)
+			if !ok {
+				return fmt.Errorf("profile '%s' must be a table", name)
+			}
+			profiles[name] = table
+		}
+		delete(root.Fields, "profiles")
+	}
+	if err := tomlSettings.UnmarshalTable(root, cfg); err != nil {
+		return err
+	}
+	if profile == "" {
+		return nil
+	}
+	table, ok := profiles[profile]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile '%s' not found (available: %s)", profile, strings.Join(names, ", "))
+	}
+	return tomlSettings.UnmarshalTable(table, cfg)
+}
+
+// Fork derives the config of a fork from the config file and the keys of its
+// profile, then applies overrides (the MFER_* env and the flags) so they take
+// precedence over the profile. cfg must not have the overrides applied yet.
+// Unless the profile sets them, a fork is served under /<name> and keeps its
+// own key cache file next to the shared one.
+func (cfg *Config) Fork(name string, overrides func(*Config) error) (*Config, error) {
+	table, ok := cfg.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("fork '%s': profile not found", name)
+	}
+	fork := *cfg
+	fork.Namespaces = append([]string{}, cfg.Namespaces...)
+	fork.Forks = nil
+	if err := tomlSettings.UnmarshalTable(table, &fork); err != nil {
+		return nil, fmt.Errorf("fork '%s': %v", name, err)
+	}
+	if len(fork.Forks) > 0 {
+		return nil, fmt.Errorf("fork '%s': forks can not be nested", name)
+	}
+	if overrides != nil {
+		if err := overrides(&fork); err != nil {
+			return nil, fmt.Errorf("fork '%s': %v", name, err)
+		}
+		fork.Forks = nil
+	}
+	if _, ok := table.Fields["path"]; !ok {
+		fork.Path = "/" + name
+	}
+	if _, ok := table.Fields["keycache"]; !ok {
+		ext := path.Ext(fork.KeyCache)
+		fork.KeyCache = strings.TrimSuffix(fork.KeyCache, ext) + "-" + name + ext
+	}
+	if err := fork.Validate(); err != nil {
+		return nil, fmt.Errorf("fork '%s': %v", name, err)
+	}
+	return &fork, nil
+}
+
+// ApplyEnv overrides the keys which have a MFER_<KEY> environment variable,
+// nested keys are joined by underscores (MFER_LOG_LEVEL).
+func (cfg *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, key := range cfg.Keys() {
+		env := EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		if value, ok := lookup(env); ok {
+			if err := cfg.Set(key, value); err != nil {
+				return fmt.Errorf("env %s: %v", env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Keys lists all config keys, nested keys are dot separated (log.level).
+func (cfg *Config) Keys() []string {
+	return fieldKeys(reflect.TypeOf(*cfg), "")
+}
+
+func fieldKeys(rt reflect.Type, prefix string) []string {
+	keys := make([]string, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.Tag.Get("toml") == "" {
+			continue
+		}
+		key := prefix + field.Tag.Get("toml")
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			// tables can only be set in the config file
+			continue
+		}
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, fieldKeys(field.Type, key+".")...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Set parses value into the field of the given key. Lists are comma
+// separated.
+func (cfg *Config) Set(key, value string) error {
+	rv := reflect.ValueOf(cfg).Elem()
+	for _, name := range strings.Split(key, ".") {
+		found := false
+		for i := 0; i < rv.NumField(); i++ {
+			if rv.Type().Field(i).Tag.Get("toml") == name {
+				rv = rv.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown key '%s'", key)
+		}
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q for '%s'", value, key)
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q for '%s'", value, key)
+		}
+		rv.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q for '%s'", value, key)
+		}
+		rv.SetUint(n)
+	case reflect.Slice:
+		items := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		rv.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s for '%s'", rv.Type(), key)
+	}
+	return nil
+}
+
+var logLevels = []string{"disable", "fatal", "error", "warn", "info", "debug"}
+
+// Validate checks every key and reports all problems at once.
+func (cfg *Config) Validate() error {
+	var errs []string
+	if err := validateUpstream(cfg.Upstream); err != nil {
+		errs = append(errs, fmt.Sprintf("upstream: %v", err))
+	}
+	if err := validateListen(cfg.Listen); err != nil {
+		errs = append(errs, fmt.Sprintf("listen: %v", err))
+	}
+	if err := validateListen(cfg.OpsListen); err != nil {
+		errs = append(errs, fmt.Sprintf("opslisten: %v", err))
+	}
+	if !common.IsHexAddress(cfg.Account) {
+		errs = append(errs, fmt.Sprintf("account: %q is not a hex address", cfg.Account))
+	}
+	if cfg.BatchSize <= 0 {
+		errs = append(errs, fmt.Sprintf("batchsize: must be positive, got %d", cfg.BatchSize))
+	}
+	if cfg.KeyCache == "" {
+		errs = append(errs, "keycache: must not be empty")
+	}
+	if len(cfg.Namespaces) == 0 {
+		errs = append(errs, "namespaces: at least one namespace must be enabled")
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		errs = append(errs, fmt.Sprintf("path: %q must start with /", cfg.Path))
+	}
+	validLevel := false
+	for _, level := range logLevels {
+		if cfg.Log.Level == level {
+			validLevel = true
+		}
+	}
+	if !validLevel {
+		errs = append(errs, fmt.Sprintf("log.level: %q is not one of %s", cfg.Log.Level, strings.Join(logLevels, ", ")))
+	}
+	errs = append(errs, cfg.Auth.validate()...)
+	errs = append(errs, cfg.Security.validate()...)
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func (c *AuthConfig) validate() (errs []string) {
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, key := range c.Keys {
+		switch {
+		case key.Name == "":
+			errs = append(errs, fmt.Sprintf("auth.keys[%d]: name must not be empty", i))
+		case names[key.Name]:
+			errs = append(errs, fmt.Sprintf("auth.keys[%d]: duplicated name %q", i, key.Name))
+		}
+		names[key.Name] = true
+		switch {
+		case key.Key == "" && c.JWTSecret == "":
+			errs = append(errs, fmt.Sprintf("auth.keys[%d]: key must not be empty without auth.jwtsecret", i))
+		case key.Key != "" && keys[key.Key]:
+			errs = append(errs, fmt.Sprintf("auth.keys[%d]: duplicated key", i))
+		}
+		keys[key.Key] = true
+		for _, method := range key.Methods {
+			if !strings.Contains(method, "_") {
+				errs = append(errs, fmt.Sprintf("auth.keys[%d]: %q is not a <namespace>_<method> name", i, method))
+			}
+		}
+	}
+	return errs
+}
+
+func (c *SecurityConfig) validate() (errs []string) {
+	for i, delegate := range c.Delegates {
+		if !common.IsHexAddress(delegate) {
+			errs = append(errs, fmt.Sprintf("security.delegates[%d]: %q is not a hex address", i, delegate))
+		}
+	}
+	if c.OutflowRatio == 0 || c.OutflowRatio > 100 {
+		errs = append(errs, fmt.Sprintf("security.outflowratio: must be between 1 and 100, got %d", c.OutflowRatio))
+	}
+	return errs
+}
+
+func validateUpstream(rawurl string) error {
+	if rawurl == "" {
+		return errors.New("must not be empty")
+	}
+	// strip the block pinning postfix (@height)
+	if i := strings.LastIndex(rawurl, "@"); i > 0 {
+		if _, err := strconv.ParseUint(rawurl[i+1:], 10, 64); err == nil {
+			rawurl = rawurl[:i]
+		}
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+		if u.Host == "" {
+			return fmt.Errorf("%q has no host", rawurl)
+		}
+	case "":
+		// ipc path
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+func validateListen(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// redacted replaces the secrets in the rendered config.
+const redacted = "<redacted>"
+
+// Marshal renders the config as toml, with the auth secrets redacted.
+func (cfg *Config) Marshal() ([]byte, error) {
+	out := *cfg
+	if out.Auth.JWTSecret != "" {
+		out.Auth.JWTSecret = redacted
+	}
+	out.Auth.Keys = nil
+	for _, key := range cfg.Auth.Keys {
+		if key.Key != "" {
+			key.Key = redacted
+		}
+		out.Auth.Keys = append(out.Auth.Keys, key)
+	}
+	var buf bytes.Buffer
+	if err := tomlSettings.NewEncoder(&buf).Encode(&out); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,168 @@
+package mferconfig
+
+import (
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+upstream = "http://localhost:8545"
+batchsize = 50
+namespaces = ["eth", "mfer"]
+
+[log]
+level = "debug"
+
+[profiles.mainnet]
+upstream = "https://rpc.ankr.com/eth"
+
+[profiles.arbitrum-pinned]
+upstream = "https://arb1.arbitrum.io/rpc@19000000"
+batchsize = 20
+passthrough = false
+`
+
+func TestLoadProfile(t *testing.T) {
+	cfg := Default()
+	if err := cfg.load([]byte(testConfig), "arbitrum-pinned"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Upstream != "https://arb1.arbitrum.io/rpc@19000000" || cfg.BatchSize != 20 || cfg.Passthrough {
+		t.Fatalf("profile not applied: %+v", cfg)
+	}
+	if cfg.Log.Level != "debug" || len(cfg.Namespaces) != 2 {
+		t.Fatalf("top-level keys not applied: %+v", cfg)
+	}
+	if cfg.MaxKeys != 100 {
+		t.Fatalf("default overwritten: %d", cfg.MaxKeys)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Default().load([]byte(testConfig), "optimism"); err == nil || !strings.Contains(err.Error(), "arbitrum-pinned, mainnet") {
+		t.Fatalf("expected unknown profile error, got %v", err)
+	}
+	if err := Default().load([]byte("upstrem = \"x\""), ""); err == nil || !strings.Contains(err.Error(), "upstrem") {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"MFER_BATCHSIZE":   "7",
+		"MFER_LOG_LEVEL":   "warn",
+		"MFER_NAMESPACES":  "eth, debug",
+		"MFER_PASSTHROUGH": "false",
+	}
+	cfg := Default()
+	err := cfg.ApplyEnv(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BatchSize != 7 || cfg.Log.Level != "warn" || cfg.Passthrough || strings.Join(cfg.Namespaces, ",") != "eth,debug" {
+		t.Fatalf("env not applied: %+v", cfg)
+	}
+
+	err = cfg.ApplyEnv(func(key string) (string, bool) {
+		return "many", key == "MFER_MAXKEYS"
+	})
+	if err == nil || !strings.Contains(err.Error(), "MFER_MAXKEYS") {
+		t.Fatalf("expected env error, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Upstream = "ftp://example.com"
+	cfg.Listen = "10545"
+	cfg.Account = "0x1234"
+	cfg.BatchSize = 0
+	cfg.Log.Level = "verbose"
+	cfg.Security.Delegates = []string{"0xdead"}
+	cfg.Security.OutflowRatio = 150
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, key := range []string{"upstream", "listen", "account", "batchsize", "log.level", "security.delegates[0]", "security.outflowratio"} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("missing %s in %v", key, err)
+		}
+	}
+}
+
+func TestFork(t *testing.T) {
+	cfg := Default()
+	cfg.KeyCache = "/tmp/cache/keys.txt"
+	if err := cfg.load([]byte(testConfig+"\n[profiles.optimism]\nforks = [\"mainnet\"]\n"), ""); err != nil {
+		t.Fatal(err)
+	}
+	fork, err := cfg.Fork("mainnet", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.Upstream != "https://rpc.ankr.com/eth" || fork.Path != "/mainnet" || fork.KeyCache != "/tmp/cache/keys-mainnet.txt" || fork.BatchSize != 50 {
+		t.Fatalf("unexpected fork config: %+v", fork)
+	}
+	if cfg.Upstream != "http://localhost:8545" {
+		t.Fatalf("base config mutated: %s", cfg.Upstream)
+	}
+	if _, err := cfg.Fork("optimism", nil); err == nil {
+		t.Fatal("expected nested forks error")
+	}
+	if _, err := cfg.Fork("polygon", nil); err == nil {
+		t.Fatal("expected missing profile error")
+	}
+}
+
+func TestForkPrecedence(t *testing.T) {
+	cfg := Default()
+	if err := cfg.load([]byte(testConfig), ""); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"MFER_UPSTREAM": "http://env:8545",
+		"MFER_FORKS":    "mainnet",
+	}
+	overrides := func(c *Config) error {
+		if err := c.ApplyEnv(func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		}); err != nil {
+			return err
+		}
+		// a flag
+		return c.Set("batchsize", "30")
+	}
+	// the profile sets the upstream and the batch size over the file
+	fork, err := cfg.Fork("arbitrum-pinned", overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.Upstream != "http://env:8545" || fork.BatchSize != 30 || fork.Passthrough || fork.Log.Level != "debug" {
+		t.Fatalf("env and flags do not take precedence over the profile: %+v", fork)
+	}
+	if fork.Path != "/arbitrum-pinned" || len(fork.Forks) != 0 {
+		t.Fatalf("unexpected fork config: %+v", fork)
+	}
+}
+
+func TestMarshalRedacts(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.JWTSecret = "jwt-secret"
+	cfg.Auth.Keys = []AuthKey{{Name: "ci", Key: "api-secret"}, {Name: "jwt-only"}}
+	out, err := cfg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "jwt-secret") || strings.Contains(string(out), "api-secret") || strings.Count(string(out), redacted) != 2 || !strings.Contains(string(out), "ci") {
+		t.Fatalf("secrets not redacted:\n%s", out)
+	}
+	if cfg.Auth.JWTSecret != "jwt-secret" || cfg.Auth.Keys[0].Key != "api-secret" {
+		t.Fatal("config mutated")
+	}
+}
@@ -0,0 +1,104 @@
+package mferevm
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sec-bit/mfer-node/mferstate"
+)
+
+// Branch creates an EVM with its own overlay branch, block context, time
+// deltas and tracer on top of the root state cache of a. It shares the
+// upstream connection and chain config with a and follows its block context
+// updates until it is closed.
+func (a *MferEVM) Branch(impersonatedAccount common.Address) *MferEVM {
+	branch := &MferEVM{
+		ctx:                 a.ctx,
+		RpcClient:           a.RpcClient,
+		Conn:                a.Conn,
+		Metrics:             a.Metrics,
+		StateDB:             a.StateDB.Branch(),
+		keyCacheFilePath:    a.keyCacheFilePath,
+		maxKeyCache:         a.maxKeyCache,
+		batchSize:           a.batchSize,
+		vmContext:           a.GetVMContext(),
+		vmContextLock:       &sync.RWMutex{},
+		chainConfig:         a.chainConfig,
+		callMutex:           &sync.RWMutex{},
+		stateLock:           &sync.RWMutex{},
+		impersonatedAccount: impersonatedAccount,
+		timeDelta:           a.GetTimeDelta(),
+		blockNumberDelta:    a.GetBlockNumberDelta(),
+		blockNumber:         a.blockNumber,
+		pinBlock:            a.pinBlock,
+		upstreamURL:         a.upstreamURL,
+		status:              a.status,
+		parent:              a,
+		branchesMutex:       &sync.RWMutex{},
+		branches:            make(map[*MferEVM]bool),
+	}
+	branch.StateDB.InitFakeAccounts()
+	branch.AddGasPool()
+
+	a.branchesMutex.Lock()
+	a.branches[branch] = true
+	a.branchesMutex.Unlock()
+	return branch
+}
+
+// Close stops a branch from following the block context of its parent.
+func (a *MferEVM) Close() {
+	if a.parent == nil {
+		return
+	}
+	a.parent.branchesMutex.Lock()
+	delete(a.parent.branches, a)
+	a.parent.branchesMutex.Unlock()
+}
+
+// syncVMContext re-applies the latest header of the parent with the deltas of
+// the branch.
+func (a *MferEVM) syncVMContext() {
+	a.parent.vmContextLock.RLock()
+	header := a.parent.header
+	a.parent.vmContextLock.RUnlock()
+	if header != nil {
+		a.applyHeader(header)
+	}
+}
+
+// Replay creates a detached EVM on a private state at block stateBN, it
+// replays historical blocks without moving the block number, the root state
+// cache or the block context of a and its branches. It shares the upstream
+// connection and chain config with a and does not follow its updates. The
+// caller closes its StateDB when the replay is done.
+func (a *MferEVM) Replay(stateBN uint64) *MferEVM {
+	blockNumber := stateBN
+	replay := &MferEVM{
+		ctx:                 a.ctx,
+		RpcClient:           a.RpcClient,
+		Conn:                a.Conn,
+		Metrics:             a.Metrics,
+		keyCacheFilePath:    a.keyCacheFilePath,
+		maxKeyCache:         a.maxKeyCache,
+		batchSize:           a.batchSize,
+		vmContext:           a.GetVMContext(),
+		vmContextLock:       &sync.RWMutex{},
+		chainConfig:         a.chainConfig,
+		callMutex:           &sync.RWMutex{},
+		stateLock:           &sync.RWMutex{},
+		impersonatedAccount: a.impersonatedAccount,
+		timeDelta:           a.GetTimeDelta(),
+		blockNumber:         &blockNumber,
+		pinBlock:            true,
+		upstreamURL:         a.upstreamURL,
+		status:              &status{},
+		branchesMutex:       &sync.RWMutex{},
+		branches:            make(map[*MferEVM]bool),
+	}
+	// the key cache file belongs to a, the private state starts empty
+	replay.StateDB = mferstate.NewOverlayStateDB(a.RpcClient, replay.blockNumber, a.keyCacheFilePath, a.maxKeyCache, a.batchSize)
+	replay.StateDB.SetMetrics(a.Metrics)
+	replay.AddGasPool()
+	return replay
+}
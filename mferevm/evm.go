@@ -29,8 +29,10 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/sec-bit/mfer-node/constant"
+	"github.com/sec-bit/mfer-node/mfermetrics"
 	"github.com/sec-bit/mfer-node/mfersigner"
 	"github.com/sec-bit/mfer-node/mferstate"
+	"github.com/sec-bit/mfer-node/mfertracer"
 )
 
 type MferEVM struct {
@@ -39,12 +41,16 @@ type MferEVM struct {
 	Conn       *ethclient.Client
 	SelfClient *rpc.Client
 	SelfConn   *ethclient.Client
+	// Metrics records the upstream requests and the state of the fork, it
+	// must be set before Connect.
+	Metrics *mfermetrics.Fork
 
 	StateDB             *mferstate.OverlayStateDB
 	keyCacheFilePath    string
 	maxKeyCache         uint64
 	batchSize           int
-	vmContext           vm.BlockContext
+	vmContext           vm.BlockContext // replaced, never updated in place
+	vmContextLock       *sync.RWMutex
 	gasPool             *core.GasPool
 	chainConfig         *params.ChainConfig
 	callMutex           *sync.RWMutex
@@ -55,6 +61,12 @@ type MferEVM struct {
 	tracer              vm.EVMLogger
 	blockNumber         *uint64
 	pinBlock            bool
+	upstreamURL         string
+	status              *status
+	header              *types.Header
+	parent              *MferEVM
+	branchesMutex       *sync.RWMutex
+	branches            map[*MferEVM]bool
 	// specifiedBlockNumber *uint64
 }
 
@@ -73,19 +85,14 @@ func NewMferEVM(rawurl string, impersonatedAccount common.Address, keyCacheFileP
 		lastIndex := strings.LastIndex(rawurl, "@"+bnStr)
 		rawurl = rawurl[:lastIndex]
 	}
-	ctx := context.Background()
-DIAL:
-	RpcClient, err := rpc.DialContext(ctx, rawurl)
-	if err != nil {
-		golog.Errorf("Dial [%s] error: [%v] retrying", rawurl, err)
-		time.Sleep(time.Second * 3)
-		goto DIAL
-	}
-	mferEVM.ctx = ctx
-	mferEVM.RpcClient = RpcClient
-	mferEVM.Conn = ethclient.NewClient(RpcClient)
+	mferEVM.ctx = context.Background()
+	mferEVM.upstreamURL = rawurl
 	mferEVM.callMutex = &sync.RWMutex{}
 	mferEVM.stateLock = &sync.RWMutex{}
+	mferEVM.vmContextLock = &sync.RWMutex{}
+	mferEVM.status = &status{}
+	mferEVM.branchesMutex = &sync.RWMutex{}
+	mferEVM.branches = make(map[*MferEVM]bool)
 	mferEVM.impersonatedAccount = impersonatedAccount
 	mferEVM.keyCacheFilePath = keyCacheFilePath
 	mferEVM.maxKeyCache = maxKeyCache
@@ -95,18 +102,39 @@ DIAL:
 		mferEVM.SetBlockNumber(*specificBlock)
 		mferEVM.pinBlock = true
 		golog.Infof("Using specific block %d, auto update block context disabled", *specificBlock)
-	} else {
-		go mferEVM.updatePendingBN()
 	}
-	err = mferEVM.Prepare()
+	return mferEVM
+}
+
+// Connect dials the upstream and prepares the first state. It retries until
+// it succeeds, progress can be observed through Health meanwhile.
+func (a *MferEVM) Connect() {
+DIAL:
+	RpcClient, err := rpc.DialContext(a.ctx, a.upstreamURL)
+	if err != nil {
+		golog.Errorf("Dial [%s] error: [%v] retrying", a.upstreamURL, err)
+		a.status.setError(err)
+		time.Sleep(time.Second * 3)
+		goto DIAL
+	}
+	// Health reads the client under the status lock while this retries
+	a.status.mu.Lock()
+	a.RpcClient = RpcClient
+	a.Conn = ethclient.NewClient(RpcClient)
+	a.status.mu.Unlock()
+	err = a.Prepare()
 	if err != nil {
 		golog.Errorf("Prepare error: %v", err)
+		a.status.setError(err)
 		time.Sleep(time.Second)
 		goto DIAL
 	}
-	golog.Infof("Using block %d", mferEVM.StateDB.StateBlockNumber())
+	a.status.setPrepared()
+	golog.Infof("Using block %d", a.StateDB.StateBlockNumber())
 
-	return mferEVM
+	if !a.pinBlock {
+		go a.updatePendingBN()
+	}
 }
 
 func (a *MferEVM) StateLock() {
@@ -119,7 +147,9 @@ func (a *MferEVM) StateUnlock() {
 
 func (a *MferEVM) GetBlockHeader(blockNumber string) *types.Header {
 	var raw json.RawMessage
+	start := time.Now()
 	err := a.RpcClient.CallContext(a.ctx, &raw, "eth_getBlockByNumber", blockNumber, false)
+	a.Metrics.UpstreamCall("eth_getBlockByNumber", start, err)
 	if err != nil {
 		golog.Errorf("GetBlockHeader err: %v", err)
 		return nil
@@ -152,15 +182,20 @@ func (a *MferEVM) ResetToRoot() {
 	a.StateDB.InitState(false, false)
 	a.StateDB.InitFakeAccounts()
 	a.gasPool = new(core.GasPool)
-	a.gasPool.AddGas(a.vmContext.GasLimit)
+	a.gasPool.AddGas(a.GetVMContext().GasLimit)
 }
 
 func (a *MferEVM) AddGasPool() {
 	a.gasPool = new(core.GasPool)
-	a.gasPool.AddGas(a.vmContext.GasLimit)
+	a.gasPool.AddGas(a.GetVMContext().GasLimit)
 }
 
 func (a *MferEVM) Prepare() error {
+	if a.parent != nil {
+		a.syncVMContext()
+		a.ResetToRoot()
+		return nil
+	}
 	a.chainConfig = core.DefaultGenesisBlock().Config
 	chainID, err := a.Conn.ChainID(a.ctx)
 	if err != nil {
@@ -179,6 +214,7 @@ func (a *MferEVM) Prepare() error {
 		}
 		return blk.Hash()
 	}
+	a.vmContextLock.Lock()
 	a.vmContext = vm.BlockContext{
 		CanTransfer: core.CanTransfer,
 		Transfer:    core.Transfer,
@@ -189,11 +225,16 @@ func (a *MferEVM) Prepare() error {
 		Time:        big.NewInt(0),
 		Difficulty:  big.NewInt(0),
 	}
+	a.vmContextLock.Unlock()
 	header := a.setVMContext()
+	if header == nil {
+		return errors.New("failed to fetch block header")
+	}
 	bn := header.Number.Uint64()
 	a.SetBlockNumber(bn)
 	if a.StateDB == nil {
 		a.StateDB = mferstate.NewOverlayStateDB(a.RpcClient, a.blockNumber, a.keyCacheFilePath, a.maxKeyCache, a.batchSize)
+		a.StateDB.SetMetrics(a.Metrics)
 	}
 	a.StateDB.InitState(true, false)
 	a.StateDB.InitFakeAccounts()
@@ -201,23 +242,38 @@ func (a *MferEVM) Prepare() error {
 	return nil
 }
 
+// ActivePrecompiles returns the precompiled contracts of the current block.
+func (a *MferEVM) ActivePrecompiles() []common.Address {
+	blockCtx := a.GetVMContext()
+	rules := a.chainConfig.Rules(blockCtx.BlockNumber, blockCtx.Random != nil)
+	return vm.ActivePrecompiles(rules)
+}
+
 func (a *MferEVM) GetChainConfig() params.ChainConfig {
 	return *a.chainConfig
 }
 
 func (a *MferEVM) SetTimeDelta(delta uint64) {
+	a.vmContextLock.Lock()
+	defer a.vmContextLock.Unlock()
 	a.timeDelta = delta
 }
 
 func (a *MferEVM) GetTimeDelta() uint64 {
+	a.vmContextLock.RLock()
+	defer a.vmContextLock.RUnlock()
 	return a.timeDelta
 }
 
 func (a *MferEVM) SetBlockNumberDelta(delta uint64) {
+	a.vmContextLock.Lock()
+	defer a.vmContextLock.Unlock()
 	a.blockNumberDelta = delta
 }
 
 func (a *MferEVM) GetBlockNumberDelta() uint64 {
+	a.vmContextLock.RLock()
+	defer a.vmContextLock.RUnlock()
 	return a.blockNumberDelta
 }
 
@@ -233,22 +289,62 @@ func (a *MferEVM) setVMContext() (header *types.Header) {
 		return
 	}
 
-	a.vmContext.Coinbase = header.Coinbase // use real world coinbase to avoid simulation cheating
-	a.vmContext.BlockNumber.SetInt64(int64(header.Number.Uint64() + 1 + a.blockNumberDelta))
-	a.vmContext.Time.SetInt64(int64(header.Time + a.timeDelta))
-	a.vmContext.Difficulty.Set(header.Difficulty)
-	a.vmContext.GasLimit = header.GasLimit
+	a.status.setRefreshed(header.Number.Uint64())
+	a.applyHeader(header)
+	a.branchesMutex.RLock()
+	for branch := range a.branches {
+		branch.applyHeader(header)
+	}
+	a.branchesMutex.RUnlock()
 	return
 }
 
+// applyHeader replaces the block context with the one of the block pending
+// on header. The contexts handed out before keep their values.
+func (a *MferEVM) applyHeader(header *types.Header) {
+	a.vmContextLock.Lock()
+	defer a.vmContextLock.Unlock()
+	a.header = header
+	blockCtx := a.vmContext
+	blockCtx.Coinbase = header.Coinbase // use real world coinbase to avoid simulation cheating
+	blockCtx.BlockNumber = new(big.Int).SetUint64(header.Number.Uint64() + 1 + a.blockNumberDelta)
+	blockCtx.Time = new(big.Int).SetUint64(header.Time + a.timeDelta)
+	blockCtx.Difficulty = new(big.Int).Set(header.Difficulty)
+	blockCtx.GasLimit = header.GasLimit
+	// the simulated blocks have no base fee, a replay may have set one
+	blockCtx.BaseFee = new(big.Int)
+	blockCtx.Random = nil
+	a.vmContext = blockCtx
+}
+
+// SetVMContextByBlockHeader sets the context of header to replay its block,
+// with its coinbase, base fee and randomness.
 func (a *MferEVM) SetVMContextByBlockHeader(header *types.Header) {
-	a.vmContext.BlockNumber.SetInt64(int64(header.Number.Uint64()))
-	a.vmContext.Time.SetInt64(int64(header.Time + a.timeDelta))
-	a.vmContext.Difficulty.Set(header.Difficulty)
-	a.vmContext.GasLimit = header.GasLimit
+	a.vmContextLock.Lock()
+	defer a.vmContextLock.Unlock()
+	blockCtx := a.vmContext
+	blockCtx.BlockNumber = new(big.Int).Set(header.Number)
+	blockCtx.Time = new(big.Int).SetUint64(header.Time + a.timeDelta)
+	blockCtx.Difficulty = new(big.Int).Set(header.Difficulty)
+	blockCtx.GasLimit = header.GasLimit
+	blockCtx.Coinbase = header.Coinbase
+	blockCtx.BaseFee = new(big.Int)
+	if header.BaseFee != nil {
+		blockCtx.BaseFee.Set(header.BaseFee)
+	}
+	blockCtx.Random = nil
+	if header.Difficulty.Sign() == 0 {
+		random := header.MixDigest
+		blockCtx.Random = &random
+	}
+	a.vmContext = blockCtx
 }
 
+// GetVMContext is the context of the pending block. The header updates
+// replace it rather than change it, so it stays as it is.
 func (a *MferEVM) GetVMContext() vm.BlockContext {
+	a.vmContextLock.RLock()
+	defer a.vmContextLock.RUnlock()
 	return a.vmContext
 }
 
@@ -301,6 +397,7 @@ func (a *MferEVM) updatePendingBN() {
 				// a.StateDB.InitState()
 				// header := a.setVMContext()
 				// a.SetBlockNumber(header.Number.Uint64())
+				a.Metrics.Refork()
 				a.SelfClient.Call(nil, "mfer_reExecTxPool")
 			}
 
@@ -312,9 +409,14 @@ func (a *MferEVM) updatePendingBN() {
 		if a.StateDB == nil {
 			continue
 		}
-		sizeStr := humanize.Bytes(uint64(a.StateDB.CacheSize()))
+		cacheSize := a.StateDB.CacheSize()
+		a.Metrics.SetScratchPadSize(cacheSize)
+		a.Metrics.SetOverlayDepth(a.StateDB.GetOverlayDepth())
+		a.Metrics.SetStateBlock(a.StateDB.StateBlockNumber(), a.status.head())
+		sizeStr := humanize.Bytes(uint64(cacheSize))
+		blockCtx := a.GetVMContext()
 		golog.Infof("[Update] BN: %d, StateBlock: %d, Ts: %d, Diff: %d, GasLimit: %d, Cache: %s, RPCReq: %d",
-			a.vmContext.BlockNumber, a.StateDB.StateBlockNumber(), a.vmContext.Time, a.vmContext.Difficulty, a.vmContext.GasLimit, sizeStr, a.StateDB.RPCRequestCount())
+			blockCtx.BlockNumber, a.StateDB.StateBlockNumber(), blockCtx.Time, blockCtx.Difficulty, blockCtx.GasLimit, sizeStr, a.StateDB.RPCRequestCount())
 	}
 
 }
@@ -336,12 +438,19 @@ func (a *MferEVM) TxToMessage(tx *types.Transaction) types.Message {
 	} else {
 		signer = types.NewLondonSigner(a.ChainID())
 	}
-	msg, _ := tx.AsMessage(signer, nil)
+	// the simulated blocks have no base fee, the pool txs pay their fee cap.
+	// The blocks replayed from the upstream price them at their base fee.
+	var baseFee *big.Int
+	if blockBaseFee := a.GetVMContext().BaseFee; blockBaseFee != nil && blockBaseFee.Sign() > 0 {
+		baseFee = blockBaseFee
+	}
+	msg, _ := tx.AsMessage(signer, baseFee)
 	return msg
 }
 
 // WarmUpCache is a specular method, it execute txs parallely to make batch getStorageAt request
-func (a *MferEVM) WarmUpCache(txs types.Transactions, stateDB *mferstate.OverlayStateDB) {
+// until ctx is done
+func (a *MferEVM) WarmUpCache(ctx context.Context, txs types.Transactions, stateDB *mferstate.OverlayStateDB) {
 	golog.Infof("Warming up %d txs", len(txs))
 	start := time.Now()
 	wg := sync.WaitGroup{}
@@ -351,13 +460,18 @@ func (a *MferEVM) WarmUpCache(txs types.Transactions, stateDB *mferstate.Overlay
 		go func(db *mferstate.OverlayStateDB) {
 			defer wg.Done()
 			stateDB := db.Clone()
+			defer stateDB.BindContext(ctx)()
+			blockCtx := a.GetVMContext()
 			for tx := range txCh {
+				if ctx.Err() != nil {
+					continue
+				}
 				msg := a.TxToMessage(tx)
 				gp := new(core.GasPool)
 				gp.AddGas(math.MaxUint64)
 				// stateDB.(*mferstate.OverlayStateDB).SetCodeHash(msg.From(), common.Hash{})
 				txContext := core.NewEVMTxContext(msg)
-				evm := vm.NewEVM(a.vmContext, txContext, stateDB, a.chainConfig, vm.Config{})
+				evm := vm.NewEVM(blockCtx, txContext, stateDB, a.chainConfig, vm.Config{})
 				stateDB.StartLogCollection(tx.Hash(), blockHash)
 				core.ApplyMessage(evm, msg, gp)
 			}
@@ -372,19 +486,31 @@ func (a *MferEVM) WarmUpCache(txs types.Transactions, stateDB *mferstate.Overlay
 	golog.Infof("Warmed up %d caches (consumes: %s)", cacheSize, time.Since(start))
 }
 
-func (a *MferEVM) ExecuteTxs(txs types.Transactions, stateDB *mferstate.OverlayStateDB, config *tracers.TraceConfig) (execResults []error) {
+// ExecuteTxs executes txs on stateDB, the txs left when ctx is done are
+// aborted and leave no trace on stateDB.
+func (a *MferEVM) ExecuteTxs(ctx context.Context, txs types.Transactions, stateDB *mferstate.OverlayStateDB, config *tracers.TraceConfig) (execResults []error) {
+	return a.ExecuteTxsWithGasPool(ctx, txs, stateDB, config, a.gasPool)
+}
+
+// ExecuteTxsWithGasPool is ExecuteTxs drawing the gas from gasPool instead of
+// the block gas pool, replays use it to leave the gas of the pool txs alone.
+func (a *MferEVM) ExecuteTxsWithGasPool(ctx context.Context, txs types.Transactions, stateDB *mferstate.OverlayStateDB, config *tracers.TraceConfig, gasPool *core.GasPool) (execResults []error) {
 	execResults = make([]error, len(txs))
 	var (
 		gasUsed = uint64(0)
 		txIndex = 0
 	)
 	for i, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			execResults[i] = fmt.Errorf("execution aborted: %w", err)
+			continue
+		}
 		// just try some txs
 		if i < 100 {
-			a.WarmUpCache(txs[i:], stateDB.Clone())
+			a.WarmUpCache(ctx, txs[i:], stateDB.Clone())
 		}
 		msg := a.TxToMessage(tx)
-		gas, result := a.ExecuteMsg(stateDB, msg, tx.Hash(), i, config)
+		gas, result := a.ExecuteMsgWithGasPool(ctx, stateDB, msg, tx.Hash(), i, config, gasPool)
 		gasUsed += gas
 		execResults[i] = result
 		txIndex++
@@ -393,7 +519,27 @@ func (a *MferEVM) ExecuteTxs(txs types.Transactions, stateDB *mferstate.OverlayS
 	return
 }
 
-func (a *MferEVM) ExecuteMsg(stateDB *mferstate.OverlayStateDB, msg types.Message, txHash common.Hash, txIndex int, config *tracers.TraceConfig) (gasUsed uint64, execResult error) {
+// ExecError is the error of a failed tx with its revert data and the call
+// frame that raised it.
+type ExecError struct {
+	err    error
+	Revert []byte
+	Origin *mfertracer.RevertOrigin
+}
+
+func (e *ExecError) Error() string {
+	return e.err.Error()
+}
+
+// ExecuteMsg executes msg on stateDB. The execution is aborted when ctx is
+// done, stateDB is then left as it was.
+func (a *MferEVM) ExecuteMsg(ctx context.Context, stateDB *mferstate.OverlayStateDB, msg types.Message, txHash common.Hash, txIndex int, config *tracers.TraceConfig) (gasUsed uint64, execResult error) {
+	return a.ExecuteMsgWithGasPool(ctx, stateDB, msg, txHash, txIndex, config, a.gasPool)
+}
+
+// ExecuteMsgWithGasPool is ExecuteMsg drawing the gas from gasPool.
+func (a *MferEVM) ExecuteMsgWithGasPool(ctx context.Context, stateDB *mferstate.OverlayStateDB, msg types.Message, txHash common.Hash, txIndex int, config *tracers.TraceConfig, gasPool *core.GasPool) (gasUsed uint64, execResult error) {
+	defer stateDB.BindContext(ctx)()
 	stateDB.SetCodeHash(msg.From(), common.Hash{})
 	txContext := core.NewEVMTxContext(msg)
 	snapshot := stateDB.Snapshot()
@@ -442,17 +588,36 @@ func (a *MferEVM) ExecuteMsg(stateDB *mferstate.OverlayStateDB, msg types.Messag
 		tracer = logger.NewStructLogger(config.Config)
 	}
 
-	evm := vm.NewEVM(a.vmContext, txContext, stateDB, a.chainConfig, vm.Config{
-		Debug:  true,
-		Tracer: tracer,
+	blockCtx := a.GetVMContext()
+	evm := vm.NewEVM(blockCtx, txContext, stateDB, a.chainConfig, vm.Config{
+		Debug:                   true,
+		Tracer:                  tracer,
+		EnablePreimageRecording: true,
 	})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			evm.Cancel()
+		case <-done:
+		}
+	}()
 
 	stateDB.StartLogCollection(txHash, blockHash)
-	msgResult, err := core.ApplyMessage(evm, msg, a.gasPool)
+	msgResult, err := core.ApplyMessage(evm, msg, gasPool)
+	if evm.Cancelled() || ctx.Err() != nil {
+		// the loads abandoned by ctx read as empty, nothing of it is kept
+		if msgResult != nil {
+			gasPool.AddGas(msgResult.UsedGas)
+		}
+		stateDB.RevertToSnapshot(snapshot)
+		return 0, fmt.Errorf("execution aborted: %w", ctx.Err())
+	}
 	if err != nil {
 		golog.Errorf("rejected tx: %s, from: %s, err: %v", txHash.Hex(), msg.From(), err)
 		// print msg gas and gasPool
-		golog.Infof("msg gas: %d, gasPool: %d", msg.Gas(), a.gasPool.Gas())
+		golog.Infof("msg gas: %d, gasPool: %d", msg.Gas(), gasPool.Gas())
 		stateDB.RevertToSnapshot(snapshot)
 		return 0, err
 	}
@@ -460,9 +625,14 @@ func (a *MferEVM) ExecuteMsg(stateDB *mferstate.OverlayStateDB, msg types.Messag
 	if len(msgResult.Revert()) > 0 || msgResult.Err != nil {
 		// spew.Dump(msgResult.Revert(), msgResult.Err)
 		reason, errUnpack := abi.UnpackRevert(msgResult.Revert())
-		msgExecErr = errors.New("execution reverted")
+		err := errors.New("execution reverted")
 		if errUnpack == nil {
-			msgExecErr = fmt.Errorf("execution reverted: %v", reason)
+			err = fmt.Errorf("execution reverted: %v", reason)
+		}
+		msgExecErr = &ExecError{
+			err:    err,
+			Revert: common.CopyBytes(msgResult.Revert()),
+			Origin: a.revertOrigin(ctx, &msg, blockCtx, vm.Config{}, stateDB, snapshot),
 		}
 		golog.Errorf("TxIdx: %d, Hash: %s, unwrapped: %v, err: %v", txIndex, txHash.Hex(), msgResult.Unwrap(), msgExecErr)
 	}
@@ -475,7 +645,7 @@ func (a *MferEVM) ExecuteMsg(stateDB *mferstate.OverlayStateDB, msg types.Messag
 	}
 	receipt.TxHash = txHash
 	receipt.BlockHash = blockHash
-	receipt.BlockNumber = a.vmContext.BlockNumber
+	receipt.BlockNumber = blockCtx.BlockNumber
 	receipt.GasUsed = msgResult.UsedGas
 
 	if msg.To() == nil {
@@ -500,9 +670,8 @@ func (a *MferEVM) ExecuteMsg(stateDB *mferstate.OverlayStateDB, msg types.Messag
 	return gasUsed, msgExecErr
 }
 
-func (a *MferEVM) DoCall(msg *types.Message, debug bool, stateDB *mferstate.OverlayStateDB) (*core.ExecutionResult, error) {
-	txContext := core.NewEVMTxContext(msg)
-
+// DoCall executes msg on stateDB, the execution is aborted when ctx is done.
+func (a *MferEVM) DoCall(ctx context.Context, msg *types.Message, debug bool, stateDB *mferstate.OverlayStateDB) (*core.ExecutionResult, error) {
 	// a.callMutex.Lock()
 	// log.Printf("DoCall clone from depth: %d", a.StateDB.GetOverlayDepth())
 	// clonedDB := a.StateDB.Clone()
@@ -511,15 +680,98 @@ func (a *MferEVM) DoCall(msg *types.Message, debug bool, stateDB *mferstate.Over
 		Debug:  debug,
 		Tracer: a.tracer,
 	}
+	return a.doCall(ctx, msg, a.GetVMContext(), vmCfg, stateDB)
+}
 
-	stateDB.SetCodeHash(msg.From(), common.Hash{})
-	evm := vm.NewEVM(a.vmContext, txContext, stateDB, a.chainConfig, vmCfg)
+// DoCallWithBlockContext is DoCallWithTracer in blockCtx instead of the
+// context of the pending block.
+func (a *MferEVM) DoCallWithBlockContext(ctx context.Context, msg *types.Message, blockCtx vm.BlockContext, tracer vm.EVMLogger, stateDB *mferstate.OverlayStateDB) (*core.ExecutionResult, error) {
+	vmCfg := vm.Config{
+		Debug:  tracer != nil,
+		Tracer: tracer,
+	}
+	return a.doCall(ctx, msg, blockCtx, vmCfg, stateDB)
+}
+
+// DoCallWithTracer is DoCall with its own tracer instead of the one set by
+// SetTracer, so concurrent calls do not share it.
+func (a *MferEVM) DoCallWithTracer(ctx context.Context, msg *types.Message, tracer vm.EVMLogger, stateDB *mferstate.OverlayStateDB) (*core.ExecutionResult, error) {
+	vmCfg := vm.Config{
+		Debug:  tracer != nil,
+		Tracer: tracer,
+	}
+	return a.doCall(ctx, msg, a.GetVMContext(), vmCfg, stateDB)
+}
 
+// DoCallWithTxContext is DoCallWithBlockContext with the tx context msg
+// runs in instead of the one of msg, the ORIGIN and GASPRICE it reads. The
+// sender and the fee of msg are unchanged.
+func (a *MferEVM) DoCallWithTxContext(ctx context.Context, msg *types.Message, blockCtx vm.BlockContext, txContext vm.TxContext, tracer vm.EVMLogger, stateDB *mferstate.OverlayStateDB) (*core.ExecutionResult, error) {
+	vmCfg := vm.Config{
+		Debug:     tracer != nil,
+		Tracer:    tracer,
+		NoBaseFee: true,
+	}
 	gasPool := new(core.GasPool).AddGas(math.MaxUint64)
+	return a.applyMessage(ctx, msg, blockCtx, txContext, vmCfg, stateDB, gasPool)
+}
+
+// CallRevertOrigin finds the frame that raised the revert of a call made
+// with DoCallWithBlockContext on stateDB, revisionID is a snapshot of stateDB
+// taken before the call. Calls run without the RevertTracer, only the ones
+// that revert are replayed with it.
+func (a *MferEVM) CallRevertOrigin(ctx context.Context, msg *types.Message, blockCtx vm.BlockContext, stateDB *mferstate.OverlayStateDB, revisionID int) *mfertracer.RevertOrigin {
+	return a.revertOrigin(ctx, msg, blockCtx, vm.Config{NoBaseFee: true}, stateDB, revisionID)
+}
+
+// revertOrigin replays msg with a RevertTracer on stateDB as it was at the
+// snapshot revisionID, the replay is dropped.
+func (a *MferEVM) revertOrigin(ctx context.Context, msg *types.Message, blockCtx vm.BlockContext, vmCfg vm.Config, stateDB *mferstate.OverlayStateDB, revisionID int) *mfertracer.RevertOrigin {
+	tracer := mfertracer.NewRevertTracer()
+	vmCfg.Debug, vmCfg.Tracer = true, tracer
+	gasPool := new(core.GasPool).AddGas(math.MaxUint64)
+	if _, err := a.ApplyMessage(ctx, msg, blockCtx, vmCfg, stateDB.CloneAt(revisionID), gasPool); err != nil {
+		return nil
+	}
+	return tracer.Origin()
+}
+
+func (a *MferEVM) doCall(ctx context.Context, msg *types.Message, blockCtx vm.BlockContext, vmCfg vm.Config, stateDB *mferstate.OverlayStateDB) (*core.ExecutionResult, error) {
+	// calls pay no fee, like eth_call of geth
+	vmCfg.NoBaseFee = true
+	gasPool := new(core.GasPool).AddGas(math.MaxUint64)
+	return a.ApplyMessage(ctx, msg, blockCtx, vmCfg, stateDB, gasPool)
+}
+
+// ApplyMessage applies msg on stateDB in blockCtx, drawing its gas from
+// gasPool. The execution is aborted when ctx is done.
+func (a *MferEVM) ApplyMessage(ctx context.Context, msg *types.Message, blockCtx vm.BlockContext, vmCfg vm.Config, stateDB *mferstate.OverlayStateDB, gasPool *core.GasPool) (*core.ExecutionResult, error) {
+	return a.applyMessage(ctx, msg, blockCtx, core.NewEVMTxContext(msg), vmCfg, stateDB, gasPool)
+}
+
+func (a *MferEVM) applyMessage(ctx context.Context, msg *types.Message, blockCtx vm.BlockContext, txContext vm.TxContext, vmCfg vm.Config, stateDB *mferstate.OverlayStateDB, gasPool *core.GasPool) (*core.ExecutionResult, error) {
+	defer stateDB.BindContext(ctx)()
+	stateDB.SetCodeHash(msg.From(), common.Hash{})
+	evm := vm.NewEVM(blockCtx, txContext, stateDB, a.chainConfig, vmCfg)
+
+	// cancel the evm when the request is cancelled or times out
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			evm.Cancel()
+		case <-done:
+		}
+	}()
+
 	result, err := core.ApplyMessage(evm, msg, gasPool)
+	// the loads abandoned by ctx read as empty, the result is not kept
+	if evm.Cancelled() || ctx.Err() != nil {
+		return nil, fmt.Errorf("execution aborted: %w", ctx.Err())
+	}
 	if err != nil {
-		return result, fmt.Errorf("err: %w (supplied gas %d)", err, msg.Gas())
+		return nil, fmt.Errorf("err: %w (supplied gas %d)", err, msg.Gas())
 	}
-
 	return result, nil
 }
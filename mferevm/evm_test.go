@@ -2,6 +2,7 @@ package mferevm
 
 import (
 	"context"
+	"math/big"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -11,7 +12,7 @@ import (
 
 func TestEVMExecute(t *testing.T) {
 	mferEVM := NewMferEVM("http://tractor.local:8545", common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), "./keycache.txt", 100, 50)
-	mferEVM.Prepare()
+	mferEVM.Connect()
 
 	tx, _, _ := mferEVM.Conn.TransactionByHash(context.Background(), common.HexToHash("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"))
 
@@ -19,11 +20,41 @@ func TestEVMExecute(t *testing.T) {
 	txs[0] = tx
 	txs[1] = tx
 
-	mferEVM.ExecuteTxs(txs, nil, nil)
+	mferEVM.ExecuteTxs(context.Background(), txs, nil, nil)
 }
 
 func TestGetBlockHeader(t *testing.T) {
 	mferEVM := NewMferEVM("https://arb1.arbitrum.io/rpc", common.HexToAddress("0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), "./cache.txt", 100, 50)
+	mferEVM.Connect()
 	header := mferEVM.GetBlockHeader("0x124bb29")
 	spew.Dump(header)
 }
+
+func TestApplyHeader(t *testing.T) {
+	mferEVM := NewMferEVM("http://127.0.0.1:8545", common.Address{}, "", 0, 1)
+	mferEVM.SetTimeDelta(10)
+	header := func(n int64) *types.Header {
+		return &types.Header{Number: big.NewInt(n), Time: uint64(n) * 12, Difficulty: big.NewInt(0), GasLimit: 30000000}
+	}
+	mferEVM.applyHeader(header(1))
+	blockCtx := mferEVM.GetVMContext()
+
+	// the context handed out keeps its values while the header is updated
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := int64(2); n < 100; n++ {
+			mferEVM.applyHeader(header(n))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		mferEVM.GetVMContext()
+	}
+	<-done
+	if blockCtx.BlockNumber.Int64() != 2 || blockCtx.Time.Int64() != 22 {
+		t.Errorf("context changed to block %d time %d", blockCtx.BlockNumber, blockCtx.Time)
+	}
+	if n := mferEVM.GetVMContext().BlockNumber.Int64(); n != 100 {
+		t.Errorf("pending block %d, want 100", n)
+	}
+}
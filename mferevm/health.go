@@ -0,0 +1,103 @@
+package mferevm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type status struct {
+	mu           sync.RWMutex
+	prepared     bool
+	lastRefresh  time.Time
+	lastErr      error
+	upstreamHead uint64
+}
+
+func (s *status) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+}
+
+func (s *status) setPrepared() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepared = true
+	s.lastErr = nil
+}
+
+func (s *status) setRefreshed(head uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRefresh = time.Now()
+	s.upstreamHead = head
+}
+
+func (s *status) head() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.upstreamHead
+}
+
+// Health is a point-in-time report of the upstream connection and the state
+// the overlay is forked from.
+type Health struct {
+	Upstream          string       `json:"upstream"`
+	UpstreamReachable bool         `json:"upstreamReachable"`
+	UpstreamError     string       `json:"upstreamError,omitempty"`
+	ChainID           *hexutil.Big `json:"chainId"`
+	Prepared          bool         `json:"prepared"`
+	PinBlock          bool         `json:"pinBlock"`
+	StateBlock        uint64       `json:"stateBlock"`
+	UpstreamHead      uint64       `json:"upstreamHead"`
+	Lag               int64        `json:"lag"`
+	LastRefresh       *time.Time   `json:"lastRefresh"`
+	LastError         string       `json:"lastError,omitempty"`
+}
+
+// Health probes the upstream with eth_blockNumber and reports it together
+// with the state status.
+func (a *MferEVM) Health(ctx context.Context) *Health {
+	a.status.mu.RLock()
+	h := &Health{
+		Upstream:     a.upstreamURL,
+		Prepared:     a.status.prepared,
+		PinBlock:     a.pinBlock,
+		UpstreamHead: a.status.upstreamHead,
+	}
+	if !a.status.lastRefresh.IsZero() {
+		lastRefresh := a.status.lastRefresh
+		h.LastRefresh = &lastRefresh
+	}
+	if a.status.lastErr != nil {
+		h.LastError = a.status.lastErr.Error()
+	}
+	client := a.RpcClient
+	a.status.mu.RUnlock()
+
+	if client != nil {
+		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		var bn hexutil.Uint64
+		if err := client.CallContext(ctx, &bn, "eth_blockNumber"); err != nil {
+			h.UpstreamError = err.Error()
+		} else {
+			h.UpstreamReachable = true
+			if !a.pinBlock {
+				h.UpstreamHead = uint64(bn)
+			}
+		}
+	} else {
+		h.UpstreamError = "not dialed"
+	}
+
+	if h.Prepared {
+		h.ChainID = (*hexutil.Big)(a.ChainID())
+		h.StateBlock = a.StateDB.StateBlockNumber()
+		h.Lag = int64(h.UpstreamHead) - int64(h.StateBlock)
+	}
+	return h
+}
@@ -0,0 +1,27 @@
+package mferevm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestHealthWhileConnecting(t *testing.T) {
+	// nothing listens there, Connect dials again until the test ends
+	mferEVM := NewMferEVM("http://127.0.0.1:1", common.Address{}, t.TempDir()+"/keys.txt", 0, 1)
+	go mferEVM.Connect()
+
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		h := mferEVM.Health(context.Background())
+		if h.UpstreamReachable || h.Prepared {
+			t.Fatalf("health of an unreachable upstream: %+v", h)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if h := mferEVM.Health(context.Background()); h.UpstreamError == "" || h.LastError == "" {
+		t.Errorf("health without the errors: %+v", h)
+	}
+}
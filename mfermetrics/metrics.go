@@ -0,0 +1,431 @@
+package mfermetrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/kataras/golog"
+)
+
+// Fork holds the metrics of one fork in a registry of its own, they are
+// exposed with a fork label so the forks served by one process keep apart
+// series. A nil Fork records nothing, that is what NewFork returns until
+// Enable is called so the hot paths (scratchpad lookups, batch loads) cost
+// nothing when metrics are off.
+type Fork struct {
+	name     string
+	registry metrics.Registry
+
+	upstreamBatchSize metrics.Histogram
+
+	scratchPadHit  metrics.Counter
+	scratchPadMiss metrics.Counter
+	scratchPadSize metrics.Gauge
+
+	overlayDepth metrics.Gauge
+	poolSize     metrics.Gauge
+
+	reforkCount    metrics.Counter
+	stateBlockLag  metrics.Gauge
+	stateBlock     metrics.Gauge
+	upstreamHeight metrics.Gauge
+
+	shadowCalls      metrics.Counter
+	shadowMismatches metrics.Counter
+
+	upstreamMutex sync.Mutex
+	upstream      map[string]*upstreamMethod
+}
+
+// upstreamMethod holds the upstream request metrics of one method, they are
+// exposed as one family per metric with a method label.
+type upstreamMethod struct {
+	requests metrics.Counter
+	errors   metrics.Counter
+	latency  *histogram
+}
+
+// latencyBuckets are the upper bounds of the upstream latency histogram, in
+// seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram counts the observations in the latencyBuckets, it is exposed as a
+// prometheus histogram.
+type histogram struct {
+	mutex  sync.Mutex
+	counts []uint64 // by bucket, the last one is +Inf
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	h.mutex.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.mutex.Unlock()
+}
+
+// snapshot returns the cumulative bucket counts, the last one is the count of
+// all observations, and their sum.
+func (h *histogram) snapshot() ([]uint64, float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	cumulative := make([]uint64, len(h.counts))
+	var total uint64
+	for i, n := range h.counts {
+		total += n
+		cumulative[i] = total
+	}
+	return cumulative, h.sum
+}
+
+var (
+	forksMutex sync.RWMutex
+	forks      []*Fork
+)
+
+// Enable turns on metrics collection. It must be called before the forks
+// are created, the geth rpc server picks up per-method latency
+// (rpc/duration/<method>/...) from the same switch.
+func Enable() {
+	metrics.Enabled = true
+}
+
+// NewFork creates the metrics of the fork name, the unnamed fork is exposed
+// without a fork label. It is nil while metrics are disabled.
+func NewFork(name string) *Fork {
+	if !metrics.Enabled {
+		return nil
+	}
+	r := metrics.NewRegistry()
+	f := &Fork{
+		name:     name,
+		registry: r,
+
+		upstreamBatchSize: metrics.NewRegisteredHistogram("mfer/upstream/batchsize", r, metrics.NewExpDecaySample(1028, 0.015)),
+
+		scratchPadHit:  metrics.NewRegisteredCounter("mfer/scratchpad/hit", r),
+		scratchPadMiss: metrics.NewRegisteredCounter("mfer/scratchpad/miss", r),
+		scratchPadSize: metrics.NewRegisteredGauge("mfer/scratchpad/size", r),
+
+		overlayDepth: metrics.NewRegisteredGauge("mfer/overlay/depth", r),
+		poolSize:     metrics.NewRegisteredGauge("mfer/txpool/size", r),
+
+		reforkCount:    metrics.NewRegisteredCounter("mfer/state/refork", r),
+		stateBlockLag:  metrics.NewRegisteredGauge("mfer/state/lag", r),
+		stateBlock:     metrics.NewRegisteredGauge("mfer/state/block", r),
+		upstreamHeight: metrics.NewRegisteredGauge("mfer/upstream/height", r),
+
+		shadowCalls:      metrics.NewRegisteredCounter("mfer/shadow/calls", r),
+		shadowMismatches: metrics.NewRegisteredCounter("mfer/shadow/mismatch", r),
+
+		upstream: make(map[string]*upstreamMethod),
+	}
+	forksMutex.Lock()
+	forks = append(forks, f)
+	forksMutex.Unlock()
+	return f
+}
+
+// upstreamMethod returns the metrics of method, created on first use.
+func (f *Fork) upstreamMethod(method string) *upstreamMethod {
+	f.upstreamMutex.Lock()
+	defer f.upstreamMutex.Unlock()
+	m, ok := f.upstream[method]
+	if !ok {
+		m = &upstreamMethod{
+			requests: metrics.NewCounter(),
+			errors:   metrics.NewCounter(),
+			latency:  newHistogram(),
+		}
+		f.upstream[method] = m
+	}
+	return m
+}
+
+// upstreamMethods is a copy of the metrics by method.
+func (f *Fork) upstreamMethods() map[string]*upstreamMethod {
+	f.upstreamMutex.Lock()
+	defer f.upstreamMutex.Unlock()
+	methods := make(map[string]*upstreamMethod, len(f.upstream))
+	for method, m := range f.upstream {
+		methods[method] = m
+	}
+	return methods
+}
+
+// UpstreamCall records a single upstream request.
+func (f *Fork) UpstreamCall(method string, start time.Time, err error) {
+	if f == nil {
+		return
+	}
+	m := f.upstreamMethod(method)
+	m.latency.observe(time.Since(start))
+	m.requests.Inc(1)
+	if err != nil {
+		m.errors.Inc(1)
+	}
+}
+
+// UpstreamBatch records a batch upstream request. The latency of the whole
+// batch is accounted to every method it carries.
+func (f *Fork) UpstreamBatch(elems []rpc.BatchElem, start time.Time, err error) {
+	if f == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	f.upstreamBatchSize.Update(int64(len(elems)))
+
+	seen := make(map[string]bool)
+	for _, elem := range elems {
+		m := f.upstreamMethod(elem.Method)
+		m.requests.Inc(1)
+		if err != nil || elem.Error != nil {
+			m.errors.Inc(1)
+		}
+		if !seen[elem.Method] {
+			m.latency.observe(elapsed)
+			seen[elem.Method] = true
+		}
+	}
+}
+
+func (f *Fork) ScratchPadHit() {
+	if f != nil {
+		f.scratchPadHit.Inc(1)
+	}
+}
+
+func (f *Fork) ScratchPadMiss() {
+	if f != nil {
+		f.scratchPadMiss.Inc(1)
+	}
+}
+
+func (f *Fork) SetScratchPadSize(size int) {
+	if f != nil {
+		f.scratchPadSize.Update(int64(size))
+	}
+}
+
+func (f *Fork) SetOverlayDepth(d int64) {
+	if f != nil {
+		f.overlayDepth.Update(d)
+	}
+}
+
+func (f *Fork) SetPoolSize(n int) {
+	if f != nil {
+		f.poolSize.Update(int64(n))
+	}
+}
+
+func (f *Fork) Refork() {
+	if f != nil {
+		f.reforkCount.Inc(1)
+	}
+}
+
+// ShadowCall records a call compared against the upstream.
+func (f *Fork) ShadowCall(match bool) {
+	if f == nil {
+		return
+	}
+	f.shadowCalls.Inc(1)
+	if !match {
+		f.shadowMismatches.Inc(1)
+	}
+}
+
+// SetStateBlock records the block the overlay is forked from against the
+// upstream head.
+func (f *Fork) SetStateBlock(stateBN, headBN uint64) {
+	if f == nil {
+		return
+	}
+	f.stateBlock.Update(int64(stateBN))
+	f.upstreamHeight.Update(int64(headBN))
+	f.stateBlockLag.Update(int64(headBN) - int64(stateBN))
+}
+
+// Handler serves the collected metrics in the prometheus exposition format,
+// the process wide ones (rpc latency) without a label and the ones of each
+// fork with its fork label.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registries := []labeledRegistry{{registry: metrics.DefaultRegistry}}
+		forksMutex.RLock()
+		for _, f := range forks {
+			registries = append(registries, labeledRegistry{fork: f.name, registry: f.registry, upstream: f.upstreamMethods()})
+		}
+		forksMutex.RUnlock()
+
+		var buf bytes.Buffer
+		writeMetrics(&buf, registries)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Header().Set("Content-Length", fmt.Sprint(buf.Len()))
+		w.Write(buf.Bytes())
+	})
+}
+
+type labeledRegistry struct {
+	fork     string
+	registry metrics.Registry
+	upstream map[string]*upstreamMethod
+}
+
+type sample struct {
+	fork   string
+	method string
+	metric interface{}
+}
+
+var quantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999, 0.9999}
+
+// writeMetrics writes the metrics of registries grouped by name, a name
+// registered by several forks is one family with a sample per fork. The
+// upstream metrics are one family each with a sample per fork and method.
+func writeMetrics(w io.Writer, registries []labeledRegistry) {
+	families := make(map[string][]sample)
+	for _, r := range registries {
+		r.registry.Each(func(name string, metric interface{}) {
+			name = strings.ReplaceAll(name, "/", "_")
+			families[name] = append(families[name], sample{fork: r.fork, metric: metric})
+		})
+		for method, m := range r.upstream {
+			families["mfer_upstream_requests"] = append(families["mfer_upstream_requests"], sample{r.fork, method, m.requests})
+			families["mfer_upstream_errors"] = append(families["mfer_upstream_errors"], sample{r.fork, method, m.errors})
+			families["mfer_upstream_latency_seconds"] = append(families["mfer_upstream_latency_seconds"], sample{r.fork, method, m.latency})
+		}
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		samples := families[name]
+		sort.SliceStable(samples, func(i, j int) bool {
+			if samples[i].fork != samples[j].fork {
+				return samples[i].fork < samples[j].fork
+			}
+			return samples[i].method < samples[j].method
+		})
+		typ := ""
+		for _, s := range samples {
+			var (
+				count, sum  int64
+				percentiles []float64
+			)
+			switch m := s.metric.(type) {
+			case metrics.Counter:
+				typ = writeType(w, name, "counter", typ)
+				fmt.Fprintf(w, "%s%s %d\n", name, labels("fork", s.fork, "method", s.method), m.Count())
+				continue
+			case metrics.Gauge:
+				typ = writeType(w, name, "gauge", typ)
+				fmt.Fprintf(w, "%s%s %d\n", name, labels("fork", s.fork, "method", s.method), m.Value())
+				continue
+			case metrics.GaugeFloat64:
+				typ = writeType(w, name, "gauge", typ)
+				fmt.Fprintf(w, "%s%s %v\n", name, labels("fork", s.fork, "method", s.method), m.Value())
+				continue
+			case metrics.Meter:
+				typ = writeType(w, name, "counter", typ)
+				fmt.Fprintf(w, "%s%s %d\n", name, labels("fork", s.fork, "method", s.method), m.Count())
+				continue
+			case *histogram:
+				typ = writeType(w, name, "histogram", typ)
+				cumulative, sum := m.snapshot()
+				for i, bound := range latencyBuckets {
+					fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels("fork", s.fork, "method", s.method, "le", strconv.FormatFloat(bound, 'f', -1, 64)), cumulative[i])
+				}
+				count := cumulative[len(cumulative)-1]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels("fork", s.fork, "method", s.method, "le", "+Inf"), count)
+				fmt.Fprintf(w, "%s_sum%s %v\n", name, labels("fork", s.fork, "method", s.method), sum)
+				fmt.Fprintf(w, "%s_count%s %d\n", name, labels("fork", s.fork, "method", s.method), count)
+				continue
+			case metrics.Histogram:
+				snapshot := m.Snapshot()
+				count, sum, percentiles = snapshot.Count(), snapshot.Sum(), snapshot.Percentiles(quantiles)
+			case metrics.Timer:
+				snapshot := m.Snapshot()
+				count, sum, percentiles = snapshot.Count(), snapshot.Sum(), snapshot.Percentiles(quantiles)
+			default:
+				continue
+			}
+			typ = writeType(w, name, "summary", typ)
+			for i, q := range quantiles {
+				fmt.Fprintf(w, "%s%s %v\n", name, labels("fork", s.fork, "method", s.method, "quantile", strconv.FormatFloat(q, 'f', -1, 64)), percentiles[i])
+			}
+			fmt.Fprintf(w, "%s_sum%s %d\n", name, labels("fork", s.fork, "method", s.method), sum)
+			fmt.Fprintf(w, "%s_count%s %d\n", name, labels("fork", s.fork, "method", s.method), count)
+		}
+		if typ != "" {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// writeType writes the type line of a family once, before its first sample.
+func writeType(w io.Writer, name, typ, written string) string {
+	if written == "" {
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	}
+	return typ
+}
+
+// labels renders the label pairs (name, value, ...), the empty values are
+// left out.
+func labels(pairs ...string) string {
+	var out []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			out = append(out, pairs[i]+"="+strconv.Quote(pairs[i+1]))
+		}
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(out, ",") + "}"
+}
+
+// Server is the operational http endpoint (metrics, health checks), it is
+// served apart from the rpc so it is reachable before the first state is
+// loaded.
+type Server struct {
+	addr string
+	mux  *http.ServeMux
+}
+
+func NewServer(addr string) *Server {
+	return &Server{addr: addr, mux: http.NewServeMux()}
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Start() {
+	golog.Infof("Starting ops server on http://%s", s.addr)
+	go func() {
+		if err := http.ListenAndServe(s.addr, s.mux); err != nil {
+			golog.Errorf("ops server err: %v", err)
+		}
+	}()
+}
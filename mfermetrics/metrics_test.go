@@ -0,0 +1,97 @@
+package mfermetrics
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestForkLabels(t *testing.T) {
+	Enable()
+	r := metrics.NewRegistry()
+	metrics.NewRegisteredGauge("rpc/requests", r).Update(3)
+	a, b := metrics.NewRegistry(), metrics.NewRegistry()
+	metrics.NewRegisteredGauge("mfer/state/block", a).Update(10)
+	metrics.NewRegisteredGauge("mfer/state/block", b).Update(20)
+	metrics.NewRegisteredCounter("mfer/state/refork", b).Inc(1)
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, []labeledRegistry{{registry: r}, {fork: "b", registry: b}, {fork: "a", registry: a}})
+	want := `# TYPE mfer_state_block gauge
+mfer_state_block{fork="a"} 10
+mfer_state_block{fork="b"} 20
+
+# TYPE mfer_state_refork counter
+mfer_state_refork{fork="b"} 1
+
+# TYPE rpc_requests gauge
+rpc_requests 3
+
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	timer := metrics.NewRegisteredTimer("mfer/upstream/batchsize", a)
+	timer.Update(5)
+	timer.Update(7)
+	writeMetrics(&buf, []labeledRegistry{{fork: "a", registry: a}})
+	for _, line := range []string{
+		"# TYPE mfer_upstream_batchsize summary",
+		`mfer_upstream_batchsize{fork="a",quantile="0.5"} 6`,
+		`mfer_upstream_batchsize_sum{fork="a"} 12`,
+		`mfer_upstream_batchsize_count{fork="a"} 2`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestUpstreamMethodLabels(t *testing.T) {
+	Enable()
+	f := NewFork("a")
+	f.UpstreamCall("eth_call", time.Now().Add(-20*time.Millisecond), nil)
+	f.UpstreamCall("eth_call", time.Now().Add(-2*time.Second), errors.New("timeout"))
+	f.UpstreamBatch([]rpc.BatchElem{{Method: "eth_getBalance"}, {Method: "eth_getBalance"}}, time.Now(), nil)
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, []labeledRegistry{{fork: "a", registry: metrics.NewRegistry(), upstream: f.upstreamMethods()}})
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE mfer_upstream_latency_seconds histogram",
+		`mfer_upstream_latency_seconds_bucket{fork="a",method="eth_call",le="0.01"} 0`,
+		`mfer_upstream_latency_seconds_bucket{fork="a",method="eth_call",le="0.025"} 1`,
+		`mfer_upstream_latency_seconds_bucket{fork="a",method="eth_call",le="2.5"} 2`,
+		`mfer_upstream_latency_seconds_bucket{fork="a",method="eth_call",le="+Inf"} 2`,
+		`mfer_upstream_latency_seconds_count{fork="a",method="eth_call"} 2`,
+		`mfer_upstream_latency_seconds_count{fork="a",method="eth_getBalance"} 1`,
+		"# TYPE mfer_upstream_requests counter",
+		`mfer_upstream_requests{fork="a",method="eth_call"} 2`,
+		`mfer_upstream_requests{fork="a",method="eth_getBalance"} 2`,
+		`mfer_upstream_errors{fork="a",method="eth_call"} 1`,
+		`mfer_upstream_errors{fork="a",method="eth_getBalance"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+	// one family per metric, not per method
+	if strings.Count(out, "# TYPE") != 3 || strings.Contains(out, "eth_call_latency") {
+		t.Errorf("unexpected families:\n%s", out)
+	}
+}
+
+func TestNilFork(t *testing.T) {
+	// a disabled fork records nothing and does not panic
+	var f *Fork
+	f.ScratchPadHit()
+	f.SetStateBlock(1, 2)
+	f.UpstreamCall("eth_call", time.Now(), nil)
+}
@@ -0,0 +1,118 @@
+package mferstate
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// accessList is the EIP-2929 access list of the executing tx. Entries added
+// after a snapshot are dropped when the snapshot is reverted.
+type accessList struct {
+	addresses map[common.Address]map[common.Hash]struct{}
+	journal   []accessListChange
+}
+
+type accessListChange struct {
+	revision int64
+	address  common.Address
+	slot     *common.Hash // nil if the address itself was added
+}
+
+func newAccessList() *accessList {
+	return &accessList{
+		addresses: make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+func (al *accessList) containsAddress(address common.Address) bool {
+	_, ok := al.addresses[address]
+	return ok
+}
+
+func (al *accessList) contains(address common.Address, slot common.Hash) (addressOk bool, slotOk bool) {
+	slots, ok := al.addresses[address]
+	if !ok {
+		return false, false
+	}
+	_, slotOk = slots[slot]
+	return true, slotOk
+}
+
+func (al *accessList) addAddress(revision int64, address common.Address) {
+	if al.containsAddress(address) {
+		return
+	}
+	al.addresses[address] = make(map[common.Hash]struct{})
+	al.journal = append(al.journal, accessListChange{revision: revision, address: address})
+}
+
+func (al *accessList) addSlot(revision int64, address common.Address, slot common.Hash) {
+	al.addAddress(revision, address)
+	if _, ok := al.addresses[address][slot]; ok {
+		return
+	}
+	al.addresses[address][slot] = struct{}{}
+	al.journal = append(al.journal, accessListChange{revision: revision, address: address, slot: &slot})
+}
+
+// revert drops the entries added at or after revision.
+func (al *accessList) revert(revision int64) {
+	i := len(al.journal) - 1
+	for ; i >= 0 && al.journal[i].revision >= revision; i-- {
+		change := al.journal[i]
+		if change.slot != nil {
+			delete(al.addresses[change.address], *change.slot)
+		} else {
+			delete(al.addresses, change.address)
+		}
+	}
+	al.journal = al.journal[:i+1]
+}
+
+// PrepareAccessList starts the access list of a tx with the sender, the
+// recipient, the precompiles and the entries of the tx access list.
+func (db *OverlayStateDB) PrepareAccessList(sender common.Address, dest *common.Address, precompiles []common.Address, txAccesses types.AccessList) {
+	db.accessList = newAccessList()
+	revision := db.state.deriveCnt
+	db.accessList.addAddress(revision, sender)
+	if dest != nil {
+		db.accessList.addAddress(revision, *dest)
+	}
+	for _, addr := range precompiles {
+		db.accessList.addAddress(revision, addr)
+	}
+	for _, el := range txAccesses {
+		db.accessList.addAddress(revision, el.Address)
+		for _, key := range el.StorageKeys {
+			db.accessList.addSlot(revision, el.Address, key)
+		}
+	}
+}
+
+// AddressInAccessList reports every address as warm outside of a prepared tx,
+// as for blocks before Berlin.
+func (db *OverlayStateDB) AddressInAccessList(addr common.Address) bool {
+	if db.accessList == nil {
+		return true
+	}
+	return db.accessList.containsAddress(addr)
+}
+
+func (db *OverlayStateDB) SlotInAccessList(addr common.Address, slot common.Hash) (addressOk bool, slotOk bool) {
+	if db.accessList == nil {
+		return true, true
+	}
+	return db.accessList.contains(addr, slot)
+}
+
+func (db *OverlayStateDB) AddAddressToAccessList(addr common.Address) {
+	if db.accessList != nil {
+		db.accessList.addAddress(db.state.deriveCnt, addr)
+	}
+}
+
+func (db *OverlayStateDB) AddSlotToAccessList(addr common.Address, slot common.Hash) {
+	if db.accessList != nil {
+		db.accessList.addSlot(db.state.deriveCnt, addr, slot)
+	}
+}
@@ -0,0 +1,39 @@
+package mferstate
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestAccessListRevert(t *testing.T) {
+	var (
+		a    = common.HexToAddress("0xaa")
+		b    = common.HexToAddress("0xbb")
+		slot = common.HexToHash("0x01")
+	)
+	al := newAccessList()
+	al.addAddress(1, a)
+	al.addSlot(2, a, slot)
+	al.addSlot(3, b, slot)
+
+	al.revert(3)
+	if al.containsAddress(b) {
+		t.Errorf("address added after the snapshot is still warm")
+	}
+	if addressOk, slotOk := al.contains(a, slot); !addressOk || !slotOk {
+		t.Errorf("slot added before the snapshot is cold")
+	}
+
+	al.revert(2)
+	if addressOk, slotOk := al.contains(a, slot); !addressOk || slotOk {
+		t.Errorf("contains(a, slot) = %v, %v, want true, false", addressOk, slotOk)
+	}
+
+	// re-adding after a revert is journaled again
+	al.addSlot(2, a, slot)
+	al.revert(2)
+	if _, slotOk := al.contains(a, slot); slotOk {
+		t.Errorf("re-added slot survived the revert")
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/kataras/golog"
+	"github.com/sec-bit/mfer-node/mfermetrics"
 	"github.com/tj/go-spin"
 )
 
@@ -63,16 +64,21 @@ type OverlayState struct {
 	currentTxHash, currentBlockHash common.Hash
 	deriveCnt                       int64
 	rpcCnt                          int64
-	storageReqChan                  chan chan StorageReq
-	accReqChan                      chan chan FetchedAccountResult
+	storageReqChan                  chan *storageFetch
+	accReqChan                      chan *accountFetch
 
 	loadAccountMutex *sync.Mutex
 
 	upstreamReqCh chan bool
 	clientReqCh   chan bool
 
+	done      chan struct{} // closed when the batch loads stop
+	closeOnce *sync.Once
+
 	reason  string
 	stateID uint64
+
+	metrics *mfermetrics.Fork // set on the root state
 }
 
 func NewOverlayState(ctx context.Context, ec *rpc.Client, bn *uint64, batchSize int) *OverlayState {
@@ -92,17 +98,39 @@ func NewOverlayState(ctx context.Context, ec *rpc.Client, bn *uint64, batchSize
 		txLogs:           make(map[common.Hash][]*types.Log),
 		receipts:         make(map[common.Hash]*types.Receipt),
 		deriveCnt:        0,
-		storageReqChan:   make(chan chan StorageReq, 500),
-		accReqChan:       make(chan chan FetchedAccountResult, 200),
+		storageReqChan:   make(chan *storageFetch, 500),
+		accReqChan:       make(chan *accountFetch, 200),
 		loadAccountMutex: &sync.Mutex{},
 
 		upstreamReqCh: make(chan bool, 100),
 		clientReqCh:   make(chan bool, 100),
+
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
 	}
 	go state.timeSlot()
 	return state
 }
 
+// Close stops the batch loads of the root state s, it is also stopped when
+// the context of s is done. Pending and later loads read as not loaded.
+func (s *OverlayState) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// storageFetch is a slot queued for the next batch load. result is buffered,
+// the batch never waits for a requester that gave up.
+type storageFetch struct {
+	req    StorageReq
+	result chan StorageReq
+}
+
+// accountFetch is an account queued for the next batch load.
+type accountFetch struct {
+	account common.Address
+	result  chan FetchedAccountResult
+}
+
 func (s *OverlayState) Derive(reason string) *OverlayState {
 	state := &OverlayState{
 		parent:           s,
@@ -143,7 +171,12 @@ var (
 	CODE_KEY     = crypto.Keccak256Hash([]byte("mfersafe-scratchpad-code"))
 	CODEHASH_KEY = crypto.Keccak256Hash([]byte("mfersafe-scratchpad-codehash"))
 	STATE_KEY    = crypto.Keccak256Hash([]byte("mfersafe-scratchpad-state"))
-	SUICIDE_KEY  = crypto.Keccak256Hash([]byte("mfersafe-suicide-state"))
+	// SUICIDE_KEY marks a self-destructed account, its storage reads as
+	// cleared from that layer.
+	SUICIDE_KEY = crypto.Keccak256Hash([]byte("mfersafe-suicide-state"))
+	// STORAGE_CLEARED_KEY marks the layer below which the storage of an
+	// account is not read, slots not set above it are zero.
+	STORAGE_CLEARED_KEY = crypto.Keccak256Hash([]byte("mfersafe-storage-cleared"))
 )
 
 type FetchedAccountResult struct {
@@ -199,7 +232,9 @@ func (s *OverlayState) loadAccountBatchRPC(accounts []common.Address) ([]Fetched
 				end = len(batchElem)
 			}
 			golog.Debugf("loadAccount batch req(total=%d): begin: %d, end: %d", len(batchElem), begin, end)
+			batchStart := time.Now()
 			err := s.ec.BatchCallContext(s.ctx, batchElem[begin:end])
+			s.metrics.UpstreamBatch(batchElem[begin:end], batchStart, err)
 			if err != nil {
 				rpcTries++
 				if rpcTries > 5 {
@@ -246,7 +281,9 @@ func (s *OverlayState) loadAccountViaGetProof(account common.Address) (*AccountR
 
 	for {
 		start := time.Now()
-		err := s.ec.BatchCallContext(s.ctx, []rpc.BatchElem{getProofReq, getCodeReq})
+		batch := []rpc.BatchElem{getProofReq, getCodeReq}
+		err := s.ec.BatchCallContext(s.ctx, batch)
+		s.metrics.UpstreamBatch(batch, start, err)
 		if err != nil {
 			rpcTries++
 			if rpcTries > 5 {
@@ -297,7 +334,10 @@ func (s *OverlayState) loadStateBatchRPC(storageReqs []*StorageReq) error {
 			end = len(reqs)
 		}
 		golog.Debugf("loadState batch req(total=%d): begin: %d, end: %d", len(reqs), begin, end)
-		if err := s.ec.BatchCallContext(s.ctx, reqs[begin:end]); err != nil {
+		batchStart := time.Now()
+		err := s.ec.BatchCallContext(s.ctx, reqs[begin:end])
+		s.metrics.UpstreamBatch(reqs[begin:end], batchStart, err)
+		if err != nil {
 			return err
 		}
 	}
@@ -313,7 +353,9 @@ func (s *OverlayState) loadStateBatchRPC(storageReqs []*StorageReq) error {
 func (s *OverlayState) loadStateRPC(account common.Address, key common.Hash) (common.Hash, error) {
 	s.rpcCnt++
 	// s.upstreamReqCh <- true
+	start := time.Now()
 	storage, err := s.conn.StorageAt(s.ctx, account, key, big.NewInt(int64(*s.bn)))
+	s.metrics.UpstreamCall("eth_getStorageAt", start, err)
 	if err != nil {
 		return common.Hash{}, err
 	}
@@ -324,25 +366,33 @@ func (s *OverlayState) loadStateRPC(account common.Address, key common.Hash) (co
 func (s *OverlayState) timeSlot() {
 	tickerStorage := time.NewTicker(time.Millisecond * 3)
 	tickerAccount := time.NewTicker(time.Millisecond * 10)
+	defer tickerStorage.Stop()
+	defer tickerAccount.Stop()
 	for {
 		storageReqLen := len(s.storageReqChan)
 		accReqLen := len(s.accReqChan)
 		select {
+		case <-s.done:
+			return
+		case <-s.ctx.Done():
+			s.Close()
+			return
 		case <-tickerStorage.C:
 			storageReqPending := make([]*StorageReq, storageReqLen)
 			storageReqChanPending := make([]chan StorageReq, storageReqLen)
 			for i := 0; i < storageReqLen; i++ {
-				req := <-s.storageReqChan
-				storageReq := <-req
-				storageReqPending[i] = &storageReq
-				storageReqChanPending[i] = req
+				fetch := <-s.storageReqChan
+				storageReqPending[i] = &fetch.req
+				storageReqChanPending[i] = fetch.result
 			}
 			if storageReqLen > 0 {
 				for {
 					err := s.loadStateBatchRPC(storageReqPending)
 					if err != nil {
 						golog.Errorf("loadStateBatch, err: %v", err)
-						time.Sleep(time.Second * 1)
+						if !s.retryAfter(time.Second * 1) {
+							return
+						}
 					} else {
 						break
 					}
@@ -350,20 +400,15 @@ func (s *OverlayState) timeSlot() {
 			}
 
 			for i := 0; i < storageReqLen; i++ {
-				req := storageReqChanPending[i]
-				req <- *storageReqPending[i]
-				close(req)
+				storageReqChanPending[i] <- *storageReqPending[i]
 			}
 		case <-tickerAccount.C:
-			accReqPending := make([]*FetchedAccountResult, accReqLen)
 			accReqChanPending := make([]chan FetchedAccountResult, accReqLen)
 			accounts := make([]common.Address, accReqLen)
 			for i := 0; i < accReqLen; i++ {
-				req := <-s.accReqChan
-				accReq := <-req
-				accReqPending[i] = &accReq
-				accReqChanPending[i] = req
-				accounts[i] = accReq.Account
+				fetch := <-s.accReqChan
+				accReqChanPending[i] = fetch.result
+				accounts[i] = fetch.account
 			}
 
 			var accResult []FetchedAccountResult
@@ -373,7 +418,9 @@ func (s *OverlayState) timeSlot() {
 					accResult, err = s.loadAccountBatchRPC(accounts)
 					if err != nil {
 						golog.Errorf("loadAccountBatchRPC, err: %v", err)
-						time.Sleep(time.Second * 1)
+						if !s.retryAfter(time.Second * 1) {
+							return
+						}
 					} else {
 						break
 					}
@@ -381,14 +428,28 @@ func (s *OverlayState) timeSlot() {
 			}
 
 			for i := 0; i < len(accResult); i++ {
-				req := accReqChanPending[i]
-				req <- accResult[i]
-				close(req)
+				accReqChanPending[i] <- accResult[i]
 			}
 		}
 	}
 }
 
+// retryAfter waits d before a failed batch is loaded again, it is false when
+// the state is closed meanwhile.
+func (s *OverlayState) retryAfter(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.done:
+		return false
+	case <-s.ctx.Done():
+		s.Close()
+		return false
+	}
+}
+
 func (db *OverlayState) statistics() {
 	spinnerUpstream := &spin.Spinner{}
 	spinnerUpstream.Set("🌍🌎🌏")
@@ -422,22 +483,46 @@ func (db *OverlayState) statistics() {
 	}
 }
 
-func (s *OverlayState) loadState(account common.Address, key common.Hash) common.Hash {
-	retChan := make(chan StorageReq)
-	s.storageReqChan <- retChan
-	retChan <- StorageReq{Address: account, Key: key}
-	result := <-retChan
-	// spew.Dump(result)
-	return result.Value
+// loadState loads a slot with the next batch, ok is false when ctx is done or
+// s is closed before it is loaded.
+func (s *OverlayState) loadState(ctx context.Context, account common.Address, key common.Hash) (value common.Hash, ok bool) {
+	fetch := &storageFetch{req: StorageReq{Address: account, Key: key}, result: make(chan StorageReq, 1)}
+	select {
+	case s.storageReqChan <- fetch:
+	case <-ctx.Done():
+		return common.Hash{}, false
+	case <-s.done:
+		return common.Hash{}, false
+	}
+	select {
+	case result := <-fetch.result:
+		return result.Value, true
+	case <-ctx.Done():
+		return common.Hash{}, false
+	case <-s.done:
+		return common.Hash{}, false
+	}
 }
 
-func (s *OverlayState) loadAccount(account common.Address) FetchedAccountResult {
-	retChan := make(chan FetchedAccountResult)
-	s.accReqChan <- retChan
-	retChan <- FetchedAccountResult{Account: account}
-	result := <-retChan
-	// spew.Dump(result)
-	return result
+// loadAccount loads an account with the next batch, ok is false when ctx is
+// done or s is closed before it is loaded.
+func (s *OverlayState) loadAccount(ctx context.Context, account common.Address) (result FetchedAccountResult, ok bool) {
+	fetch := &accountFetch{account: account, result: make(chan FetchedAccountResult, 1)}
+	select {
+	case s.accReqChan <- fetch:
+	case <-ctx.Done():
+		return result, false
+	case <-s.done:
+		return result, false
+	}
+	select {
+	case result = <-fetch.result:
+		return result, true
+	case <-ctx.Done():
+		return result, false
+	case <-s.done:
+		return result, false
+	}
 }
 
 func calcKey(op common.Hash, account common.Address) string {
@@ -450,7 +535,10 @@ func calcStateKey(account common.Address, key common.Hash) string {
 	return stateKey
 }
 
-func (s *OverlayState) get(account common.Address, action RequestType, key common.Hash) ([]byte, error) {
+// get reads a value through the overlays, a value missing from the root cache
+// is loaded from the upstream. When ctx is done before it is loaded, the value
+// is empty and not cached.
+func (s *OverlayState) get(ctx context.Context, account common.Address, action RequestType, key common.Hash) ([]byte, error) {
 	// if s.parent == nil && *s.bn != *s.lastBN {
 	// 	golog.Infof("State BN: %d", *s.bn)
 	// 	s.lastBN = *s.bn
@@ -473,21 +561,29 @@ func (s *OverlayState) get(account common.Address, action RequestType, key commo
 		s.scratchPadMutex.Lock()
 		if val, ok := s.scratchPad[scratchpadKey]; ok {
 			s.scratchPadMutex.Unlock()
+			s.metrics.ScratchPadHit()
 			return val, nil
 		}
 		s.scratchPadMutex.Unlock()
+		s.metrics.ScratchPadMiss()
 
 		var res []byte
 		switch action {
 		case GET_STATE:
-			result := s.loadState(account, key)
+			result, ok := s.loadState(ctx, account, key)
+			if !ok {
+				return nil, nil
+			}
 			s.scratchPadMutex.Lock()
 			s.scratchPad[scratchpadKey] = result.Bytes()
 			s.scratchPadMutex.Unlock()
 			res = result.Bytes()
 
 		case GET_BALANCE, GET_NONCE, GET_CODE, GET_CODEHASH:
-			result := s.loadAccount(account)
+			result, ok := s.loadAccount(ctx, account)
+			if !ok {
+				return nil, nil
+			}
 			nonce := uint64(result.Nonce)
 			balance := result.Balance.ToInt()
 			codeHash := result.CodeHash
@@ -524,7 +620,17 @@ func (s *OverlayState) get(account common.Address, action RequestType, key commo
 		if val, ok := s.scratchPad[scratchpadKey]; ok {
 			return val, nil
 		}
-		return s.parent.get(account, action, key)
+		if action == GET_STATE {
+			// a self-destructed account lost its storage like a cleared one,
+			// the state diff sent upstream clears it too
+			if _, ok := s.scratchPad[calcKey(STORAGE_CLEARED_KEY, account)]; ok {
+				return common.Hash{}.Bytes(), nil
+			}
+			if _, ok := s.scratchPad[calcKey(SUICIDE_KEY, account)]; ok {
+				return common.Hash{}.Bytes(), nil
+			}
+		}
+		return s.parent.get(ctx, account, action, key)
 	}
 }
 
@@ -0,0 +1,41 @@
+package mferstate
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestOverlayStateClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	client := rpc.DialInProc(rpc.NewServer())
+	bn := uint64(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	closed := NewOverlayState(context.Background(), client, &bn, 10)
+	canceled := NewOverlayState(ctx, client, &bn, 10)
+	if _, ok := closed.loadState(context.Background(), common.Address{1}, common.Hash{}); !ok {
+		t.Fatal("load failed before close")
+	}
+	closed.Close()
+	closed.Close()
+	cancel()
+	for _, s := range []*OverlayState{closed, canceled} {
+		if _, ok := s.loadState(context.Background(), common.Address{1}, common.Hash{}); ok {
+			t.Fatal("load after close")
+		}
+	}
+
+	client.Close()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("batch loads still running: %d goroutines, want %d", n, before)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"strings"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/common"
@@ -16,6 +17,7 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/kataras/golog"
 	"github.com/sec-bit/mfer-node/constant"
+	"github.com/sec-bit/mfer-node/mfermetrics"
 	"github.com/sec-bit/mfer-node/utils"
 )
 
@@ -38,6 +40,9 @@ type OverlayStateDB struct {
 	refundGas     uint64
 	state         *OverlayState
 	stateBN       *uint64
+	accessList    *accessList
+	preimages     *preimages
+	fetchCtx      context.Context // bounds the upstream loads, nil for none
 }
 
 func (db *OverlayStateDB) GetOverlayDepth() int64 {
@@ -53,12 +58,42 @@ func NewOverlayStateDB(rpcClient *rpc.Client, blockNumber *uint64, keyCacheFileP
 		maxKeyCache:   maxKeyCache,
 		refundGas:     0,
 		stateBN:       blockNumber,
+		preimages:     newPreimages(),
 	}
 	state := NewOverlayState(db.ctx, db.ec, db.stateBN, batchSize).Derive("protect underlying") // protect underlying state
 	db.state = state
 	return db
 }
 
+// BindContext bounds the upstream loads of db by ctx until unbind is called.
+// A load still pending when ctx is done reads as empty and is not cached, the
+// execution reading it must be aborted with ctx. It is not inherited by
+// clones.
+func (db *OverlayStateDB) BindContext(ctx context.Context) (unbind func()) {
+	prev := db.fetchCtx
+	db.fetchCtx = ctx
+	return func() { db.fetchCtx = prev }
+}
+
+func (db *OverlayStateDB) fetchContext() context.Context {
+	if db.fetchCtx == nil {
+		return db.ctx
+	}
+	return db.fetchCtx
+}
+
+// SetMetrics records the upstream requests and the scratchpad lookups of the
+// root state in m.
+func (db *OverlayStateDB) SetMetrics(m *mfermetrics.Fork) {
+	db.state.getRootState().metrics = m
+}
+
+// Close stops the upstream loads of the root state shared by db and its
+// clones, db must not be used after.
+func (db *OverlayStateDB) Close() {
+	db.state.getRootState().Close()
+}
+
 func (db *OverlayStateDB) resetScratchPad(clearKeyCache bool) {
 	s := db.state
 	s.scratchPadMutex.Lock()
@@ -163,6 +198,10 @@ func (db *OverlayStateDB) InitState(fetchNewState, clearCache bool) {
 	if fetchNewState {
 		db.resetScratchPad(clearCache)
 	}
+	// the preimages of the dropped pool executions go with them
+	if db.preimages != nil {
+		db.preimages.clear()
+	}
 	golog.Info(reason)
 	db.state = db.state.Derive(reason)
 	utils.PrintMemUsage("[current]")
@@ -171,7 +210,7 @@ func (db *OverlayStateDB) InitState(fetchNewState, clearCache bool) {
 func (db *OverlayStateDB) CreateAccount(account common.Address) {}
 
 func (db *OverlayStateDB) SubBalance(account common.Address, delta *big.Int) {
-	bal, err := db.state.get(account, GET_BALANCE, common.Hash{})
+	bal, err := db.state.get(db.fetchContext(), account, GET_BALANCE, common.Hash{})
 	if err != nil {
 		log.Panic(err)
 	}
@@ -181,7 +220,7 @@ func (db *OverlayStateDB) SubBalance(account common.Address, delta *big.Int) {
 }
 
 func (db *OverlayStateDB) AddBalance(account common.Address, delta *big.Int) {
-	bal, err := db.state.get(account, GET_BALANCE, common.Hash{})
+	bal, err := db.state.get(db.fetchContext(), account, GET_BALANCE, common.Hash{})
 	if err != nil {
 		log.Panic(err)
 	}
@@ -199,7 +238,7 @@ func (db *OverlayStateDB) InitFakeAccounts() {
 }
 
 func (db *OverlayStateDB) GetBalance(account common.Address) *big.Int {
-	bal, err := db.state.get(account, GET_BALANCE, common.Hash{})
+	bal, err := db.state.get(db.fetchContext(), account, GET_BALANCE, common.Hash{})
 	if err != nil {
 		log.Panic(err)
 	}
@@ -212,7 +251,7 @@ func (db *OverlayStateDB) SetBalance(account common.Address, balance *big.Int) {
 }
 
 func (db *OverlayStateDB) GetNonce(account common.Address) uint64 {
-	nonce, err := db.state.get(account, GET_NONCE, common.Hash{})
+	nonce, err := db.state.get(db.fetchContext(), account, GET_NONCE, common.Hash{})
 	if err != nil {
 		log.Panic(err)
 	}
@@ -224,7 +263,7 @@ func (db *OverlayStateDB) SetNonce(account common.Address, nonce uint64) {
 }
 
 func (db *OverlayStateDB) GetCodeHash(account common.Address) common.Hash {
-	codehash, err := db.state.get(account, GET_CODEHASH, common.Hash{})
+	codehash, err := db.state.get(db.fetchContext(), account, GET_CODEHASH, common.Hash{})
 	if err != nil {
 		log.Panic(err)
 	}
@@ -239,7 +278,7 @@ func (db *OverlayStateDB) SetCodeHash(account common.Address, codeHash common.Ha
 }
 
 func (db *OverlayStateDB) GetCode(account common.Address) []byte {
-	code, err := db.state.get(account, GET_CODE, common.Hash{})
+	code, err := db.state.get(db.fetchContext(), account, GET_CODE, common.Hash{})
 	if err != nil {
 		log.Panic(err)
 	}
@@ -251,7 +290,7 @@ func (db *OverlayStateDB) SetCode(account common.Address, code []byte) {
 }
 
 func (db *OverlayStateDB) GetCodeSize(account common.Address) int {
-	code, err := db.state.get(account, GET_CODE, common.Hash{})
+	code, err := db.state.get(db.fetchContext(), account, GET_CODE, common.Hash{})
 	if err != nil {
 		log.Panic(err)
 	}
@@ -263,7 +302,7 @@ func (db *OverlayStateDB) SubRefund(delta uint64) { db.refundGas -= delta }
 func (db *OverlayStateDB) GetRefund() uint64      { return db.refundGas }
 
 func (db *OverlayStateDB) GetCommittedState(account common.Address, key common.Hash) common.Hash {
-	val, err := db.state.get(account, GET_STATE, key)
+	val, err := db.state.get(db.fetchContext(), account, GET_STATE, key)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -308,20 +347,10 @@ func (db *OverlayStateDB) Empty(account common.Address) bool {
 	return false
 }
 
-func (db *OverlayStateDB) PrepareAccessList(sender common.Address, dest *common.Address, precompiles []common.Address, txAccesses types.AccessList) {
-}
-
-func (db *OverlayStateDB) AddressInAccessList(addr common.Address) bool { return true }
-
-func (db *OverlayStateDB) SlotInAccessList(addr common.Address, slot common.Hash) (addressOk bool, slotOk bool) {
-	return true, true
-}
-
-func (db *OverlayStateDB) AddAddressToAccessList(addr common.Address) { return }
-
-func (db *OverlayStateDB) AddSlotToAccessList(addr common.Address, slot common.Hash) { return }
-
 func (db *OverlayStateDB) RevertToSnapshot(revisionID int) {
+	if db.accessList != nil {
+		db.accessList.revert(int64(revisionID))
+	}
 	tmpState := db.state.Parent()
 	golog.Debugf("Rollbacking... revision: %d, currentID: %d", revisionID, tmpState.deriveCnt)
 	for {
@@ -364,10 +393,27 @@ func (db *OverlayStateDB) Clone() *OverlayStateDB {
 		// block:     db.block,
 		refundGas: 0,
 		state:     db.state.Derive("clone"),
+		preimages: db.preimages,
 	}
 	return cpy
 }
 
+// CloneAt derives a throwaway overlay from db as it was when the snapshot
+// revisionID was taken, the writes made since are not seen.
+func (db *OverlayStateDB) CloneAt(revisionID int) *OverlayStateDB {
+	state := db.state
+	for state.deriveCnt+1 != int64(revisionID) {
+		state = state.Parent()
+	}
+	return &OverlayStateDB{
+		ctx:       db.ctx,
+		ec:        db.ec,
+		conn:      db.conn,
+		state:     state.Derive("clone"),
+		preimages: db.preimages,
+	}
+}
+
 func (db *OverlayStateDB) CloneFromRoot() *OverlayStateDB {
 	cpy := &OverlayStateDB{
 		ctx:       db.ctx,
@@ -375,10 +421,24 @@ func (db *OverlayStateDB) CloneFromRoot() *OverlayStateDB {
 		conn:      db.conn,
 		refundGas: 0,
 		state:     db.state.DeriveFromRoot(),
+		preimages: db.preimages,
 	}
 	return cpy
 }
 
+// Branch derives an independent overlay from the root state cache, it shares
+// the upstream connection, the state block and the root cache with db. The
+// branch records its own preimages.
+func (db *OverlayStateDB) Branch() *OverlayStateDB {
+	cpy := *db
+	cpy.refundGas = 0
+	cpy.accessList = nil
+	cpy.fetchCtx = nil
+	cpy.preimages = newPreimages()
+	cpy.state = db.state.DeriveFromRoot()
+	return &cpy
+}
+
 func (db *OverlayStateDB) CacheSize() (size int) {
 	if db.state == nil {
 		return -1
@@ -443,8 +503,6 @@ func (db *OverlayStateDB) GetReceipt(txHash common.Hash) *types.Receipt {
 	}
 }
 
-func (db *OverlayStateDB) AddPreimage(common.Hash, []byte) {}
-
 func (db *OverlayStateDB) ForEachStorage(account common.Address, callback func(common.Hash, common.Hash) bool) error {
 	return nil
 }
@@ -458,8 +516,12 @@ func (db *OverlayStateDB) SetBatchSize(batchSize int) {
 	db.state.getRootState().batchSize = batchSize
 }
 
+// getMergedScratchPad flattens the layers above the root, the upper layers
+// win. The slots of an account written below the layer that cleared its
+// storage are dropped.
 func (db *OverlayStateDB) getMergedScratchPad() map[string][]byte {
 	mergedScratchPad := make(map[string][]byte)
+	cleared := make(map[common.Address]bool)
 	tmpState := db.state
 	for {
 		if tmpState.parent == nil {
@@ -470,8 +532,16 @@ func (db *OverlayStateDB) getMergedScratchPad() map[string][]byte {
 			if _, ok := mergedScratchPad[k]; ok {
 				continue
 			}
+			if common.BytesToHash([]byte(k)[:32]) == STATE_KEY && cleared[common.BytesToAddress([]byte(k)[32:32+20])] {
+				continue
+			}
 			mergedScratchPad[k] = v
 		}
+		for k := range tmpState.scratchPad {
+			if key := common.BytesToHash([]byte(k)[:32]); key == STORAGE_CLEARED_KEY || key == SUICIDE_KEY {
+				cleared[common.BytesToAddress([]byte(k)[32:32+20])] = true
+			}
+		}
 		tmpState = tmpState.parent
 	}
 	return mergedScratchPad
@@ -479,10 +549,19 @@ func (db *OverlayStateDB) getMergedScratchPad() map[string][]byte {
 
 func (s *OverlayStateDB) GetStateDiff() StateOverride {
 	mergedScratchPad := s.getMergedScratchPad()
-	accounts := make(StateOverride)
 	clonedState := s.CloneFromRoot()
 	clonedState.state.scratchPad = mergedScratchPad
-	for k := range mergedScratchPad {
+	return s.stateOverride(mergedScratchPad, clonedState)
+}
+
+// stateOverride holds the values in clonedState of the scratchpad keys
+// written. The storage of an account cleared or self-destructed in written is
+// overridden as a whole, with the slots known to s that it cleared set to
+// zero.
+func (s *OverlayStateDB) stateOverride(written map[string][]byte, clonedState *OverlayStateDB) StateOverride {
+	accounts := make(StateOverride)
+	cleared := make(map[common.Address]bool)
+	for k := range written {
 		key := common.BytesToHash([]byte(k)[:32])
 		account := common.BytesToAddress([]byte(k)[32 : 32+20])
 		var override *OverrideAccount
@@ -513,7 +592,43 @@ func (s *OverlayStateDB) GetStateDiff() StateOverride {
 			}
 			(*override.StateDiff)[stateKey] = stateValue
 			// scratchpadKey = calcStateKey(account, key)
+		case STORAGE_CLEARED_KEY, SUICIDE_KEY:
+			cleared[account] = true
 		}
 	}
+	for account := range cleared {
+		override := accounts[account]
+		state := make(map[common.Hash]common.Hash)
+		for slot := range s.knownSlots(account) {
+			state[slot] = common.Hash{}
+		}
+		if override.StateDiff != nil {
+			for slot, value := range *override.StateDiff {
+				state[slot] = value
+			}
+		}
+		override.State, override.StateDiff = &state, nil
+	}
 	return accounts
 }
+
+// knownSlots lists the slots of account read or written in any layer of db,
+// the root cache included.
+func (db *OverlayStateDB) knownSlots(account common.Address) map[common.Hash]struct{} {
+	prefix := calcKey(STATE_KEY, account)
+	slots := make(map[common.Hash]struct{})
+	for state := db.state; state != nil; state = state.parent {
+		if state.parent == nil {
+			state.scratchPadMutex.RLock()
+		}
+		for k := range state.scratchPad {
+			if strings.HasPrefix(k, prefix) {
+				slots[common.BytesToHash([]byte(k)[len(prefix):])] = struct{}{}
+			}
+		}
+		if state.parent == nil {
+			state.scratchPadMutex.RUnlock()
+		}
+	}
+	return slots
+}
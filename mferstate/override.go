@@ -0,0 +1,136 @@
+package mferstate
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SetStorage replaces the whole storage of account, the slots not in storage
+// read as zero.
+func (db *OverlayStateDB) SetStorage(account common.Address, storage map[common.Hash]common.Hash) {
+	db.state.scratchPad[calcKey(STORAGE_CLEARED_KEY, account)] = []byte{0x01}
+	for key, value := range storage {
+		db.SetState(account, key, value)
+	}
+}
+
+// Slots returns the slots account overrides, the whole storage when it
+// replaces it.
+func (account *OverrideAccount) Slots() map[common.Hash]common.Hash {
+	if account.State != nil {
+		return *account.State
+	}
+	if account.StateDiff != nil {
+		return *account.StateDiff
+	}
+	return nil
+}
+
+// Validate rejects accounts with both a full state and a state diff.
+func (diff *StateOverride) Validate() error {
+	if diff == nil {
+		return nil
+	}
+	for addr, account := range *diff {
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+		}
+	}
+	return nil
+}
+
+// Apply writes the overrides into the current layer of db, apply them on a
+// clone to keep the overlay untouched.
+func (diff *StateOverride) Apply(db *OverlayStateDB) error {
+	if err := diff.Validate(); err != nil {
+		return err
+	}
+	if diff == nil {
+		return nil
+	}
+	for addr, account := range *diff {
+		if account.Nonce != nil {
+			db.SetNonce(addr, uint64(*account.Nonce))
+		}
+		if account.Code != nil {
+			db.SetCode(addr, *account.Code)
+			codeHash := common.Hash{}
+			if len(*account.Code) > 0 {
+				codeHash = crypto.Keccak256Hash(*account.Code)
+			}
+			db.SetCodeHash(addr, codeHash)
+		}
+		if account.Balance != nil {
+			db.SetBalance(addr, (*account.Balance).ToInt())
+		}
+		if account.State != nil {
+			db.SetStorage(addr, *account.State)
+		}
+		if account.StateDiff != nil {
+			for key, value := range *account.StateDiff {
+				db.SetState(addr, key, value)
+			}
+		}
+	}
+	return nil
+}
+
+// Merge returns diff with the fields set in other replacing its own, a full
+// state replaces the state diff of the account.
+func (diff StateOverride) Merge(other *StateOverride) StateOverride {
+	merged := make(StateOverride, len(diff))
+	for addr, account := range diff {
+		cpy := *account
+		merged[addr] = &cpy
+	}
+	if other == nil {
+		return merged
+	}
+	for addr, account := range *other {
+		cur, ok := merged[addr]
+		if !ok {
+			cpy := *account
+			merged[addr] = &cpy
+			continue
+		}
+		if account.Nonce != nil {
+			cur.Nonce = account.Nonce
+		}
+		if account.Code != nil {
+			cur.Code = account.Code
+		}
+		if account.Balance != nil {
+			cur.Balance = account.Balance
+		}
+		if account.State != nil {
+			cur.State = account.State
+			cur.StateDiff = nil
+		}
+		if account.StateDiff != nil {
+			if cur.State != nil {
+				state := make(map[common.Hash]common.Hash, len(*cur.State))
+				for key, value := range *cur.State {
+					state[key] = value
+				}
+				for key, value := range *account.StateDiff {
+					state[key] = value
+				}
+				cur.State = &state
+				continue
+			}
+			stateDiff := make(map[common.Hash]common.Hash)
+			if cur.StateDiff != nil {
+				for key, value := range *cur.StateDiff {
+					stateDiff[key] = value
+				}
+			}
+			for key, value := range *account.StateDiff {
+				stateDiff[key] = value
+			}
+			cur.StateDiff = &stateDiff
+		}
+	}
+	return merged
+}
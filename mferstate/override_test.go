@@ -0,0 +1,48 @@
+package mferstate
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestStateOverrideMerge(t *testing.T) {
+	var (
+		a     = common.HexToAddress("0xaa")
+		b     = common.HexToAddress("0xbb")
+		slot0 = common.HexToHash("0x00")
+		slot1 = common.HexToHash("0x01")
+		one   = common.HexToHash("0x01")
+		two   = common.HexToHash("0x02")
+		nonce = hexutil.Uint64(1)
+	)
+	diff := StateOverride{
+		a: {Nonce: &nonce, StateDiff: &map[common.Hash]common.Hash{slot0: one, slot1: one}},
+		b: {StateDiff: &map[common.Hash]common.Hash{slot0: one}},
+	}
+	other := StateOverride{
+		a: {StateDiff: &map[common.Hash]common.Hash{slot1: two}},
+		b: {State: &map[common.Hash]common.Hash{slot1: two}},
+	}
+	merged := diff.Merge(&other)
+
+	if merged[a].Nonce == nil || *merged[a].Nonce != nonce {
+		t.Errorf("nonce of the diff was dropped")
+	}
+	if got := (*merged[a].StateDiff)[slot0]; got != one {
+		t.Errorf("slot0 of a = %v, want %v", got, one)
+	}
+	if got := (*merged[a].StateDiff)[slot1]; got != two {
+		t.Errorf("slot1 of a = %v, want %v", got, two)
+	}
+	if merged[b].StateDiff != nil {
+		t.Errorf("state of b did not replace its state diff")
+	}
+	if len(*merged[b].State) != 1 || (*merged[b].State)[slot1] != two {
+		t.Errorf("state of b = %v, want {slot1: two}", *merged[b].State)
+	}
+	if (*diff[a].StateDiff)[slot1] != one {
+		t.Errorf("merge modified the diff")
+	}
+}
@@ -0,0 +1,86 @@
+package mferstate
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// maxPreimageSize bounds the preimages recorded, longer ones are not the
+	// mapping keys and array slots storage layouts are decoded with.
+	maxPreimageSize = 1024
+	// maxPreimages bounds the preimages recorded between two pool resets,
+	// later ones are dropped.
+	maxPreimages = 1 << 16
+)
+
+// preimages are the keccak preimages recorded while executing, shared by a
+// state db and its clones. version changes with every change of hashes.
+type preimages struct {
+	mutex   sync.RWMutex
+	hashes  map[common.Hash][]byte
+	version uint64
+}
+
+func newPreimages() *preimages {
+	return &preimages{hashes: make(map[common.Hash][]byte)}
+}
+
+func (p *preimages) clear() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.hashes) > 0 {
+		p.hashes = make(map[common.Hash][]byte)
+		p.version++
+	}
+}
+
+// AddPreimage records the preimages of at least 32 bytes, the ones storage
+// slots are derived from.
+func (db *OverlayStateDB) AddPreimage(hash common.Hash, preimage []byte) {
+	if db.preimages == nil || len(preimage) < 32 || len(preimage) > maxPreimageSize {
+		return
+	}
+	db.preimages.mutex.Lock()
+	defer db.preimages.mutex.Unlock()
+	if _, ok := db.preimages.hashes[hash]; !ok && len(db.preimages.hashes) < maxPreimages {
+		db.preimages.hashes[hash] = common.CopyBytes(preimage)
+		db.preimages.version++
+	}
+}
+
+// Preimage is the recorded preimage of hash, nil if it is unknown.
+func (db *OverlayStateDB) Preimage(hash common.Hash) []byte {
+	if db.preimages == nil {
+		return nil
+	}
+	db.preimages.mutex.RLock()
+	defer db.preimages.mutex.RUnlock()
+	return db.preimages.hashes[hash]
+}
+
+// Preimages returns a copy of the recorded preimages and their version, the
+// copy can be reused as long as the version is unchanged.
+func (db *OverlayStateDB) Preimages() (map[common.Hash][]byte, uint64) {
+	hashes := make(map[common.Hash][]byte)
+	if db.preimages == nil {
+		return hashes, 0
+	}
+	db.preimages.mutex.RLock()
+	defer db.preimages.mutex.RUnlock()
+	for hash, preimage := range db.preimages.hashes {
+		hashes[hash] = preimage
+	}
+	return hashes, db.preimages.version
+}
+
+// PreimagesVersion is the version of the recorded preimages.
+func (db *OverlayStateDB) PreimagesVersion() uint64 {
+	if db.preimages == nil {
+		return 0
+	}
+	db.preimages.mutex.RLock()
+	defer db.preimages.mutex.RUnlock()
+	return db.preimages.version
+}
@@ -0,0 +1,54 @@
+package mferstate
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestPreimages(t *testing.T) {
+	var bn uint64
+	db := newTestStateDB(nil)
+	db.stateBN = &bn
+	db.preimages = newPreimages()
+
+	key := make([]byte, 64)
+	add := func(db *OverlayStateDB, n uint64) common.Hash {
+		binary.BigEndian.PutUint64(key[24:], n)
+		hash := crypto.Keccak256Hash(key)
+		db.AddPreimage(hash, key)
+		return hash
+	}
+	hash := add(db, 0)
+	db.AddPreimage(common.Hash{1}, []byte("short"))
+	if preimages, version := db.Preimages(); len(preimages) != 1 || version != 1 || db.Preimage(hash) == nil {
+		t.Fatalf("preimages %x, version %d", preimages, version)
+	}
+
+	// clones share the preimages, a branch records its own
+	add(db.Clone(), 1)
+	branch := db.Branch()
+	add(branch, 2)
+	if preimages, _ := db.Preimages(); len(preimages) != 2 {
+		t.Errorf("%d preimages, want 2", len(preimages))
+	}
+	if preimages, _ := branch.Preimages(); len(preimages) != 1 {
+		t.Errorf("branch: %d preimages, want 1", len(preimages))
+	}
+
+	for n := uint64(3); n < maxPreimages+10; n++ {
+		add(db, n)
+	}
+	if preimages, _ := db.Preimages(); len(preimages) != maxPreimages {
+		t.Errorf("%d preimages, want %d", len(preimages), maxPreimages)
+	}
+
+	// resetting the pool drops them
+	version := db.PreimagesVersion()
+	db.InitState(false, false)
+	if preimages, _ := db.Preimages(); len(preimages) != 0 || db.PreimagesVersion() == version {
+		t.Errorf("%d preimages after the reset, version %d", len(preimages), db.PreimagesVersion())
+	}
+}
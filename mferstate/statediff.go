@@ -0,0 +1,132 @@
+package mferstate
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// DiffAccount is an account of a StateDiff, with the fields of the geth
+// prestate tracer.
+type DiffAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Nonce   uint64                      `json:"nonce,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// StateDiff is a state change in the diffMode shape of the geth prestate
+// tracer. Pre holds the changed accounts as they were, with the changed
+// slots only, Post the fields and slots that changed. Zero slots are left
+// out, so are the accounts that did not exist from Pre and the ones
+// deleted from Post.
+type StateDiff struct {
+	Pre  map[common.Address]*DiffAccount `json:"pre"`
+	Post map[common.Address]*DiffAccount `json:"post"`
+}
+
+// Checkpoint returns a read-only view of db as it is now. The writes to db
+// from now on go to a new layer the view does not see, DiffFrom compares
+// db with it.
+func (db *OverlayStateDB) Checkpoint() *OverlayStateDB {
+	view := &OverlayStateDB{
+		ctx:       db.ctx,
+		ec:        db.ec,
+		conn:      db.conn,
+		state:     db.state,
+		preimages: db.preimages,
+	}
+	db.state = db.state.Derive("checkpoint")
+	return view
+}
+
+// StateDiffFrom is GetStateDiff limited to the fields and slots written since
+// base, a checkpoint of db. The writes leaving a value as it was are kept.
+func (db *OverlayStateDB) StateDiffFrom(base *OverlayStateDB) (StateOverride, error) {
+	written := make(map[string][]byte)
+	for state := db.state; state != base.state; state = state.parent {
+		if state.parent == nil {
+			return nil, errors.New("state is not derived from the checkpoint")
+		}
+		for k, v := range state.scratchPad {
+			written[k] = v
+		}
+	}
+	return db.stateOverride(written, db), nil
+}
+
+// DiffFrom is the change of db since base, a checkpoint of db. Writes that
+// leave a field or slot as it was in base are not changes.
+func (db *OverlayStateDB) DiffFrom(base *OverlayStateDB) (*StateDiff, error) {
+	touched := make(map[common.Address]map[common.Hash]struct{})
+	for state := db.state; state != base.state; state = state.parent {
+		if state.parent == nil {
+			return nil, errors.New("state is not derived from the checkpoint")
+		}
+		for k := range state.scratchPad {
+			account := common.BytesToAddress([]byte(k)[32 : 32+20])
+			slots, ok := touched[account]
+			if !ok {
+				slots = make(map[common.Hash]struct{})
+				touched[account] = slots
+			}
+			switch common.BytesToHash([]byte(k)[:32]) {
+			case STATE_KEY:
+				slots[common.BytesToHash([]byte(k)[32+20:])] = struct{}{}
+			case STORAGE_CLEARED_KEY, SUICIDE_KEY:
+				for slot := range db.knownSlots(account) {
+					slots[slot] = struct{}{}
+				}
+			}
+		}
+	}
+
+	diff := &StateDiff{
+		Pre:  make(map[common.Address]*DiffAccount),
+		Post: make(map[common.Address]*DiffAccount),
+	}
+	for account, slots := range touched {
+		pre := &DiffAccount{
+			Balance: (*hexutil.Big)(base.GetBalance(account)),
+			Nonce:   base.GetNonce(account),
+			Code:    base.GetCode(account),
+			Storage: make(map[common.Hash]common.Hash),
+		}
+		post := &DiffAccount{Storage: make(map[common.Hash]common.Hash)}
+		changed := false
+		if balance := db.GetBalance(account); balance.Cmp(pre.Balance.ToInt()) != 0 {
+			post.Balance, changed = (*hexutil.Big)(balance), true
+		}
+		if nonce := db.GetNonce(account); nonce != pre.Nonce {
+			post.Nonce, changed = nonce, true
+		}
+		if code := db.GetCode(account); !bytes.Equal(code, pre.Code) {
+			post.Code, changed = code, true
+		}
+		for slot := range slots {
+			before, after := base.GetState(account, slot), db.GetState(account, slot)
+			if before == after {
+				continue
+			}
+			changed = true
+			if before != (common.Hash{}) {
+				pre.Storage[slot] = before
+			}
+			if after != (common.Hash{}) {
+				post.Storage[slot] = after
+			}
+		}
+		if !changed {
+			continue
+		}
+		if !base.Empty(account) {
+			diff.Pre[account] = pre
+		}
+		if !db.Empty(account) {
+			diff.Post[account] = post
+		}
+	}
+	return diff, nil
+}
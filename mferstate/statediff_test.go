@@ -0,0 +1,170 @@
+package mferstate
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// newTestStateDB is a state db whose root holds accounts, so it never reads
+// upstream.
+func newTestStateDB(accounts map[common.Address]*DiffAccount) *OverlayStateDB {
+	root := &OverlayState{
+		scratchPadMutex: &sync.RWMutex{},
+		scratchPad:      make(map[string][]byte),
+	}
+	for address, account := range accounts {
+		root.scratchPad[calcKey(BALANCE_KEY, address)] = account.Balance.ToInt().Bytes()
+		root.scratchPad[calcKey(NONCE_KEY, address)] = new(big.Int).SetUint64(account.Nonce).Bytes()
+		root.scratchPad[calcKey(CODE_KEY, address)] = account.Code
+		for slot, value := range account.Storage {
+			root.scratchPad[calcStateKey(address, slot)] = value.Bytes()
+		}
+	}
+	return &OverlayStateDB{state: root.Derive("test")}
+}
+
+func TestStateDiff(t *testing.T) {
+	var (
+		eoa      = common.HexToAddress("0xaa")
+		contract = common.HexToAddress("0xcc")
+		fresh    = common.HexToAddress("0xff")
+		one      = common.HexToHash("0x01")
+		two      = common.HexToHash("0x02")
+		three    = common.HexToHash("0x03")
+	)
+	db := newTestStateDB(map[common.Address]*DiffAccount{
+		eoa:      {Balance: big2hex(100), Nonce: 1},
+		contract: {Balance: big2hex(0), Code: []byte{0x60}, Storage: map[common.Hash]common.Hash{one: one, two: two, three: {}}},
+		fresh:    {Balance: big2hex(0)},
+	})
+	base := db.Checkpoint()
+
+	db.SubBalance(eoa, big.NewInt(10))
+	db.SetNonce(eoa, 2)
+	db.Snapshot()
+	db.SetState(contract, one, one)     // no-op write
+	db.SetState(contract, two, three)   // changed slot
+	db.SetState(contract, three, three) // new slot
+	db.AddBalance(fresh, big.NewInt(10))
+	db.AddBalance(contract, big.NewInt(0))
+
+	diff, err := db.DiffFrom(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre := diff.Pre[eoa]; pre == nil || pre.Balance.ToInt().Int64() != 100 || pre.Nonce != 1 {
+		t.Errorf("pre[eoa] = %+v, want balance 100 and nonce 1", pre)
+	}
+	if post := diff.Post[eoa]; post == nil || post.Balance.ToInt().Int64() != 90 || post.Nonce != 2 || post.Code != nil {
+		t.Errorf("post[eoa] = %+v, want balance 90 and nonce 2 only", post)
+	}
+	pre, post := diff.Pre[contract], diff.Post[contract]
+	if pre == nil || len(pre.Storage) != 1 || pre.Storage[two] != two {
+		t.Errorf("pre[contract] = %+v, want slot 2 only", pre)
+	}
+	if post == nil || post.Balance != nil || len(post.Storage) != 2 || post.Storage[two] != three || post.Storage[three] != three {
+		t.Errorf("post[contract] = %+v, want slots 2 and 3 only", post)
+	}
+	if _, ok := diff.Pre[fresh]; ok {
+		t.Errorf("account that did not exist is in pre")
+	}
+	if post := diff.Post[fresh]; post == nil || post.Balance.ToInt().Int64() != 10 {
+		t.Errorf("post[fresh] = %+v, want balance 10", post)
+	}
+
+	// changes after a later checkpoint are not in the diff from it
+	next := db.Checkpoint()
+	db.SetState(contract, one, two)
+	diff, err = db.DiffFrom(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Pre) != 1 || len(diff.Post) != 1 || diff.Post[contract].Storage[one] != two {
+		t.Errorf("diff from the later checkpoint = %+v", diff.Post)
+	}
+	if _, err := base.DiffFrom(next); err == nil {
+		t.Errorf("diff from a later checkpoint succeeded")
+	}
+
+	// the state diff from a checkpoint holds the entries written since, the
+	// no-op writes too
+	last := db.Checkpoint()
+	db.SetState(contract, three, three)
+	db.SetNonce(eoa, 3)
+	override, err := db.StateDiffFrom(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(override) != 2 || override[eoa].Nonce == nil || *override[eoa].Nonce != 3 || override[eoa].Balance != nil ||
+		override[contract].StateDiff == nil || len(*override[contract].StateDiff) != 1 || (*override[contract].StateDiff)[three] != three {
+		t.Errorf("state diff from the last checkpoint = %+v", override)
+	}
+	if _, err := base.StateDiffFrom(last); err == nil {
+		t.Errorf("state diff from a later checkpoint succeeded")
+	}
+}
+
+func TestStateDiffClearedStorage(t *testing.T) {
+	var (
+		replaced  = common.HexToAddress("0xcc")
+		destroyed = common.HexToAddress("0xdd")
+		one       = common.HexToHash("0x01")
+		two       = common.HexToHash("0x02")
+		three     = common.HexToHash("0x03")
+	)
+	db := newTestStateDB(map[common.Address]*DiffAccount{
+		replaced:  {Balance: big2hex(0), Code: []byte{0x60}, Storage: map[common.Hash]common.Hash{one: one, two: two, three: {}}},
+		destroyed: {Balance: big2hex(0), Code: []byte{0x60}, Storage: map[common.Hash]common.Hash{one: one, two: {}}},
+	})
+	base := db.Checkpoint()
+
+	db.SetState(destroyed, two, two)
+	db.Snapshot()
+	db.SetStorage(replaced, map[common.Hash]common.Hash{three: three})
+	db.Suicide(destroyed)
+
+	// the cleared slots are zero, the slots written after the clear are kept
+	override := db.GetStateDiff()
+	want := map[common.Address]map[common.Hash]common.Hash{
+		replaced:  {one: {}, two: {}, three: three},
+		destroyed: {one: {}, two: {}},
+	}
+	for account, storage := range want {
+		got := override[account]
+		if got == nil || got.StateDiff != nil || got.State == nil || len(*got.State) != len(storage) {
+			t.Errorf("override[%s] = %+v, want the storage %v", account.Hex(), got, storage)
+			continue
+		}
+		for slot, value := range storage {
+			if (*got.State)[slot] != value {
+				t.Errorf("override[%s] slot %s = %s, want %s", account.Hex(), slot.Hex(), (*got.State)[slot].Hex(), value.Hex())
+			}
+		}
+	}
+	override, err := db.StateDiffFrom(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := override[replaced]; got == nil || got.State == nil || len(*got.State) != 3 {
+		t.Errorf("state diff from the checkpoint[replaced] = %+v", got)
+	}
+
+	diff, err := db.DiffFrom(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pre := diff.Pre[replaced]; pre == nil || len(pre.Storage) != 2 || pre.Storage[one] != one || pre.Storage[two] != two {
+		t.Errorf("pre[replaced] = %+v, want slots 1 and 2", pre)
+	}
+	if post := diff.Post[replaced]; post == nil || len(post.Storage) != 1 || post.Storage[three] != three {
+		t.Errorf("post[replaced] = %+v, want slot 3 only", post)
+	}
+}
+
+func big2hex(n int64) *hexutil.Big {
+	return (*hexutil.Big)(big.NewInt(n))
+}
@@ -0,0 +1,173 @@
+package mfertracer
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// ErrTooManySteps aborts a call recorded by a StepTracer that executes more
+// opcodes than the tracer keeps.
+var ErrTooManySteps = errors.New("too many steps to debug")
+
+// Step is an executed opcode. Address is the account whose storage the code
+// runs on, CodeAddress the account the code comes from, they differ in a
+// delegate call. Slot is the slot written by an SSTORE.
+type Step struct {
+	Pc          uint64
+	Op          vm.OpCode
+	Gas         uint64
+	Cost        uint64
+	Depth       int
+	Address     common.Address
+	CodeAddress common.Address
+	Slot        *common.Hash
+	Err         error
+}
+
+// Snapshot is the machine state before a step. Storage holds the slots of
+// the step's Address that were read or written before it.
+type Snapshot struct {
+	Stack      []uint256.Int
+	Memory     []byte
+	ReturnData []byte
+	Storage    map[common.Hash]common.Hash
+}
+
+func newStep(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) Step {
+	step := Step{
+		Pc:          pc,
+		Op:          op,
+		Gas:         gas,
+		Cost:        cost,
+		Depth:       depth,
+		Address:     scope.Contract.Address(),
+		CodeAddress: scope.Contract.Address(),
+		Err:         err,
+	}
+	if scope.Contract.CodeAddr != nil {
+		step.CodeAddress = *scope.Contract.CodeAddr
+	}
+	if op == vm.SSTORE && len(scope.Stack.Data()) > 0 {
+		slot := common.Hash(scope.Stack.Back(0).Bytes32())
+		step.Slot = &slot
+	}
+	return step
+}
+
+// StepTracer records the steps of a call, the call is aborted with
+// ErrTooManySteps past max steps.
+type StepTracer struct {
+	env   *vm.EVM
+	max   int
+	steps []Step
+	err   error
+}
+
+func NewStepTracer(max int) *StepTracer {
+	return &StepTracer{max: max}
+}
+
+// Steps returns the recorded steps and ErrTooManySteps if the call was
+// aborted.
+func (t *StepTracer) Steps() ([]Step, error) {
+	return t.steps, t.err
+}
+
+func (t *StepTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+}
+
+func (t *StepTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.err != nil {
+		return
+	}
+	if t.max > 0 && len(t.steps) >= t.max {
+		t.err = ErrTooManySteps
+		t.env.Cancel()
+		return
+	}
+	t.steps = append(t.steps, newStep(pc, op, gas, cost, scope, depth, err))
+}
+
+func (t *StepTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+	if t.err == nil && len(t.steps) > 0 {
+		t.steps[len(t.steps)-1].Err = err
+	}
+}
+
+func (t *StepTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+func (t *StepTracer) CaptureExit(output []byte, gasUsed uint64, err error)                    {}
+func (t *StepTracer) CaptureEnd(output []byte, gasUsed uint64, time time.Duration, err error) {}
+func (t *StepTracer) CaptureTxStart(gasLimit uint64)                                          {}
+func (t *StepTracer) CaptureTxEnd(restGas uint64)                                             {}
+
+// SnapshotTracer takes the snapshot of one step of a call, counted like the
+// steps of a StepTracer, and aborts the call once it has it.
+type SnapshotTracer struct {
+	env      *vm.EVM
+	target   int
+	index    int
+	touched  map[common.Address]map[common.Hash]struct{}
+	snapshot *Snapshot
+}
+
+func NewSnapshotTracer(step int) *SnapshotTracer {
+	return &SnapshotTracer{
+		target:  step,
+		touched: make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+// Snapshot is the snapshot of the step, nil if the call did not reach it.
+func (t *SnapshotTracer) Snapshot() *Snapshot {
+	return t.snapshot
+}
+
+func (t *SnapshotTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+}
+
+func (t *SnapshotTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if t.snapshot != nil {
+		return
+	}
+	address := scope.Contract.Address()
+	if t.index == t.target {
+		storage := make(map[common.Hash]common.Hash, len(t.touched[address]))
+		for slot := range t.touched[address] {
+			storage[slot] = t.env.StateDB.GetState(address, slot)
+		}
+		t.snapshot = &Snapshot{
+			Stack:      append([]uint256.Int{}, scope.Stack.Data()...),
+			Memory:     common.CopyBytes(scope.Memory.Data()),
+			ReturnData: common.CopyBytes(rData),
+			Storage:    storage,
+		}
+		t.env.Cancel()
+		return
+	}
+	t.index++
+	if (op == vm.SLOAD || op == vm.SSTORE) && len(scope.Stack.Data()) > 0 {
+		slots, ok := t.touched[address]
+		if !ok {
+			slots = make(map[common.Hash]struct{})
+			t.touched[address] = slots
+		}
+		slots[common.Hash(scope.Stack.Back(0).Bytes32())] = struct{}{}
+	}
+}
+
+func (t *SnapshotTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+func (t *SnapshotTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+}
+func (t *SnapshotTracer) CaptureExit(output []byte, gasUsed uint64, err error)                    {}
+func (t *SnapshotTracer) CaptureEnd(output []byte, gasUsed uint64, time time.Duration, err error) {}
+func (t *SnapshotTracer) CaptureTxStart(gasLimit uint64)                                          {}
+func (t *SnapshotTracer) CaptureTxEnd(restGas uint64)                                             {}
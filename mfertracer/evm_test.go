@@ -0,0 +1,119 @@
+package mfertracer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	testSender = common.HexToAddress("0xaaaa")
+	testOrigin = common.HexToAddress("0xbbbb")
+)
+
+// asm assembles test bytecode: an opcode is emitted as is, an address is
+// pushed with PUSH20, a byte slice with the PUSH of its length and an int
+// with PUSH1 or PUSH2. The DUP and SWAP constants of vm are untyped, they
+// are passed as vm.OpCode(vm.DUP1).
+func asm(parts ...interface{}) []byte {
+	var code []byte
+	push := func(data []byte) {
+		code = append(code, byte(vm.PUSH1)+byte(len(data)-1))
+		code = append(code, data...)
+	}
+	for _, part := range parts {
+		switch part := part.(type) {
+		case vm.OpCode:
+			code = append(code, byte(part))
+		case common.Address:
+			push(part.Bytes())
+		case common.Hash:
+			push(part.Bytes())
+		case []byte:
+			push(part)
+		case int:
+			if part < 256 {
+				push([]byte{byte(part)})
+			} else {
+				push([]byte{byte(part >> 8), byte(part)})
+			}
+		default:
+			panic("asm: unexpected part")
+		}
+	}
+	return code
+}
+
+// callOp assembles a call to to with no input and output, passing gas.
+func callOp(op vm.OpCode, to common.Address, value, gas int) []interface{} {
+	parts := []interface{}{0, 0, 0, 0}
+	if op == vm.CALL || op == vm.CALLCODE {
+		parts = append(parts, value)
+	}
+	return append(parts, to, gas, op)
+}
+
+// testAccount is the pre-state of an account.
+type testAccount struct {
+	code    []byte
+	balance int64
+	storage map[common.Hash]common.Hash
+}
+
+// testTx is a tx sent by testSender with testOrigin as the tx origin when
+// origin is set.
+type testTx struct {
+	to         common.Address
+	input      []byte
+	value      int64
+	accessList types.AccessList
+	origin     bool
+}
+
+// runTx runs tx on a fresh state holding accounts, traced by tracer.
+func runTx(t *testing.T, tracer vm.EVMLogger, accounts map[common.Address]testAccount, tx testTx) (*core.ExecutionResult, *state.StateDB) {
+	t.Helper()
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.AddBalance(testSender, big.NewInt(1e18))
+	for address, account := range accounts {
+		statedb.SetCode(address, account.code)
+		statedb.AddBalance(address, big.NewInt(account.balance))
+		for key, value := range account.storage {
+			statedb.SetState(address, key, value)
+		}
+	}
+	statedb.Finalise(true)
+
+	blockCtx := vm.BlockContext{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(1),
+		Difficulty:  big.NewInt(0),
+		BaseFee:     big.NewInt(0),
+		GasLimit:    30000000,
+	}
+	from := testSender
+	msg := types.NewMessage(from, &tx.to, 0, big.NewInt(tx.value), 1000000, big.NewInt(0), big.NewInt(0), big.NewInt(0), tx.input, tx.accessList, false)
+	txCtx := core.NewEVMTxContext(msg)
+	if tx.origin {
+		txCtx.Origin = testOrigin
+	}
+	evm := vm.NewEVM(blockCtx, txCtx, statedb, params.TestChainConfig, vm.Config{Debug: true, Tracer: tracer, NoBaseFee: true})
+	result, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(blockCtx.GasLimit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, statedb
+}
@@ -0,0 +1,290 @@
+package mfertracer
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// opcode classes of GasFrame.Classes
+const (
+	GasStorage    = "storage"
+	GasCalls      = "calls"
+	GasMemory     = "memory"
+	GasLogs       = "logs"
+	GasKeccak     = "keccak"
+	GasAccount    = "account"
+	GasCompute    = "compute"
+	GasPrecompile = "precompile"
+)
+
+// GasClass is the class of op in a gas profile.
+func GasClass(op vm.OpCode) string {
+	switch op {
+	case vm.SLOAD, vm.SSTORE:
+		return GasStorage
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL, vm.CREATE, vm.CREATE2, vm.SELFDESTRUCT:
+		return GasCalls
+	case vm.MLOAD, vm.MSTORE, vm.MSTORE8, vm.MSIZE, vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY, vm.RETURN, vm.REVERT:
+		return GasMemory
+	case vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4:
+		return GasLogs
+	case vm.KECCAK256:
+		return GasKeccak
+	case vm.BALANCE, vm.SELFBALANCE, vm.EXTCODESIZE, vm.EXTCODECOPY, vm.EXTCODEHASH:
+		return GasAccount
+	}
+	return GasCompute
+}
+
+// GasFrame is the gas profile of a call frame. GasUsed includes the gas of
+// the calls it makes, SelfGas is the gas of its own opcodes split by class in
+// Classes. ColdAccessGas is what the cold accesses of the frame cost over
+// warm ones (EIP-2929).
+type GasFrame struct {
+	Type          string
+	From          common.Address
+	To            common.Address
+	Input         []byte
+	GasUsed       uint64
+	SelfGas       uint64
+	Classes       map[string]uint64
+	WarmAccesses  int
+	ColdAccesses  int
+	ColdAccessGas uint64
+	Err           error
+	Calls         []*GasFrame
+
+	pending  *vm.OpCode // the last op, charged at the next step of the frame
+	gas      uint64     // gas before the last op
+	childGas uint64     // gas used by the calls of the last op
+	warmed   []accessKey
+}
+
+func (f *GasFrame) charge(class string, gas uint64) {
+	f.SelfGas += gas
+	f.Classes[class] += gas
+}
+
+type accessKey struct {
+	address common.Address
+	slot    common.Hash
+	isSlot  bool
+}
+
+// GasTracer profiles the gas of a call by frame and opcode class. The warm
+// accounts and slots are followed as EIP-2929 does, from the access list of
+// the tx, and reverted with the frames that warmed them.
+type GasTracer struct {
+	env        *vm.EVM
+	accessList types.AccessList
+	berlin     bool
+	warm       map[accessKey]bool
+	frames     []*GasFrame
+	root       *GasFrame
+	gasLimit   uint64
+	gasUsed    uint64
+	intrinsic  uint64
+}
+
+func NewGasTracer(accessList types.AccessList) *GasTracer {
+	return &GasTracer{accessList: accessList, warm: make(map[accessKey]bool)}
+}
+
+// Root is the profile of the top call frame.
+func (t *GasTracer) Root() *GasFrame {
+	return t.root
+}
+
+// GasUsed is the gas used by the tx, after the refund.
+func (t *GasTracer) GasUsed() uint64 {
+	return t.gasUsed
+}
+
+// IntrinsicGas is the gas charged before the execution.
+func (t *GasTracer) IntrinsicGas() uint64 {
+	return t.intrinsic
+}
+
+// Refund is the gas refunded after the execution.
+func (t *GasTracer) Refund() uint64 {
+	if t.root == nil || t.intrinsic+t.root.GasUsed < t.gasUsed {
+		return 0
+	}
+	return t.intrinsic + t.root.GasUsed - t.gasUsed
+}
+
+func (t *GasTracer) push(typ vm.OpCode, from, to common.Address, input []byte) {
+	frame := &GasFrame{
+		Type:    typ.String(),
+		From:    from,
+		To:      to,
+		Input:   common.CopyBytes(input),
+		Classes: make(map[string]uint64),
+	}
+	if len(t.frames) > 0 {
+		parent := t.frames[len(t.frames)-1]
+		parent.Calls = append(parent.Calls, frame)
+	}
+	t.frames = append(t.frames, frame)
+	t.access(frame, accessKey{address: to})
+}
+
+func (t *GasTracer) pop(gasUsed uint64, err error) *GasFrame {
+	frame := t.frames[len(t.frames)-1]
+	t.frames = t.frames[:len(t.frames)-1]
+	frame.GasUsed, frame.Err = gasUsed, err
+
+	// the last op is charged what the frame used beyond the rest
+	accounted := frame.SelfGas
+	for _, call := range frame.Calls {
+		accounted += call.GasUsed
+	}
+	var rest uint64
+	if gasUsed > accounted {
+		rest = gasUsed - accounted
+	}
+	switch {
+	case frame.pending != nil:
+		frame.charge(GasClass(*frame.pending), rest)
+	case rest > 0:
+		frame.charge(GasPrecompile, rest)
+	}
+	frame.pending = nil
+
+	if err != nil {
+		for _, key := range frame.warmed {
+			delete(t.warm, key)
+		}
+	} else if len(t.frames) > 0 {
+		parent := t.frames[len(t.frames)-1]
+		parent.warmed = append(parent.warmed, frame.warmed...)
+	}
+	frame.warmed = nil
+	if len(t.frames) > 0 {
+		t.frames[len(t.frames)-1].childGas += gasUsed
+	}
+	return frame
+}
+
+// access warms key, it reports whether key was cold.
+func (t *GasTracer) access(frame *GasFrame, key accessKey) bool {
+	if t.warm[key] {
+		return false
+	}
+	t.warm[key] = true
+	frame.warmed = append(frame.warmed, key)
+	return true
+}
+
+func (t *GasTracer) CaptureTxStart(gasLimit uint64) {
+	t.gasLimit = gasLimit
+}
+
+func (t *GasTracer) CaptureTxEnd(restGas uint64) {
+	t.gasUsed = t.gasLimit - restGas
+}
+
+func (t *GasTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	if t.gasLimit > gas {
+		t.intrinsic = t.gasLimit - gas
+	}
+	rules := env.ChainConfig().Rules(env.Context.BlockNumber, env.Context.Random != nil)
+	t.berlin = rules.IsBerlin
+	t.warm[accessKey{address: from}] = true
+	for _, precompile := range vm.ActivePrecompiles(rules) {
+		t.warm[accessKey{address: precompile}] = true
+	}
+	for _, tuple := range t.accessList {
+		t.warm[accessKey{address: tuple.Address}] = true
+		for _, slot := range tuple.StorageKeys {
+			t.warm[accessKey{address: tuple.Address, slot: slot, isSlot: true}] = true
+		}
+	}
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.push(typ, from, to, input)
+	// the callee is warm from the start
+	t.frames[0].warmed = nil
+}
+
+func (t *GasTracer) CaptureEnd(output []byte, gasUsed uint64, time time.Duration, err error) {
+	t.root = t.pop(gasUsed, err)
+}
+
+func (t *GasTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.push(typ, from, to, input)
+}
+
+func (t *GasTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	t.pop(gasUsed, err)
+}
+
+func (t *GasTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	frame := t.frames[len(t.frames)-1]
+	if frame.pending != nil {
+		var used uint64
+		if frame.gas > gas+frame.childGas {
+			used = frame.gas - gas - frame.childGas
+		}
+		frame.charge(GasClass(*frame.pending), used)
+	}
+	frame.pending, frame.gas, frame.childGas = &op, gas, 0
+	if t.berlin {
+		t.countAccess(frame, op, scope)
+	}
+}
+
+// countAccess counts the warm and cold accesses of op, with what a cold
+// access costs over a warm one.
+func (t *GasTracer) countAccess(frame *GasFrame, op vm.OpCode, scope *vm.ScopeContext) {
+	stack := scope.Stack.Data()
+	var (
+		key   accessKey
+		extra uint64
+	)
+	switch op {
+	case vm.SLOAD, vm.SSTORE:
+		if len(stack) < 1 {
+			return
+		}
+		key = accessKey{address: scope.Contract.Address(), slot: scope.Stack.Back(0).Bytes32(), isSlot: true}
+		extra = params.ColdSloadCostEIP2929
+		if op == vm.SLOAD {
+			extra -= params.WarmStorageReadCostEIP2929
+		}
+	case vm.BALANCE, vm.EXTCODESIZE, vm.EXTCODECOPY, vm.EXTCODEHASH, vm.SELFDESTRUCT:
+		if len(stack) < 1 {
+			return
+		}
+		key = accessKey{address: scope.Stack.Back(0).Bytes20()}
+		extra = params.ColdAccountAccessCostEIP2929
+		if op != vm.SELFDESTRUCT {
+			extra -= params.WarmStorageReadCostEIP2929
+		}
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		if len(stack) < 2 {
+			return
+		}
+		key = accessKey{address: scope.Stack.Back(1).Bytes20()}
+		extra = params.ColdAccountAccessCostEIP2929 - params.WarmStorageReadCostEIP2929
+	default:
+		return
+	}
+	if t.access(frame, key) {
+		frame.ColdAccesses++
+		frame.ColdAccessGas += extra
+	} else {
+		frame.WarmAccesses++
+	}
+}
+
+func (t *GasTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
@@ -0,0 +1,184 @@
+package mfertracer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestGasTracer(t *testing.T) {
+	var (
+		caller   = common.HexToAddress("0x1000")
+		callee   = common.HexToAddress("0x2000")
+		reverter = common.HexToAddress("0x3000")
+		identity = common.BytesToAddress([]byte{4})
+	)
+	// a cold and a warm SLOAD of slot 0: 4 pushes and pops, 2100 and 100
+	sloads := asm(0, vm.SLOAD, vm.POP, 0, vm.SLOAD, vm.POP, vm.STOP)
+	// a cold SLOAD, then a revert: 3 pushes and a pop, 2100
+	reverts := asm(0, vm.SLOAD, vm.POP, 0, 0, vm.REVERT)
+	calls := func(targets ...common.Address) []byte {
+		var parts []interface{}
+		for _, target := range targets {
+			parts = append(parts, callOp(vm.CALL, target, 0, 50000)...)
+			parts = append(parts, vm.POP)
+		}
+		return asm(append(parts, vm.STOP)...)
+	}
+	callCost := 7*3 + 2 // the pushes of a call and the pop of its result
+
+	type frame struct {
+		gasUsed, selfGas uint64
+		classes          map[string]uint64
+		warm, cold       int
+		coldGas          uint64
+		failed           bool
+		calls            []frame
+	}
+	tests := []struct {
+		name     string
+		accounts map[common.Address]testAccount
+		tx       testTx
+		gasUsed  uint64
+		refund   uint64
+		root     frame
+	}{
+		{
+			name:     "cold and warm sload",
+			accounts: map[common.Address]testAccount{callee: {code: sloads}},
+			tx:       testTx{to: callee},
+			gasUsed:  params.TxGas + 2210,
+			root: frame{
+				gasUsed: 2210, selfGas: 2210,
+				classes: map[string]uint64{GasStorage: 2200, GasCompute: 10},
+				warm:    1, cold: 1, coldGas: params.ColdSloadCostEIP2929 - params.WarmStorageReadCostEIP2929,
+			},
+		},
+		{
+			name:     "sload warmed by the access list",
+			accounts: map[common.Address]testAccount{callee: {code: sloads}},
+			tx:       testTx{to: callee, accessList: types.AccessList{{Address: callee, StorageKeys: []common.Hash{{}}}}},
+			gasUsed:  params.TxGas + params.TxAccessListAddressGas + params.TxAccessListStorageKeyGas + 210,
+			root: frame{
+				gasUsed: 210, selfGas: 210,
+				classes: map[string]uint64{GasStorage: 200, GasCompute: 10},
+				warm:    2,
+			},
+		},
+		{
+			// the gas forwarded to the callee is charged to the callee, the
+			// caller keeps the cold account access of the call
+			name:     "call forwarding gas",
+			accounts: map[common.Address]testAccount{caller: {code: calls(callee)}, callee: {code: sloads}},
+			tx:       testTx{to: caller},
+			gasUsed:  params.TxGas + uint64(callCost) + params.ColdAccountAccessCostEIP2929 + 2210,
+			root: frame{
+				gasUsed: uint64(callCost) + params.ColdAccountAccessCostEIP2929 + 2210, selfGas: uint64(callCost) + params.ColdAccountAccessCostEIP2929,
+				classes: map[string]uint64{GasCalls: params.ColdAccountAccessCostEIP2929, GasCompute: uint64(callCost)},
+				cold:    1, coldGas: params.ColdAccountAccessCostEIP2929 - params.WarmStorageReadCostEIP2929,
+				calls: []frame{{
+					gasUsed: 2210, selfGas: 2210,
+					classes: map[string]uint64{GasStorage: 2200, GasCompute: 10},
+					warm:    1, cold: 1, coldGas: params.ColdSloadCostEIP2929 - params.WarmStorageReadCostEIP2929,
+				}},
+			},
+		},
+		{
+			// the slot warmed by a reverted frame is cold again
+			name:     "reverted warm slot",
+			accounts: map[common.Address]testAccount{caller: {code: calls(reverter, reverter)}, reverter: {code: reverts}},
+			tx:       testTx{to: caller},
+			gasUsed:  params.TxGas + 2*uint64(callCost) + params.ColdAccountAccessCostEIP2929 + params.WarmStorageReadCostEIP2929 + 2*2111,
+			root: frame{
+				gasUsed: 2*uint64(callCost) + params.ColdAccountAccessCostEIP2929 + params.WarmStorageReadCostEIP2929 + 2*2111,
+				selfGas: 2*uint64(callCost) + params.ColdAccountAccessCostEIP2929 + params.WarmStorageReadCostEIP2929,
+				classes: map[string]uint64{GasCalls: params.ColdAccountAccessCostEIP2929 + params.WarmStorageReadCostEIP2929, GasCompute: 2 * uint64(callCost)},
+				warm:    1, cold: 1, coldGas: params.ColdAccountAccessCostEIP2929 - params.WarmStorageReadCostEIP2929,
+				calls: []frame{
+					{gasUsed: 2111, selfGas: 2111, classes: map[string]uint64{GasStorage: 2100, GasCompute: 11}, cold: 1, coldGas: 2000, failed: true},
+					{gasUsed: 2111, selfGas: 2111, classes: map[string]uint64{GasStorage: 2100, GasCompute: 11}, cold: 1, coldGas: 2000, failed: true},
+				},
+			},
+		},
+		{
+			// a precompile has no ops, its gas is charged as a whole
+			name:     "precompile",
+			accounts: map[common.Address]testAccount{caller: {code: calls(identity)}},
+			tx:       testTx{to: caller},
+			gasUsed:  params.TxGas + uint64(callCost) + params.WarmStorageReadCostEIP2929 + params.IdentityBaseGas,
+			root: frame{
+				gasUsed: uint64(callCost) + params.WarmStorageReadCostEIP2929 + params.IdentityBaseGas, selfGas: uint64(callCost) + params.WarmStorageReadCostEIP2929,
+				classes: map[string]uint64{GasCalls: params.WarmStorageReadCostEIP2929, GasCompute: uint64(callCost)},
+				warm:    1,
+				calls:   []frame{{gasUsed: params.IdentityBaseGas, selfGas: params.IdentityBaseGas, classes: map[string]uint64{GasPrecompile: params.IdentityBaseGas}}},
+			},
+		},
+		{
+			// clearing a slot refunds 4800 after the execution
+			name: "refund",
+			accounts: map[common.Address]testAccount{callee: {
+				code:    asm(0, 0, vm.SSTORE, vm.STOP),
+				storage: map[common.Hash]common.Hash{{}: common.BigToHash(common.Big1)},
+			}},
+			tx:      testTx{to: callee},
+			gasUsed: params.TxGas + 5006 - params.SstoreClearsScheduleRefundEIP3529,
+			refund:  params.SstoreClearsScheduleRefundEIP3529,
+			root: frame{
+				gasUsed: 5006, selfGas: 5006,
+				classes: map[string]uint64{GasStorage: 5000, GasCompute: 6},
+				cold:    1, coldGas: params.ColdSloadCostEIP2929,
+			},
+		},
+	}
+
+	var compare func(path string, got *GasFrame, want frame) []string
+	compare = func(path string, got *GasFrame, want frame) []string {
+		var errs []string
+		if got.GasUsed != want.gasUsed || got.SelfGas != want.selfGas {
+			errs = append(errs, fmt.Sprintf("%s: gas used %d self %d, want %d self %d", path, got.GasUsed, got.SelfGas, want.gasUsed, want.selfGas))
+		}
+		for class, gas := range got.Classes {
+			if gas != want.classes[class] {
+				errs = append(errs, fmt.Sprintf("%s: %s gas %d, want %d", path, class, gas, want.classes[class]))
+			}
+		}
+		for class, gas := range want.classes {
+			if _, ok := got.Classes[class]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: %s gas missing, want %d", path, class, gas))
+			}
+		}
+		if got.WarmAccesses != want.warm || got.ColdAccesses != want.cold || got.ColdAccessGas != want.coldGas {
+			errs = append(errs, fmt.Sprintf("%s: warm %d cold %d (%d gas), want warm %d cold %d (%d gas)", path, got.WarmAccesses, got.ColdAccesses, got.ColdAccessGas, want.warm, want.cold, want.coldGas))
+		}
+		if (got.Err != nil) != want.failed {
+			errs = append(errs, fmt.Sprintf("%s: err %v", path, got.Err))
+		}
+		if len(got.Calls) != len(want.calls) {
+			return append(errs, fmt.Sprintf("%s: %d calls, want %d", path, len(got.Calls), len(want.calls)))
+		}
+		for i, call := range got.Calls {
+			errs = append(errs, compare(fmt.Sprintf("%s.%d", path, i), call, want.calls[i])...)
+		}
+		return errs
+	}
+	for _, test := range tests {
+		tracer := NewGasTracer(test.tx.accessList)
+		result, _ := runTx(t, tracer, test.accounts, test.tx)
+		if tracer.GasUsed() != result.UsedGas {
+			t.Errorf("%s: gas used %d, the evm used %d", test.name, tracer.GasUsed(), result.UsedGas)
+		}
+		if tracer.GasUsed() != test.gasUsed || tracer.Refund() != test.refund {
+			t.Errorf("%s: gas used %d refund %d, want %d refund %d", test.name, tracer.GasUsed(), tracer.Refund(), test.gasUsed, test.refund)
+		}
+		if tracer.IntrinsicGas()+tracer.Root().GasUsed != tracer.GasUsed()+tracer.Refund() {
+			t.Errorf("%s: intrinsic %d and root %d do not add up", test.name, tracer.IntrinsicGas(), tracer.Root().GasUsed)
+		}
+		for _, err := range compare("root", tracer.Root(), test.root) {
+			t.Errorf("%s: %s", test.name, err)
+		}
+	}
+}
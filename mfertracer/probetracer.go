@@ -0,0 +1,171 @@
+package mfertracer
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ProbeCall is a call frame of a probed execution, in the order the frames
+// are entered.
+type ProbeCall struct {
+	Type   string
+	From   common.Address
+	To     common.Address
+	Input  []byte
+	Value  *big.Int
+	Depth  int
+	Failed bool
+}
+
+// ProbeWrite is an SSTORE of a probed execution.
+type ProbeWrite struct {
+	Address common.Address
+	Slot    common.Hash
+	Value   common.Hash
+}
+
+// maxProbeLogMemory is beyond the memory a call can pay for.
+const maxProbeLogMemory = 1 << 32
+
+type probeFrame struct {
+	call   *ProbeCall
+	writes int
+	logs   int
+}
+
+// ProbeTracer records what an execution does that can be observed: the calls
+// it makes, the storage it writes, the logs it emits and the block and tx
+// context it reads. The writes and logs of the frames that revert are
+// dropped.
+type ProbeTracer struct {
+	calls  []*ProbeCall
+	frames []probeFrame
+	writes []ProbeWrite
+	logs   []*types.Log
+	reads  map[vm.OpCode]int
+}
+
+func NewProbeTracer() *ProbeTracer {
+	return &ProbeTracer{reads: make(map[vm.OpCode]int)}
+}
+
+// Calls lists the call frames in the order they were entered.
+func (t *ProbeTracer) Calls() []*ProbeCall {
+	return t.calls
+}
+
+// Writes lists the storage writes that were not reverted.
+func (t *ProbeTracer) Writes() []ProbeWrite {
+	return t.writes
+}
+
+// Logs lists the logs that were not reverted.
+func (t *ProbeTracer) Logs() []*types.Log {
+	return t.logs
+}
+
+// Reads counts the context opcodes executed, reverted frames included.
+func (t *ProbeTracer) Reads() map[vm.OpCode]int {
+	return t.reads
+}
+
+// IsContextOp reports whether op reads the block or tx context.
+func IsContextOp(op vm.OpCode) bool {
+	switch op {
+	case vm.TIMESTAMP, vm.NUMBER, vm.COINBASE, vm.BASEFEE, vm.DIFFICULTY, vm.GASLIMIT, vm.GASPRICE, vm.ORIGIN, vm.BLOCKHASH, vm.CHAINID:
+		return true
+	}
+	return false
+}
+
+func (t *ProbeTracer) enter(typ vm.OpCode, from, to common.Address, input []byte, value *big.Int) {
+	call := &ProbeCall{
+		Type:  typ.String(),
+		From:  from,
+		To:    to,
+		Input: common.CopyBytes(input),
+		Depth: len(t.frames),
+	}
+	if value != nil {
+		call.Value = new(big.Int).Set(value)
+	}
+	t.calls = append(t.calls, call)
+	t.frames = append(t.frames, probeFrame{call: call, writes: len(t.writes), logs: len(t.logs)})
+}
+
+func (t *ProbeTracer) exit(err error) {
+	frame := t.frames[len(t.frames)-1]
+	t.frames = t.frames[:len(t.frames)-1]
+	if err != nil {
+		frame.call.Failed = true
+		t.writes = t.writes[:frame.writes]
+		t.logs = t.logs[:frame.logs]
+	}
+}
+
+func (t *ProbeTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.enter(typ, from, to, input, value)
+}
+
+func (t *ProbeTracer) CaptureEnd(output []byte, gasUsed uint64, time time.Duration, err error) {
+	t.exit(err)
+}
+
+func (t *ProbeTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.enter(typ, from, to, input, value)
+}
+
+func (t *ProbeTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	t.exit(err)
+}
+
+func (t *ProbeTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	if IsContextOp(op) {
+		t.reads[op]++
+	}
+	stack := scope.Stack.Data()
+	switch op {
+	case vm.SSTORE:
+		if len(stack) < 2 {
+			return
+		}
+		t.writes = append(t.writes, ProbeWrite{
+			Address: scope.Contract.Address(),
+			Slot:    scope.Stack.Back(0).Bytes32(),
+			Value:   scope.Stack.Back(1).Bytes32(),
+		})
+	case vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4:
+		n := int(op - vm.LOG0)
+		if len(stack) < 2+n {
+			return
+		}
+		offset, size := scope.Stack.Back(0), scope.Stack.Back(1)
+		if !offset.IsUint64() || !size.IsUint64() || offset.Uint64()+size.Uint64() > maxProbeLogMemory {
+			// out of gas, the frame reverts
+			return
+		}
+		// the memory is expanded after the op is traced, the rest is zero
+		data := make([]byte, size.Uint64())
+		if start := offset.Uint64(); start < uint64(scope.Memory.Len()) {
+			copy(data, scope.Memory.Data()[start:])
+		}
+		log := &types.Log{Address: scope.Contract.Address(), Data: data}
+		for i := 0; i < n; i++ {
+			log.Topics = append(log.Topics, scope.Stack.Back(2+i).Bytes32())
+		}
+		t.logs = append(t.logs, log)
+	}
+}
+
+func (t *ProbeTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+func (t *ProbeTracer) CaptureTxStart(gasLimit uint64) {}
+func (t *ProbeTracer) CaptureTxEnd(restGas uint64)    {}
@@ -0,0 +1,75 @@
+package mfertracer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestProbeTracer(t *testing.T) {
+	var (
+		caller   = common.HexToAddress("0x1000")
+		reverter = common.HexToAddress("0x2000")
+		callee   = common.HexToAddress("0x3000")
+	)
+	// writes slot 1, emits a log with the timestamp and calls both callees
+	var callerCode []interface{}
+	callerCode = append(callerCode, 7, 1, vm.SSTORE, vm.TIMESTAMP, 0, vm.MSTORE, 0xaa, 32, 0, vm.LOG1)
+	callerCode = append(callerCode, callOp(vm.CALL, reverter, 0, 60000)...)
+	callerCode = append(callerCode, vm.POP)
+	callerCode = append(callerCode, callOp(vm.CALL, callee, 0, 50000)...)
+	callerCode = append(callerCode, vm.POP, vm.STOP)
+	// the reverter writes and logs, then calls the callee and reverts
+	var reverterCode []interface{}
+	reverterCode = append(reverterCode, 9, 2, vm.SSTORE, 0, 0, vm.LOG0)
+	reverterCode = append(reverterCode, callOp(vm.CALL, callee, 0, 30000)...)
+	reverterCode = append(reverterCode, vm.POP, 0, 0, vm.REVERT)
+
+	tracer := NewProbeTracer()
+	result, _ := runTx(t, tracer, map[common.Address]testAccount{
+		caller:   {code: asm(callerCode...)},
+		reverter: {code: asm(reverterCode...)},
+		callee:   {code: asm(vm.NUMBER, 3, vm.SSTORE, 0xbb, 0, 0, vm.LOG1, vm.STOP)},
+	}, testTx{to: caller})
+	if result.Failed() {
+		t.Fatal(result.Err)
+	}
+
+	word := func(n int64) common.Hash { return common.BigToHash(big.NewInt(n)) }
+	// the writes and logs of the reverter and of the callee it called are
+	// dropped
+	writes := tracer.Writes()
+	if len(writes) != 2 || writes[0].Address != caller || writes[0].Slot != word(1) || writes[0].Value != word(7) ||
+		writes[1].Address != callee || writes[1].Value != word(1) {
+		t.Errorf("writes %+v", writes)
+	}
+	logs := tracer.Logs()
+	if len(logs) != 2 || logs[0].Address != caller || logs[0].Topics[0] != word(0xaa) || common.BytesToHash(logs[0].Data) != word(1) ||
+		logs[1].Address != callee || logs[1].Topics[0] != word(0xbb) {
+		t.Errorf("logs %+v", logs)
+	}
+
+	// the frames are kept in the order they were entered, the reverted ones
+	// marked failed
+	want := []struct {
+		to     common.Address
+		depth  int
+		failed bool
+	}{{caller, 0, false}, {reverter, 1, true}, {callee, 2, false}, {callee, 1, false}}
+	calls := tracer.Calls()
+	if len(calls) != len(want) {
+		t.Fatalf("calls %+v", calls)
+	}
+	for i, w := range want {
+		if calls[i].To != w.to || calls[i].Depth != w.depth || calls[i].Failed != w.failed {
+			t.Errorf("call %d: %+v, want %+v", i, calls[i], w)
+		}
+	}
+
+	// the reads of the reverted frames count
+	if reads := tracer.Reads(); reads[vm.TIMESTAMP] != 1 || reads[vm.NUMBER] != 2 || len(reads) != 2 {
+		t.Errorf("reads %v", reads)
+	}
+}
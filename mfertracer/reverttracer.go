@@ -0,0 +1,101 @@
+package mfertracer
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// RevertOrigin is the call frame that raised a revert. Address is the
+// contract whose code reverted, the implementation for a delegate call, and
+// Depth is 0 for the top call frame.
+type RevertOrigin struct {
+	Type    string
+	From    common.Address
+	Address common.Address
+	Input   []byte
+	Depth   int
+	Output  []byte
+}
+
+type revertFrame struct {
+	typ         vm.OpCode
+	from        common.Address
+	to          common.Address
+	input       []byte
+	failed      *RevertOrigin // origin of the last child, nil if it succeeded
+	childOutput []byte        // output of the last child
+}
+
+// RevertTracer finds the frame that raised the revert of a call. A frame
+// reverting with the revert data of the child it just called passes the
+// revert on, the child stays the origin.
+type RevertTracer struct {
+	frames []*revertFrame
+	origin *RevertOrigin
+}
+
+func NewRevertTracer() *RevertTracer {
+	return &RevertTracer{}
+}
+
+// Origin is the frame that raised the revert, nil if the call succeeded.
+func (t *RevertTracer) Origin() *RevertOrigin {
+	return t.origin
+}
+
+func (t *RevertTracer) enter(typ vm.OpCode, from, to common.Address, input []byte) {
+	t.frames = append(t.frames, &revertFrame{typ: typ, from: from, to: to, input: common.CopyBytes(input)})
+}
+
+func (t *RevertTracer) exit(output []byte, err error) *RevertOrigin {
+	frame := t.frames[len(t.frames)-1]
+	t.frames = t.frames[:len(t.frames)-1]
+	if err == nil {
+		return nil
+	}
+	if frame.failed != nil && bytes.Equal(frame.childOutput, output) {
+		return frame.failed
+	}
+	return &RevertOrigin{
+		Type:    frame.typ.String(),
+		From:    frame.from,
+		Address: frame.to,
+		Input:   frame.input,
+		Depth:   len(t.frames),
+		Output:  common.CopyBytes(output),
+	}
+}
+
+func (t *RevertTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.frames, t.origin = nil, nil
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.enter(typ, from, to, input)
+}
+
+func (t *RevertTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.enter(typ, from, to, input)
+}
+
+func (t *RevertTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	origin := t.exit(output, err)
+	parent := t.frames[len(t.frames)-1]
+	parent.failed, parent.childOutput = origin, common.CopyBytes(output)
+}
+
+func (t *RevertTracer) CaptureEnd(output []byte, gasUsed uint64, time time.Duration, err error) {
+	t.origin = t.exit(output, err)
+}
+
+func (t *RevertTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+func (t *RevertTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+func (t *RevertTracer) CaptureTxStart(gasLimit uint64) {}
+func (t *RevertTracer) CaptureTxEnd(restGas uint64)    {}
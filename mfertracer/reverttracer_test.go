@@ -0,0 +1,73 @@
+package mfertracer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+func TestRevertTracer(t *testing.T) {
+	var (
+		entry     = common.HexToAddress("0x1000")
+		leaf      = common.HexToAddress("0x2000")
+		bubbler   = common.HexToAddress("0x3000")
+		wrapper   = common.HexToAddress("0x4000")
+		catcher   = common.HexToAddress("0x5000")
+		delegator = common.HexToAddress("0x6000")
+	)
+	// revertWith reverts with the single byte b
+	revertWith := func(b int) []interface{} {
+		return []interface{}{b, 0, vm.MSTORE8, 1, 0, vm.REVERT}
+	}
+	// bubble calls to and reverts with the revert data it returned
+	bubble := func(op vm.OpCode, to common.Address) []byte {
+		code := append(callOp(op, to, 0, 30000), vm.POP)
+		code = append(code, vm.RETURNDATASIZE, 0, 0, vm.RETURNDATACOPY, vm.RETURNDATASIZE, 0, vm.REVERT)
+		return asm(code...)
+	}
+	accounts := map[common.Address]testAccount{
+		leaf: {code: asm(revertWith(0xaa)...)},
+		// passes the revert of the leaf on
+		bubbler: {code: bubble(vm.CALL, leaf)},
+		// reverts with its own data after the leaf reverted
+		wrapper: {code: asm(append(append(callOp(vm.CALL, leaf, 0, 30000), vm.POP), revertWith(0xbb)...)...)},
+		// ignores the revert of the leaf
+		catcher: {code: asm(append(callOp(vm.CALL, leaf, 0, 30000), vm.POP, vm.STOP)...)},
+		// runs the code of the leaf in its own context
+		delegator: {code: bubble(vm.DELEGATECALL, leaf)},
+	}
+
+	for _, test := range []struct {
+		name   string
+		code   []byte
+		origin *RevertOrigin
+	}{
+		{"top frame", asm(revertWith(0xcc)...), &RevertOrigin{Type: "CALL", From: testSender, Address: entry, Depth: 0, Output: []byte{0xcc}}},
+		{"bubbled up", bubble(vm.CALL, bubbler), &RevertOrigin{Type: "CALL", From: bubbler, Address: leaf, Depth: 2, Output: []byte{0xaa}}},
+		{"nested", bubble(vm.CALL, wrapper), &RevertOrigin{Type: "CALL", From: entry, Address: wrapper, Depth: 1, Output: []byte{0xbb}}},
+		{"wrapped at the top", asm(append(append(callOp(vm.CALL, bubbler, 0, 60000), vm.POP), revertWith(0xdd)...)...),
+			&RevertOrigin{Type: "CALL", From: testSender, Address: entry, Depth: 0, Output: []byte{0xdd}}},
+		{"delegate call", bubble(vm.CALL, delegator), &RevertOrigin{Type: "DELEGATECALL", From: delegator, Address: leaf, Depth: 2, Output: []byte{0xaa}}},
+		{"caught", asm(append(callOp(vm.CALL, catcher, 0, 60000), vm.POP, vm.STOP)...), nil},
+	} {
+		accounts[entry] = testAccount{code: test.code}
+		tracer := NewRevertTracer()
+		result, _ := runTx(t, tracer, accounts, testTx{to: entry})
+		if failed := test.origin != nil; result.Failed() != failed {
+			t.Fatalf("%s: failed %v, want %v", test.name, result.Failed(), failed)
+		}
+		origin := tracer.Origin()
+		if test.origin == nil {
+			if origin != nil {
+				t.Errorf("%s: origin %+v, want none", test.name, origin)
+			}
+			continue
+		}
+		if origin == nil || origin.Type != test.origin.Type || origin.From != test.origin.From || origin.Address != test.origin.Address ||
+			origin.Depth != test.origin.Depth || !bytes.Equal(origin.Output, test.origin.Output) {
+			t.Errorf("%s: origin %+v, want %+v", test.name, origin, test.origin)
+		}
+	}
+}
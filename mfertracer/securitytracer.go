@@ -0,0 +1,339 @@
+package mfertracer
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+// security finding kinds
+const (
+	FindingTxOrigin      = "txOriginAuth"
+	FindingReentrancy    = "reentrancy"
+	FindingUncheckedCall = "uncheckedCall"
+	FindingDelegateCall  = "delegateCall"
+	FindingSelfDestruct  = "selfDestruct"
+)
+
+// Finding is a pattern the security tracer saw. Address is the contract whose
+// storage the code at CodeAddress runs on, Pc the instruction. Target is the
+// callee of a call, the contract reentered or the beneficiary of a
+// selfdestruct. Reverted is set when the frame of the finding reverted.
+type Finding struct {
+	Kind        string
+	Address     common.Address
+	CodeAddress common.Address
+	Pc          uint64
+	Depth       int
+	Op          vm.OpCode
+	Target      *common.Address
+	Value       *big.Int
+	WriteAfter  bool // the reentered frame wrote storage after the reentry
+	Reverted    bool
+}
+
+// Outflow is a transfer of ether, with a nil Token, or of an ERC-20 token
+// out of From.
+type Outflow struct {
+	From   common.Address
+	To     common.Address
+	Token  *common.Address
+	Amount *big.Int
+}
+
+// taint marks where a stack item comes from.
+type taint struct {
+	origin bool
+	caller bool
+	call   int // the call the item is the result of, counted from 1
+}
+
+type securityFrame struct {
+	address common.Address // the storage context
+	code    common.Address
+	pc      uint64
+	stack   []taint
+	calls   []*Finding // unchecked until their result is used
+	// the findings of the frame and of its callees start at findings, the
+	// outflows at outflows
+	findings int
+	outflows int
+	// reentries are the findings of the reentries into this frame
+	reentries []*Finding
+}
+
+// SecurityTracer looks for the use of tx.origin for authentication,
+// reentrancy, call results never used, delegate calls to untrusted code and
+// selfdestructs, and records the ether and token outflows. The stack items
+// are tainted by the ORIGIN, CALLER and call results they come from, through
+// DUP, SWAP and the AND masking addresses.
+type SecurityTracer struct {
+	trusted     map[common.Address]bool
+	precompiles map[common.Address]bool
+	frames      []*securityFrame
+	findings    []*Finding
+	outflows    []*Outflow
+}
+
+// NewSecurityTracer reports the delegate calls to code not in trusted.
+func NewSecurityTracer(trusted map[common.Address]bool) *SecurityTracer {
+	return &SecurityTracer{trusted: trusted}
+}
+
+// Findings lists the findings in the order they were seen.
+func (t *SecurityTracer) Findings() []*Finding {
+	return t.findings
+}
+
+// Outflows lists the transfers that were not reverted.
+func (t *SecurityTracer) Outflows() []*Outflow {
+	return t.outflows
+}
+
+// stackInputs is the number of stack items op consumes, DUP and SWAP aside.
+func stackInputs(op vm.OpCode) int {
+	switch op {
+	case vm.ISZERO, vm.NOT, vm.BALANCE, vm.CALLDATALOAD, vm.EXTCODESIZE, vm.EXTCODEHASH, vm.BLOCKHASH,
+		vm.POP, vm.MLOAD, vm.SLOAD, vm.JUMP, vm.SELFDESTRUCT:
+		return 1
+	case vm.ADD, vm.MUL, vm.SUB, vm.DIV, vm.SDIV, vm.MOD, vm.SMOD, vm.EXP, vm.SIGNEXTEND,
+		vm.LT, vm.GT, vm.SLT, vm.SGT, vm.EQ, vm.AND, vm.OR, vm.XOR, vm.BYTE, vm.SHL, vm.SHR, vm.SAR,
+		vm.KECCAK256, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMPI, vm.RETURN, vm.REVERT, vm.LOG0:
+		return 2
+	case vm.ADDMOD, vm.MULMOD, vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY, vm.CREATE, vm.LOG1:
+		return 3
+	case vm.EXTCODECOPY, vm.CREATE2, vm.LOG2:
+		return 4
+	case vm.LOG3:
+		return 5
+	case vm.DELEGATECALL, vm.STATICCALL, vm.LOG4:
+		return 6
+	case vm.CALL, vm.CALLCODE:
+		return 7
+	}
+	return 0
+}
+
+func (t *SecurityTracer) current() *securityFrame {
+	return t.frames[len(t.frames)-1]
+}
+
+func (t *SecurityTracer) addFinding(f *Finding) *Finding {
+	t.findings = append(t.findings, f)
+	return f
+}
+
+func (t *SecurityTracer) enter(typ vm.OpCode, from, to common.Address, value *big.Int) {
+	if typ == vm.SELFDESTRUCT {
+		caller := t.current()
+		beneficiary := to
+		t.addFinding(&Finding{
+			Kind:        FindingSelfDestruct,
+			Address:     from,
+			CodeAddress: caller.code,
+			Pc:          caller.pc,
+			Depth:       len(t.frames) - 1,
+			Op:          typ,
+			Target:      &beneficiary,
+			Value:       new(big.Int).Set(value),
+		})
+	}
+	if len(t.frames) > 0 {
+		caller := t.current()
+		target := to
+		switch {
+		case (typ == vm.CALL || typ == vm.STATICCALL) && to != caller.address:
+			for _, frame := range t.frames[:len(t.frames)-1] {
+				if frame.address != to {
+					continue
+				}
+				frame.reentries = append(frame.reentries, t.addFinding(&Finding{
+					Kind:        FindingReentrancy,
+					Address:     caller.address,
+					CodeAddress: caller.code,
+					Pc:          caller.pc,
+					Depth:       len(t.frames) - 1,
+					Op:          typ,
+					Target:      &target,
+				}))
+				break
+			}
+		case (typ == vm.DELEGATECALL || typ == vm.CALLCODE) && !t.trusted[to] && !t.precompiles[to]:
+			t.addFinding(&Finding{
+				Kind:        FindingDelegateCall,
+				Address:     caller.address,
+				CodeAddress: caller.code,
+				Pc:          caller.pc,
+				Depth:       len(t.frames) - 1,
+				Op:          typ,
+				Target:      &target,
+			})
+		}
+	}
+	// a delegate call carries the value of its caller and a callcode sends
+	// it to itself
+	if typ != vm.DELEGATECALL && typ != vm.CALLCODE && value != nil && value.Sign() > 0 {
+		t.outflows = append(t.outflows, &Outflow{From: from, To: to, Amount: new(big.Int).Set(value)})
+	}
+	frame := &securityFrame{address: to, code: to, findings: len(t.findings), outflows: len(t.outflows)}
+	if typ == vm.DELEGATECALL || typ == vm.CALLCODE {
+		frame.address = from
+	}
+	t.frames = append(t.frames, frame)
+}
+
+func (t *SecurityTracer) exit(err error) {
+	frame := t.current()
+	t.frames = t.frames[:len(t.frames)-1]
+	for _, call := range frame.calls {
+		if call != nil {
+			t.findings = append(t.findings, call)
+		}
+	}
+	if err != nil {
+		for _, f := range t.findings[frame.findings:] {
+			f.Reverted = true
+		}
+		t.outflows = t.outflows[:frame.outflows]
+	}
+}
+
+func (t *SecurityTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	rules := env.ChainConfig().Rules(env.Context.BlockNumber, env.Context.Random != nil)
+	t.precompiles = make(map[common.Address]bool)
+	for _, address := range vm.ActivePrecompiles(rules) {
+		t.precompiles[address] = true
+	}
+	typ := vm.CALL
+	if create {
+		typ = vm.CREATE
+	}
+	t.enter(typ, from, to, value)
+}
+
+func (t *SecurityTracer) CaptureEnd(output []byte, gasUsed uint64, time time.Duration, err error) {
+	t.exit(err)
+}
+
+func (t *SecurityTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	t.enter(typ, from, to, value)
+}
+
+func (t *SecurityTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	t.exit(err)
+}
+
+func (t *SecurityTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+	frame := t.current()
+	frame.pc = pc
+	stack := scope.Stack.Data()
+	// the items pushed by the untracked ops are clean
+	for len(frame.stack) < len(stack) {
+		frame.stack = append(frame.stack, taint{})
+	}
+	frame.stack = frame.stack[:len(stack)]
+	n := len(stack)
+
+	switch {
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		if i := int(op-vm.DUP1) + 1; i <= n {
+			frame.stack = append(frame.stack, frame.stack[n-i])
+		}
+		return
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		if i := int(op-vm.SWAP1) + 1; i < n {
+			frame.stack[n-1], frame.stack[n-1-i] = frame.stack[n-1-i], frame.stack[n-1]
+		}
+		return
+	}
+	k := stackInputs(op)
+	if k > n {
+		return
+	}
+	inputs := frame.stack[n-k:]
+	var masked taint
+	if op != vm.POP {
+		// the result of a call is checked once it is used
+		for _, in := range inputs {
+			if in.call > 0 {
+				frame.calls[in.call-1] = nil
+			}
+		}
+	}
+	switch op {
+	case vm.EQ:
+		if (inputs[0].origin || inputs[1].origin) && !inputs[0].caller && !inputs[1].caller {
+			t.addFinding(&Finding{
+				Kind:        FindingTxOrigin,
+				Address:     frame.address,
+				CodeAddress: frame.code,
+				Pc:          pc,
+				Depth:       len(t.frames) - 1,
+				Op:          op,
+			})
+		}
+	case vm.AND:
+		masked = taint{origin: inputs[0].origin || inputs[1].origin, caller: inputs[0].caller || inputs[1].caller}
+	case vm.SSTORE:
+		for _, f := range frame.reentries {
+			f.WriteAfter = true
+		}
+	}
+	frame.stack = frame.stack[:n-k]
+
+	switch op {
+	case vm.ORIGIN:
+		frame.stack = append(frame.stack, taint{origin: true})
+	case vm.CALLER:
+		frame.stack = append(frame.stack, taint{caller: true})
+	case vm.AND:
+		frame.stack = append(frame.stack, masked)
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		to := common.Address(scope.Stack.Back(1).Bytes20())
+		if t.precompiles[to] {
+			return
+		}
+		frame.calls = append(frame.calls, &Finding{
+			Kind:        FindingUncheckedCall,
+			Address:     frame.address,
+			CodeAddress: frame.code,
+			Pc:          pc,
+			Depth:       len(t.frames) - 1,
+			Op:          op,
+			Target:      &to,
+		})
+		frame.stack = append(frame.stack, taint{call: len(frame.calls)})
+	case vm.LOG3:
+		t.captureTransfer(scope)
+	}
+}
+
+var transferTopic256 = new(uint256.Int).SetBytes(transferTopic.Bytes())
+
+// captureTransfer records the ERC-20 Transfer emitted by a LOG3.
+func (t *SecurityTracer) captureTransfer(scope *vm.ScopeContext) {
+	offset, size := scope.Stack.Back(0), scope.Stack.Back(1)
+	if !scope.Stack.Back(2).Eq(transferTopic256) || !size.Eq(uint256.NewInt(32)) || !offset.IsUint64() || offset.Uint64() > maxProbeLogMemory {
+		return
+	}
+	from, to := common.Address(scope.Stack.Back(3).Bytes20()), common.Address(scope.Stack.Back(4).Bytes20())
+	if from == (common.Address{}) {
+		// a mint
+		return
+	}
+	// the memory is expanded after the op is traced, the rest is zero
+	data := make([]byte, 32)
+	if start := offset.Uint64(); start < uint64(scope.Memory.Len()) {
+		copy(data, scope.Memory.Data()[start:])
+	}
+	token := scope.Contract.Address()
+	t.outflows = append(t.outflows, &Outflow{From: from, To: to, Token: &token, Amount: new(big.Int).SetBytes(data)})
+}
+
+func (t *SecurityTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+func (t *SecurityTracer) CaptureTxStart(gasLimit uint64) {}
+func (t *SecurityTracer) CaptureTxEnd(restGas uint64)    {}
@@ -0,0 +1,236 @@
+package mfertracer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// findingsOf lists the findings of kind.
+func findingsOf(tracer *SecurityTracer, kind string) []*Finding {
+	var findings []*Finding
+	for _, f := range tracer.Findings() {
+		if f.Kind == kind {
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+func TestSecurityTracerTxOrigin(t *testing.T) {
+	wallet := common.HexToAddress("0x1000")
+	tests := []struct {
+		name    string
+		code    []byte
+		flagged bool
+	}{
+		// require(tx.origin == owner)
+		{"origin compared with the owner", asm(vm.ORIGIN, 0, vm.SLOAD, vm.EQ, vm.POP, vm.STOP), true},
+		// the masked origin is still the origin
+		{"masked origin", asm(0, vm.SLOAD, vm.ORIGIN, common.Address{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, vm.AND, vm.EQ, vm.POP, vm.STOP), true},
+		// require(msg.sender == tx.origin) keeps contracts out, it is no
+		// authentication
+		{"sender is the origin", asm(vm.CALLER, vm.ORIGIN, vm.EQ, vm.POP, vm.STOP), false},
+		{"sender is the origin through dup and swap", asm(vm.CALLER, vm.ORIGIN, vm.OpCode(vm.DUP2), vm.OpCode(vm.SWAP1), vm.EQ, vm.POP, vm.POP, vm.STOP), false},
+		{"owner only", asm(vm.CALLER, 0, vm.SLOAD, vm.EQ, vm.POP, vm.STOP), false},
+	}
+	for _, test := range tests {
+		tracer := NewSecurityTracer(nil)
+		runTx(t, tracer, map[common.Address]testAccount{wallet: {
+			code:    test.code,
+			storage: map[common.Hash]common.Hash{{}: common.BytesToHash(testOrigin.Bytes())},
+		}}, testTx{to: wallet, origin: true})
+		findings := findingsOf(tracer, FindingTxOrigin)
+		if (len(findings) > 0) != test.flagged {
+			t.Errorf("%s: findings %v, want flagged %v", test.name, findings, test.flagged)
+			continue
+		}
+		if test.flagged && (findings[0].Address != wallet || findings[0].Op != vm.EQ) {
+			t.Errorf("%s: finding %+v", test.name, findings[0])
+		}
+	}
+}
+
+func TestSecurityTracerUncheckedCall(t *testing.T) {
+	wallet, callee := common.HexToAddress("0x1000"), common.HexToAddress("0x2000")
+	call := func(parts ...interface{}) []byte {
+		return asm(append(callOp(vm.CALL, callee, 0, 50000), append(parts, vm.STOP)...)...)
+	}
+	tests := []struct {
+		name      string
+		code      []byte
+		unchecked bool
+	}{
+		{"result dropped", call(vm.POP), true},
+		{"result checked", call(vm.ISZERO, vm.POP), false},
+		{"copy dropped", call(vm.OpCode(vm.DUP1), vm.POP, vm.POP), true},
+		{"copy checked", call(vm.OpCode(vm.DUP1), vm.ISZERO, vm.POP, vm.POP), false},
+		{"swapped away", call(5, vm.OpCode(vm.SWAP1), vm.POP, vm.ISZERO, vm.POP), true},
+		{"swapped and checked", call(5, vm.OpCode(vm.SWAP1), vm.ISZERO, vm.POP, vm.POP), false},
+		// the result is returned or checked later
+		{"stored in memory", call(0, vm.MSTORE), false},
+		{"stored in storage", call(0, vm.SSTORE), false},
+		// the result of a precompile call is no external call
+		{"precompile", asm(append(callOp(vm.STATICCALL, common.BytesToAddress([]byte{4}), 0, 50000), vm.POP, vm.STOP)...), false},
+	}
+	for _, test := range tests {
+		tracer := NewSecurityTracer(nil)
+		runTx(t, tracer, map[common.Address]testAccount{wallet: {code: test.code}, callee: {code: asm(vm.STOP)}}, testTx{to: wallet})
+		findings := findingsOf(tracer, FindingUncheckedCall)
+		if (len(findings) > 0) != test.unchecked {
+			t.Errorf("%s: findings %v, want unchecked %v", test.name, findings, test.unchecked)
+			continue
+		}
+		if test.unchecked && (findings[0].Address != wallet || *findings[0].Target != callee || findings[0].Op != vm.CALL) {
+			t.Errorf("%s: finding %+v", test.name, findings[0])
+		}
+	}
+}
+
+func TestSecurityTracerReentrancy(t *testing.T) {
+	vault, attacker := common.HexToAddress("0x1000"), common.HexToAddress("0x2000")
+	// the vault calls the caller back unless the attacker called it, it
+	// writes storage after the call when write is set
+	vaultCode := func(write bool) []byte {
+		body := append(callOp(vm.CALL, attacker, 0, 100000), vm.POP)
+		if write {
+			body = append(body, 1, 0, vm.SSTORE)
+		}
+		body = append(body, vm.STOP)
+		head := func(dest int) []interface{} {
+			return []interface{}{vm.CALLER, attacker, vm.EQ, dest, vm.JUMPI}
+		}
+		dest := len(asm(head(0)...)) + len(asm(body...))
+		return asm(append(append(head(dest), body...), vm.JUMPDEST, vm.STOP)...)
+	}
+	attackerCode := asm(append(callOp(vm.CALL, vault, 0, 50000), vm.POP, vm.STOP)...)
+
+	for _, write := range []bool{false, true} {
+		tracer := NewSecurityTracer(nil)
+		runTx(t, tracer, map[common.Address]testAccount{vault: {code: vaultCode(write)}, attacker: {code: attackerCode}}, testTx{to: vault})
+		findings := findingsOf(tracer, FindingReentrancy)
+		if len(findings) != 1 {
+			t.Errorf("write %v: reentrancy findings %v", write, findings)
+			continue
+		}
+		f := findings[0]
+		if f.Address != attacker || *f.Target != vault || f.Depth != 1 || f.WriteAfter != write {
+			t.Errorf("write %v: finding %+v", write, f)
+		}
+	}
+
+	// a call into a contract not on the call stack is no reentry
+	tracer := NewSecurityTracer(nil)
+	runTx(t, tracer, map[common.Address]testAccount{attacker: {code: attackerCode}, vault: {code: asm(vm.STOP)}}, testTx{to: attacker})
+	if findings := findingsOf(tracer, FindingReentrancy); len(findings) != 0 {
+		t.Errorf("plain call: reentrancy findings %v", findings)
+	}
+}
+
+func TestSecurityTracerDelegateCall(t *testing.T) {
+	proxy, lib := common.HexToAddress("0x1000"), common.HexToAddress("0x2000")
+	accounts := map[common.Address]testAccount{
+		proxy: {code: asm(append(callOp(vm.DELEGATECALL, lib, 0, 50000), vm.ISZERO, vm.POP, vm.STOP)...)},
+		lib:   {code: asm(1, 0, vm.SSTORE, vm.STOP)},
+	}
+	for _, trusted := range []bool{false, true} {
+		tracer := NewSecurityTracer(map[common.Address]bool{lib: trusted})
+		result, statedb := runTx(t, tracer, accounts, testTx{to: proxy})
+		if result.Failed() || statedb.GetState(proxy, common.Hash{}) != common.BytesToHash([]byte{1}) {
+			t.Fatalf("delegate call not executed: %v", result.Err)
+		}
+		findings := findingsOf(tracer, FindingDelegateCall)
+		if trusted {
+			if len(findings) != 0 {
+				t.Errorf("trusted: findings %v", findings)
+			}
+			continue
+		}
+		if len(findings) != 1 || findings[0].Address != proxy || *findings[0].Target != lib || findings[0].Op != vm.DELEGATECALL {
+			t.Errorf("untrusted: findings %v", findings)
+		}
+	}
+}
+
+func TestSecurityTracerSelfDestruct(t *testing.T) {
+	victim, beneficiary := common.HexToAddress("0x1000"), common.HexToAddress("0x2000")
+	tracer := NewSecurityTracer(nil)
+	runTx(t, tracer, map[common.Address]testAccount{victim: {code: asm(beneficiary, vm.SELFDESTRUCT), balance: 5}}, testTx{to: victim})
+	findings := findingsOf(tracer, FindingSelfDestruct)
+	if len(findings) != 1 {
+		t.Fatalf("findings %v", findings)
+	}
+	f := findings[0]
+	if f.Address != victim || *f.Target != beneficiary || f.Value.Int64() != 5 || f.Reverted {
+		t.Errorf("finding %+v", f)
+	}
+}
+
+func TestSecurityTracerOutflows(t *testing.T) {
+	var (
+		wallet   = common.HexToAddress("0x1000")
+		reverter = common.HexToAddress("0x2000")
+		token    = common.HexToAddress("0x3000")
+		to       = common.HexToAddress("0x4000")
+		holder   = common.HexToAddress("0x5000")
+	)
+	// emit Transfer(holder, to, amount)
+	transfer := func(amount int) []interface{} {
+		return []interface{}{amount, 0, vm.MSTORE, to, holder, transferTopic, 32, 0, vm.LOG3}
+	}
+	// sends 3 wei, moves 7 tokens, then calls the reverter
+	var walletCode []interface{}
+	walletCode = append(walletCode, callOp(vm.CALL, to, 3, 50000)...)
+	walletCode = append(walletCode, vm.POP)
+	walletCode = append(walletCode, callOp(vm.CALL, token, 0, 50000)...)
+	walletCode = append(walletCode, vm.POP)
+	walletCode = append(walletCode, callOp(vm.CALL, reverter, 0, 100000)...)
+	walletCode = append(walletCode, vm.POP, vm.STOP)
+	// sends 4 wei and has 9 tokens moved, then reverts
+	var reverterCode []interface{}
+	reverterCode = append(reverterCode, callOp(vm.CALL, to, 4, 50000)...)
+	reverterCode = append(reverterCode, vm.POP)
+	reverterCode = append(reverterCode, callOp(vm.CALL, token, 1, 50000)...)
+	reverterCode = append(reverterCode, vm.POP, 0, 0, vm.REVERT)
+	// the token moves 7 tokens unless it is sent ether, then 9
+	head := func(dest int) []interface{} {
+		return []interface{}{vm.CALLVALUE, dest, vm.JUMPI}
+	}
+	moves7 := append(transfer(7), vm.STOP)
+	tokenCode := append(append(head(len(asm(head(0)...))+len(asm(moves7...))), moves7...), vm.JUMPDEST)
+	tokenCode = append(append(tokenCode, transfer(9)...), vm.STOP)
+
+	tracer := NewSecurityTracer(nil)
+	result, _ := runTx(t, tracer, map[common.Address]testAccount{
+		wallet:   {code: asm(walletCode...), balance: 10},
+		reverter: {code: asm(reverterCode...), balance: 10},
+		token:    {code: asm(tokenCode...)},
+	}, testTx{to: wallet, value: 2})
+	if result.Failed() {
+		t.Fatal(result.Err)
+	}
+	var got []string
+	for _, o := range tracer.Outflows() {
+		asset := "ether"
+		if o.Token != nil {
+			asset = o.Token.Hex()
+		}
+		got = append(got, fmt.Sprintf("%s %s->%s %s", asset, o.From.Hex(), o.To.Hex(), o.Amount))
+	}
+	want := []string{
+		fmt.Sprintf("ether %s->%s 2", testSender.Hex(), wallet.Hex()),
+		fmt.Sprintf("ether %s->%s 3", wallet.Hex(), to.Hex()),
+		fmt.Sprintf("%s %s->%s 7", token.Hex(), holder.Hex(), to.Hex()),
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("outflows:\n%v\nwant:\n%v", got, want)
+	}
+	// the calls of the reverted frame stay findings, marked reverted
+	for _, f := range tracer.Findings() {
+		if f.Reverted != (f.Address == reverter) {
+			t.Errorf("finding %+v reverted %v", f, f.Reverted)
+		}
+	}
+}
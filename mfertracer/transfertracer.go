@@ -0,0 +1,60 @@
+package mfertracer
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	// TransferAddress is the pseudo contract emitting the ether transfer logs,
+	// as in eth_simulateV1.
+	TransferAddress = common.HexToAddress("0xeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee")
+	transferTopic   = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+)
+
+// TransferTracer adds an ERC20-like Transfer log to the state for every ether
+// transfer, the logs of reverted frames are reverted with them.
+type TransferTracer struct {
+	env *vm.EVM
+}
+
+func NewTransferTracer() *TransferTracer {
+	return &TransferTracer{}
+}
+
+func (t *TransferTracer) addTransfer(from, to common.Address, value *big.Int) {
+	if value == nil || value.Sign() <= 0 {
+		return
+	}
+	t.env.StateDB.AddLog(&types.Log{
+		Address: TransferAddress,
+		Topics:  []common.Hash{transferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+		Data:    common.BigToHash(value).Bytes(),
+	})
+}
+
+func (t *TransferTracer) CaptureStart(env *vm.EVM, from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	t.addTransfer(from, to, value)
+}
+
+func (t *TransferTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	// a delegate call carries the value of its caller without moving it
+	if typ != vm.DELEGATECALL {
+		t.addTransfer(from, to, value)
+	}
+}
+
+func (t *TransferTracer) CaptureState(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, rData []byte, depth int, err error) {
+}
+func (t *TransferTracer) CaptureFault(pc uint64, op vm.OpCode, gas, cost uint64, scope *vm.ScopeContext, depth int, err error) {
+}
+func (t *TransferTracer) CaptureExit(output []byte, gasUsed uint64, err error)                    {}
+func (t *TransferTracer) CaptureEnd(output []byte, gasUsed uint64, time time.Duration, err error) {}
+func (t *TransferTracer) CaptureTxStart(gasLimit uint64)                                          {}
+func (t *TransferTracer) CaptureTxEnd(restGas uint64)                                             {}
@@ -3,11 +3,14 @@ package mfertxpool
 import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sec-bit/mfer-node/mfermetrics"
 )
 
 type MferTxPool struct {
 	txs         types.Transactions
 	execResults []error
+	// Metrics records the pool size, nil for the pools of sessions.
+	Metrics *mfermetrics.Fork
 }
 
 func NewMferTxPool() *MferTxPool {
@@ -21,6 +24,7 @@ func NewMferTxPool() *MferTxPool {
 func (pool *MferTxPool) AddTx(tx *types.Transaction, execResult error) {
 	pool.txs = append(pool.txs, tx)
 	pool.execResults = append(pool.execResults, execResult)
+	pool.Metrics.SetPoolSize(len(pool.txs))
 }
 
 func (pool *MferTxPool) SetResults(execResults []error) {
@@ -31,6 +35,7 @@ func (pool *MferTxPool) Reset() (n int) {
 	n = len(pool.txs)
 	pool.txs = make(types.Transactions, 0)
 	pool.execResults = make([]error, 0)
+	pool.Metrics.SetPoolSize(0)
 	return
 }
 
@@ -52,6 +57,11 @@ func (pool *MferTxPool) RemoveTxByHash(txHash common.Hash) {
 	resHead := pool.execResults[:txIndex]
 	resTail := pool.execResults[txIndex+1:]
 	pool.execResults = append(resHead, resTail...)
+	pool.Metrics.SetPoolSize(len(pool.txs))
+}
+
+func (pool *MferTxPool) Len() int {
+	return len(pool.txs)
 }
 
 func (pool *MferTxPool) GetPoolTxs() (types.Transactions, []error) {
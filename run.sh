@@ -5,15 +5,20 @@ if [ -f .env ]; then
   source .env
 fi
 
-# Check if environment variables are defined, otherwise use default values and append flag if LOGPATH is empty
+# Pass a flag only for the variables that are set, so the config file and the
+# MFER_* variables apply to the others
 UPSTREAM=${UPSTREAM:+"--upstream=$UPSTREAM"}
 LISTEN=${LISTEN:+"--listen=$LISTEN"}
 MAXKEYS=${MAXKEYS:+"--maxkeys=$MAXKEYS"}
 BATCHSIZE=${BATCHSIZE:+"--batchsize=$BATCHSIZE"}
-LOGPATH_FLAG="--logpath="
-LOGPATH=${LOGPATH_FLAG}${LOGPATH:-""}
+OPSLISTEN=${OPSLISTEN:+"--opslisten=$OPSLISTEN"}
+MAXLAG=${MAXLAG:+"--maxlag=$MAXLAG"}
+CONFIG=${CONFIG:+"--config=$CONFIG"}
+PROFILE=${PROFILE:+"--profile=$PROFILE"}
+# an empty LOGPATH still passes --logpath= to log to stdout
+LOGPATH=${LOGPATH+"--logpath=$LOGPATH"}
 
 # Build command with environment variables
-command="$UPSTREAM $LISTEN $MAXKEYS $BATCHSIZE $LOGPATH"
+command="$CONFIG $PROFILE $UPSTREAM $LISTEN $MAXKEYS $BATCHSIZE $OPSLISTEN $MAXLAG $LOGPATH"
 # Run command
 mfer-node $command
\ No newline at end of file